@@ -0,0 +1,81 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Decides whether an operation on a path in a MergedFS is allowed, given the
+// context passed to OpenContext, or context.Background() for operations that
+// don't take one. Op is "open", "readfile", "readdir", "lstat", "readlink",
+// "exists", "stat", or "hash". Returns nil to allow the operation, or a
+// non-nil error to deny it.
+type Authorizer func(ctx context.Context, path, op string) error
+
+// Installs a hook that's called before every Open, OpenContext, ReadFile,
+// ReadDir, Lstat, ReadLink, Exists, Hash, and Explain on m, and for each path
+// passed to StatMany, before any middleware runs or any layer is accessed.
+// If it returns an error, the operation fails with an *fs.PathError wrapping
+// fs.ErrPermission, so errors.Is(e, fs.ErrPermission) holds. Combined with
+// OpenContext, this lets a single MergedFS serve users with different
+// entitlements, e.g. premium asset packs only available to some users,
+// without building a separate merge for each:
+//
+//	merged.SetAuthorizer(func(ctx context.Context, p, op string) error {
+//		if strings.HasPrefix(p, "premium/") && !isPremium(ctx) {
+//			return fs.ErrPermission
+//		}
+//		return nil
+//	})
+//
+// The hook is called once per operation, with the path as passed to m, so it
+// sees "premium" when listing the directory as well as paths within it. With
+// ResolveAcrossLayers (see SetSymlinkShadowing), it's also called for the
+// destination of each link followed and for the path the links resolve to,
+// so links can't lead to paths it denies. These calls use the op "open", or
+// "lstat" or "readlink" for links in the parent directories of those
+// operations' paths. Directory listings aren't filtered, so denied paths
+// still appear in the listings of directories the hook allows; use
+// Layer.Visible to hide layers from some requests entirely. The hook only
+// applies to m, and not to any MergedFS nested within it, and isn't called
+// for paths resolved by a predictor (see SetPredictor), whose files are never
+// returned. Pass nil to remove the hook, which is the default. It must be
+// safe for concurrent use.
+func (m *MergedFS) SetAuthorizer(authorize Authorizer) {
+	m.configMutex.Lock()
+	m.authorizer = authorize
+	m.configMutex.Unlock()
+}
+
+// Returns an error if m's authorizer denies the operation on p, or nil if it's
+// allowed or there is no authorizer. Invalid paths are left for the operation
+// itself to reject.
+func (m *MergedFS) authorize(ctx context.Context, op, p string) error {
+	m.configMutex.RLock()
+	authorize := m.authorizer
+	m.configMutex.RUnlock()
+	if (authorize == nil) || !fs.ValidPath(p) {
+		return nil
+	}
+	e := authorize(ctx, p, op)
+	if e == nil {
+		return nil
+	}
+	if !errors.Is(e, fs.ErrPermission) {
+		e = fmt.Errorf("%w: %s", fs.ErrPermission, e)
+	}
+	return &fs.PathError{Op: op, Path: p, Err: e}
+}
+
+// Returns the info for the file at p, as fs.Stat would, after the authorizer,
+// if any, allowed the operation that needs it.
+func (m *MergedFS) statAuthorized(p string) (fs.FileInfo, error) {
+	f, e := m.openAuthorized(context.Background(), "lstat", p)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	return f.Stat()
+}
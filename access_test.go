@@ -0,0 +1,235 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// The context key used to mark premium requests in TestAuthorizer.
+type premiumKey struct{}
+
+func TestAuthorizer(t *testing.T) {
+	fsA := fstest.MapFS{
+		"premium/pack.dat": newMapFile("premium pack"),
+		"index.html":       newMapFile("index"),
+	}
+	fsB := fstest.MapFS{
+		"premium/readme.txt": newMapFile("readme"),
+		"free/pack.dat":      newMapFile("free pack"),
+	}
+	counting := &openCountingFS{FS: fsA}
+	merged := NewMergedFS(counting, fsB)
+	var calls []string
+	merged.SetAuthorizer(func(ctx context.Context, p, op string) error {
+		calls = append(calls, op+" "+p)
+		if (p == "premium") || strings.HasPrefix(p, "premium/") {
+			if ctx.Value(premiumKey{}) == nil {
+				return errors.New("premium content")
+			}
+		}
+		return nil
+	})
+
+	_, e := merged.Open("premium/pack.dat")
+	if !errors.Is(e, fs.ErrPermission) {
+		t.Logf("Didn't get a permission error for a denied open: %v\n", e)
+		t.FailNow()
+	}
+	if counting.opens != 0 {
+		t.Logf("Accessed a layer for a denied open.\n")
+		t.FailNow()
+	}
+	if !strings.Contains(e.Error(), "premium content") {
+		t.Logf("Error didn't include the authorizer's reason: %s\n", e)
+		t.FailNow()
+	}
+	_, e = merged.ReadFile("premium/pack.dat")
+	if !errors.Is(e, fs.ErrPermission) {
+		t.Logf("Didn't get a permission error for a denied read: %v\n", e)
+		t.FailNow()
+	}
+	_, e = merged.ReadDir("premium")
+	if !errors.Is(e, fs.ErrPermission) {
+		t.Logf("Didn't get a permission error for a denied listing: %v\n", e)
+		t.FailNow()
+	}
+	_, e = merged.Lstat("premium/readme.txt")
+	if !errors.Is(e, fs.ErrPermission) {
+		t.Logf("Didn't get a permission error for a denied lstat: %v\n", e)
+		t.FailNow()
+	}
+
+	ctx := context.WithValue(context.Background(), premiumKey{}, true)
+	f, e := merged.OpenContext(ctx, "premium/pack.dat")
+	if e != nil {
+		t.Logf("Failed opening premium file with entitlement: %s\n", e)
+		t.FailNow()
+	}
+	f.Close()
+
+	// Each operation must call the authorizer exactly once.
+	calls = nil
+	data, e := merged.ReadFile("free/pack.dat")
+	if e != nil {
+		t.Logf("Failed reading allowed file: %s\n", e)
+		t.FailNow()
+	}
+	if string(data) != "free pack" {
+		t.Logf("Got wrong content for allowed file: %q\n", data)
+		t.FailNow()
+	}
+	_, e = merged.ReadDir(".")
+	if e != nil {
+		t.Logf("Failed listing root: %s\n", e)
+		t.FailNow()
+	}
+	expected := "readfile free/pack.dat, readdir ."
+	if strings.Join(calls, ", ") != expected {
+		t.Logf("Expected authorizer calls %q, got %q\n", expected,
+			strings.Join(calls, ", "))
+		t.FailNow()
+	}
+
+	merged.SetAuthorizer(nil)
+	_, e = merged.ReadFile("premium/pack.dat")
+	if e != nil {
+		t.Logf("Failed reading after removing the authorizer: %s\n", e)
+		t.FailNow()
+	}
+}
+
+func TestAuthorizerStatMany(t *testing.T) {
+	merged := NewMergedFS(fstest.MapFS{
+		"docs/public.txt": newMapFile("public"),
+		"docs/secret.txt": newMapFile("secret"),
+	}, fstest.MapFS{
+		"docs/other.txt": newMapFile("other"),
+	})
+	var calls []string
+	merged.SetAuthorizer(func(ctx context.Context, p, op string) error {
+		calls = append(calls, op+" "+p)
+		if p == "docs/secret.txt" {
+			return fs.ErrPermission
+		}
+		return nil
+	})
+	paths := []string{"docs/public.txt", "docs/secret.txt", "docs/other.txt",
+		"."}
+	infos, errs := merged.StatMany(paths)
+	for i, p := range paths {
+		if p == "docs/secret.txt" {
+			if !errors.Is(errs[i], fs.ErrPermission) || (infos[i] != nil) {
+				t.Logf("Didn't get a permission error for %s: %v\n", p,
+					errs[i])
+				t.FailNow()
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Logf("Failed getting info for allowed path %s: %s\n", p,
+				errs[i])
+			t.FailNow()
+		}
+	}
+	// Every requested path is checked once, and their parent directories
+	// aren't checked at all.
+	expected := "stat docs/public.txt, stat docs/secret.txt, " +
+		"stat docs/other.txt, stat ."
+	if strings.Join(calls, ", ") != expected {
+		t.Logf("Expected authorizer calls %q, got %q\n", expected,
+			strings.Join(calls, ", "))
+		t.FailNow()
+	}
+}
+
+func TestAuthorizerFollowsLinks(t *testing.T) {
+	fsA := createLinkLayer(t, map[string]string{"public/a.txt": "a"},
+		map[string]string{
+			"shortcut": "hop",
+			"hop":      "premium",
+		})
+	fsB := createLinkLayer(t, map[string]string{
+		"premium/pack.dat": "premium pack",
+	}, nil)
+	merged := NewMergedFS(fsA, fsB)
+	merged.SetSymlinkShadowing(ResolveAcrossLayers)
+	var calls []string
+	merged.SetAuthorizer(func(ctx context.Context, p, op string) error {
+		calls = append(calls, op+" "+p)
+		if (p == "premium") || strings.HasPrefix(p, "premium/") {
+			return errors.New("premium content")
+		}
+		return nil
+	})
+	_, e := merged.ReadFile("shortcut/pack.dat")
+	if !errors.Is(e, fs.ErrPermission) {
+		t.Logf("Didn't get a permission error reading through links: %v\n",
+			e)
+		t.FailNow()
+	}
+	expected := "readfile shortcut/pack.dat, open hop, open premium"
+	if strings.Join(calls, ", ") != expected {
+		t.Logf("Expected authorizer calls %q, got %q\n", expected,
+			strings.Join(calls, ", "))
+		t.FailNow()
+	}
+
+	// Explain must not reveal where a denied path comes from.
+	r := merged.Explain("premium/pack.dat")
+	if !errors.Is(r.Err, fs.ErrPermission) || (len(r.Steps) != 0) {
+		t.Logf("Explain didn't apply the authorizer: %v, %d steps\n", r.Err,
+			len(r.Steps))
+		t.FailNow()
+	}
+	r = merged.Explain("public/a.txt")
+	if (r.Err != nil) || (len(r.Steps) == 0) {
+		t.Logf("Failed explaining an allowed path: %v\n", r.Err)
+		t.FailNow()
+	}
+}
+
+func TestAuthorizerHash(t *testing.T) {
+	merged := NewMergedFS(&knownHashFS{
+		MapFS: fstest.MapFS{
+			"secret.bin": newMapFile("secret"),
+			"public.bin": newMapFile("public"),
+		},
+		hashes: map[string][]byte{
+			"secret.bin": {1, 2, 3},
+			"public.bin": {4, 5, 6},
+		},
+	}, fstest.MapFS{})
+	var calls []string
+	merged.SetAuthorizer(func(ctx context.Context, p, op string) error {
+		calls = append(calls, op+" "+p)
+		if p == "secret.bin" {
+			return fs.ErrPermission
+		}
+		return nil
+	})
+	_, e := merged.Hash("secret.bin", "sha256")
+	if !errors.Is(e, fs.ErrPermission) {
+		t.Logf("Didn't get a permission error for a denied hash: %v\n", e)
+		t.FailNow()
+	}
+	// HashFile mustn't fall back to reading the denied file.
+	_, e = HashFile(merged, "secret.bin", "sha256")
+	if !errors.Is(e, fs.ErrPermission) {
+		t.Logf("Didn't get a permission error from HashFile: %v\n", e)
+		t.FailNow()
+	}
+	calls = nil
+	_, e = merged.Hash("public.bin", "sha256")
+	if e != nil {
+		t.Logf("Failed hashing an allowed file: %s\n", e)
+		t.FailNow()
+	}
+	if strings.Join(calls, ", ") != "hash public.bin" {
+		t.Logf("Got wrong authorizer calls: %q\n", calls)
+		t.FailNow()
+	}
+}
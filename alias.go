@@ -0,0 +1,194 @@
+package merged_fs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// Maps a virtual path to a path in a MergedFS. See Alias.
+type pathAlias struct {
+	virtual string
+	target  string
+}
+
+// Makes the path virtual refer to target in m, so that, for example, old URLs
+// keep working after files are moved:
+//
+//	e := merged.Alias("img/logo.png", "static/images/logo.png")
+//
+// If target is a directory, paths within virtual refer to the corresponding
+// paths within target. Opening the virtual path returns the target file,
+// reporting the virtual path's base name from Stat. The virtual path appears
+// in its parent directory's listing (if the target exists), and any parent
+// directories that don't otherwise exist in m are synthesized as read-only
+// directories.
+//
+// Aliases take priority over any files in m's layers at the virtual path.
+// Aliases are only resolved once, so a target can't refer to another alias.
+// Returns an error if either path is invalid or ".", or if one path contains
+// the other, or if virtual has already been aliased.
+func (m *MergedFS) Alias(virtual, target string) error {
+	for _, p := range []string{virtual, target} {
+		if !fs.ValidPath(p) || (p == ".") {
+			return fmt.Errorf("Invalid alias path %q: %w", p, fs.ErrInvalid)
+		}
+	}
+	if (virtual == target) || strings.HasPrefix(target, virtual+"/") ||
+		strings.HasPrefix(virtual, target+"/") {
+		return fmt.Errorf("Alias %q and its target %q overlap", virtual,
+			target)
+	}
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+	for _, a := range m.aliases {
+		if a.virtual == virtual {
+			return fmt.Errorf("%q is already an alias", virtual)
+		}
+	}
+	// Copy, so that concurrent Opens never see a partially updated slice.
+	aliases := make([]pathAlias, len(m.aliases), len(m.aliases)+1)
+	copy(aliases, m.aliases)
+	m.aliases = append(aliases, pathAlias{
+		virtual: virtual,
+		target:  target,
+	})
+	m.publish(Event{Path: virtual, Reason: "alias"})
+	return nil
+}
+
+// Returns the path that p refers to after resolving aliases, and true if an
+// alias applied.
+func resolveAlias(aliases []pathAlias, p string) (string, bool) {
+	for _, a := range aliases {
+		if p == a.virtual {
+			return a.target, true
+		}
+		if strings.HasPrefix(p, a.virtual+"/") {
+			return a.target + p[len(a.virtual):], true
+		}
+	}
+	return p, false
+}
+
+// Opens the path, taking aliases into account.
+func (m *MergedFS) openAliased(ctx context.Context, aliases []pathAlias,
+	path string) (fs.File, error) {
+	if target, ok := resolveAlias(aliases, path); ok {
+		traceStep(ctx, "alias", "", "%s refers to %s", path, target)
+		f, e := m.openResolved(ctx, target)
+		if e != nil {
+			return nil, e
+		}
+		return renameFile(f, baseName(path)), nil
+	}
+	f, e := m.openResolved(ctx, path)
+	children := aliasChildren(aliases, path)
+	if len(children) == 0 {
+		return f, e
+	}
+	if e != nil {
+		if !isBadPathError(e) {
+			return nil, e
+		}
+		d := newSyntheticDir(baseName(path))
+		d.entries = m.aliasEntries(ctx, children, nil)
+		if len(d.entries) == 0 {
+			return nil, e
+		}
+		return d, nil
+	}
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return f, nil
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if !info.IsDir() {
+		// The alias is hidden by a file in the merged view.
+		return f, nil
+	}
+	entries, e := dir.ReadDir(-1)
+	f.Close()
+	if e != nil {
+		return nil, e
+	}
+	return &completedDir{
+		MergedDirectory: &MergedDirectory{
+			name:    info.Name(),
+			mode:    info.Mode(),
+			entries: m.aliasEntries(ctx, children, entries),
+		},
+		info: info,
+	}, nil
+}
+
+// Returns the names of the entries that aliases add to the directory at
+// dirPath, mapped to the alias targets. Intermediate directories leading to
+// deeper aliases map to an empty string.
+func aliasChildren(aliases []pathAlias, dirPath string) map[string]string {
+	prefix := dirPath + "/"
+	if dirPath == "." {
+		prefix = ""
+	}
+	var toReturn map[string]string
+	for _, a := range aliases {
+		if !strings.HasPrefix(a.virtual, prefix) {
+			continue
+		}
+		if toReturn == nil {
+			toReturn = make(map[string]string)
+		}
+		rest := a.virtual[len(prefix):]
+		if first, remainder := SplitFirst(rest); remainder != "" {
+			if _, ok := toReturn[first]; !ok {
+				toReturn[first] = ""
+			}
+			continue
+		}
+		toReturn[rest] = a.target
+	}
+	return toReturn
+}
+
+// Returns entries, sorted by name, with the entries for aliases (and the
+// directories leading to them) added or replacing existing entries.
+func (m *MergedFS) aliasEntries(ctx context.Context,
+	children map[string]string, entries []fs.DirEntry) []fs.DirEntry {
+	toReturn := make([]fs.DirEntry, 0, len(entries)+len(children))
+	existing := make(map[string]fs.DirEntry)
+	for _, entry := range entries {
+		if _, ok := children[entry.Name()]; ok {
+			existing[entry.Name()] = entry
+			continue
+		}
+		toReturn = append(toReturn, entry)
+	}
+	for name, target := range children {
+		if target == "" {
+			if entry := existing[name]; (entry != nil) && entry.IsDir() {
+				toReturn = append(toReturn, entry)
+			} else {
+				toReturn = append(toReturn, newSyntheticDir(name))
+			}
+			continue
+		}
+		f, e := m.openResolved(ctx, target)
+		if e != nil {
+			continue
+		}
+		info, e := f.Stat()
+		f.Close()
+		if e != nil {
+			continue
+		}
+		toReturn = append(toReturn, infoDirEntry{renamedInfo{info, name}})
+	}
+	sort.Sort(dirEntrySlice(toReturn))
+	return toReturn
+}
@@ -0,0 +1,68 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAlias(t *testing.T) {
+	fsA := fstest.MapFS{
+		"static/images/logo.png": newMapFile("new logo"),
+		"static/css/site.css":    newMapFile("css"),
+	}
+	fsB := fstest.MapFS{
+		"index.html":      newMapFile("index"),
+		"legacy/old.html": newMapFile("old"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	e := merged.Alias("img/logo.png", "static/images/logo.png")
+	if e != nil {
+		t.Logf("Failed adding file alias: %s\n", e)
+		t.FailNow()
+	}
+	e = merged.Alias("legacy/css", "static/css")
+	if e != nil {
+		t.Logf("Failed adding directory alias: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(merged, "img/logo.png", "legacy/css/site.css",
+		"legacy/old.html", "static/images/logo.png")
+	if e != nil {
+		t.Logf("Merged FS with aliases failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	content, e := fs.ReadFile(merged, "img/logo.png")
+	if e != nil {
+		t.Logf("Failed reading aliased file: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "new logo" {
+		t.Logf("Got wrong content for aliased file: %s\n", content)
+		t.FailNow()
+	}
+	info, e := fs.Stat(merged, "legacy/css")
+	if e != nil {
+		t.Logf("Failed getting info for aliased dir: %s\n", e)
+		t.FailNow()
+	}
+	if !info.IsDir() || (info.Name() != "css") {
+		t.Logf("Got wrong info for aliased dir: %s, %s\n", info.Name(),
+			info.Mode())
+		t.FailNow()
+	}
+
+	badAliases := [][2]string{
+		{".", "static"},
+		{"static/x", "static"},
+		{"img/logo.png", "index.html"},
+		{"../x", "index.html"},
+	}
+	for _, a := range badAliases {
+		if merged.Alias(a[0], a[1]) == nil {
+			t.Logf("Didn't get expected error for alias %q -> %q\n", a[0],
+				a[1])
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// The maximum number of files opened by Analyze to time opens.
+const analysisOpenSamples = 100
+
+// A suggested change to a MergedFS's configuration, returned by Analyze.
+type Recommendation struct {
+	// What to change, e.g. "Enable directory caching using
+	// UseDirectoryCaching(true)".
+	Action string
+	// Why the change is likely to help, based on what Analyze measured.
+	Reason string
+}
+
+func (r Recommendation) String() string {
+	return r.Action + ": " + r.Reason
+}
+
+// The measurements and recommendations returned by Analyze.
+type Analysis struct {
+	// The numbers of regular files and directories visible in the MergedFS.
+	Files, Dirs int
+	// The number of regular files provided by each layer, indexed as in
+	// Layers. Files without provenance, such as aliases, aren't counted.
+	LayerFiles []int
+	// The time taken to walk the entire MergedFS.
+	WalkTime time.Duration
+	// The number of files opened to time opens, and the mean time taken to
+	// open and close each of them.
+	OpenSamples  int
+	MeanOpenTime time.Duration
+	// The time taken by a glob matching every path two levels deep.
+	GlobTime time.Duration
+	// The capabilities of each layer, as returned by Capabilities.
+	Capabilities *Capabilities
+	// Suggested changes, most significant first. Empty if nothing stands
+	// out.
+	Recommendations []Recommendation
+}
+
+// Samples representative operations against m, and returns measurements along
+// with recommendations for settings that may make it faster, to help choose
+// among the available options. This walks all of m, opens a sample of up to
+// 100 of its regular files, runs a glob, and checks the capabilities of each
+// layer as Capabilities does, so it's intended to be called once at startup
+// or from a diagnostic tool, not while serving requests. The operations fill
+// any of m's enabled caches, as real use would. Nothing about m's
+// configuration is changed.
+//
+// Recommendations are heuristics based on the shape of the merge and the
+// capabilities of its layers, not guarantees. In particular, reordering
+// layers changes which files are visible wherever they overlap, so use
+// Preview to check any suggested reordering before making it. Returns an
+// error if walking m fails.
+func (m *MergedFS) Analyze() (*Analysis, error) {
+	layers := m.Layers()
+	toReturn := &Analysis{
+		LayerFiles: make([]int, len(layers)),
+	}
+	var files []string
+	start := time.Now()
+	e := fs.WalkDir(m, ".", func(p string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if d.IsDir() {
+			toReturn.Dirs++
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		toReturn.Files++
+		files = append(files, p)
+		if provenance, ok := d.(ProvenanceEntry); ok {
+			index, _ := provenance.Provenance()
+			if (index >= 0) && (index < len(layers)) {
+				toReturn.LayerFiles[index]++
+			}
+		}
+		return nil
+	})
+	toReturn.WalkTime = time.Since(start)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't walk the merged FS: %w", e)
+	}
+
+	// Sample files spread evenly across the walk order, rather than only the
+	// first directories.
+	step := 1
+	if len(files) > analysisOpenSamples {
+		step = len(files) / analysisOpenSamples
+	}
+	var openTime time.Duration
+	for i := 0; (i < len(files)) &&
+		(toReturn.OpenSamples < analysisOpenSamples); i += step {
+		start = time.Now()
+		f, e := m.Open(files[i])
+		if e != nil {
+			continue
+		}
+		f.Close()
+		openTime += time.Since(start)
+		toReturn.OpenSamples++
+	}
+	if toReturn.OpenSamples != 0 {
+		toReturn.MeanOpenTime = openTime /
+			time.Duration(toReturn.OpenSamples)
+	}
+	start = time.Now()
+	fs.Glob(m, "*/*")
+	toReturn.GlobTime = time.Since(start)
+
+	toReturn.Capabilities = m.Capabilities()
+	toReturn.Recommendations = m.recommend(toReturn, layers)
+	return toReturn, nil
+}
+
+// Returns the recommendations for m, given the other measurements in a.
+func (m *MergedFS) recommend(a *Analysis, layers []fs.FS) []Recommendation {
+	var toReturn []Recommendation
+	add := func(action, format string, args ...interface{}) {
+		toReturn = append(toReturn, Recommendation{
+			Action: action,
+			Reason: fmt.Sprintf(format, args...),
+		})
+	}
+	if (len(layers) >= 3) && (m.validateFor("Compile") == nil) {
+		add("Serve a compiled snapshot using Compile, or an index using "+
+			"WriteIndex and OpenIndex, if the layers don't change",
+			"Opening a path may probe each of the %d layers; the mean open "+
+				"took %s. A snapshot opens files directly from the layer "+
+				"providing them.", len(layers), a.MeanOpenTime)
+	}
+	m.okPrefixesMutex.Lock()
+	pathCaching := m.prefixCachingEnabled
+	m.okPrefixesMutex.Unlock()
+	if !pathCaching && (len(layers) > 1) {
+		add("Enable path caching using UsePathCaching(true)",
+			"Without it, opening a path checks every parent directory for "+
+				"shadowing files in each higher-priority layer. Clear the "+
+				"cache when layers change, rather than disabling it.")
+	}
+	m.dirCacheMutex.Lock()
+	dirCaching := m.dirCache != nil
+	m.dirCacheMutex.Unlock()
+	if !dirCaching && (len(layers) > 1) && (a.Dirs > 1) {
+		add("Enable directory caching using UseDirectoryCaching(true)",
+			"Walking the %d directories took %s. Each directory is merged "+
+				"from every layer whenever it's read, unless it's cached.",
+			a.Dirs, a.WalkTime)
+	}
+	if (a.Files != 0) && (len(layers) > 1) {
+		busiest := 0
+		for i, count := range a.LayerFiles {
+			if count > a.LayerFiles[busiest] {
+				busiest = i
+			}
+		}
+		count := a.LayerFiles[busiest]
+		if (busiest != 0) && (count*2 > a.Files) {
+			add(fmt.Sprintf("Consider giving layer %d (%s) a higher "+
+				"priority", busiest, describeFS(layers[busiest])),
+				"It provides %d of the %d files, but %d layers are probed "+
+					"before it. Use Preview to check that moving it doesn't "+
+					"change which files are visible.", count, a.Files, busiest)
+		}
+	}
+	for i, c := range a.Capabilities.Layers {
+		var missing []string
+		if !c.StatFS {
+			missing = append(missing, "fs.StatFS")
+		}
+		if !c.ReadDirFS {
+			missing = append(missing, "fs.ReadDirFS")
+		}
+		if c.FilesChecked && !c.SeekerFiles {
+			missing = append(missing, "io.Seeker (on files)")
+		}
+		if c.FilesChecked && !c.ReaderAtFiles {
+			missing = append(missing, "io.ReaderAt (on files)")
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		add(fmt.Sprintf("Use an FS implementing %s for layer %d (%s), or "+
+			"wrap it in a ContentCache", strings.Join(missing, ", "), i,
+			describeFS(layers[i])),
+			"Without these interfaces, stats and directory reads require "+
+				"opening files, and range requests require reading files "+
+				"from the start.")
+	}
+	return toReturn
+}
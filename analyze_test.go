@@ -0,0 +1,78 @@
+package merged_fs
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// Returns true if any of the recommendations' actions contain s.
+func hasRecommendation(a *Analysis, s string) bool {
+	for _, r := range a.Recommendations {
+		if strings.Contains(r.Action, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAnalyze(t *testing.T) {
+	overrides := fstest.MapFS{
+		"site/index.html": newMapFile("override"),
+	}
+	minimal := minimalFS{fstest.MapFS{
+		"site/extra.txt": newMapFile("extra"),
+	}}
+	base := fstest.MapFS{
+		"site/index.html": newMapFile("base"),
+		"site/a.css":      newMapFile("a"),
+		"site/b.css":      newMapFile("b"),
+		"site/c.css":      newMapFile("c"),
+		"site/img/d.png":  newMapFile("d"),
+	}
+	m := MergeMultiple(overrides, minimal, base).(*MergedFS)
+	m.UsePathCaching(false)
+	a, e := m.Analyze()
+	if e != nil {
+		t.Logf("Analyze failed: %s\n", e)
+		t.FailNow()
+	}
+	for _, r := range a.Recommendations {
+		t.Logf("Recommendation: %s\n", r)
+	}
+	if (a.Files != 6) || (a.Dirs != 3) || (a.OpenSamples != 6) {
+		t.Logf("Expected 6 files, 3 dirs, and 6 samples, got %d, %d, %d\n",
+			a.Files, a.Dirs, a.OpenSamples)
+		t.FailNow()
+	}
+	if (a.LayerFiles[0] != 1) || (a.LayerFiles[1] != 1) ||
+		(a.LayerFiles[2] != 4) {
+		t.Logf("Got incorrect files per layer: %v\n", a.LayerFiles)
+		t.FailNow()
+	}
+	expected := []string{"Compile", "UsePathCaching", "UseDirectoryCaching",
+		"layer 2", "fs.StatFS, fs.ReadDirFS, io.Seeker"}
+	for _, s := range expected {
+		if !hasRecommendation(a, s) {
+			t.Logf("Didn't get a recommendation mentioning %q\n", s)
+			t.FailNow()
+		}
+	}
+	if hasRecommendation(a, "for layer 0") {
+		t.Logf("Got a capability recommendation for a complete layer\n")
+		t.FailNow()
+	}
+
+	// Following the recommendations should remove them.
+	m = MergeMultiple(base, fstest.MapFS{}).(*MergedFS)
+	m.UseDirectoryCaching(true)
+	a, e = m.Analyze()
+	if e != nil {
+		t.Logf("Analyze failed: %s\n", e)
+		t.FailNow()
+	}
+	if len(a.Recommendations) != 0 {
+		t.Logf("Got unexpected recommendations: %v\n", a.Recommendations)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,273 @@
+package merged_fs
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Determines the order in which NewFromArchiveDir merges archives.
+type ArchiveOrder int
+
+const (
+	// Orders archives by file name.
+	OrderByName ArchiveOrder = iota
+	// Orders archives by modification time, newest first, breaking ties by
+	// file name.
+	OrderByModTime
+	// Orders archives as listed in the index file in the directory; see
+	// ArchiveIndexFile.
+	OrderByIndexFile
+)
+
+// The name of the file listing the order of archives for OrderByIndexFile. It
+// must contain one archive file name per line, in priority order. Blank lines
+// and lines starting with "#" are ignored. Archives matching the pattern but
+// not listed in the index are left out of the merge, and it's an error for the
+// index to list an archive that doesn't exist.
+const ArchiveIndexFile = "archives.txt"
+
+// A merged FS over the archives in a directory, returned by
+// NewFromArchiveDir. Safe for concurrent use.
+type ArchiveDirFS struct {
+	dir     string
+	pattern string
+	order   ArchiveOrder
+	// Protects the fields below.
+	mutex sync.RWMutex
+	// The merge of the current archives.
+	merged fs.FS
+	// The open archives, in priority order.
+	archives []*openArchive
+}
+
+// An archive opened by an ArchiveDirFS.
+type openArchive struct {
+	name    string
+	size    int64
+	modTime time.Time
+	reader  *zip.ReadCloser
+	report  *ArchiveReport
+}
+
+// Opens every zip archive in the OS directory dir whose file name matches the
+// given pattern (using the syntax of filepath.Match, e.g. "*.zip"), and merges
+// them in the given order. As with MergeMultiple, archives earlier in the
+// order take priority over later ones. Each archive is wrapped in a Layer
+// named after its file name, so zip archives lacking directory entries work
+// as expected. Archives are passed through SandboxZip with the default limits
+// before being merged; see SandboxReports for the entries that were dropped.
+// This is intended for the "mods folder" pattern, in which content is added
+// to an application by dropping archives into a directory; call Rescan to
+// pick up changes to the directory.
+//
+// Close the returned FS when it's no longer needed to close the archives.
+func NewFromArchiveDir(dir, pattern string, order ArchiveOrder) (
+	*ArchiveDirFS, error) {
+	if _, e := filepath.Match(pattern, ""); e != nil {
+		return nil, fmt.Errorf("Invalid pattern %q: %w", pattern, e)
+	}
+	toReturn := &ArchiveDirFS{
+		dir:     dir,
+		pattern: pattern,
+		order:   order,
+	}
+	e := toReturn.Rescan()
+	if e != nil {
+		return nil, e
+	}
+	return toReturn, nil
+}
+
+// Returns the file names of the archives in the directory that should be
+// merged, in priority order, along with their FileInfo.
+func (a *ArchiveDirFS) scan() ([]string, map[string]fs.FileInfo, error) {
+	entries, e := os.ReadDir(a.dir)
+	if e != nil {
+		return nil, nil, fmt.Errorf("Couldn't read archive dir: %w", e)
+	}
+	infos := make(map[string]fs.FileInfo)
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matched, _ := filepath.Match(a.pattern, entry.Name())
+		if !matched {
+			continue
+		}
+		info, e := entry.Info()
+		if e != nil {
+			return nil, nil, fmt.Errorf("Couldn't stat %s: %w", entry.Name(),
+				e)
+		}
+		infos[entry.Name()] = info
+		names = append(names, entry.Name())
+	}
+	switch a.order {
+	case OrderByName:
+		// os.ReadDir already sorts entries by name.
+	case OrderByModTime:
+		sort.SliceStable(names, func(i, j int) bool {
+			return infos[names[i]].ModTime().After(infos[names[j]].ModTime())
+		})
+	case OrderByIndexFile:
+		names, e = a.readIndex(infos)
+		if e != nil {
+			return nil, nil, e
+		}
+	default:
+		return nil, nil, fmt.Errorf("Invalid archive order: %d", a.order)
+	}
+	return names, infos, nil
+}
+
+// Returns the archive names listed in the index file.
+func (a *ArchiveDirFS) readIndex(infos map[string]fs.FileInfo) ([]string,
+	error) {
+	f, e := os.Open(filepath.Join(a.dir, ArchiveIndexFile))
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't open archive index: %w", e)
+	}
+	defer f.Close()
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if (line == "") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, ok := infos[line]; !ok {
+			return nil, fmt.Errorf("Archive %s is listed in the index, but "+
+				"doesn't exist or doesn't match %q", line, a.pattern)
+		}
+		names = append(names, line)
+	}
+	e = scanner.Err()
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't read archive index: %w", e)
+	}
+	return names, nil
+}
+
+// Scans the directory again, replacing the merged FS with one containing the
+// archives that are currently present, in the current order. Archives that
+// haven't changed size or modification time since they were opened are
+// reused. On error, the previous merged FS remains in use.
+//
+// Archives that were removed or changed are closed, so reading files that
+// were opened from them before the call to Rescan may fail.
+func (a *ArchiveDirFS) Rescan() error {
+	names, infos, e := a.scan()
+	if e != nil {
+		return e
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	existing := make(map[string]*openArchive)
+	for _, archive := range a.archives {
+		existing[archive.name] = archive
+	}
+	archives := make([]*openArchive, 0, len(names))
+	layers := make([]fs.FS, 0, len(names))
+	kept := make(map[*openArchive]bool)
+	for _, name := range names {
+		info := infos[name]
+		archive := existing[name]
+		if (archive == nil) || (archive.size != info.Size()) ||
+			!archive.modTime.Equal(info.ModTime()) {
+			reader, e := zip.OpenReader(filepath.Join(a.dir, name))
+			if e != nil {
+				for _, opened := range archives {
+					if !kept[opened] {
+						opened.reader.Close()
+					}
+				}
+				return fmt.Errorf("Couldn't open archive %s: %w", name, e)
+			}
+			archive = &openArchive{
+				name:    name,
+				size:    info.Size(),
+				modTime: info.ModTime(),
+				reader:  reader,
+				report:  SandboxZip(&reader.Reader, ArchiveLimits{}),
+			}
+		} else {
+			kept[archive] = true
+		}
+		archives = append(archives, archive)
+		layers = append(layers, &Layer{FS: &archive.reader.Reader, Name: name})
+	}
+	for _, archive := range a.archives {
+		if !kept[archive] {
+			archive.reader.Close()
+		}
+	}
+	a.archives = archives
+	a.merged = MergeMultiple(layers...)
+	return nil
+}
+
+// Returns the file names of the merged archives, in priority order.
+func (a *ArchiveDirFS) Archives() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	toReturn := make([]string, len(a.archives))
+	for i, archive := range a.archives {
+		toReturn[i] = archive.name
+	}
+	return toReturn
+}
+
+// Returns the changes SandboxZip made to each of the merged archives, keyed by
+// archive file name. Archives that didn't need any changes are left out.
+func (a *ArchiveDirFS) SandboxReports() map[string]*ArchiveReport {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	toReturn := make(map[string]*ArchiveReport)
+	for _, archive := range a.archives {
+		r := archive.report
+		if (len(r.Dropped) != 0) || (len(r.Renamed) != 0) {
+			toReturn[archive.name] = r
+		}
+	}
+	return toReturn
+}
+
+// Returns the current merged FS.
+func (a *ArchiveDirFS) current() fs.FS {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.merged
+}
+
+func (a *ArchiveDirFS) Open(path string) (fs.File, error) {
+	return a.current().Open(path)
+}
+
+func (a *ArchiveDirFS) ReadFile(path string) ([]byte, error) {
+	return fs.ReadFile(a.current(), path)
+}
+
+// Closes all of the archives. The FS must not be used afterwards.
+func (a *ArchiveDirFS) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	var toReturn error
+	for _, archive := range a.archives {
+		e := archive.reader.Close()
+		if (e != nil) && (toReturn == nil) {
+			toReturn = e
+		}
+	}
+	a.archives = nil
+	a.merged = Empty
+	return toReturn
+}
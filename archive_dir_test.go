@@ -0,0 +1,149 @@
+package merged_fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Copies the named zip file from test_data into dir, setting its modification
+// time to the given time.
+func copyTestZip(name, dir string, modTime time.Time, t *testing.T) {
+	data, e := os.ReadFile(filepath.Join("test_data", name))
+	if e != nil {
+		t.Logf("Failed reading %s: %s\n", name, e)
+		t.FailNow()
+	}
+	dest := filepath.Join(dir, name)
+	e = os.WriteFile(dest, data, 0644)
+	if e == nil {
+		e = os.Chtimes(dest, modTime, modTime)
+	}
+	if e != nil {
+		t.Logf("Failed copying %s: %s\n", name, e)
+		t.FailNow()
+	}
+}
+
+func TestArchiveDir(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	copyTestZip("test_a.zip", dir, now.Add(-time.Hour), t)
+	copyTestZip("test_b.zip", dir, now, t)
+	e := os.WriteFile(filepath.Join(dir, "readme.txt"), []byte("hi"),
+		0644)
+	if e != nil {
+		t.Logf("Failed writing readme: %s\n", e)
+		t.FailNow()
+	}
+
+	byName, e := NewFromArchiveDir(dir, "*.zip", OrderByName)
+	if e != nil {
+		t.Logf("Failed opening archive dir: %s\n", e)
+		t.FailNow()
+	}
+	defer byName.Close()
+	e = fstest.TestFS(byName, "test1.txt", "test2.txt", "a", "b/1.txt")
+	if e != nil {
+		t.Logf("TestFS failed for archive dir: %s\n", e)
+		t.FailNow()
+	}
+	data, e := byName.ReadFile("test1.txt")
+	if (e != nil) || (len(data) != 2) {
+		t.Logf("Expected test_a.zip's test1.txt, got %q, %v\n", data, e)
+		t.FailNow()
+	}
+
+	// test_b.zip is newer, so it takes priority when ordered by time.
+	byTime, e := NewFromArchiveDir(dir, "*.zip", OrderByModTime)
+	if e != nil {
+		t.Logf("Failed opening archive dir by mod time: %s\n", e)
+		t.FailNow()
+	}
+	defer byTime.Close()
+	data, e = byTime.ReadFile("test1.txt")
+	if (e != nil) || (len(data) != 13) {
+		t.Logf("Expected test_b.zip's test1.txt, got %q, %v\n", data, e)
+		t.FailNow()
+	}
+
+	// Adding an archive has no effect until rescanning.
+	copyTestZip("test_c.zip", dir, now, t)
+	_, e = byName.Open("b/0.txt")
+	if e == nil {
+		t.Logf("Didn't get an error opening a file before rescanning\n")
+		t.FailNow()
+	}
+	e = byName.Rescan()
+	if e != nil {
+		t.Logf("Failed rescanning: %s\n", e)
+		t.FailNow()
+	}
+	archives := byName.Archives()
+	if len(archives) != 3 {
+		t.Logf("Expected 3 archives after rescanning, got %v\n", archives)
+		t.FailNow()
+	}
+	_, e = byName.Open("b/0.txt")
+	if e != nil {
+		t.Logf("Failed opening b/0.txt after rescanning: %s\n", e)
+		t.FailNow()
+	}
+}
+
+func TestArchiveDirIndex(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	copyTestZip("test_a.zip", dir, now, t)
+	copyTestZip("test_b.zip", dir, now, t)
+	copyTestZip("test_c.zip", dir, now, t)
+	index := "# Load order\ntest_b.zip\n\ntest_a.zip\n"
+	e := os.WriteFile(filepath.Join(dir, ArchiveIndexFile),
+		[]byte(index), 0644)
+	if e != nil {
+		t.Logf("Failed writing index: %s\n", e)
+		t.FailNow()
+	}
+	merged, e := NewFromArchiveDir(dir, "*.zip", OrderByIndexFile)
+	if e != nil {
+		t.Logf("Failed opening archive dir with an index: %s\n", e)
+		t.FailNow()
+	}
+	defer merged.Close()
+	archives := merged.Archives()
+	if (len(archives) != 2) || (archives[0] != "test_b.zip") {
+		t.Logf("Got incorrect archives from index: %v\n", archives)
+		t.FailNow()
+	}
+	data, e := merged.ReadFile("test1.txt")
+	if (e != nil) || (len(data) != 13) {
+		t.Logf("Expected test_b.zip's test1.txt, got %q, %v\n", data, e)
+		t.FailNow()
+	}
+	// test_c.zip isn't in the index.
+	_, e = merged.Open("b/0.txt")
+	if e == nil {
+		t.Logf("Didn't get an error opening a file from an unlisted " +
+			"archive\n")
+		t.FailNow()
+	}
+
+	e = os.WriteFile(filepath.Join(dir, ArchiveIndexFile),
+		[]byte("missing.zip\n"), 0644)
+	if e != nil {
+		t.Logf("Failed rewriting index: %s\n", e)
+		t.FailNow()
+	}
+	e = merged.Rescan()
+	if e == nil {
+		t.Logf("Didn't get an error for an index listing a missing archive\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+	if len(merged.Archives()) != 2 {
+		t.Logf("A failed rescan changed the archives\n")
+		t.FailNow()
+	}
+}
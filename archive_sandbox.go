@@ -0,0 +1,153 @@
+package merged_fs
+
+import (
+	"archive/zip"
+	"path"
+	"strings"
+)
+
+// Limits on the entry names accepted by SandboxZip. Zero values use the
+// defaults.
+type ArchiveLimits struct {
+	// The maximum length of an entry's path, in bytes. Defaults to 4096.
+	MaxPathLength int
+	// The maximum length of any single component of an entry's path, in
+	// bytes. Defaults to 255.
+	MaxNameLength int
+}
+
+// An archive entry dropped by SandboxZip.
+type DroppedEntry struct {
+	// The entry's name, exactly as it appears in the archive.
+	Name string
+	// Why the entry was dropped, e.g. "absolute path".
+	Reason string
+}
+
+// An archive entry whose name was normalized by SandboxZip.
+type RenamedEntry struct {
+	// The entry's name, exactly as it appears in the archive.
+	Name string
+	// The name the entry is served under.
+	NewName string
+}
+
+// Describes the changes SandboxZip made to an archive.
+type ArchiveReport struct {
+	Dropped []DroppedEntry
+	Renamed []RenamedEntry
+}
+
+// Returns the normalized name for an entry, which ends in "/" if the entry is
+// a directory, or an empty name and the reason the entry must be dropped.
+func sandboxedName(name string, limits ArchiveLimits) (string, string) {
+	if strings.Contains(name, "\\") {
+		return "", "contains a backslash"
+	}
+	if strings.HasPrefix(name, "/") ||
+		((len(name) >= 2) && (name[1] == ':')) {
+		return "", "absolute path"
+	}
+	isDir := strings.HasSuffix(name, "/")
+	var components []string
+	for _, c := range strings.Split(name, "/") {
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			return "", "contains \"..\""
+		}
+		if len(c) > limits.MaxNameLength {
+			return "", "name too long"
+		}
+		components = append(components, c)
+	}
+	if len(components) == 0 {
+		return "", "empty path"
+	}
+	toReturn := strings.Join(components, "/")
+	if len(toReturn) > limits.MaxPathLength {
+		return "", "path too long"
+	}
+	if isDir {
+		toReturn += "/"
+	}
+	return toReturn, ""
+}
+
+// Removes or renames the entries of r that could be used to escape the
+// archive or confuse the merge, and returns a report of what was changed.
+// This is intended for archives from untrusted sources, which may contain
+// "zip-slip" style names meant to write outside of a directory when
+// extracted, or to shadow content unexpectedly when merged. It must be called
+// before anything is opened from r. Specifically, SandboxZip:
+//
+//   - Drops entries with absolute paths, including Windows drive letters,
+//     or paths containing backslashes or ".." components.
+//   - Drops entries with paths or components longer than the given limits.
+//   - Removes empty and "." components from the remaining names, so
+//     "a//./b.txt" is served as "a/b.txt".
+//   - Drops entries with the same normalized name as an earlier entry.
+//   - Drops files with the same name as a directory containing other
+//     entries.
+//
+// Each dropped entry is listed in the report, along with each renamed entry.
+// r.File is modified in place; the dropped entries can't be opened using r
+// afterwards.
+func SandboxZip(r *zip.Reader, limits ArchiveLimits) *ArchiveReport {
+	if limits.MaxPathLength <= 0 {
+		limits.MaxPathLength = 4096
+	}
+	if limits.MaxNameLength <= 0 {
+		limits.MaxNameLength = 255
+	}
+	toReturn := &ArchiveReport{}
+	drop := func(f *zip.File, reason string) {
+		toReturn.Dropped = append(toReturn.Dropped, DroppedEntry{
+			Name:   f.Name,
+			Reason: reason,
+		})
+	}
+	kept := make([]*zip.File, 0, len(r.File))
+	// The original names of the kept entries, indexed like kept.
+	var originalNames []string
+	seen := make(map[string]bool)
+	// Directories containing at least one kept entry.
+	parents := make(map[string]bool)
+	for _, f := range r.File {
+		name, reason := sandboxedName(f.Name, limits)
+		if reason != "" {
+			drop(f, reason)
+			continue
+		}
+		trimmed := strings.TrimSuffix(name, "/")
+		if seen[trimmed] {
+			drop(f, "duplicate entry")
+			continue
+		}
+		seen[trimmed] = true
+		for dir := path.Dir(trimmed); dir != "."; dir = path.Dir(dir) {
+			parents[dir] = true
+		}
+		originalNames = append(originalNames, f.Name)
+		f.Name = name
+		kept = append(kept, f)
+	}
+	r.File = kept[:0]
+	for i, f := range kept {
+		original := originalNames[i]
+		if !strings.HasSuffix(f.Name, "/") && parents[f.Name] {
+			f.Name = original
+			drop(f, "file conflicts with a directory")
+			continue
+		}
+		if f.Name != original {
+			toReturn.Renamed = append(toReturn.Renamed, RenamedEntry{
+				Name:    original,
+				NewName: f.Name,
+			})
+		}
+		r.File = append(r.File, f)
+	}
+	return toReturn
+}
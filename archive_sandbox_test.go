@@ -0,0 +1,87 @@
+package merged_fs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSandboxZip(t *testing.T) {
+	var buffer bytes.Buffer
+	w := zip.NewWriter(&buffer)
+	names := []string{
+		"ok.txt",
+		"../escape.txt",
+		"/etc/passwd",
+		"C:/windows.txt",
+		"dir\\backslash.txt",
+		"a//./b.txt",
+		"ok.txt",
+		"x",
+		"x/y.txt",
+		strings.Repeat("n", 300) + ".txt",
+	}
+	for _, name := range names {
+		f, e := w.Create(name)
+		if e == nil {
+			_, e = f.Write([]byte(name))
+		}
+		if e != nil {
+			t.Logf("Failed writing %s to zip: %s\n", name, e)
+			t.FailNow()
+		}
+	}
+	e := w.Close()
+	if e != nil {
+		t.Logf("Failed finishing zip: %s\n", e)
+		t.FailNow()
+	}
+	r, e := zip.NewReader(bytes.NewReader(buffer.Bytes()),
+		int64(buffer.Len()))
+	if e != nil {
+		t.Logf("Failed reading zip: %s\n", e)
+		t.FailNow()
+	}
+	report := SandboxZip(r, ArchiveLimits{})
+	dropped := make([]string, len(report.Dropped))
+	for i, d := range report.Dropped {
+		dropped[i] = d.Name + ": " + d.Reason
+	}
+	got := strings.Join(dropped, ", ")
+	expected := strings.Join([]string{
+		"../escape.txt: contains \"..\"",
+		"/etc/passwd: absolute path",
+		"C:/windows.txt: absolute path",
+		"dir\\backslash.txt: contains a backslash",
+		"ok.txt: duplicate entry",
+		names[len(names)-1] + ": name too long",
+		"x: file conflicts with a directory",
+	}, ", ")
+	if got != expected {
+		t.Logf("Got wrong dropped entries.\nExpected: %s\nGot: %s\n",
+			expected, got)
+		t.FailNow()
+	}
+	if (len(report.Renamed) != 1) || (report.Renamed[0].NewName != "a/b.txt") {
+		t.Logf("Got wrong renamed entries: %v\n", report.Renamed)
+		t.FailNow()
+	}
+	layer := &Layer{FS: r, Name: "untrusted"}
+	e = fstest.TestFS(layer, "ok.txt", "a/b.txt", "x/y.txt")
+	if e != nil {
+		t.Logf("Sandboxed zip failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	content, e := fs.ReadFile(layer, "a/b.txt")
+	if e != nil {
+		t.Logf("Failed reading renamed entry: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "a//./b.txt" {
+		t.Logf("Got wrong content for renamed entry: %s\n", content)
+		t.FailNow()
+	}
+}
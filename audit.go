@@ -0,0 +1,270 @@
+package merged_fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Describes a single operation served by a MergedFS, as passed to an
+// AuditSink.
+type AuditRecord struct {
+	// When the path was opened.
+	Time time.Time
+	// The path, as passed to the MergedFS.
+	Path string
+	// "open" for Open and OpenContext, or "readfile", "readdir", or "lstat"
+	// for the methods with those names.
+	Op string
+	// The index of the layer that served the path, as used by Layers, or -1
+	// if it wasn't served by a single layer, e.g. if it's a directory merged
+	// from several layers.
+	LayerIndex int
+	// The name of the layer that served the path, if it's a *Layer with a
+	// Name, or an empty string otherwise.
+	Layer string
+	// The number of bytes read from the file before it was closed.
+	Bytes int64
+	// The principal stored in the context passed to OpenContext using
+	// WithPrincipal, or an empty string if there wasn't one.
+	Principal string
+}
+
+// Receives the records of operations sampled by a MergedFS. See
+// MergedFS.SetAuditSink.
+type AuditSink interface {
+	// Called once for each sampled operation, after the file is closed. Audit
+	// is called synchronously by Close, so it should return quickly, e.g. by
+	// buffering the record. It must be safe for concurrent use.
+	Audit(record AuditRecord)
+}
+
+// The key for a principal stored in a context.
+type principalKey struct{}
+
+// Returns a copy of ctx carrying the given principal, such as a user or
+// account ID, to include in AuditRecords for operations using the context.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Returns the principal stored in ctx using WithPrincipal, or an empty string
+// if there isn't one.
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// The audit settings of a MergedFS, set using SetAuditSink.
+type auditor struct {
+	sink        AuditSink
+	sampleEvery int64
+	patterns    []string
+	// The number of operations seen, used for sampling. Only access this
+	// atomically.
+	count int64
+}
+
+// Installs sink to receive a record of the paths m serves: when each sampled
+// path was opened, which layer served it, the number of bytes read from it,
+// and the principal from the context passed to OpenContext (see
+// WithPrincipal). This lets compliance teams trace which layer served
+// regulated content, and is intended to stay enabled in production, unlike
+// Recorder or DebugDump: operations that aren't sampled only cost a counter
+// increment and matching the path against the given patterns.
+//
+// One in every sampleEvery operations is recorded, chosen by counting, along
+// with every operation on a path matching one of the always patterns, which
+// use the syntax described in the package documentation. If sampleEvery is 1,
+// every operation is recorded; if it's 0 or negative, only operations on
+// paths matching a pattern are. Operations that fail aren't recorded, and
+// neither are files that are never closed. Returns an error if a pattern is
+// malformed.
+//
+// Only operations on m itself are recorded, so install the sink on the value
+// returned by MergeMultiple to record every operation. Pass a nil sink to stop
+// recording, which is the default.
+func (m *MergedFS) SetAuditSink(sink AuditSink, sampleEvery int,
+	always ...string) error {
+	for _, pattern := range always {
+		e := validatePattern(pattern)
+		if e != nil {
+			return fmt.Errorf("Invalid audit pattern: %w", e)
+		}
+	}
+	var a *auditor
+	if sink != nil {
+		a = &auditor{
+			sink:        sink,
+			sampleEvery: int64(sampleEvery),
+			patterns:    append([]string(nil), always...),
+		}
+	}
+	m.configMutex.Lock()
+	m.auditor = a
+	m.configMutex.Unlock()
+	return nil
+}
+
+// Returns true if an operation on p should be recorded.
+func (a *auditor) sampled(p string) bool {
+	for _, pattern := range a.patterns {
+		if matchPattern(pattern, p) {
+			return true
+		}
+	}
+	if a.sampleEvery <= 0 {
+		return false
+	}
+	return atomic.AddInt64(&a.count, 1)%a.sampleEvery == 0
+}
+
+// The key for an *auditSlot stored in a context.
+type auditKey struct{}
+
+// Tracks the layers that files were opened from during a sampled operation.
+type auditSlot struct {
+	mutex sync.Mutex
+	// Maps files opened from layers that aren't MergedFS instances to the
+	// layer index and name relative to the MergedFS that opened them.
+	served map[fs.File]*servedBy
+}
+
+// The layer a file was served by.
+type servedBy struct {
+	layerIndex int
+	layerName  string
+}
+
+// Returns the layer that served f, or one of the files it wraps, according
+// to the slot, or nil if it isn't known.
+func (s *auditSlot) find(f fs.File) *servedBy {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for ; f != nil; f = Unwrap(f) {
+		// Files with uncomparable types can't be map keys, so they're never
+		// recorded.
+		if !reflect.TypeOf(f).Comparable() {
+			continue
+		}
+		if served := s.served[f]; served != nil {
+			return served
+		}
+	}
+	return nil
+}
+
+// If ctx belongs to a sampled operation, records that f was opened from the
+// given side of m, so that the layer can be reported when f is served.
+func (m *MergedFS) recordServed(ctx context.Context, side int, f fs.File) {
+	slot, _ := ctx.Value(auditKey{}).(*auditSlot)
+	if slot == nil {
+		return
+	}
+	offset := 0
+	if side == 1 {
+		offset = layerCount(m.A)
+	}
+	if isMergedFS(m.layer(side)) {
+		// The nested MergedFS recorded the layer relative to itself.
+		if s := slot.find(f); s != nil {
+			slot.mutex.Lock()
+			s.layerIndex += offset
+			slot.mutex.Unlock()
+		}
+		return
+	}
+	if !reflect.TypeOf(f).Comparable() {
+		return
+	}
+	slot.mutex.Lock()
+	slot.served[f] = &servedBy{
+		layerIndex: offset,
+		layerName:  layerNameForProvenance(m.layer(side)),
+	}
+	slot.mutex.Unlock()
+}
+
+// An operation being recorded for an AuditSink.
+type auditEntry struct {
+	sink   AuditSink
+	record AuditRecord
+	slot   *auditSlot
+}
+
+// Returns the entry recording an operation on p, or nil if m has no audit
+// sink or the operation wasn't sampled.
+func (m *MergedFS) startAudit(ctx context.Context, op,
+	p string) *auditEntry {
+	m.configMutex.RLock()
+	a := m.auditor
+	m.configMutex.RUnlock()
+	if (a == nil) || !a.sampled(p) {
+		return nil
+	}
+	return &auditEntry{
+		sink: a.sink,
+		record: AuditRecord{
+			Time:       time.Now(),
+			Path:       p,
+			Op:         op,
+			LayerIndex: -1,
+			Principal:  PrincipalFromContext(ctx),
+		},
+		slot: &auditSlot{served: make(map[fs.File]*servedBy)},
+	}
+}
+
+// Returns a copy of ctx that causes layers to be recorded in the entry.
+func (a *auditEntry) context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, auditKey{}, a.slot)
+}
+
+// Wraps f, which was opened for the entry's operation, so that the entry is
+// sent to the sink when it's closed.
+func (a *auditEntry) wrap(f fs.File) fs.File {
+	if s := a.slot.find(f); s != nil {
+		a.record.LayerIndex = s.layerIndex
+		a.record.Layer = s.layerName
+	}
+	a.slot = nil
+	meter := &readMeter{}
+	metered := newMeteredFile(f, meter)
+	dir, _ := metered.(dirReader)
+	seeker, _ := metered.(io.Seeker)
+	readerAt, _ := metered.(io.ReaderAt)
+	mapped, _ := metered.(mapper)
+	writerTo, _ := metered.(io.WriterTo)
+	return addFileInterfaces(&auditedFile{
+		File:  metered,
+		entry: a,
+		meter: meter,
+	}, dir, seeker, readerAt, mapped, writerTo)
+}
+
+// Wraps a file opened by a sampled operation, sending the operation's record
+// to the sink when it's closed.
+type auditedFile struct {
+	fs.File
+	entry     *auditEntry
+	meter     *readMeter
+	closeOnce sync.Once
+}
+
+func (f *auditedFile) Unwrap() fs.File {
+	return f.File
+}
+
+func (f *auditedFile) Close() error {
+	f.closeOnce.Do(func() {
+		record := f.entry.record
+		record.Bytes = f.meter.bytesRead()
+		f.entry.sink.Audit(record)
+	})
+	return f.File.Close()
+}
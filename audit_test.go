@@ -0,0 +1,154 @@
+package merged_fs
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// An AuditSink that keeps every record it receives.
+type recordingSink struct {
+	mutex   sync.Mutex
+	records []AuditRecord
+}
+
+func (s *recordingSink) Audit(record AuditRecord) {
+	s.mutex.Lock()
+	s.records = append(s.records, record)
+	s.mutex.Unlock()
+}
+
+// Returns the records received so far, and clears them.
+func (s *recordingSink) take() []AuditRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	toReturn := s.records
+	s.records = nil
+	return toReturn
+}
+
+func TestAuditSink(t *testing.T) {
+	merged := MergeMultiple(
+		&Layer{Name: "overrides", FS: fstest.MapFS{
+			"docs/a.txt": newMapFile("override"),
+		}},
+		fstest.MapFS{"docs/b.txt": newMapFile("b")},
+		&Layer{Name: "regulated", FS: fstest.MapFS{
+			"docs/c.txt":         newMapFile("regulated content"),
+			"records/secret.txt": newMapFile("secret"),
+		}},
+	).(*MergedFS)
+	sink := &recordingSink{}
+	e := merged.SetAuditSink(sink, 1)
+	if e != nil {
+		t.Logf("Failed setting audit sink: %s\n", e)
+		t.FailNow()
+	}
+
+	ctx := WithPrincipal(context.Background(), "user-1")
+	f, e := merged.OpenContext(ctx, "docs/c.txt")
+	if e != nil {
+		t.Logf("Failed opening docs/c.txt: %s\n", e)
+		t.FailNow()
+	}
+	_, e = io.ReadAll(f)
+	if e != nil {
+		t.Logf("Failed reading docs/c.txt: %s\n", e)
+		t.FailNow()
+	}
+	if len(sink.take()) != 0 {
+		t.Logf("Got a record before the file was closed.\n")
+		t.FailNow()
+	}
+	f.Close()
+	f.Close()
+	records := sink.take()
+	if len(records) != 1 {
+		t.Logf("Expected 1 record, got %d\n", len(records))
+		t.FailNow()
+	}
+	r := records[0]
+	if (r.Path != "docs/c.txt") || (r.Op != "open") || (r.LayerIndex != 2) ||
+		(r.Layer != "regulated") || (r.Bytes != 17) ||
+		(r.Principal != "user-1") || r.Time.IsZero() {
+		t.Logf("Got wrong record for docs/c.txt: %+v\n", r)
+		t.FailNow()
+	}
+
+	_, e = merged.ReadFile("docs/a.txt")
+	if e != nil {
+		t.Logf("Failed reading docs/a.txt: %s\n", e)
+		t.FailNow()
+	}
+	_, e = merged.ReadDir("docs")
+	if e != nil {
+		t.Logf("Failed reading docs: %s\n", e)
+		t.FailNow()
+	}
+	records = sink.take()
+	if len(records) != 2 {
+		t.Logf("Expected 2 records, got %d\n", len(records))
+		t.FailNow()
+	}
+	r = records[0]
+	if (r.Op != "readfile") || (r.LayerIndex != 0) ||
+		(r.Layer != "overrides") || (r.Bytes != 8) || (r.Principal != "") {
+		t.Logf("Got wrong record for ReadFile: %+v\n", r)
+		t.FailNow()
+	}
+	r = records[1]
+	if (r.Op != "readdir") || (r.LayerIndex != -1) {
+		t.Logf("Got wrong record for merged directory: %+v\n", r)
+		t.FailNow()
+	}
+
+	// Sample every third operation, but always record regulated paths.
+	e = merged.SetAuditSink(sink, 3, "records/**")
+	if e != nil {
+		t.Logf("Failed changing audit sink: %s\n", e)
+		t.FailNow()
+	}
+	for i := 0; i < 6; i++ {
+		_, e = merged.ReadFile("docs/b.txt")
+		if e != nil {
+			t.Logf("Failed reading docs/b.txt: %s\n", e)
+			t.FailNow()
+		}
+	}
+	_, e = merged.ReadFile("records/secret.txt")
+	if e != nil {
+		t.Logf("Failed reading records/secret.txt: %s\n", e)
+		t.FailNow()
+	}
+	records = sink.take()
+	if len(records) != 3 {
+		t.Logf("Expected 3 sampled records, got %d\n", len(records))
+		t.FailNow()
+	}
+	if (records[0].Path != "docs/b.txt") || (records[0].LayerIndex != 1) {
+		t.Logf("Got wrong sampled record: %+v\n", records[0])
+		t.FailNow()
+	}
+	if records[2].Path != "records/secret.txt" {
+		t.Logf("Didn't record regulated path: %+v\n", records[2])
+		t.FailNow()
+	}
+
+	e = merged.SetAuditSink(sink, 1, "[")
+	if e == nil {
+		t.Logf("Didn't get an error for a malformed pattern.\n")
+		t.FailNow()
+	}
+	merged.SetAuditSink(nil, 1)
+	_, e = merged.ReadFile("docs/c.txt")
+	if e != nil {
+		t.Logf("Failed reading without an audit sink: %s\n", e)
+		t.FailNow()
+	}
+	if len(sink.take()) != 0 {
+		t.Logf("Got a record after removing the sink.\n")
+		t.FailNow()
+	}
+}
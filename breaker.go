@@ -0,0 +1,111 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// The backoff period used if a Layer's BreakerThreshold is set but its
+// BreakerBackoff isn't.
+const defaultBreakerBackoff = 30 * time.Second
+
+// Wrapped in the *fs.PathError returned by operations on a Layer while its
+// circuit breaker is open. Unwraps to fs.ErrNotExist, so a MergedFS treats
+// the layer as empty rather than failing.
+type LayerUnavailableError struct {
+	// The name of the unavailable layer.
+	Layer string
+	// The time after which the layer will be tried again.
+	RetryAt time.Time
+}
+
+func (e *LayerUnavailableError) Error() string {
+	return fmt.Sprintf("layer %q is unavailable until %s after repeated "+
+		"errors", e.Layer, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *LayerUnavailableError) Unwrap() error {
+	return fs.ErrNotExist
+}
+
+// The state of a Layer's circuit breaker, returned by Layer.BreakerState.
+type BreakerState struct {
+	// True if the breaker is open, meaning the layer isn't being used.
+	Open bool
+	// The number of consecutive errors from the layer's FS.
+	ConsecutiveErrors int
+	// If Open is true, this is when the layer will be tried again.
+	RetryAt time.Time
+}
+
+// Returns the current state of the layer's circuit breaker. Returns the zero
+// BreakerState if BreakerThreshold isn't set.
+func (l *Layer) BreakerState() BreakerState {
+	if l.BreakerThreshold <= 0 {
+		return BreakerState{}
+	}
+	l.breakerMutex.Lock()
+	defer l.breakerMutex.Unlock()
+	return BreakerState{
+		Open:              l.breakerOpenLocked(time.Now()),
+		ConsecutiveErrors: l.consecutiveErrors,
+		RetryAt:           l.retryAt,
+	}
+}
+
+// Only call this while holding l.breakerMutex.
+func (l *Layer) breakerOpenLocked(now time.Time) bool {
+	return (l.consecutiveErrors >= l.BreakerThreshold) &&
+		now.Before(l.retryAt)
+}
+
+// Returns true if the layer's circuit breaker is open.
+func (l *Layer) breakerOpen() bool {
+	return l.BreakerState().Open
+}
+
+// Returns an error if the layer's circuit breaker is open, for the given
+// operation and path.
+func (l *Layer) checkBreaker(op, path string) error {
+	if l.BreakerThreshold <= 0 {
+		return nil
+	}
+	l.breakerMutex.Lock()
+	defer l.breakerMutex.Unlock()
+	if !l.breakerOpenLocked(time.Now()) {
+		return nil
+	}
+	return &fs.PathError{
+		Op:   op,
+		Path: path,
+		Err: &LayerUnavailableError{
+			Layer:   l.Name,
+			RetryAt: l.retryAt,
+		},
+	}
+}
+
+// Updates the layer's circuit breaker with the error returned by an operation
+// on its FS. Errors indicating that a path doesn't exist or is invalid are
+// treated as successes, since the FS responded normally.
+func (l *Layer) recordResult(e error) {
+	if l.BreakerThreshold <= 0 {
+		return
+	}
+	l.breakerMutex.Lock()
+	defer l.breakerMutex.Unlock()
+	if (e == nil) || isBadPathError(e) {
+		l.consecutiveErrors = 0
+		return
+	}
+	l.consecutiveErrors++
+	if l.consecutiveErrors < l.BreakerThreshold {
+		return
+	}
+	backoff := l.BreakerBackoff
+	if backoff <= 0 {
+		backoff = defaultBreakerBackoff
+	}
+	l.retryAt = time.Now().Add(backoff)
+}
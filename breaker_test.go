@@ -0,0 +1,192 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var errLayerDown = errors.New("layer is down")
+
+// An FS that fails every operation with errLayerDown while down is set, and
+// counts the number of calls to Open.
+type flakyFS struct {
+	fs.FS
+	down  bool
+	opens int
+}
+
+func (f *flakyFS) Open(path string) (fs.File, error) {
+	f.opens++
+	if f.down {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: errLayerDown}
+	}
+	return f.FS.Open(path)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	remote := &flakyFS{FS: fstest.MapFS{
+		"remote.txt": newMapFile("remote"),
+	}}
+	layerA := &Layer{
+		FS:               remote,
+		Name:             "remote",
+		BreakerThreshold: 3,
+		BreakerBackoff:   50 * time.Millisecond,
+	}
+	fsB := fstest.MapFS{
+		"local.txt": newMapFile("local"),
+	}
+	merged := NewMergedFS(layerA, fsB)
+	data, e := merged.ReadFile("remote.txt")
+	if (e != nil) || (string(data) != "remote") {
+		t.Logf("Failed reading remote.txt: %q, %v\n", data, e)
+		t.FailNow()
+	}
+
+	remote.down = true
+	_, e = merged.ReadFile("local.txt")
+	if !errors.Is(e, errLayerDown) {
+		t.Logf("Didn't get expected error from down layer: %v\n", e)
+		t.FailNow()
+	}
+	// A single ReadFile may use the layer more than once, so just make sure
+	// that the breaker opens after a few more attempts.
+	for i := 0; (i < 3) && !layerA.BreakerState().Open; i++ {
+		merged.ReadFile("local.txt")
+	}
+	state := layerA.BreakerState()
+	if !state.Open || (state.ConsecutiveErrors != 3) {
+		t.Logf("Breaker didn't open after 3 errors: %+v\n", state)
+		t.FailNow()
+	}
+
+	// The layer must be skipped entirely while the breaker is open.
+	remote.opens = 0
+	data, e = merged.ReadFile("local.txt")
+	if (e != nil) || (string(data) != "local") {
+		t.Logf("Failed reading local.txt with the breaker open: %q, %v\n",
+			data, e)
+		t.FailNow()
+	}
+	if remote.opens != 0 {
+		t.Logf("The layer was used %d times while its breaker was open\n",
+			remote.opens)
+		t.FailNow()
+	}
+	_, e = layerA.Open("remote.txt")
+	var unavailable *LayerUnavailableError
+	if !errors.As(e, &unavailable) || !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Didn't get expected unavailable error: %v\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+
+	// After the backoff, the layer is tried again, and a success closes the
+	// breaker.
+	time.Sleep(60 * time.Millisecond)
+	remote.down = false
+	data, e = merged.ReadFile("remote.txt")
+	if (e != nil) || (string(data) != "remote") {
+		t.Logf("Failed reading remote.txt after recovery: %q, %v\n", data, e)
+		t.FailNow()
+	}
+	state = layerA.BreakerState()
+	if state.Open || (state.ConsecutiveErrors != 0) {
+		t.Logf("Breaker didn't close after recovery: %+v\n", state)
+		t.FailNow()
+	}
+}
+
+func TestCircuitBreakerSkipsLimits(t *testing.T) {
+	remote := &flakyFS{FS: fstest.MapFS{"a.txt": newMapFile("a")}, down: true}
+	layer := &Layer{
+		FS:               remote,
+		Name:             "remote",
+		MaxConcurrent:    1,
+		BreakerThreshold: 1,
+		BreakerBackoff:   time.Hour,
+	}
+	_, e := layer.Open("a.txt")
+	if !errors.Is(e, errLayerDown) {
+		t.Logf("Didn't get expected error from down layer: %v\n", e)
+		t.FailNow()
+	}
+	// Occupy the only slot, so any operation that waits for one would block
+	// until the context expires.
+	release, e := layer.acquire(context.Background())
+	if e != nil {
+		t.Logf("Failed acquiring the layer's slot: %s\n", e)
+		t.FailNow()
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, e = layer.OpenContext(ctx, "a.txt")
+	var unavailable *LayerUnavailableError
+	if !errors.As(e, &unavailable) {
+		t.Logf("Expected the open breaker to fail fast, got %v\n", e)
+		t.FailNow()
+	}
+	_, e = layer.Stat("a.txt")
+	if !errors.As(e, &unavailable) {
+		t.Logf("Expected Stat to fail fast, got %v\n", e)
+		t.FailNow()
+	}
+}
+
+// A flakyFS that also implements fs.GlobFS, since fs.Glob ignores errors from
+// reading directories.
+type flakyGlobFS struct {
+	*flakyFS
+}
+
+func (f flakyGlobFS) Glob(pattern string) ([]string, error) {
+	if f.down {
+		return nil, &fs.PathError{Op: "glob", Path: pattern,
+			Err: errLayerDown}
+	}
+	return fs.Glob(f.FS, pattern)
+}
+
+func TestCircuitBreakerGlob(t *testing.T) {
+	remote := &flakyFS{FS: fstest.MapFS{"a.txt": newMapFile("a")}}
+	layer := &Layer{
+		FS:               flakyGlobFS{remote},
+		Name:             "remote",
+		BreakerThreshold: 2,
+		BreakerBackoff:   time.Hour,
+	}
+	// Malformed patterns aren't failures of the layer.
+	for i := 0; i < 2; i++ {
+		_, e := layer.Glob("[")
+		if e == nil {
+			t.Logf("Didn't get an error for a malformed pattern\n")
+			t.FailNow()
+		}
+	}
+	if layer.BreakerState().Open {
+		t.Logf("Malformed patterns opened the breaker\n")
+		t.FailNow()
+	}
+	remote.down = true
+	for i := 0; i < 2; i++ {
+		_, e := layer.Glob("*.txt")
+		if !errors.Is(e, errLayerDown) {
+			t.Logf("Didn't get expected error from down layer: %v\n", e)
+			t.FailNow()
+		}
+	}
+	if !layer.BreakerState().Open {
+		t.Logf("Failed Globs didn't open the breaker\n")
+		t.FailNow()
+	}
+	matches, e := layer.Glob("*.txt")
+	if (e != nil) || (len(matches) != 0) {
+		t.Logf("Got %v, %v from Glob with the breaker open\n", matches, e)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,135 @@
+package merged_fs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// Reports which optional interfaces an FS, and the files it opens, support.
+// See MergedFS.Capabilities.
+type FSCapabilities struct {
+	StatFS     bool
+	ReadDirFS  bool
+	ReadFileFS bool
+	GlobFS     bool
+	// True if the FS has ReadLink and Lstat methods, like fs.ReadLinkFS in
+	// newer versions of Go.
+	ReadLinkFS bool
+	// True if a regular file was found to check the interfaces below. If
+	// false, the FS has no regular files, and the fields below are false.
+	FilesChecked bool
+	// True if regular files implement io.Seeker.
+	SeekerFiles bool
+	// True if regular files implement io.ReaderAt.
+	ReaderAtFiles bool
+}
+
+// The capabilities of a MergedFS, returned by Capabilities.
+type Capabilities struct {
+	// The capabilities of each layer, indexed as in Layers.
+	Layers []FSCapabilities
+	// The capabilities of the MergedFS itself. Since regular files are
+	// opened directly from the layers, the file interfaces are only reported
+	// as supported if they're supported by every layer with regular files.
+	Merged FSCapabilities
+}
+
+// The optional interfaces implemented by every *MergedFS, and so by every
+// *Group, regardless of its layers or options. See Capabilities.
+var (
+	_ fs.ReadDirFS  = (*MergedFS)(nil)
+	_ fs.ReadFileFS = (*MergedFS)(nil)
+	_ symlinkFS     = (*MergedFS)(nil)
+	_ fs.ReadDirFS  = (*Group)(nil)
+	_ fs.ReadFileFS = (*Group)(nil)
+	_ symlinkFS     = (*Group)(nil)
+)
+
+// Used to stop walking a layer once a regular file has been found.
+var errFoundFile = errors.New("found a regular file")
+
+// Returns the optional interfaces implemented by fsys, and by its regular
+// files, for an FS that isn't a MergedFS, such as the result of MergeMultiple
+// with a single layer. To check the files, this walks fsys until it finds a
+// regular file, and opens it.
+func CheckCapabilities(fsys fs.FS) FSCapabilities {
+	var toReturn FSCapabilities
+	_, toReturn.StatFS = fsys.(fs.StatFS)
+	_, toReturn.ReadDirFS = fsys.(fs.ReadDirFS)
+	_, toReturn.ReadFileFS = fsys.(fs.ReadFileFS)
+	_, toReturn.GlobFS = fsys.(fs.GlobFS)
+	_, toReturn.ReadLinkFS = fsys.(symlinkFS)
+	fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, e error) error {
+		if (e != nil) || !d.Type().IsRegular() {
+			return nil
+		}
+		f, e := fsys.Open(p)
+		if e != nil {
+			return nil
+		}
+		defer f.Close()
+		toReturn.FilesChecked = true
+		_, toReturn.SeekerFiles = f.(io.Seeker)
+		_, toReturn.ReaderAtFiles = f.(io.ReaderAt)
+		return errFoundFile
+	})
+	return toReturn
+}
+
+// Reports which optional io/fs interfaces are supported by each of m's
+// layers, and by m itself, e.g. to decide whether range requests can be
+// served efficiently. Support for interfaces on files is checked by opening
+// the first regular file found in each layer, so this assumes that every
+// regular file from a layer supports the same interfaces, and may need to
+// walk a large part of a layer with few files. The results are best
+// computed once, at startup.
+//
+// The interfaces implemented by m itself are part of its API, and don't
+// depend on its layers or options, or on whether it was created by
+// NewMergedFS, MergeAll, MergeMultiple, or NewGroup:
+//
+//   - Every MergedFS implements fs.ReadDirFS and fs.ReadFileFS, and has
+//     ReadLink and Lstat methods like those of fs.ReadLinkFS.
+//   - No MergedFS implements fs.StatFS, fs.GlobFS, or fs.SubFS; fs.Stat,
+//     fs.Glob, and fs.Sub work by opening paths and reading directories.
+//   - Directories opened from a MergedFS implement fs.ReadDirFile.
+//   - Regular files implement io.Seeker and io.ReaderAt if and only if the
+//     files opened from the layer providing them do, including when read
+//     quotas, open-file tracking, or read fallback are enabled. The same
+//     applies to io.WriterTo, except that files don't implement it while
+//     read fallback is enabled. Middleware may return files with other
+//     interfaces.
+//
+// Note that MergeMultiple returns its only layer, without wrapping it, if
+// given exactly one, so its result only has these interfaces if that layer
+// does. Use MergeAll to always get a MergedFS.
+func (m *MergedFS) Capabilities() *Capabilities {
+	layers := m.Layers()
+	toReturn := &Capabilities{
+		Layers: make([]FSCapabilities, len(layers)),
+	}
+	merged := &toReturn.Merged
+	_, merged.StatFS = fs.FS(m).(fs.StatFS)
+	_, merged.ReadDirFS = fs.FS(m).(fs.ReadDirFS)
+	_, merged.ReadFileFS = fs.FS(m).(fs.ReadFileFS)
+	_, merged.GlobFS = fs.FS(m).(fs.GlobFS)
+	_, merged.ReadLinkFS = fs.FS(m).(symlinkFS)
+	merged.SeekerFiles = true
+	merged.ReaderAtFiles = true
+	for i, layer := range layers {
+		c := CheckCapabilities(layer)
+		toReturn.Layers[i] = c
+		if !c.FilesChecked {
+			continue
+		}
+		merged.FilesChecked = true
+		merged.SeekerFiles = merged.SeekerFiles && c.SeekerFiles
+		merged.ReaderAtFiles = merged.ReaderAtFiles && c.ReaderAtFiles
+	}
+	if !merged.FilesChecked {
+		merged.SeekerFiles = false
+		merged.ReaderAtFiles = false
+	}
+	return toReturn
+}
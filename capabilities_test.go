@@ -0,0 +1,135 @@
+package merged_fs
+
+import (
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// Wraps an FS, hiding its optional interfaces and those of its files, other
+// than fs.ReadDirFile.
+type minimalFS struct {
+	fsys fs.FS
+}
+
+type minimalFile struct {
+	fs.File
+}
+
+func (f minimalFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	return f.File.(fs.ReadDirFile).ReadDir(n)
+}
+
+func (m minimalFS) Open(path string) (fs.File, error) {
+	f, e := m.fsys.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	return minimalFile{f}, nil
+}
+
+func TestCapabilities(t *testing.T) {
+	full := fstest.MapFS{"a/b.txt": newMapFile("b")}
+	minimal := minimalFS{fstest.MapFS{"c.txt": newMapFile("c")}}
+	m := MergeMultiple(full, minimal, fstest.MapFS{}).(*MergedFS)
+	c := m.Capabilities()
+	if len(c.Layers) != 3 {
+		t.Logf("Expected 3 layers, got %d\n", len(c.Layers))
+		t.FailNow()
+	}
+	expected := FSCapabilities{
+		StatFS:        true,
+		ReadDirFS:     true,
+		ReadFileFS:    true,
+		GlobFS:        true,
+		FilesChecked:  true,
+		SeekerFiles:   true,
+		ReaderAtFiles: true,
+	}
+	// MapFS only supports links in newer versions of Go.
+	_, expected.ReadLinkFS = fs.FS(full).(symlinkFS)
+	if c.Layers[0] != expected {
+		t.Logf("Got wrong capabilities for MapFS: %+v\n", c.Layers[0])
+		t.FailNow()
+	}
+	if c.Layers[1] != (FSCapabilities{FilesChecked: true}) {
+		t.Logf("Got wrong capabilities for minimal FS: %+v\n", c.Layers[1])
+		t.FailNow()
+	}
+	if c.Layers[2].FilesChecked {
+		t.Logf("Files were checked in an empty layer\n")
+		t.FailNow()
+	}
+	merged := c.Merged
+	if !merged.ReadFileFS || !merged.ReadDirFS || !merged.ReadLinkFS {
+		t.Logf("Missing capabilities of the MergedFS: %+v\n", merged)
+		t.FailNow()
+	}
+	if !merged.FilesChecked || merged.SeekerFiles || merged.ReaderAtFiles {
+		t.Logf("Got wrong file capabilities for the merge: %+v\n", merged)
+		t.FailNow()
+	}
+}
+
+func TestMergedFSInterfaces(t *testing.T) {
+	layer := fstest.MapFS{"a.txt": newMapFile("a")}
+	merged := []*MergedFS{
+		MergeAll(),
+		MergeAll(layer),
+		MergeAll(layer, nil, fstest.MapFS{}, Empty),
+		NewGroup("group", layer).MergedFS,
+	}
+	for i, m := range merged {
+		c := m.Capabilities().Merged
+		if !c.ReadDirFS || !c.ReadFileFS || !c.ReadLinkFS || c.StatFS ||
+			c.GlobFS {
+			t.Logf("Got wrong capabilities for MergedFS %d: %+v\n", i, c)
+			t.FailNow()
+		}
+		if _, ok := fs.FS(m).(fs.SubFS); ok {
+			t.Logf("MergedFS %d unexpectedly implements fs.SubFS\n", i)
+			t.FailNow()
+		}
+		f, e := m.Open(".")
+		if e != nil {
+			t.Logf("Failed opening root of MergedFS %d: %s\n", i, e)
+			t.FailNow()
+		}
+		_, ok := f.(fs.ReadDirFile)
+		f.Close()
+		if !ok {
+			t.Logf("The root of MergedFS %d isn't a ReadDirFile\n", i)
+			t.FailNow()
+		}
+	}
+	if len(MergeAll(layer).Layers()) != 2 {
+		t.Logf("MergeAll didn't fill out a single layer with Empty\n")
+		t.FailNow()
+	}
+
+	// File interfaces must come from the layer, regardless of options.
+	m := MergeAll(layer, fstest.MapFS{})
+	m.SetReadQuota(0)
+	m.TrackOpenFiles(true, nil)
+	m.UseReadFallback(true)
+	f, e := m.Open("a.txt")
+	if e != nil {
+		t.Logf("Failed opening a.txt: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	if _, ok := f.(io.Seeker); !ok {
+		t.Logf("a.txt doesn't implement io.Seeker with options enabled\n")
+		t.FailNow()
+	}
+	if _, ok := f.(io.ReaderAt); !ok {
+		t.Logf("a.txt doesn't implement io.ReaderAt with options enabled\n")
+		t.FailNow()
+	}
+	c := CheckCapabilities(minimalFS{layer})
+	if c != (FSCapabilities{FilesChecked: true}) {
+		t.Logf("Got wrong capabilities for a minimal FS: %+v\n", c)
+		t.FailNow()
+	}
+}
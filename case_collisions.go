@@ -0,0 +1,124 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Describes a set of paths in a MergedFS that differ only in case, such as
+// "docs/README.md" and "docs/Readme.md". Only one of them would be usable if
+// the merged content were copied to a case-insensitive filesystem, as used
+// by default on Windows and macOS.
+type CaseCollision struct {
+	// The colliding paths, in lexical order.
+	Paths []string
+	// The index of the layer each path came from, as reported by
+	// ProvenanceEntry, or -1 if the path has no provenance (e.g. because
+	// it was synthesized).
+	Layers []int
+}
+
+// Returns a key that's the same for any two names that are equal under
+// Unicode case folding, as defined by strings.EqualFold.
+func caseFoldKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		smallest := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < smallest {
+				smallest = f
+			}
+		}
+		return smallest
+	}, name)
+}
+
+// Returns every set of paths in m that collide when compared
+// case-insensitively, so that content can be fixed before it's used on
+// case-insensitive filesystems. Paths collide if they're in the same
+// directory and their names are equal under Unicode case folding, as
+// determined by strings.EqualFold. Directories that collide are treated as a
+// single directory when looking for collisions within them, so "Docs/a.txt"
+// and "docs/A.txt" are reported as colliding, in addition to "Docs" and
+// "docs". Like ChangedSince, this requires walking the entire merged FS.
+func (m *MergedFS) CaseCollisions() ([]CaseCollision, error) {
+	var toReturn []CaseCollision
+	e := m.findCaseCollisions([]string{"."}, &toReturn)
+	if e != nil {
+		return nil, e
+	}
+	return toReturn, nil
+}
+
+// Appends the collisions among the entries of the given directories, which
+// are treated as a single directory, and their subdirectories to results.
+func (m *MergedFS) findCaseCollisions(dirs []string,
+	results *[]CaseCollision) error {
+	// Maps fold keys to the paths of the entries with that key, and the
+	// corresponding entries.
+	paths := make(map[string][]string)
+	entries := make(map[string][]fs.DirEntry)
+	var keys []string
+	for _, dir := range dirs {
+		dirEntries, e := m.ReadDir(dir)
+		if e != nil {
+			return e
+		}
+		for _, entry := range dirEntries {
+			key := caseFoldKey(entry.Name())
+			if paths[key] == nil {
+				keys = append(keys, key)
+			}
+			p := entry.Name()
+			if dir != "." {
+				p = dir + "/" + p
+			}
+			paths[key] = append(paths[key], p)
+			entries[key] = append(entries[key], entry)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if len(paths[key]) > 1 {
+			*results = append(*results, newCaseCollision(paths[key],
+				entries[key]))
+		}
+		var subdirs []string
+		for i, entry := range entries[key] {
+			if entry.IsDir() {
+				subdirs = append(subdirs, paths[key][i])
+			}
+		}
+		if len(subdirs) == 0 {
+			continue
+		}
+		e := m.findCaseCollisions(subdirs, results)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func newCaseCollision(paths []string, entries []fs.DirEntry) CaseCollision {
+	indices := make([]int, len(paths))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.Slice(indices, func(a, b int) bool {
+		return paths[indices[a]] < paths[indices[b]]
+	})
+	toReturn := CaseCollision{
+		Paths:  make([]string, len(paths)),
+		Layers: make([]int, len(paths)),
+	}
+	for i, index := range indices {
+		toReturn.Paths[i] = paths[index]
+		toReturn.Layers[i] = -1
+		if p, ok := entries[index].(ProvenanceEntry); ok {
+			toReturn.Layers[i], _ = p.Provenance()
+		}
+	}
+	return toReturn
+}
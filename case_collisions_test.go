@@ -0,0 +1,51 @@
+package merged_fs
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCaseCollisions(t *testing.T) {
+	fsA := fstest.MapFS{
+		"README.md":     newMapFile("A"),
+		"Docs/a.txt":    newMapFile("A"),
+		"Straße.txt":    newMapFile("A"),
+		"unique/x.txt":  newMapFile("A"),
+		"same/name.txt": newMapFile("A"),
+	}
+	fsB := fstest.MapFS{
+		"Readme.md":     newMapFile("B"),
+		"docs/A.txt":    newMapFile("B"),
+		"docs/b.txt":    newMapFile("B"),
+		"STRASSE.txt":   newMapFile("B"),
+		"same/name.txt": newMapFile("B"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	collisions, e := merged.CaseCollisions()
+	if e != nil {
+		t.Logf("Failed finding collisions: %s\n", e)
+		t.FailNow()
+	}
+	var found []string
+	for _, c := range collisions {
+		found = append(found, strings.Join(c.Paths, ","))
+	}
+	expected := "Docs,docs Docs/a.txt,docs/A.txt README.md,Readme.md"
+	if strings.Join(found, " ") != expected {
+		t.Logf("Got wrong collisions: %v\n", found)
+		t.FailNow()
+	}
+	readme := collisions[2]
+	if (readme.Layers[0] != 0) || (readme.Layers[1] != 1) {
+		t.Logf("Got wrong layers for README collision: %v\n", readme.Layers)
+		t.FailNow()
+	}
+
+	collisions, e = NewMergedFS(fsA, fstest.MapFS{}).CaseCollisions()
+	if (e != nil) || (len(collisions) != 0) {
+		t.Logf("Expected no collisions in one layer, got %v (%v)\n",
+			collisions, e)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,40 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"time"
+)
+
+// Returns the paths of all regular files visible in m with modification times
+// after t, in lexical order. This requires walking the entire merged FS, so
+// it's best combined with directory caching if called often.
+//
+// This relies solely on the modification times reported by the underlying
+// FSs, so it can't detect files that were deleted, and can't detect changes
+// in FSs that don't report meaningful modification times (such as the FSs
+// created by go:embed, where every file's time is zero). A file that becomes
+// visible because a higher-priority copy was deleted will only be reported if
+// the newly visible copy's own modification time is after t.
+func (m *MergedFS) ChangedSince(t time.Time) ([]string, error) {
+	var toReturn []string
+	e := fs.WalkDir(m, ".", func(path string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, e := d.Info()
+		if e != nil {
+			return e
+		}
+		if info.ModTime().After(t) {
+			toReturn = append(toReturn, path)
+		}
+		return nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	return toReturn, nil
+}
@@ -0,0 +1,35 @@
+package merged_fs
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestChangedSince(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cutoff := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsA := fstest.MapFS{
+		"a.txt":       &fstest.MapFile{Data: []byte("a"), ModTime: recent},
+		"dir/old.txt": &fstest.MapFile{Data: []byte("o"), ModTime: old},
+		"shadow.txt":  &fstest.MapFile{Data: []byte("s"), ModTime: old},
+	}
+	fsB := fstest.MapFS{
+		"dir/new.txt": &fstest.MapFile{Data: []byte("n"), ModTime: recent},
+		"shadow.txt":  &fstest.MapFile{Data: []byte("s"), ModTime: recent},
+		"b.txt":       &fstest.MapFile{Data: []byte("b"), ModTime: old},
+	}
+	merged := NewMergedFS(fsA, fsB)
+	changed, e := merged.ChangedSince(cutoff)
+	if e != nil {
+		t.Logf("ChangedSince failed: %s\n", e)
+		t.FailNow()
+	}
+	// shadow.txt must not be included, since the visible copy is old.
+	if strings.Join(changed, ",") != "a.txt,dir/new.txt" {
+		t.Logf("Got incorrect list of changed files: %v\n", changed)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,167 @@
+package merged_fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Whether a file contains text or binary data, as returned by ClassifyFile.
+type FileClass uint8
+
+const (
+	// The zero value, which ClassifyFile never returns. Stored in indexes
+	// for paths that weren't classified.
+	unclassified FileClass = iota
+	// The file contains text, such as source code or markup.
+	TextFile
+	// The file contains binary data, such as an image or an executable.
+	BinaryFile
+)
+
+func (c FileClass) String() string {
+	switch c {
+	case TextFile:
+		return "text"
+	case BinaryFile:
+		return "binary"
+	}
+	return fmt.Sprintf("FileClass(%d)", uint8(c))
+}
+
+// May be implemented by FSs that can classify their files more cheaply than
+// ClassifyFile, e.g. by caching the results. MergedFS and IndexFS implement
+// it.
+type ClassifyFS interface {
+	fs.FS
+	// Returns the class of the regular file at path, or an error if it's a
+	// directory.
+	Classify(path string) (FileClass, error)
+}
+
+// Extensions of formats that are always text, regardless of their content.
+var textExtensions = map[string]bool{
+	".c": true, ".cc": true, ".cfg": true, ".conf": true, ".cpp": true,
+	".cs": true, ".css": true, ".csv": true, ".go": true, ".h": true,
+	".htm": true, ".html": true, ".ini": true, ".java": true, ".js": true,
+	".json": true, ".jsx": true, ".lua": true, ".md": true, ".mjs": true,
+	".php": true, ".py": true, ".rb": true, ".rs": true, ".scss": true,
+	".sh": true, ".sql": true, ".svg": true, ".tmpl": true, ".toml": true,
+	".ts": true, ".tsx": true, ".tsv": true, ".txt": true, ".xml": true,
+	".yaml": true, ".yml": true,
+}
+
+// Extensions of formats that are always binary, in addition to
+// compressedExtensions.
+var binaryExtensions = map[string]bool{
+	".a": true, ".bin": true, ".bmp": true, ".class": true, ".dll": true,
+	".exe": true, ".ico": true, ".o": true, ".otf": true, ".pdf": true,
+	".so": true, ".tif": true, ".tiff": true, ".ttf": true, ".wasm": true,
+	".wav": true,
+}
+
+// The number of bytes examined when classifying a file by its content.
+const classificationSampleSize = 8000
+
+// Returns the class of a file with the given extension, or unclassified if
+// the extension isn't recognized.
+func classifyExtension(p string) FileClass {
+	ext := strings.ToLower(path.Ext(p))
+	if textExtensions[ext] {
+		return TextFile
+	}
+	if binaryExtensions[ext] || compressedExtensions[ext] {
+		return BinaryFile
+	}
+	return unclassified
+}
+
+// Returns the class of data sampled from the start of a file.
+func classifyContent(sample []byte) FileClass {
+	for _, b := range sample {
+		if b == 0 {
+			return BinaryFile
+		}
+	}
+	if strings.HasPrefix(http.DetectContentType(sample), "text/") {
+		return TextFile
+	}
+	return BinaryFile
+}
+
+// Returns whether the regular file at p in fsys contains text or binary
+// data, for tools such as search features, diff viewers, or previews that
+// need to decide how to display it. If fsys implements ClassifyFS, its
+// Classify method is used. Otherwise, files with common extensions, such as
+// ".html" or ".png", are classified by their extension without reading them.
+// Other files are classified by their first 8000 bytes: files containing a
+// NUL byte, or detected as non-text by http.DetectContentType, are binary.
+// Empty files are text. Returns an error if p is a directory.
+func ClassifyFile(fsys fs.FS, p string) (FileClass, error) {
+	if classifyFS, ok := fsys.(ClassifyFS); ok {
+		return classifyFS.Classify(p)
+	}
+	return classifyFile(fsys, p)
+}
+
+// Implements ClassifyFile for FSs that don't implement ClassifyFS.
+func classifyFile(fsys fs.FS, p string) (FileClass, error) {
+	f, e := fsys.Open(p)
+	if e != nil {
+		return unclassified, e
+	}
+	defer f.Close()
+	info, e := f.Stat()
+	if e != nil {
+		return unclassified, e
+	}
+	if info.IsDir() {
+		return unclassified, &fs.PathError{Op: "classify", Path: p,
+			Err: fmt.Errorf("%s is a directory", p)}
+	}
+	if c := classifyExtension(p); c != unclassified {
+		return c, nil
+	}
+	sample := make([]byte, classificationSampleSize)
+	n, e := io.ReadFull(f, sample)
+	if (e != nil) && !errors.Is(e, io.EOF) &&
+		!errors.Is(e, io.ErrUnexpectedEOF) {
+		return unclassified, e
+	}
+	return classifyContent(sample[:n]), nil
+}
+
+// Holds the cache used by MergedFS.Classify.
+type fileClasses struct {
+	mutex sync.Mutex
+	cache map[string]FileClass
+}
+
+// Implements ClassifyFS, classifying the file at p in the same way as
+// ClassifyFile. Results are cached per path until the caches are cleared by
+// NotifyChanged, so changes to the contents of m's layers may not be
+// reflected until then.
+func (m *MergedFS) Classify(p string) (FileClass, error) {
+	m.fileClasses.mutex.Lock()
+	c, ok := m.fileClasses.cache[p]
+	m.fileClasses.mutex.Unlock()
+	if ok {
+		return c, nil
+	}
+	c, e := classifyFile(m, p)
+	if e != nil {
+		return unclassified, e
+	}
+	m.fileClasses.mutex.Lock()
+	if m.fileClasses.cache == nil {
+		m.fileClasses.cache = make(map[string]FileClass)
+	}
+	m.fileClasses.cache[p] = c
+	m.fileClasses.mutex.Unlock()
+	return c, nil
+}
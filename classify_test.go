@@ -0,0 +1,131 @@
+package merged_fs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestClassifyFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"image.png":     newMapFile("not really a PNG"),
+		"page.html":     newMapFile("\x00 not really HTML"),
+		"README":        newMapFile("Plain text, café\n"),
+		"data":          newMapFile("abc\x00def"),
+		"empty":         newMapFile(""),
+		"dir/child.txt": newMapFile("child"),
+	}
+	expected := map[string]FileClass{
+		"image.png": BinaryFile,
+		"page.html": TextFile,
+		"README":    TextFile,
+		"data":      BinaryFile,
+		"empty":     TextFile,
+	}
+	for p, want := range expected {
+		c, e := ClassifyFile(fsys, p)
+		if e != nil {
+			t.Logf("Failed classifying %s: %s\n", p, e)
+			t.FailNow()
+		}
+		if c != want {
+			t.Logf("Expected %s to be %s, got %s\n", p, want, c)
+			t.FailNow()
+		}
+	}
+	_, e := ClassifyFile(fsys, "dir")
+	if e == nil {
+		t.Logf("Didn't get expected error classifying a directory\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error classifying a directory: %s\n", e)
+}
+
+func TestClassifyCaching(t *testing.T) {
+	fsA := &openCountingFS{FS: fstest.MapFS{
+		"data":       newMapFile("abc\x00def"),
+		"notes/todo": newMapFile("text"),
+	}}
+	fsB := fstest.MapFS{
+		"notes/done": newMapFile("more text"),
+	}
+	m := NewMergedFS(fsA, fsB)
+	c, e := ClassifyFile(m, "data")
+	if e != nil {
+		t.Logf("Failed classifying data: %s\n", e)
+		t.FailNow()
+	}
+	if c != BinaryFile {
+		t.Logf("Expected data to be binary, got %s\n", c)
+		t.FailNow()
+	}
+	opens := atomic.LoadInt64(&fsA.opens)
+	c, e = m.Classify("data")
+	if (e != nil) || (c != BinaryFile) {
+		t.Logf("Classifying data again got %s, %v\n", c, e)
+		t.FailNow()
+	}
+	if atomic.LoadInt64(&fsA.opens) != opens {
+		t.Logf("Classifying data again wasn't cached\n")
+		t.FailNow()
+	}
+	stats := m.MemoryStats()
+	if stats.ClassCacheEntries != 1 {
+		t.Logf("Expected 1 cached class, got %d\n", stats.ClassCacheEntries)
+		t.FailNow()
+	}
+	m.NotifyChanged("data")
+	if m.MemoryStats().ClassCacheEntries != 0 {
+		t.Logf("NotifyChanged didn't clear the cached classes\n")
+		t.FailNow()
+	}
+
+	// Indexes record the classes, so classifying a file doesn't open it.
+	var buf bytes.Buffer
+	e = m.WriteIndex(&buf)
+	if e != nil {
+		t.Logf("Failed writing index: %s\n", e)
+		t.FailNow()
+	}
+	indexPath := filepath.Join(t.TempDir(), "merged.index")
+	e = os.WriteFile(indexPath, buf.Bytes(), 0644)
+	if e != nil {
+		t.Logf("Failed saving index: %s\n", e)
+		t.FailNow()
+	}
+	index, e := OpenIndex(NewMergedFS(fsA, fsB), indexPath)
+	if e != nil {
+		t.Logf("Failed opening index: %s\n", e)
+		t.FailNow()
+	}
+	defer index.Close()
+	opens = atomic.LoadInt64(&fsA.opens)
+	expected := map[string]FileClass{
+		"data":       BinaryFile,
+		"notes/todo": TextFile,
+		"notes/done": TextFile,
+	}
+	for p, want := range expected {
+		c, e = ClassifyFile(index, p)
+		if e != nil {
+			t.Logf("Failed classifying %s using the index: %s\n", p, e)
+			t.FailNow()
+		}
+		if c != want {
+			t.Logf("Expected %s to be %s, got %s\n", p, want, c)
+			t.FailNow()
+		}
+	}
+	if atomic.LoadInt64(&fsA.opens) != opens {
+		t.Logf("Classifying files using the index opened them\n")
+		t.FailNow()
+	}
+	_, e = index.Classify("notes")
+	if e == nil {
+		t.Logf("Didn't get expected error classifying a directory\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,209 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// What a compiled FS serves at a single path.
+type compiledEntry struct {
+	info fs.FileInfo
+	// The directory's entries, in the order returned by the MergedFS. Only
+	// used for directories.
+	entries []fs.DirEntry
+	// The layer serving a regular file. Nil if the file must be opened
+	// through the MergedFS, e.g. because it's an alias or a symlink.
+	layer fs.FS
+	// The index of the layer the entry came from, as reported by
+	// ProvenanceEntry, or -1 if unknown.
+	layerIndex int
+	// True if the path is a symbolic link, so it and anything within it
+	// must be resolved through the MergedFS.
+	link bool
+}
+
+// An immutable snapshot of the decisions made by a MergedFS. See Compile.
+type compiledFS struct {
+	source *MergedFS
+	// Maps every visible path to what's served there. Never modified after
+	// Compile returns, so it's safe to read without locking.
+	paths map[string]*compiledEntry
+}
+
+// Walks the entire merged FS and returns an FS serving the same content, in
+// which every decision m would make (which layer serves each file, which
+// paths are shadowed, and the contents of every directory) has been made in
+// advance. The returned FS never takes locks: opening a directory reads it
+// from memory without accessing any layer, and opening a file opens it
+// directly in the one layer that provides it. This is intended for servers
+// whose layers never change, such as those serving embedded assets.
+//
+// The returned FS is a snapshot: it doesn't reflect later changes to m's
+// layers or settings, so call Compile again if anything changes. Since files
+// are opened directly from their layers, m's middleware, read fallback, read
+// quota, and open-file tracking don't apply to them. Files without
+// provenance (see ProvenanceEntry), such as aliases, and symbolic links and
+// any paths within them, are still opened through m.
+//
+// Returns ConfigErrors if m's settings are invalid, as reported by Validate,
+// or depend on when or by whom paths are opened, since the snapshot would
+// bypass or freeze them.
+func (m *MergedFS) Compile() (fs.FS, error) {
+	e := m.validateFor("Compile")
+	if e != nil {
+		return nil, e
+	}
+	return m.compile()
+}
+
+// Implements Compile, without validating m's settings.
+func (m *MergedFS) compile() (*compiledFS, error) {
+	rootInfo, e := fs.Stat(m, ".")
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't stat the root directory: %w", e)
+	}
+	toReturn := &compiledFS{
+		source: m,
+		paths:  make(map[string]*compiledEntry),
+	}
+	e = toReturn.addDir(m.Layers(), ".", rootInfo)
+	if e != nil {
+		return nil, e
+	}
+	return toReturn, nil
+}
+
+// Adds the directory at p, and everything within it, to c.
+func (c *compiledFS) addDir(layers []fs.FS, p string,
+	info fs.FileInfo) error {
+	entries, e := c.source.ReadDir(p)
+	if e != nil {
+		return fmt.Errorf("Couldn't read directory %s: %w", p, e)
+	}
+	if entry := c.paths[p]; entry != nil {
+		entry.entries = entries
+	} else {
+		c.paths[p] = &compiledEntry{
+			info:       info,
+			entries:    entries,
+			layerIndex: -1,
+		}
+	}
+	for _, entry := range entries {
+		childPath := path.Join(p, entry.Name())
+		childInfo, e := entry.Info()
+		if e != nil {
+			return fmt.Errorf("Couldn't get info for %s: %w", childPath, e)
+		}
+		child := &compiledEntry{
+			info:       childInfo,
+			link:       entry.Type()&fs.ModeSymlink != 0,
+			layerIndex: -1,
+		}
+		if provenance, ok := entry.(ProvenanceEntry); ok {
+			index, _ := provenance.Provenance()
+			if (index >= 0) && (index < len(layers)) {
+				child.layerIndex = index
+			}
+		}
+		c.paths[childPath] = child
+		if entry.IsDir() {
+			e = c.addDir(layers, childPath, childInfo)
+			if e != nil {
+				return e
+			}
+			continue
+		}
+		if !child.link && (child.layerIndex >= 0) {
+			child.layer = layers[child.layerIndex]
+		}
+	}
+	return nil
+}
+
+// Returns the entry for the path, or nil if it must be resolved through the
+// MergedFS because it's within a symbolic link. Returns an error if the path
+// doesn't exist.
+func (c *compiledFS) lookup(op, p string) (*compiledEntry, error) {
+	if !fs.ValidPath(p) {
+		return nil, &fs.PathError{Op: op, Path: p, Err: fs.ErrInvalid}
+	}
+	if entry := c.paths[p]; entry != nil {
+		if entry.link {
+			return nil, nil
+		}
+		return entry, nil
+	}
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if entry := c.paths[dir]; entry != nil {
+			if entry.link {
+				return nil, nil
+			}
+			break
+		}
+	}
+	return nil, &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+}
+
+func (c *compiledFS) Open(p string) (fs.File, error) {
+	entry, e := c.lookup("open", p)
+	if e != nil {
+		return nil, e
+	}
+	switch {
+	case entry == nil:
+		return c.source.Open(p)
+	case entry.info.IsDir():
+		return &completedDir{
+			MergedDirectory: &MergedDirectory{
+				name:    entry.info.Name(),
+				mode:    entry.info.Mode(),
+				entries: entry.entries,
+			},
+			info: entry.info,
+		}, nil
+	case entry.layer == nil:
+		return c.source.Open(p)
+	}
+	return entry.layer.Open(p)
+}
+
+func (c *compiledFS) ReadFile(p string) ([]byte, error) {
+	entry, e := c.lookup("readfile", p)
+	if e != nil {
+		return nil, e
+	}
+	if (entry == nil) || (entry.layer == nil) {
+		return c.source.ReadFile(p)
+	}
+	return fs.ReadFile(entry.layer, p)
+}
+
+func (c *compiledFS) Stat(p string) (fs.FileInfo, error) {
+	entry, e := c.lookup("stat", p)
+	if e != nil {
+		return nil, e
+	}
+	if entry == nil {
+		return fs.Stat(c.source, p)
+	}
+	return entry.info, nil
+}
+
+func (c *compiledFS) ReadDir(p string) ([]fs.DirEntry, error) {
+	entry, e := c.lookup("readdir", p)
+	if e != nil {
+		return nil, e
+	}
+	if entry == nil {
+		return c.source.ReadDir(p)
+	}
+	if !entry.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: p,
+			Err: fmt.Errorf("%w: not a directory", fs.ErrInvalid)}
+	}
+	toReturn := make([]fs.DirEntry, len(entry.entries))
+	copy(toReturn, entry.entries)
+	return toReturn, nil
+}
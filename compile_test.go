@@ -0,0 +1,66 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCompile(t *testing.T) {
+	fsA := &openCountingFS{FS: fstest.MapFS{
+		"dir/a.txt": newMapFile("in A"),
+		"shared":    newMapFile("a file in A"),
+	}}
+	fsB := &openCountingFS{FS: fstest.MapFS{
+		"dir/b.txt":        newMapFile("in B"),
+		"shared/hidden":    newMapFile("hidden by A"),
+		"only_b/child.txt": newMapFile("only in B"),
+	}}
+	m := NewMergedFS(fsA, fsB)
+	e := m.Alias("alias.txt", "dir/b.txt")
+	if e != nil {
+		t.Logf("Failed adding alias: %s\n", e)
+		t.FailNow()
+	}
+	compiled, e := m.Compile()
+	if e != nil {
+		t.Logf("Failed compiling merge: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(compiled, "dir/a.txt", "dir/b.txt", "shared",
+		"only_b/child.txt", "alias.txt")
+	if e != nil {
+		t.Logf("Compiled FS failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	_, e = compiled.Open("shared/hidden")
+	if e == nil {
+		t.Logf("Didn't get an error opening a shadowed path\n")
+		t.FailNow()
+	}
+
+	// Directories are served without touching either layer, and files from
+	// the one layer containing them.
+	opensA := atomic.LoadInt64(&fsA.opens)
+	opensB := atomic.LoadInt64(&fsB.opens)
+	entries, e := fs.ReadDir(compiled, "dir")
+	if (e != nil) || (len(entries) != 2) {
+		t.Logf("Failed reading dir: %v, %v\n", entries, e)
+		t.FailNow()
+	}
+	content, e := fs.ReadFile(compiled, "dir/b.txt")
+	if (e != nil) || (string(content) != "in B") {
+		t.Logf("Failed reading dir/b.txt: %q, %v\n", content, e)
+		t.FailNow()
+	}
+	if atomic.LoadInt64(&fsA.opens) != opensA {
+		t.Logf("Compiled FS opened a file in A unnecessarily\n")
+		t.FailNow()
+	}
+	if atomic.LoadInt64(&fsB.opens) != opensB+1 {
+		t.Logf("Expected exactly 1 open in B, got %d\n",
+			atomic.LoadInt64(&fsB.opens)-opensB)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,147 @@
+package merged_fs
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Options for ExportZipWithOptions and ExportTarWithOptions. The zero value
+// gives the same behavior as ExportZip and ExportTar. Files exported to zip
+// archives that keep the compression method from their original zip headers
+// aren't affected by the compression options.
+type ExportOptions struct {
+	// Zip only. Files smaller than this many bytes are stored in the archive
+	// uncompressed rather than deflated, since deflating tiny files costs
+	// time and rarely saves space.
+	StoreSmallerThan int64
+	// Zip only. If true, files that appear to be compressed already are
+	// stored uncompressed rather than deflated. This includes files with the
+	// extensions of common compressed formats such as ".png", ".jpg", ".mp4",
+	// or ".zip", and files whose first 64 KiB don't deflate to less than 90%
+	// of their size.
+	DetectCompressed bool
+	// Tar only. The name of a compressor, registered using
+	// RegisterCompressor, used to compress the entire tar stream. "gzip" is
+	// always available. The tar stream isn't compressed if this is empty.
+	TarCompressor string
+	// If non-empty, only files matching at least one of these patterns are
+	// exported. Patterns use the same syntax as AddPriorityOverride, e.g.
+	// "static/**" or "**/*.json".
+	Include []string
+	// Files and directories matching any of these patterns aren't exported,
+	// even if they match an Include pattern. Excluding a directory excludes
+	// everything within it.
+	Exclude []string
+	// If non-nil, a JSON manifest of the exported files is written here after
+	// the export completes. See ExportManifest.
+	Manifest io.Writer
+}
+
+// Returns a WriteCloser that compresses the data written to it and writes it
+// to w. Closing it must flush any buffered data, but mustn't close w.
+type Compressor func(w io.Writer) (io.WriteCloser, error)
+
+var (
+	compressorsMutex sync.RWMutex
+	compressors      = map[string]Compressor{
+		"gzip": func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+	}
+)
+
+// Makes a compressor available for ExportOptions.TarCompressor under the given
+// name, replacing any compressor already registered with the name. This
+// package only depends on the standard library, so it only provides "gzip",
+// but others can be registered by programs that need them. For example, using
+// github.com/klauspost/compress/zstd:
+//
+//	merged_fs.RegisterCompressor("zstd", func(w io.Writer) (io.WriteCloser,
+//		error) {
+//		return zstd.NewWriter(w)
+//	})
+func RegisterCompressor(name string, c Compressor) {
+	compressorsMutex.Lock()
+	defer compressorsMutex.Unlock()
+	compressors[name] = c
+}
+
+// Returns the compressor registered with the given name.
+func getCompressor(name string) (Compressor, error) {
+	compressorsMutex.RLock()
+	defer compressorsMutex.RUnlock()
+	c := compressors[name]
+	if c == nil {
+		return nil, fmt.Errorf("No compressor named %q is registered", name)
+	}
+	return c, nil
+}
+
+// Extensions of formats that are already compressed, so deflating them is a
+// waste of time.
+var compressedExtensions = map[string]bool{
+	".7z": true, ".avif": true, ".br": true, ".bz2": true, ".flac": true,
+	".gif": true, ".gz": true, ".heic": true, ".jar": true, ".jpeg": true,
+	".jpg": true, ".m4a": true, ".mkv": true, ".mov": true, ".mp3": true,
+	".mp4": true, ".ogg": true, ".opus": true, ".png": true, ".rar": true,
+	".webm": true, ".webp": true, ".woff": true, ".woff2": true, ".xz": true,
+	".zip": true, ".zst": true,
+}
+
+// The amount of a file's content examined when checking whether it's
+// compressible.
+const compressionSampleSize = 64 * 1024
+
+// Counts the bytes written to it.
+type countingWriter int64
+
+func (c *countingWriter) Write(data []byte) (int, error) {
+	*c += countingWriter(len(data))
+	return len(data), nil
+}
+
+// Returns true if deflating the sample saves at least 10% of its size.
+func sampleCompresses(sample []byte) bool {
+	if len(sample) == 0 {
+		return false
+	}
+	var compressedSize countingWriter
+	w, e := flate.NewWriter(&compressedSize, flate.BestSpeed)
+	if e != nil {
+		return true
+	}
+	w.Write(sample)
+	w.Close()
+	return int64(compressedSize) < int64(len(sample))*9/10
+}
+
+// Returns true if the file at p, with the given size and content, should be
+// stored uncompressed according to the options. Also returns the reader to use
+// for the file's content in place of content, since checking whether it's
+// compressible may consume part of it.
+func (o *ExportOptions) shouldStore(p string, size int64,
+	content io.Reader) (bool, io.Reader, error) {
+	if size < o.StoreSmallerThan {
+		return true, content, nil
+	}
+	if !o.DetectCompressed {
+		return false, content, nil
+	}
+	if compressedExtensions[strings.ToLower(path.Ext(p))] {
+		return true, content, nil
+	}
+	sample := make([]byte, compressionSampleSize)
+	n, e := io.ReadFull(content, sample)
+	if (e != nil) && (e != io.EOF) && (e != io.ErrUnexpectedEOF) {
+		return false, nil, e
+	}
+	sample = sample[:n]
+	return !sampleCompresses(sample),
+		io.MultiReader(bytes.NewReader(sample), content), nil
+}
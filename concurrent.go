@@ -0,0 +1,31 @@
+package merged_fs
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Calls fn(i) for each i in [0, n), running up to GOMAXPROCS calls at once,
+// and returns after all calls have completed.
+func runConcurrently(n int, fn func(i int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
@@ -0,0 +1,297 @@
+package merged_fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// One of the regular files at a path, passed to a ConflictResolver.
+type ConflictCandidate struct {
+	// The index of the layer containing the file, as used by Layers.
+	Layer int
+	// The layer's name, if it's a *Layer with a Name.
+	LayerName string
+	// The file's info, as returned by its Stat method.
+	Info fs.FileInfo
+
+	fsys       fs.FS
+	path       string
+	maxPreview int
+	// Set by the first call to Preview.
+	previewed  bool
+	preview    []byte
+	previewErr error
+}
+
+// Returns the start of the file's content, up to the limit passed to
+// AddConflictResolver; compare the length to Info.Size() to tell whether it
+// was truncated. The first call opens and reads the file, which costs as
+// much as reading the file normally, up to the limit. Later calls return the
+// same content without reading it again. Candidates that aren't previewed
+// aren't read at all.
+func (c *ConflictCandidate) Preview() ([]byte, error) {
+	if c.previewed {
+		return c.preview, c.previewErr
+	}
+	c.previewed = true
+	f, e := c.fsys.Open(c.path)
+	if e != nil {
+		c.previewErr = e
+		return nil, e
+	}
+	defer f.Close()
+	c.preview, c.previewErr = io.ReadAll(io.LimitReader(f,
+		int64(c.maxPreview)))
+	return c.preview, c.previewErr
+}
+
+// Chooses which of several regular files at the same path to serve. The
+// candidates are in priority order, and the function returns the index of
+// the chosen candidate, or -1 to use the normal priority order. If it returns
+// an error, opening the path fails with that error.
+type ConflictResolver func(path string,
+	candidates []*ConflictCandidate) (int, error)
+
+// A ConflictResolver added using AddConflictResolver.
+type conflictRule struct {
+	pattern    string
+	maxPreview int
+	resolve    ConflictResolver
+}
+
+// Calls resolve whenever a path matching the pattern is a regular file in
+// more than one of m's layers, and serves the file it chooses. This allows
+// decisions that depend on content, such as preferring the copy of a
+// configuration file that parses, or an image whose magic bytes match its
+// extension:
+//
+//	e := merged.AddConflictResolver("**/*.json", 64<<10,
+//		func(p string, candidates []*ConflictCandidate) (int, error) {
+//			for i, c := range candidates {
+//				content, e := c.Preview()
+//				if (e == nil) && (int64(len(content)) == c.Info.Size()) &&
+//					json.Valid(content) {
+//					return i, nil
+//				}
+//			}
+//			return -1, nil
+//		})
+//
+// Each candidate's Preview method returns up to maxPreview bytes of its
+// content, reading it only if asked. Resolvers that don't need content, such
+// as NewestWins, can use a maxPreview of 0.
+//
+// Adding resolvers for several patterns allows a different policy for each
+// kind of file, without a single resolver needing to handle every case:
+//
+//	merged.AddConflictResolver("**/*.log", 0, NewestWins)
+//	merged.AddConflictResolver("**/*.bin", 0, LargestWins)
+//
+// Resolving a conflict is expensive: every time a matching path is opened,
+// m opens and stats it in every layer to find the candidates, before calling
+// resolve and reading any previews it requests. Listing a directory does the
+// same for every matching regular file in it, so directory listings report
+// the chosen files. None of this work is cached, so use patterns that match
+// as few paths as possible.
+//
+// Resolvers follow the same rules as priority overrides (see
+// AddPriorityOverride), and only apply to paths not matched by any override
+// or pin. If more than one resolver's pattern matches a path, the one added
+// first is used. resolve may be called concurrently. Returns an error if the
+// pattern is malformed or maxPreview is negative.
+func (m *MergedFS) AddConflictResolver(pattern string, maxPreview int,
+	resolve ConflictResolver) error {
+	e := validatePattern(pattern)
+	if e != nil {
+		return fmt.Errorf("Invalid pattern %q: %w", pattern, e)
+	}
+	if maxPreview < 0 {
+		return fmt.Errorf("Invalid preview limit: %d", maxPreview)
+	}
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+	m.conflictRules = append(m.conflictRules, conflictRule{
+		pattern:    pattern,
+		maxPreview: maxPreview,
+		resolve:    resolve,
+	})
+	m.publish(Event{Path: literalPrefix(pattern), Reason: "override"})
+	return nil
+}
+
+// Returns the candidate chosen by the first rule matching the path, or nil
+// if no rule matches, the path isn't a regular file in more than one layer,
+// or the resolver left the choice to the normal priority order.
+func (m *MergedFS) resolveConflict(ctx context.Context, rules []conflictRule,
+	p string) (*ConflictCandidate, error) {
+	var rule *conflictRule
+	for i := range rules {
+		if matchPattern(rules[i].pattern, p) {
+			rule = &rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return nil, nil
+	}
+	var candidates []*ConflictCandidate
+	for i, layer := range m.Layers() {
+		f, e := openContext(ctx, layer, p)
+		if e != nil {
+			if isBadPathError(e) {
+				continue
+			}
+			return nil, fmt.Errorf("Couldn't open %s in layer %d: %w", p, i,
+				e)
+		}
+		info, e := f.Stat()
+		f.Close()
+		if e != nil {
+			return nil, fmt.Errorf("Couldn't stat %s in layer %d: %w", p, i,
+				e)
+		}
+		if info.IsDir() {
+			continue
+		}
+		candidates = append(candidates, &ConflictCandidate{
+			Layer:      i,
+			LayerName:  layerNameForProvenance(layer),
+			Info:       info,
+			fsys:       layer,
+			path:       p,
+			maxPreview: rule.maxPreview,
+		})
+	}
+	if len(candidates) < 2 {
+		return nil, nil
+	}
+	chosen, e := rule.resolve(p, candidates)
+	if e != nil {
+		return nil, e
+	}
+	if chosen == -1 {
+		traceStep(ctx, "conflict", "", "%q matches, but the resolver used "+
+			"the normal priority order", rule.pattern)
+		return nil, nil
+	}
+	if (chosen < 0) || (chosen >= len(candidates)) {
+		return nil, fmt.Errorf("Conflict resolver for %q chose invalid "+
+			"candidate %d of %d", rule.pattern, chosen, len(candidates))
+	}
+	traceStep(ctx, "conflict", "", "%q matches, and the resolver chose "+
+		"layer %d", rule.pattern, candidates[chosen].Layer)
+	return candidates[chosen], nil
+}
+
+// Opens the file chosen by a conflict resolver at the path, or returns nil
+// if no resolver made a choice.
+func (m *MergedFS) openConflict(ctx context.Context, rules []conflictRule,
+	p string) (fs.File, error) {
+	chosen, e := m.resolveConflict(ctx, rules, p)
+	if e != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: e}
+	}
+	if chosen == nil {
+		return nil, nil
+	}
+	return openContext(ctx, chosen.fsys, p)
+}
+
+// If f is a directory, returns a directory with the same metadata as f, but
+// with any regular files replaced by the ones chosen by conflict resolvers.
+// Closes f if it's replaced.
+func (m *MergedFS) applyConflictsToDir(ctx context.Context,
+	rules []conflictRule, dirPath string, f fs.File) (fs.File, error) {
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return f, nil
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if !info.IsDir() {
+		return f, nil
+	}
+	entries, e := dir.ReadDir(-1)
+	f.Close()
+	if e != nil {
+		return nil, e
+	}
+	entries = append([]fs.DirEntry(nil), entries...)
+	for i, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		chosen, e := m.resolveConflict(ctx, rules,
+			path.Join(dirPath, entry.Name()))
+		if e != nil {
+			return nil, e
+		}
+		if chosen == nil {
+			continue
+		}
+		entries[i] = &provenanceEntry{
+			DirEntry:   infoDirEntry{chosen.Info},
+			layerIndex: chosen.Layer,
+			layerName:  chosen.LayerName,
+		}
+	}
+	return &completedDir{
+		MergedDirectory: &MergedDirectory{
+			name:    info.Name(),
+			mode:    info.Mode(),
+			entries: entries,
+		},
+		info: info,
+	}, nil
+}
+
+// Returns the index of the first candidate for which better returns true
+// when compared with every earlier best candidate.
+func bestCandidate(candidates []*ConflictCandidate,
+	better func(a, b *ConflictCandidate) bool) int {
+	best := 0
+	for i := 1; i < len(candidates); i++ {
+		if better(candidates[i], candidates[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// A ConflictResolver that serves the candidate with the latest modification
+// time, preferring higher-priority layers in case of a tie.
+func NewestWins(path string, candidates []*ConflictCandidate) (int, error) {
+	return bestCandidate(candidates, func(a, b *ConflictCandidate) bool {
+		return a.Info.ModTime().After(b.Info.ModTime())
+	}), nil
+}
+
+// A ConflictResolver that serves the largest candidate, preferring
+// higher-priority layers in case of a tie.
+func LargestWins(path string, candidates []*ConflictCandidate) (int, error) {
+	return bestCandidate(candidates, func(a, b *ConflictCandidate) bool {
+		return a.Info.Size() > b.Info.Size()
+	}), nil
+}
+
+// A ConflictResolver that serves the candidate from the lowest-priority
+// layer, e.g. to let a base layer's copy of some files win over any
+// customizations.
+func LowestPriorityWins(path string, candidates []*ConflictCandidate) (int,
+	error) {
+	return len(candidates) - 1, nil
+}
+
+// A ConflictResolver that always uses the normal priority order. Adding it
+// for a pattern exempts matching paths from any resolvers added later with
+// broader patterns.
+func HighestPriorityWins(path string, candidates []*ConflictCandidate) (int,
+	error) {
+	return -1, nil
+}
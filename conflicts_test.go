@@ -0,0 +1,143 @@
+package merged_fs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestConflictResolver(t *testing.T) {
+	broken := &Layer{Name: "broken", FS: fstest.MapFS{
+		"config.json": &fstest.MapFile{Data: []byte("{not json")},
+		"big.json":    &fstest.MapFile{Data: []byte(`{"a": "aaaaaaaaaa"}`)},
+		"other.txt":   &fstest.MapFile{Data: []byte("broken")},
+	}}
+	valid := &Layer{Name: "valid", FS: fstest.MapFS{
+		"config.json": &fstest.MapFile{Data: []byte(`{"ok": true}`)},
+		"big.json":    &fstest.MapFile{Data: []byte(`{"b": "bbbbbbbbbb"}`)},
+		"other.txt":   &fstest.MapFile{Data: []byte("valid")},
+	}}
+	m := NewMergedFS(broken, valid)
+	previews := 0
+	e := m.AddConflictResolver("*.json", 16,
+		func(p string, candidates []*ConflictCandidate) (int, error) {
+			for i, c := range candidates {
+				content, e := c.Preview()
+				previews++
+				if e != nil {
+					return -1, e
+				}
+				if int64(len(content)) < c.Info.Size() {
+					// Too big to check, so keep the normal order.
+					return -1, nil
+				}
+				if json.Valid(content) {
+					return i, nil
+				}
+			}
+			return -1, nil
+		})
+	if e != nil {
+		t.Logf("Failed adding conflict resolver: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(m, "config.json", "big.json", "other.txt")
+	if e != nil {
+		t.Logf("FS with conflict resolver failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	expected := map[string]string{
+		"config.json": `{"ok": true}`,
+		"big.json":    `{"a": "aaaaaaaaaa"}`,
+		"other.txt":   "broken",
+	}
+	for p, want := range expected {
+		content, e := m.ReadFile(p)
+		if (e != nil) || (string(content) != want) {
+			t.Logf("Got wrong content for %s: %q, %v\n", p, content, e)
+			t.FailNow()
+		}
+	}
+	if previews == 0 {
+		t.Logf("The resolver never previewed any content\n")
+		t.FailNow()
+	}
+
+	e = m.AddConflictResolver("**", -1, nil)
+	if e == nil {
+		t.Logf("Didn't get an error for an invalid preview limit\n")
+		t.FailNow()
+	}
+	failure := errors.New("can't decide")
+	m = NewMergedFS(broken, valid)
+	m.AddConflictResolver("other.txt", 1,
+		func(p string, candidates []*ConflictCandidate) (int, error) {
+			return 0, failure
+		})
+	_, e = fs.ReadFile(m, "other.txt")
+	if !errors.Is(e, failure) {
+		t.Logf("Didn't get the resolver's error: %v\n", e)
+		t.FailNow()
+	}
+}
+
+func TestConflictPolicies(t *testing.T) {
+	now := time.Now()
+	a := fstest.MapFS{
+		"app.log":          &fstest.MapFile{Data: []byte("old"), ModTime: now},
+		"keep/app.log":     &fstest.MapFile{Data: []byte("A"), ModTime: now},
+		"data.bin":         &fstest.MapFile{Data: []byte("small")},
+		"settings.ini":     &fstest.MapFile{Data: []byte("custom")},
+		"logs/service.log": &fstest.MapFile{Data: []byte("old"), ModTime: now},
+	}
+	b := fstest.MapFS{
+		"app.log": &fstest.MapFile{Data: []byte("new"),
+			ModTime: now.Add(time.Hour)},
+		"keep/app.log": &fstest.MapFile{Data: []byte("B"),
+			ModTime: now.Add(time.Hour)},
+		"data.bin":     &fstest.MapFile{Data: []byte("much larger")},
+		"settings.ini": &fstest.MapFile{Data: []byte("base")},
+		"logs/service.log": &fstest.MapFile{Data: []byte("new"),
+			ModTime: now.Add(time.Hour)},
+	}
+	m := NewMergedFS(a, b)
+	policies := []struct {
+		pattern  string
+		resolver ConflictResolver
+	}{
+		{"keep/**", HighestPriorityWins},
+		{"**/*.log", NewestWins},
+		{"*.bin", LargestWins},
+		{"*.ini", LowestPriorityWins},
+	}
+	for _, p := range policies {
+		e := m.AddConflictResolver(p.pattern, 0, p.resolver)
+		if e != nil {
+			t.Logf("Failed adding policy for %s: %s\n", p.pattern, e)
+			t.FailNow()
+		}
+	}
+	expected := map[string]string{
+		"app.log":          "new",
+		"logs/service.log": "new",
+		"keep/app.log":     "A",
+		"data.bin":         "much larger",
+		"settings.ini":     "base",
+	}
+	for p, want := range expected {
+		content, e := fs.ReadFile(m, p)
+		if (e != nil) || (string(content) != want) {
+			t.Logf("Got wrong content for %s: %q, %v\n", p, content, e)
+			t.FailNow()
+		}
+	}
+	e := fstest.TestFS(m, "app.log", "logs/service.log", "keep/app.log",
+		"data.bin", "settings.ini")
+	if e != nil {
+		t.Logf("FS with conflict policies failed fstest: %s\n", e)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,195 @@
+package merged_fs
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// An in-memory cache of the contents of small files from an FS, returned by
+// NewContentCache. Safe for concurrent use.
+type ContentCache struct {
+	fsys        fs.FS
+	maxBytes    int64
+	maxFileSize int64
+	// Protects the fields below.
+	mutex sync.Mutex
+	// Maps paths to elements of lru, which hold *contentCacheEntry values.
+	entries map[string]*list.Element
+	// Cached files, most recently used first.
+	lru *list.List
+	// The total size of the cached content.
+	size int64
+	// Incremented by Clear, so that files read before a Clear aren't added
+	// to the cache after it.
+	generation uint64
+}
+
+// A file cached by a ContentCache.
+type contentCacheEntry struct {
+	path    string
+	content []byte
+	info    fs.FileInfo
+}
+
+// Returns an FS serving the same content as fsys, but that keeps the contents
+// of recently used regular files of up to maxFileSize bytes in memory, up to
+// a total of maxBytes. The least recently used files are evicted first. This
+// is intended for small files that are read often from layers that are slow
+// to read, such as templates in a zip archive:
+//
+//	cached := NewContentCache(zipFS, 16<<20, 256<<10)
+//	merged := NewMergedFS(localOverrides, cached)
+//
+// Cached files are served without accessing fsys, so changes to fsys aren't
+// noticed until Clear is called. Directories, ReadDir, and Stats of files that
+// aren't cached are passed through to fsys.
+func NewContentCache(fsys fs.FS, maxBytes, maxFileSize int64) *ContentCache {
+	return &ContentCache{
+		fsys:        fsys,
+		maxBytes:    maxBytes,
+		maxFileSize: maxFileSize,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// Returns the cached entry for path, marking it as recently used, or nil if
+// path isn't cached. Also returns the cache's current generation, to pass to
+// add if path is read from the underlying FS.
+func (c *ContentCache) get(path string) (*contentCacheEntry, uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element := c.entries[path]
+	if element == nil {
+		return nil, c.generation
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*contentCacheEntry), c.generation
+}
+
+// Adds an entry to the cache, evicting others as needed. Does nothing if the
+// cache was cleared since the given generation, as the entry may be stale.
+func (c *ContentCache) add(entry *contentCacheEntry, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if old := c.entries[entry.path]; old != nil {
+		c.remove(old)
+	}
+	c.entries[entry.path] = c.lru.PushFront(entry)
+	c.size += int64(len(entry.content))
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Removes an element from the cache. Must be called with the mutex held.
+func (c *ContentCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*contentCacheEntry)
+	delete(c.entries, entry.path)
+	c.size -= int64(len(entry.content))
+}
+
+// Removes every file from the cache. Files that were being read from the
+// underlying FS when Clear was called aren't added to the cache afterwards.
+func (c *ContentCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+	c.generation++
+}
+
+// Returns the number of files in the cache and their total size in bytes.
+func (c *ContentCache) Size() (files int, bytes int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries), c.size
+}
+
+func (c *ContentCache) Open(path string) (fs.File, error) {
+	if !fs.ValidPath(path) {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrInvalid}
+	}
+	entry, generation := c.get(path)
+	if entry != nil {
+		return newMemoryFile(entry.content, entry.info), nil
+	}
+	f, e := c.fsys.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if !info.Mode().IsRegular() || (info.Size() > c.maxFileSize) ||
+		(info.Size() > c.maxBytes) {
+		return f, nil
+	}
+	content, e := io.ReadAll(f)
+	f.Close()
+	if e != nil {
+		return nil, e
+	}
+	// Don't trust the size reported by Stat.
+	if int64(len(content)) <= c.maxFileSize {
+		c.add(&contentCacheEntry{
+			path:    path,
+			content: content,
+			info:    info,
+		}, generation)
+	}
+	return newMemoryFile(content, info), nil
+}
+
+func (c *ContentCache) ReadFile(path string) ([]byte, error) {
+	if entry, _ := c.get(path); entry != nil {
+		return append([]byte(nil), entry.content...), nil
+	}
+	f, e := c.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (c *ContentCache) Stat(path string) (fs.FileInfo, error) {
+	if entry, _ := c.get(path); entry != nil {
+		return entry.info, nil
+	}
+	return fs.Stat(c.fsys, path)
+}
+
+func (c *ContentCache) ReadDir(path string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(c.fsys, path)
+}
+
+// A regular file whose content is held in memory.
+type memoryFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func newMemoryFile(content []byte, info fs.FileInfo) *memoryFile {
+	return &memoryFile{
+		Reader: bytes.NewReader(content),
+		info:   info,
+	}
+}
+
+func (f *memoryFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *memoryFile) Close() error {
+	return nil
+}
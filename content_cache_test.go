@@ -0,0 +1,115 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestContentCache(t *testing.T) {
+	layer := &openCountingFS{FS: fstest.MapFS{
+		"templates/a.html": newMapFile("aaaa"),
+		"templates/b.html": newMapFile("bbbb"),
+		"templates/c.html": newMapFile("cccc"),
+		"big.bin":          newMapFile("0123456789"),
+	}}
+	cache := NewContentCache(layer, 8, 4)
+	e := fstest.TestFS(cache, "templates/a.html", "templates/b.html",
+		"templates/c.html", "big.bin")
+	if e != nil {
+		t.Logf("Content cache failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	files, size := cache.Size()
+	if (files != 2) || (size != 8) {
+		t.Logf("Expected 2 files and 8 bytes cached, got %d and %d\n", files,
+			size)
+		t.FailNow()
+	}
+
+	cache.Clear()
+	readFile := func(path, expected string) {
+		content, e := fs.ReadFile(cache, path)
+		if e != nil {
+			t.Logf("Failed reading %s: %s\n", path, e)
+			t.FailNow()
+		}
+		if string(content) != expected {
+			t.Logf("Got wrong content for %s: %s\n", path, content)
+			t.FailNow()
+		}
+	}
+	readFile("templates/a.html", "aaaa")
+	readFile("templates/b.html", "bbbb")
+	// Reading a.html again makes b.html the least recently used.
+	readFile("templates/a.html", "aaaa")
+	readFile("templates/c.html", "cccc")
+	opens := atomic.LoadInt64(&layer.opens)
+	readFile("templates/a.html", "aaaa")
+	readFile("templates/c.html", "cccc")
+	if atomic.LoadInt64(&layer.opens) != opens {
+		t.Logf("Cached files were opened from the layer.\n")
+		t.FailNow()
+	}
+	readFile("templates/b.html", "bbbb")
+	if atomic.LoadInt64(&layer.opens) != opens+1 {
+		t.Logf("Evicted file wasn't opened from the layer.\n")
+		t.FailNow()
+	}
+
+	// Modifying the returned content mustn't modify the cache.
+	content, _ := fs.ReadFile(cache, "templates/b.html")
+	content[0] = 'x'
+	readFile("templates/b.html", "bbbb")
+
+	// Files over the per-file limit are never cached.
+	readFile("big.bin", "0123456789")
+	opens = atomic.LoadInt64(&layer.opens)
+	readFile("big.bin", "0123456789")
+	if atomic.LoadInt64(&layer.opens) != opens+1 {
+		t.Logf("Large file wasn't read from the layer.\n")
+		t.FailNow()
+	}
+}
+
+// Wraps an FS, blocking each Open until a value is received from release,
+// after sending to opening.
+type blockingFS struct {
+	fs.FS
+	opening chan bool
+	release chan bool
+}
+
+func (b *blockingFS) Open(path string) (fs.File, error) {
+	b.opening <- true
+	<-b.release
+	return b.FS.Open(path)
+}
+
+func TestContentCacheClearDuringRead(t *testing.T) {
+	blocking := &blockingFS{
+		FS:      fstest.MapFS{"a.txt": newMapFile("old content")},
+		opening: make(chan bool),
+		release: make(chan bool),
+	}
+	cache := NewContentCache(blocking, 1024, 1024)
+	done := make(chan error)
+	go func() {
+		_, e := cache.ReadFile("a.txt")
+		done <- e
+	}()
+	<-blocking.opening
+	cache.Clear()
+	close(blocking.release)
+	e := <-done
+	if e != nil {
+		t.Logf("Failed reading a.txt: %s\n", e)
+		t.FailNow()
+	}
+	files, _ := cache.Size()
+	if files != 0 {
+		t.Logf("Cached a file read before Clear\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,131 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Holds the state used by MergedFS.ContentType.
+type contentTypes struct {
+	mutex sync.Mutex
+	// Maps lowercase extensions, including the leading dot, to MIME types.
+	overrides map[string]string
+	// Detects the MIME types of files with unknown extensions from their
+	// first 512 bytes. Nil unless set using SetContentTypeDetector.
+	detect func(header []byte) string
+	// Maps paths to the MIME types detected for them.
+	cache map[string]string
+	// Incremented whenever the cache is cleared, so that results computed
+	// using old settings aren't added to the new cache.
+	generation uint64
+}
+
+// Clears the cache. Must be called with the mutex held.
+func (c *contentTypes) clearCache() {
+	c.cache = nil
+	c.generation++
+}
+
+// Sets the MIME types returned by ContentType for files with the given
+// extensions, replacing any previous overrides, and clears ContentType's
+// cache. Keys are extensions including the leading dot, e.g. ".wasm", and
+// are matched case-insensitively. Pass nil to remove all overrides.
+func (m *MergedFS) SetContentTypes(overrides map[string]string) {
+	lowercase := make(map[string]string, len(overrides))
+	for ext, mimeType := range overrides {
+		lowercase[strings.ToLower(ext)] = mimeType
+	}
+	m.contentTypes.mutex.Lock()
+	m.contentTypes.overrides = lowercase
+	m.contentTypes.clearCache()
+	m.contentTypes.mutex.Unlock()
+}
+
+// Sets the function ContentType uses to detect the MIME types of files whose
+// extensions it doesn't know, and clears ContentType's cache. The function
+// receives up to the first 512 bytes of the file. http.DetectContentType is
+// a suitable choice; it isn't used by default to avoid making this package
+// depend on net/http. Pass nil to stop detecting types from file contents.
+func (m *MergedFS) SetContentTypeDetector(detect func(header []byte) string) {
+	m.contentTypes.mutex.Lock()
+	m.contentTypes.detect = detect
+	m.contentTypes.clearCache()
+	m.contentTypes.mutex.Unlock()
+}
+
+// Returns the MIME type of the file at p, for use in places such as HTTP
+// Content-Type headers or metadata in exported archives. The type is taken
+// from the overrides set using SetContentTypes if one matches p's extension,
+// or from mime.TypeByExtension otherwise. If neither knows the extension,
+// the type is detected from the file's first 512 bytes using the function
+// set by SetContentTypeDetector, or is "application/octet-stream" if no
+// detector was set. Directories have no MIME type, and return an error.
+//
+// Results are cached per path until SetContentTypes or
+// SetContentTypeDetector is called again, so changes to the contents of m's
+// layers may not be reflected.
+func (m *MergedFS) ContentType(p string) (string, error) {
+	m.contentTypes.mutex.Lock()
+	mimeType, ok := m.contentTypes.cache[p]
+	overrides := m.contentTypes.overrides
+	detect := m.contentTypes.detect
+	generation := m.contentTypes.generation
+	m.contentTypes.mutex.Unlock()
+	if ok {
+		return mimeType, nil
+	}
+	mimeType, e := m.detectContentType(overrides, detect, p)
+	if e != nil {
+		return "", e
+	}
+	m.contentTypes.mutex.Lock()
+	if m.contentTypes.generation == generation {
+		if m.contentTypes.cache == nil {
+			m.contentTypes.cache = make(map[string]string)
+		}
+		m.contentTypes.cache[p] = mimeType
+	}
+	m.contentTypes.mutex.Unlock()
+	return mimeType, nil
+}
+
+// Implements ContentType without caching.
+func (m *MergedFS) detectContentType(overrides map[string]string,
+	detect func(header []byte) string, p string) (string, error) {
+	f, e := m.Open(p)
+	if e != nil {
+		return "", e
+	}
+	defer f.Close()
+	info, e := f.Stat()
+	if e != nil {
+		return "", e
+	}
+	if info.IsDir() {
+		return "", &fs.PathError{Op: "contenttype", Path: p,
+			Err: fmt.Errorf("%s is a directory", p)}
+	}
+	ext := strings.ToLower(path.Ext(p))
+	if mimeType, ok := overrides[ext]; ok {
+		return mimeType, nil
+	}
+	if ext != "" {
+		if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+			return mimeType, nil
+		}
+	}
+	if detect == nil {
+		return "application/octet-stream", nil
+	}
+	header := make([]byte, 512)
+	n, e := io.ReadFull(f, header)
+	if (e != nil) && (e != io.EOF) && (e != io.ErrUnexpectedEOF) {
+		return "", e
+	}
+	return detect(header[:n]), nil
+}
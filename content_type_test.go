@@ -0,0 +1,97 @@
+package merged_fs
+
+import (
+	"net/http"
+	"testing"
+	"testing/fstest"
+)
+
+func TestContentType(t *testing.T) {
+	fsA := fstest.MapFS{
+		"index.html":  newMapFile("<html></html>"),
+		"module.wasm": newMapFile("\x00asm"),
+		"README":      newMapFile("Plain text"),
+		"dir/a.txt":   newMapFile("a"),
+	}
+	fsB := fstest.MapFS{
+		"data.unknownext": newMapFile("\x89PNG\r\n\x1a\n"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	merged.SetContentTypes(map[string]string{".WASM": "application/x-test"})
+	merged.SetContentTypeDetector(http.DetectContentType)
+	expected := map[string]string{
+		"index.html":      "text/html; charset=utf-8",
+		"module.wasm":     "application/x-test",
+		"README":          "text/plain; charset=utf-8",
+		"data.unknownext": "image/png",
+	}
+	for p, mimeType := range expected {
+		found, e := merged.ContentType(p)
+		if e != nil {
+			t.Logf("Failed getting content type of %s: %s\n", p, e)
+			t.FailNow()
+		}
+		if found != mimeType {
+			t.Logf("Got content type %q for %s, expected %q\n", found, p,
+				mimeType)
+			t.FailNow()
+		}
+	}
+	if len(merged.contentTypes.cache) != len(expected) {
+		t.Logf("Expected %d cached content types, got %d\n", len(expected),
+			len(merged.contentTypes.cache))
+		t.FailNow()
+	}
+	_, e := merged.ContentType("dir")
+	if e == nil {
+		t.Logf("Didn't get expected error for a directory's content type.\n")
+		t.FailNow()
+	}
+	merged.SetContentTypes(nil)
+	if len(merged.contentTypes.cache) != 0 {
+		t.Logf("SetContentTypes didn't clear the cache.\n")
+		t.FailNow()
+	}
+}
+
+func TestContentTypeDetector(t *testing.T) {
+	merged := NewMergedFS(fstest.MapFS{
+		"README": newMapFile("Plain text"),
+	}, fstest.MapFS{})
+	mimeType, e := merged.ContentType("README")
+	if e != nil {
+		t.Logf("Failed getting content type without a detector: %s\n", e)
+		t.FailNow()
+	}
+	if mimeType != "application/octet-stream" {
+		t.Logf("Got content type %q without a detector\n", mimeType)
+		t.FailNow()
+	}
+
+	// Block the detector until the overrides have been replaced, to make sure
+	// the result computed before the replacement isn't cached.
+	detecting := make(chan bool)
+	release := make(chan bool)
+	merged.SetContentTypeDetector(func(header []byte) string {
+		detecting <- true
+		<-release
+		return "text/x-stale"
+	})
+	done := make(chan error)
+	go func() {
+		_, e := merged.ContentType("README")
+		done <- e
+	}()
+	<-detecting
+	merged.SetContentTypes(nil)
+	close(release)
+	e = <-done
+	if e != nil {
+		t.Logf("Failed getting content type: %s\n", e)
+		t.FailNow()
+	}
+	if len(merged.contentTypes.cache) != 0 {
+		t.Logf("Cached a content type computed before SetContentTypes\n")
+		t.FailNow()
+	}
+}
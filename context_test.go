@@ -0,0 +1,73 @@
+package merged_fs
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+type tenantKey struct{}
+
+func TestOpenContext(t *testing.T) {
+	// Each tenant has its own layer, which is only visible to its requests.
+	newTenantLayer := func(tenant, content string) fs.FS {
+		return &Layer{
+			FS: fstest.MapFS{"logo.txt": newMapFile(content)},
+			Visible: func(ctx context.Context, path string) bool {
+				return ctx.Value(tenantKey{}) == tenant
+			},
+		}
+	}
+	merged := MergeMultiple(
+		newTenantLayer("x", "x logo"),
+		newTenantLayer("y", "y logo"),
+		fstest.MapFS{"logo.txt": newMapFile("default logo")},
+	).(*MergedFS)
+	var opened []string
+	built := 0
+	merged.Use(func(next Opener) Opener {
+		built++
+		return func(ctx context.Context, path string) (fs.File, error) {
+			opened = append(opened, ctx.Value(tenantKey{}).(string))
+			return next(ctx, path)
+		}
+	})
+	expected := map[string]string{
+		"x": "x logo",
+		"y": "y logo",
+		"z": "default logo",
+	}
+	for tenant, content := range expected {
+		ctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+		f, e := merged.OpenContext(ctx, "logo.txt")
+		if e != nil {
+			t.Logf("Failed opening logo for tenant %s: %s\n", tenant, e)
+			t.FailNow()
+		}
+		data := make([]byte, 32)
+		n, _ := f.Read(data)
+		f.Close()
+		if string(data[:n]) != content {
+			t.Logf("Got logo %q for tenant %s, expected %q\n", data[:n],
+				tenant, content)
+			t.FailNow()
+		}
+	}
+	if len(opened) != len(expected) {
+		t.Logf("Middleware saw %d opens, expected %d\n", len(opened),
+			len(expected))
+		t.FailNow()
+	}
+	for _, tenant := range opened {
+		if _, ok := expected[tenant]; !ok {
+			t.Logf("Middleware saw wrong tenant %q\n", tenant)
+			t.FailNow()
+		}
+	}
+	// The middleware chain must be built once, not for every OpenContext.
+	if built != 1 {
+		t.Logf("Middleware chain was built %d times\n", built)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,296 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync/atomic"
+)
+
+// Returns a short, single-line description of an FS used in a merge.
+func describeFS(fsys fs.FS) string {
+	switch v := fsys.(type) {
+	case *MergedFS:
+		return v.String()
+	case *Group:
+		return fmt.Sprintf("Group %q (%s)", v.name, v.MergedFS.String())
+	case *Layer:
+		return v.String()
+	case *mountFS:
+		return fmt.Sprintf("Mount(%s at %q)", describeFS(v.fsys), v.prefix)
+	case *windowsPathFS:
+		return fmt.Sprintf("NormalizeWindowsPaths(%s)", describeFS(v.fsys))
+	case *sanitizedFS:
+		return fmt.Sprintf("Sanitize(%s)", describeFS(v.fsys))
+	case *transcodeFS:
+		return fmt.Sprintf("%s(%s %s)", v.name, describeFS(v.fsys),
+			v.detail)
+	case *replicaFS:
+		descriptions := make([]string, len(v.replicas))
+		for i, r := range v.replicas {
+			descriptions[i] = describeFS(r)
+		}
+		return fmt.Sprintf("Replicas(%s: %s)", v.policy,
+			strings.Join(descriptions, ", "))
+	case *ArchiveDirFS:
+		return fmt.Sprintf("ArchiveDir(%q: %s)", v.dir, describeFS(v.current()))
+	case *dirLayerFS:
+		return fmt.Sprintf("DirLayer(%q)", v.root)
+	case exposedDirLayerFS:
+		return fmt.Sprintf("DirLayer(%q, exposing symlinks)", v.root)
+	case *ContentCache:
+		return fmt.Sprintf("ContentCache(%s)", describeFS(v.fsys))
+	case *compiledFS:
+		return fmt.Sprintf("Compiled(%d paths from %s)", len(v.paths),
+			v.source)
+	case *IndexFS:
+		return fmt.Sprintf("Index(%d paths from %s)",
+			len(v.records)/indexRecordSize, v.source)
+	case *tenantFS:
+		return fmt.Sprintf("TenantView(%q of %s)", v.tenantID, v.m)
+	case *diskCacheFS:
+		return fmt.Sprintf("DiskCache(%s in %q)", describeFS(v.fsys), v.dir)
+	}
+	return fmt.Sprintf("%T", fsys)
+}
+
+// Returns a single-line description of the tree of filesystems in m.
+func (m *MergedFS) String() string {
+	return fmt.Sprintf("MergedFS(%s, %s)", describeFS(m.A), describeFS(m.B))
+}
+
+// Returns a single-line description of the layer.
+func (l *Layer) String() string {
+	if l.Name == "" {
+		return fmt.Sprintf("Layer(%s)", describeFS(l.FS))
+	}
+	return fmt.Sprintf("Layer %q (%s)", l.Name, describeFS(l.FS))
+}
+
+// Writes a line to w at the given indentation level.
+func dumpLine(w io.Writer, depth int, format string,
+	args ...interface{}) error {
+	_, e := fmt.Fprintf(w, "%s%s\n", strings.Repeat("  ", depth),
+		fmt.Sprintf(format, args...))
+	return e
+}
+
+// Recursively writes the description of fsys to w.
+func debugDumpFS(w io.Writer, fsys fs.FS, depth int) error {
+	switch v := fsys.(type) {
+	case *MergedFS:
+		return v.debugDump(w, depth)
+	case *Group:
+		e := dumpLine(w, depth, "Group %q:", v.name)
+		if e != nil {
+			return e
+		}
+		return v.MergedFS.debugDump(w, depth+1)
+	case *Layer:
+		return v.debugDump(w, depth)
+	case *mountFS:
+		e := dumpLine(w, depth, "Mount at %q:", v.prefix)
+		if e != nil {
+			return e
+		}
+		return debugDumpFS(w, v.fsys, depth+1)
+	case *windowsPathFS:
+		e := dumpLine(w, depth, "NormalizeWindowsPaths:")
+		if e != nil {
+			return e
+		}
+		return debugDumpFS(w, v.fsys, depth+1)
+	case *sanitizedFS:
+		e := dumpLine(w, depth, "Sanitize:")
+		if e != nil {
+			return e
+		}
+		return debugDumpFS(w, v.fsys, depth+1)
+	case *transcodeFS:
+		e := dumpLine(w, depth, "%s %s:", v.name, v.detail)
+		if e != nil {
+			return e
+		}
+		return debugDumpFS(w, v.fsys, depth+1)
+	case *ArchiveDirFS:
+		e := dumpLine(w, depth, "Archive dir %q (pattern %q):", v.dir,
+			v.pattern)
+		if e != nil {
+			return e
+		}
+		return debugDumpFS(w, v.current(), depth+1)
+	case *ContentCache:
+		files, size := v.Size()
+		e := dumpLine(w, depth, "Content cache (%d files, %d/%d bytes):",
+			files, size, v.maxBytes)
+		if e != nil {
+			return e
+		}
+		return debugDumpFS(w, v.fsys, depth+1)
+	case *diskCacheFS:
+		e := dumpLine(w, depth, "Disk cache in %q:", v.dir)
+		if e != nil {
+			return e
+		}
+		return debugDumpFS(w, v.fsys, depth+1)
+	case *replicaFS:
+		e := dumpLine(w, depth, "Replicas (%s):", v.policy)
+		if e != nil {
+			return e
+		}
+		for i, r := range v.replicas {
+			if v.policy == ReplicaLeastOutstanding {
+				e = dumpLine(w, depth+1, "replica %d (%d open files):", i,
+					atomic.LoadInt64(&v.outstanding[i]))
+			} else {
+				e = dumpLine(w, depth+1, "replica %d:", i)
+			}
+			if e != nil {
+				return e
+			}
+			e = debugDumpFS(w, r, depth+2)
+			if e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+	return dumpLine(w, depth, "%T", fsys)
+}
+
+func (m *MergedFS) debugDump(w io.Writer, depth int) error {
+	m.okPrefixesMutex.Lock()
+	cachingEnabled := m.prefixCachingEnabled
+	cachedPrefixes := len(m.knownOKPrefixes)
+	m.okPrefixesMutex.Unlock()
+	m.dirCacheMutex.Lock()
+	dirCachingEnabled := m.dirCache != nil
+	cachedDirs := len(m.dirCache)
+	m.dirCacheMutex.Unlock()
+	m.configMutex.RLock()
+	middlewareCount := len(m.middleware)
+	aliasCount := len(m.aliases)
+	pinCount := len(m.pins)
+	conflictRuleCount := len(m.conflictRules)
+	tenantCount := len(m.tenants)
+	strict := m.strictHandler != nil
+	predicting := m.predictor != nil
+	authorizing := m.authorizer != nil
+	auditing := m.auditor != nil
+	meter := m.readMeter
+	tracker := m.openFiles
+	m.configMutex.RUnlock()
+
+	lines := []string{
+		fmt.Sprintf("path caching: %v (%d prefixes cached)", cachingEnabled,
+			cachedPrefixes),
+		fmt.Sprintf("directory caching: %v (%d directories cached)",
+			dirCachingEnabled, cachedDirs),
+		fmt.Sprintf("panic recovery: %v", m.recoveringPanics()),
+		fmt.Sprintf("synthetic root: %v",
+			atomic.LoadInt32(&m.syntheticRoot) != 0),
+		fmt.Sprintf("read fallback: %v",
+			atomic.LoadInt32(&m.readFallback) != 0),
+		fmt.Sprintf("middleware: %d", middlewareCount),
+		fmt.Sprintf("aliases: %d", aliasCount),
+		fmt.Sprintf("pins: %d", pinCount),
+		fmt.Sprintf("conflict resolvers: %d", conflictRuleCount),
+		fmt.Sprintf("tenants: %d", tenantCount),
+		fmt.Sprintf("strict mode: %v", strict),
+		fmt.Sprintf("predictor: %v", predicting),
+		fmt.Sprintf("authorizer: %v", authorizing),
+		fmt.Sprintf("audit sink: %v", auditing),
+		fmt.Sprintf("integrity checks: %v",
+			atomic.LoadInt32(&m.integrityChecks) != 0),
+		fmt.Sprintf("symlink shadowing: %s", SymlinkShadowing(
+			atomic.LoadInt32(&m.symlinkShadowing))),
+	}
+	if meter != nil {
+		lines = append(lines, fmt.Sprintf("bytes read: %d (quota %d)",
+			meter.bytesRead(), meter.limit))
+	}
+	maxEntries := atomic.LoadInt64(&m.maxDirEntries)
+	maxWork := atomic.LoadInt64(&m.maxMergeWork)
+	maxPathDepth := atomic.LoadInt64(&m.maxPathDepth)
+	maxNesting := atomic.LoadInt64(&m.maxNestingDepth)
+	if (maxPathDepth > 0) || (maxNesting > 0) {
+		lines = append(lines, fmt.Sprintf("depth limits: %d components, %d "+
+			"nested merges", maxPathDepth, maxNesting))
+	}
+	if (maxEntries > 0) || (maxWork > 0) {
+		lines = append(lines, fmt.Sprintf("merge limits: %d entries, %d work",
+			maxEntries, maxWork))
+	}
+	if tracker != nil {
+		lines = append(lines, fmt.Sprintf("open files tracked: %d",
+			len(m.OpenFiles())))
+	}
+	e := dumpLine(w, depth, "MergedFS:")
+	if e != nil {
+		return e
+	}
+	for _, line := range lines {
+		e = dumpLine(w, depth+1, "%s", line)
+		if e != nil {
+			return e
+		}
+	}
+	for side := 0; side < 2; side++ {
+		e = dumpLine(w, depth+1, "%s:", []string{"A", "B"}[side])
+		if e != nil {
+			return e
+		}
+		e = debugDumpFS(w, m.layer(side), depth+2)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (l *Layer) debugDump(w io.Writer, depth int) error {
+	e := dumpLine(w, depth, "Layer %q:", l.Name)
+	if e != nil {
+		return e
+	}
+	lines := []string{
+		fmt.Sprintf("max concurrent: %d", l.MaxConcurrent),
+		fmt.Sprintf("ops per second: %g", l.OpsPerSecond),
+		fmt.Sprintf("bytes read: %d (quota %d)", l.BytesRead(), l.ReadQuota),
+	}
+	if l.Root != "" {
+		lines = append(lines, fmt.Sprintf("root: %q", l.Root))
+	}
+	if l.SlowStat {
+		lines = append(lines, "slow stat: true")
+	}
+	if l.sparse != nil {
+		lines = append(lines, fmt.Sprintf("known directories: %d",
+			len(l.sparse.dirs)))
+	}
+	if l.gated {
+		lines = append(lines, fmt.Sprintf("visible: %v", !l.gateClosed()))
+	}
+	if l.BreakerThreshold > 0 {
+		state := l.BreakerState()
+		lines = append(lines, fmt.Sprintf("circuit breaker open: %v (%d "+
+			"consecutive errors)", state.Open, state.ConsecutiveErrors))
+	}
+	for _, line := range lines {
+		e = dumpLine(w, depth+1, "%s", line)
+		if e != nil {
+			return e
+		}
+	}
+	return debugDumpFS(w, l.FS, depth+1)
+}
+
+// Writes a multi-line, human-readable description of m to w, intended to help
+// with debugging. It includes the tree of filesystems making up m (including
+// nested MergedFS instances, such as those created by MergeMultiple), their
+// types, Layer names, the options set on each MergedFS and Layer, and the
+// sizes of their caches. The format of the output may change at any time.
+func (m *MergedFS) DebugDump(w io.Writer) error {
+	return m.debugDump(w, 0)
+}
@@ -0,0 +1,46 @@
+package merged_fs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDebugDump(t *testing.T) {
+	zip1 := openZip("test_data/test_a.zip", t)
+	zip2 := openZip("test_data/test_b.zip", t)
+	fsC := fstest.MapFS{"c.txt": newMapFile("c")}
+	merged := MergeMultiple(zip1, &Layer{FS: zip2, Name: "second",
+		MaxConcurrent: 3}, fsC).(*MergedFS)
+	merged.SetReadQuota(100)
+	_, e := merged.ReadFile("test1.txt")
+	if e != nil {
+		t.Logf("Failed reading test1.txt: %s\n", e)
+		t.FailNow()
+	}
+	s := merged.String()
+	t.Logf("String(): %s\n", s)
+	expected := `MergedFS(*zip.Reader, MergedFS(Layer "second" (*zip.Reader), ` +
+		`fstest.MapFS))`
+	if s != expected {
+		t.Logf("Expected String() to return %s\n", expected)
+		t.FailNow()
+	}
+
+	output := &bytes.Buffer{}
+	e = merged.DebugDump(output)
+	if e != nil {
+		t.Logf("DebugDump failed: %s\n", e)
+		t.FailNow()
+	}
+	dump := output.String()
+	t.Logf("DebugDump output:\n%s", dump)
+	for _, s := range []string{"Layer \"second\"", "max concurrent: 3",
+		"bytes read: 2 (quota 100)", "fstest.MapFS"} {
+		if !strings.Contains(dump, s) {
+			t.Logf("DebugDump output didn't contain %q.\n", s)
+			t.Fail()
+		}
+	}
+}
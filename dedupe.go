@@ -0,0 +1,86 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"reflect"
+)
+
+// Identifies an FS whose dynamic type can't be compared using ==, such as
+// fstest.MapFS, by its type and the address of its underlying data.
+type referenceIdentity struct {
+	t reflect.Type
+	p uintptr
+}
+
+// Identifies a *Layer by its name.
+type layerNameIdentity string
+
+// Returns a value that's equal for two FSs only if they're the same FS, for
+// use as a map key, or nil if the FS can't be identified, e.g. because it's
+// a struct, which may contain a map.
+func layerIdentity(fsys fs.FS) interface{} {
+	if l, ok := fsys.(*Layer); ok && (l.Name != "") {
+		return layerNameIdentity(l.Name)
+	}
+	t := reflect.TypeOf(fsys)
+	switch t.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		return referenceIdentity{t: t, p: reflect.ValueOf(fsys).Pointer()}
+	case reflect.Ptr, reflect.Chan, reflect.UnsafePointer, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64,
+		reflect.Complex64, reflect.Complex128, reflect.String:
+		return fsys
+	}
+	// Other types, such as structs and arrays, may be comparable but still
+	// contain values that can't be hashed, such as an interface holding a
+	// map, so using them as keys could panic.
+	return nil
+}
+
+// Returns the layers of the given FSs, in priority order, expanding any
+// MergedFS into its own layers as Layers does.
+func flattenLayers(filesystems []fs.FS) []fs.FS {
+	var toReturn []fs.FS
+	for _, f := range filesystems {
+		if f == nil {
+			continue
+		}
+		if m, ok := f.(*MergedFS); ok {
+			toReturn = append(toReturn, m.Layers()...)
+		} else {
+			toReturn = append(toReturn, f)
+		}
+	}
+	return toReturn
+}
+
+// Works like MergeMultiple, but only includes the first occurrence of any
+// layer that appears more than once, such as when helper functions each
+// build a stack of layers and the stacks overlap. A later occurrence of the
+// same FS can never provide anything not already provided by the earlier
+// one, so removing it doesn't change the merged content, but means each
+// underlying FS is only checked once per lookup.
+//
+// Any MergedFS given to MergeUnique is first expanded into its layers, as
+// returned by Layers, so duplicates within nested merges are found too; the
+// settings of the expanded MergedFS aren't kept. Layers are the same if
+// they're the same FS value, or pointers to the same FS, or if they're
+// *Layers with the same non-empty Name, so Layer names must be unique to
+// distinct content. A Group is treated as a single layer.
+func MergeUnique(filesystems ...fs.FS) fs.FS {
+	seen := make(map[interface{}]bool)
+	var unique []fs.FS
+	for _, f := range flattenLayers(filesystems) {
+		id := layerIdentity(f)
+		if id != nil {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+		}
+		unique = append(unique, f)
+	}
+	return MergeMultiple(unique...)
+}
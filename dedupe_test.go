@@ -0,0 +1,77 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMergeUnique(t *testing.T) {
+	base := &openCountingFS{FS: fstest.MapFS{
+		"base.txt":   newMapFile("base"),
+		"shared.txt": newMapFile("base"),
+	}}
+	theme := fstest.MapFS{
+		"shared.txt": newMapFile("theme"),
+	}
+	named := &Layer{FS: fstest.MapFS{"named.txt": newMapFile("1")},
+		Name: "named"}
+	sameName := &Layer{FS: fstest.MapFS{"named.txt": newMapFile("2")},
+		Name: "named"}
+	stackA := MergeMultiple(theme, base)
+	stackB := MergeMultiple(named, base, theme)
+	merged := MergeUnique(stackA, stackB, sameName, theme)
+	m, ok := merged.(*MergedFS)
+	if !ok {
+		t.Logf("Expected a *MergedFS, got %T\n", merged)
+		t.FailNow()
+	}
+	if len(m.Layers()) != 3 {
+		t.Logf("Expected 3 unique layers, got %d\n", len(m.Layers()))
+		t.FailNow()
+	}
+	e := fstest.TestFS(merged, "base.txt", "shared.txt", "named.txt")
+	if e != nil {
+		t.Logf("Deduplicated merge failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	expected := map[string]string{
+		"shared.txt": "theme",
+		"named.txt":  "1",
+	}
+	for p, want := range expected {
+		content, e := fs.ReadFile(merged, p)
+		if (e != nil) || (string(content) != want) {
+			t.Logf("Got wrong content for %s: %q, %v\n", p, content, e)
+			t.FailNow()
+		}
+	}
+	// base.txt is only in one layer, which is now only checked once.
+	opens := atomic.LoadInt64(&base.opens)
+	_, e = fs.Stat(merged, "missing.txt")
+	if e == nil {
+		t.Logf("Didn't get an error for a missing file\n")
+		t.FailNow()
+	}
+	if n := atomic.LoadInt64(&base.opens) - opens; n != 1 {
+		t.Logf("Expected 1 open of the base layer, got %d\n", n)
+		t.FailNow()
+	}
+}
+
+func TestMergeUniqueUnhashable(t *testing.T) {
+	// A comparable struct type holding a map can't be used as a map key, so
+	// it mustn't be deduplicated (or cause a panic).
+	mapFS := fstest.MapFS{"a.txt": newMapFile("a")}
+	merged := MergeUnique(corruptFS{mapFS}, corruptFS{mapFS})
+	m, ok := merged.(*MergedFS)
+	if !ok {
+		t.Logf("Expected a *MergedFS, got %T\n", merged)
+		t.FailNow()
+	}
+	if len(m.Layers()) != 2 {
+		t.Logf("Expected 2 layers, got %d\n", len(m.Layers()))
+		t.FailNow()
+	}
+}
@@ -0,0 +1,134 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// Returns a new handle for a cached merged directory. The handle shares the
+// cached directory's entries, which are never modified, but has its own read
+// offset.
+func (d *MergedDirectory) newHandle() *MergedDirectory {
+	toReturn := *d
+	toReturn.readOffset = 0
+	return &toReturn
+}
+
+// Returns a handle for the cached merged directory at the given path, or nil
+// if the path isn't cached.
+func (m *MergedFS) cachedDirectory(path string) fs.File {
+	m.dirCacheMutex.Lock()
+	defer m.dirCacheMutex.Unlock()
+	if (m.dirCache == nil) || m.visibilityHooks {
+		return nil
+	}
+	d := m.dirCache[path]
+	if d == nil {
+		return nil
+	}
+	return d.newHandle()
+}
+
+// Returns the directory cache's current generation, which changes whenever
+// the cache is cleared. Obtain this before reading the layers' directories,
+// and pass it to cacheDirectory.
+func (m *MergedFS) dirCacheGeneration() uint64 {
+	m.dirCacheMutex.Lock()
+	defer m.dirCacheMutex.Unlock()
+	return m.dirGeneration
+}
+
+// Clears the directory cache, without changing whether it's enabled. Only
+// call this while holding m.dirCacheMutex.
+func (m *MergedFS) invalidateDirCacheLocked() {
+	m.dirGeneration++
+	if m.dirCache != nil {
+		m.dirCache = make(map[string]*MergedDirectory)
+	}
+}
+
+// Adds the merged directory to the cache, if caching is enabled, and returns
+// a handle to it. The directory isn't cached if the cache has been cleared
+// since the given generation was obtained, since it may have been merged from
+// outdated contents.
+func (m *MergedFS) cacheDirectory(path string, d *MergedDirectory,
+	generation uint64) fs.File {
+	m.dirCacheMutex.Lock()
+	defer m.dirCacheMutex.Unlock()
+	if (m.dirCache == nil) || m.visibilityHooks {
+		return d
+	}
+	if generation != m.dirGeneration {
+		return d
+	}
+	m.dirCache[path] = d
+	return d.newHandle()
+}
+
+// Implements fs.ReadDirFS, returning the entries of the directory at path,
+// sorted by name. This is equivalent to fs.ReadDir, except that it doesn't
+// sort entries that are already sorted, as they are for merged directories,
+// and it always returns a copy of the entries, so cached directories can be
+// listed repeatedly without redoing any work.
+func (m *MergedFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	e := m.authorize(context.Background(), "readdir", path)
+	if e != nil {
+		return nil, e
+	}
+	f, e := m.openAuthorized(context.Background(), "readdir", path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: path,
+			Err: errors.New("not implemented")}
+	}
+	entries, e := dir.ReadDir(-1)
+	toReturn := make([]fs.DirEntry, len(entries))
+	copy(toReturn, entries)
+	less := func(i, j int) bool {
+		return toReturn[i].Name() < toReturn[j].Name()
+	}
+	if !sort.SliceIsSorted(toReturn, less) {
+		sort.Slice(toReturn, less)
+	}
+	return toReturn, e
+}
+
+// Enables or disables caching of merged directories, and clears the cache.
+//
+// Opening a directory that is present in both A and B requires reading and
+// merging the contents of both directories. If directory caching is enabled,
+// the merged contents are kept, and later calls to Open for the same
+// directory return a new handle to the cached contents without accessing A or
+// B at all. This makes patterns such as Stat-ing a directory, closing it, and
+// re-opening it to read its contents (used by some walkers and template
+// parsers) much cheaper. Each handle has its own read offset, and closing one
+// handle doesn't affect any others.
+//
+// The drawback is that changes to A or B won't be reflected in cached
+// directories. If either FS may change at runtime, call
+// merged.UseDirectoryCaching(true) to clear the cache after it changes. As
+// with UsePathCaching, this setting is also applied to any MergedFS directly
+// nested within m. Directory caching is disabled by default.
+func (m *MergedFS) UseDirectoryCaching(enabled bool) {
+	m.dirCacheMutex.Lock()
+	m.dirGeneration++
+	if enabled {
+		m.dirCache = make(map[string]*MergedDirectory)
+	} else {
+		m.dirCache = nil
+	}
+	m.dirCacheMutex.Unlock()
+	m.publish(Event{Path: ".", Reason: "cache"})
+	for side := 0; side < 2; side++ {
+		nested, ok := m.layer(side).(*MergedFS)
+		if ok {
+			nested.UseDirectoryCaching(enabled)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+// Wraps an FS, counting calls to Open.
+type openCountingFS struct {
+	fs.FS
+	opens int64
+}
+
+func (f *openCountingFS) Open(path string) (fs.File, error) {
+	atomic.AddInt64(&f.opens, 1)
+	return f.FS.Open(path)
+}
+
+func TestDirectoryCaching(t *testing.T) {
+	fsA := &openCountingFS{FS: fstest.MapFS{
+		"dir/a.txt": newMapFile("in A"),
+	}}
+	fsB := &openCountingFS{FS: fstest.MapFS{
+		"dir/b.txt": newMapFile("in B"),
+	}}
+	merged := NewMergedFS(fsA, fsB)
+	merged.UseDirectoryCaching(true)
+	e := fstest.TestFS(merged, "dir/a.txt", "dir/b.txt")
+	if e != nil {
+		t.Logf("TestFS failed with directory caching: %s\n", e)
+		t.FailNow()
+	}
+
+	// Stat, close, and reopen the directory, as text/template does.
+	f1, e := merged.Open("dir")
+	if e != nil {
+		t.Logf("Failed opening dir: %s\n", e)
+		t.FailNow()
+	}
+	opensBefore := atomic.LoadInt64(&fsA.opens) + atomic.LoadInt64(&fsB.opens)
+	f2, e := merged.Open("dir")
+	if e != nil {
+		t.Logf("Failed reopening dir: %s\n", e)
+		t.FailNow()
+	}
+	opensAfter := atomic.LoadInt64(&fsA.opens) + atomic.LoadInt64(&fsB.opens)
+	if opensAfter != opensBefore {
+		t.Logf("Reopening a cached directory accessed the underlying FSs.\n")
+		t.FailNow()
+	}
+	info1, _ := f1.Stat()
+	f1.Close()
+	entries, e := f2.(fs.ReadDirFile).ReadDir(-1)
+	if e != nil {
+		t.Logf("Failed reading dir after closing another handle: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 2 {
+		t.Logf("Expected 2 entries, got %d.\n", len(entries))
+		t.FailNow()
+	}
+	if info1.Name() != "dir" || !info1.IsDir() {
+		t.Logf("Closing a directory invalidated its FileInfo.\n")
+		t.FailNow()
+	}
+	f2.Close()
+
+	// Make sure disabling the cache works.
+	merged.UseDirectoryCaching(false)
+	f1, e = merged.Open("dir")
+	if e != nil {
+		t.Logf("Failed opening dir with caching disabled: %s\n", e)
+		t.FailNow()
+	}
+	f1.Close()
+	if atomic.LoadInt64(&fsA.opens) == opensAfter {
+		t.Logf("Directory was still cached after disabling caching.\n")
+		t.FailNow()
+	}
+}
+
+// An FS that calls a function whenever a directory is opened.
+type dirHookFS struct {
+	fs.FS
+	onDir func()
+}
+
+func (f *dirHookFS) Open(path string) (fs.File, error) {
+	if (path == "dir") && (f.onDir != nil) {
+		f.onDir()
+	}
+	return f.FS.Open(path)
+}
+
+func TestDirectoryCacheGeneration(t *testing.T) {
+	fsA := &dirHookFS{FS: fstest.MapFS{
+		"dir/a.txt": newMapFile("in A"),
+	}}
+	fsB := fstest.MapFS{
+		"dir/b.txt": newMapFile("in B"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	merged.UseDirectoryCaching(true)
+
+	// Clearing the cache while a directory is being merged must prevent the
+	// possibly-outdated result from being cached.
+	fsA.onDir = func() {
+		fsA.onDir = nil
+		merged.UseDirectoryCaching(true)
+	}
+	entries, e := merged.ReadDir("dir")
+	if e != nil {
+		t.Logf("Failed reading dir: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 2 {
+		t.Logf("Expected 2 entries, got %d\n", len(entries))
+		t.FailNow()
+	}
+	if len(merged.dirCache) != 0 {
+		t.Logf("Cached a directory merged before the cache was cleared\n")
+		t.FailNow()
+	}
+
+	// Now the directory should be cached, and ReadDir must return copies of
+	// the cached entries.
+	entries, e = merged.ReadDir("dir")
+	if e != nil {
+		t.Logf("Failed reading dir again: %s\n", e)
+		t.FailNow()
+	}
+	if len(merged.dirCache) != 1 {
+		t.Logf("Directory wasn't cached\n")
+		t.FailNow()
+	}
+	entries[0] = nil
+	entries, e = merged.ReadDir("dir")
+	if (e != nil) || (entries[0] == nil) || (entries[0].Name() != "a.txt") {
+		t.Logf("Modifying ReadDir's result changed the cache: %v, %v\n",
+			entries, e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(merged, "dir/a.txt", "dir/b.txt")
+	if e != nil {
+		t.Logf("TestFS failed with ReadDir: %s\n", e)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,287 @@
+package merged_fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Determines how a layer returned by DirLayer handles symbolic links.
+type SymlinkPolicy int
+
+const (
+	// Symbolic links are followed, and appear in directory listings as the
+	// files or directories they point to. Links that point outside of the
+	// layer's directory, or to nothing, are treated as nonexistent.
+	FollowSymlinks SymlinkPolicy = iota
+	// Symbolic links are followed when opened, in the same way as
+	// FollowSymlinks, but appear in directory listings as symbolic links.
+	// Links that can't be followed are omitted from listings, but can still
+	// be read using ReadLink. The FS also provides ReadLink and Lstat
+	// methods, with the same signatures as those of the fs.ReadLinkFS
+	// interface in newer versions of Go.
+	ExposeSymlinks
+	// Symbolic links are treated as nonexistent, as are any paths that pass
+	// through them.
+	HideSymlinks
+)
+
+// Options for DirLayer.
+type DirLayerOptions struct {
+	// How symbolic links are handled. Defaults to FollowSymlinks.
+	Symlinks SymlinkPolicy
+}
+
+// Serves a directory from the host OS. See DirLayer.
+type dirLayerFS struct {
+	// The absolute path to the directory, with any symlinks resolved.
+	root   string
+	policy SymlinkPolicy
+}
+
+// A dirLayerFS with the ReadLink and Lstat methods of fs.ReadLinkFS.
+type exposedDirLayerFS struct {
+	*dirLayerFS
+}
+
+// Returns an FS serving the given directory from the host OS, for use as a
+// layer in a merge, as a safer alternative to os.DirFS. Unlike os.DirFS, the
+// directory is resolved to an absolute path immediately, so later changes to
+// the working directory have no effect, and symbolic links can never lead
+// outside of it. The handling of symbolic links is determined by
+// opts.Symlinks.
+//
+// The layer's directory listings and file metadata are always consistent with
+// each other, as testing/fstest requires: in particular, names reported by
+// Stat are always those of the opened paths rather than those of any link
+// targets. Note that wrapping the returned FS in a Layer hides the ReadLink
+// and Lstat methods provided by ExposeSymlinks, since Layer doesn't provide
+// them, but a MergedFS reports links from layers providing them; see
+// MergedFS.SetSymlinkShadowing. Returns an error if dir isn't a directory.
+func DirLayer(dir string, opts DirLayerOptions) (fs.FS, error) {
+	root, e := filepath.Abs(dir)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't get absolute path of %s: %w", dir, e)
+	}
+	root, e = filepath.EvalSymlinks(root)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't resolve %s: %w", dir, e)
+	}
+	info, e := os.Stat(root)
+	if e != nil {
+		return nil, e
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s isn't a directory", dir)
+	}
+	d := &dirLayerFS{
+		root:   root,
+		policy: opts.Symlinks,
+	}
+	if opts.Symlinks == ExposeSymlinks {
+		return exposedDirLayerFS{d}, nil
+	}
+	return d, nil
+}
+
+// Returns the OS path corresponding to the given path within the layer,
+// without resolving any symlinks. Returns an error if the path is invalid.
+func (d *dirLayerFS) osPath(op, p string) (string, error) {
+	if !fs.ValidPath(p) || ((runtime.GOOS == "windows") &&
+		strings.ContainsAny(p, `\:`)) {
+		return "", &fs.PathError{Op: op, Path: p, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(d.root, filepath.FromSlash(p)), nil
+}
+
+// Returns an error from the OS, e, as an error referring to the path p
+// within the layer rather than to an OS path.
+func osError(op, p string, e error) error {
+	if pathError, ok := e.(*fs.PathError); ok {
+		e = pathError.Err
+	}
+	return &fs.PathError{Op: op, Path: p, Err: e}
+}
+
+// Returns true if the OS path is the root or within it.
+func (d *dirLayerFS) contains(osPath string) bool {
+	return (osPath == d.root) ||
+		strings.HasPrefix(osPath, d.root+string(filepath.Separator))
+}
+
+// Returns the OS path of the file that the given path within the layer refers
+// to, after following any symlinks permitted by the policy.
+func (d *dirLayerFS) resolve(op, p string) (string, error) {
+	full, e := d.osPath(op, p)
+	if e != nil {
+		return "", e
+	}
+	resolved, e := filepath.EvalSymlinks(full)
+	if e != nil {
+		return "", &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+	}
+	if !d.contains(resolved) {
+		return "", &fs.PathError{Op: op, Path: p,
+			Err: fmt.Errorf("%w: symlink leads outside of the layer",
+				fs.ErrNotExist)}
+	}
+	if (d.policy == HideSymlinks) && (resolved != full) {
+		return "", &fs.PathError{Op: op, Path: p,
+			Err: fmt.Errorf("%w: path contains a symlink", fs.ErrNotExist)}
+	}
+	return resolved, nil
+}
+
+// Returns the OS path of the given path within the layer, following any
+// symlinks in its parent directories but not the path itself.
+func (d *dirLayerFS) resolveParent(op, p string) (string, error) {
+	if p == "." {
+		return d.resolve(op, p)
+	}
+	parent, e := d.resolve(op, path.Dir(p))
+	if e != nil {
+		return "", &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+	}
+	return filepath.Join(parent, path.Base(p)), nil
+}
+
+func (d *dirLayerFS) Open(p string) (fs.File, error) {
+	resolved, e := d.resolve("open", p)
+	if e != nil {
+		return nil, e
+	}
+	f, e := os.Open(resolved)
+	if e != nil {
+		return nil, osError("open", p, e)
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if !info.IsDir() {
+		return renameFile(f, path.Base(p)), nil
+	}
+	entries, e := f.ReadDir(-1)
+	f.Close()
+	if e != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: e}
+	}
+	entries = d.fixEntries(resolved, entries)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	info = renamedInfo{info, path.Base(p)}
+	return &completedDir{
+		MergedDirectory: &MergedDirectory{
+			name:    info.Name(),
+			mode:    info.Mode(),
+			entries: entries,
+		},
+		info: info,
+	}, nil
+}
+
+// Adjusts the symlinks among entries from the given OS directory according to
+// the policy.
+func (d *dirLayerFS) fixEntries(osDir string,
+	entries []fs.DirEntry) []fs.DirEntry {
+	toReturn := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type()&fs.ModeSymlink == 0 {
+			toReturn = append(toReturn, entry)
+			continue
+		}
+		if d.policy == HideSymlinks {
+			continue
+		}
+		target, e := filepath.EvalSymlinks(filepath.Join(osDir, entry.Name()))
+		if (e != nil) || !d.contains(target) {
+			// Opening the link would fail, so it can't be listed.
+			continue
+		}
+		if d.policy == ExposeSymlinks {
+			toReturn = append(toReturn, entry)
+			continue
+		}
+		info, e := os.Stat(target)
+		if e != nil {
+			continue
+		}
+		toReturn = append(toReturn, infoDirEntry{
+			renamedInfo{info, entry.Name()},
+		})
+	}
+	return toReturn
+}
+
+func (d *dirLayerFS) Stat(p string) (fs.FileInfo, error) {
+	resolved, e := d.resolve("stat", p)
+	if e != nil {
+		return nil, e
+	}
+	info, e := os.Stat(resolved)
+	if e != nil {
+		return nil, osError("stat", p, e)
+	}
+	return renamedInfo{info, path.Base(p)}, nil
+}
+
+func (d *dirLayerFS) ReadFile(p string) ([]byte, error) {
+	resolved, e := d.resolve("readfile", p)
+	if e != nil {
+		return nil, e
+	}
+	data, e := os.ReadFile(resolved)
+	if e != nil {
+		return nil, osError("readfile", p, e)
+	}
+	return data, nil
+}
+
+// Returns the destination of the symbolic link at the given path. Absolute
+// destinations within the layer are returned relative to the link's
+// directory, and it's an error for a destination to be absolute but outside
+// of the layer.
+func (d exposedDirLayerFS) ReadLink(p string) (string, error) {
+	full, e := d.resolveParent("readlink", p)
+	if e != nil {
+		return "", e
+	}
+	target, e := os.Readlink(full)
+	if e != nil {
+		return "", osError("readlink", p, e)
+	}
+	if !filepath.IsAbs(target) {
+		return filepath.ToSlash(target), nil
+	}
+	if !d.contains(target) {
+		return "", &fs.PathError{Op: "readlink", Path: p,
+			Err: errors.New("the link's destination is outside of the layer")}
+	}
+	relative, e := filepath.Rel(filepath.Dir(full), target)
+	if e != nil {
+		return "", &fs.PathError{Op: "readlink", Path: p, Err: e}
+	}
+	return filepath.ToSlash(relative), nil
+}
+
+// Returns information about the file at the given path, without following it
+// if it's a symbolic link.
+func (d exposedDirLayerFS) Lstat(p string) (fs.FileInfo, error) {
+	full, e := d.resolveParent("lstat", p)
+	if e != nil {
+		return nil, e
+	}
+	info, e := os.Lstat(full)
+	if e != nil {
+		return nil, osError("lstat", p, e)
+	}
+	return renamedInfo{info, path.Base(p)}, nil
+}
@@ -0,0 +1,166 @@
+package merged_fs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// Creates a directory for testing DirLayer, containing links to a file, a
+// directory, a file outside of the directory, and nothing.
+func createSymlinkDir(t *testing.T) string {
+	outside := t.TempDir()
+	dir := t.TempDir()
+	e := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("no"),
+		0644)
+	if e == nil {
+		e = os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	}
+	if e == nil {
+		e = os.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte("hi"),
+			0644)
+	}
+	if e != nil {
+		t.Logf("Failed creating test files: %s\n", e)
+		t.FailNow()
+	}
+	links := map[string]string{
+		"file_link.txt":   filepath.Join("sub", "file.txt"),
+		"dir_link":        "sub",
+		"abs_link.txt":    filepath.Join(dir, "sub", "file.txt"),
+		"escape_link.txt": filepath.Join(outside, "secret.txt"),
+		"dangling.txt":    "missing.txt",
+	}
+	for name, target := range links {
+		e = os.Symlink(target, filepath.Join(dir, name))
+		if e != nil {
+			t.Skipf("Can't create symlinks: %s\n", e)
+		}
+	}
+	return dir
+}
+
+func TestDirLayerFollow(t *testing.T) {
+	dir := createSymlinkDir(t)
+	layer, e := DirLayer(dir, DirLayerOptions{Symlinks: FollowSymlinks})
+	if e != nil {
+		t.Logf("Failed creating dir layer: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(layer, "sub/file.txt", "file_link.txt",
+		"dir_link/file.txt", "abs_link.txt")
+	if e != nil {
+		t.Logf("TestFS failed when following symlinks: %s\n", e)
+		t.FailNow()
+	}
+	info, e := fs.Stat(layer, "file_link.txt")
+	if (e != nil) || (info.Name() != "file_link.txt") {
+		t.Logf("Got incorrect info for link: %v, %v\n", info, e)
+		t.FailNow()
+	}
+	for _, p := range []string{"escape_link.txt", "dangling.txt"} {
+		_, e = layer.Open(p)
+		if !errors.Is(e, fs.ErrNotExist) {
+			t.Logf("Didn't get ErrNotExist opening %s: %v\n", p, e)
+			t.FailNow()
+		}
+	}
+	entries, e := fs.ReadDir(layer, ".")
+	if (e != nil) || (len(entries) != 4) {
+		t.Logf("Expected 4 entries in the root dir, got %v, %v\n", entries,
+			e)
+		t.FailNow()
+	}
+
+	// The layer must work in a merge.
+	merged := NewMergedFS(fstest.MapFS{"a.txt": newMapFile("a")}, layer)
+	e = fstest.TestFS(merged, "a.txt", "file_link.txt", "dir_link/file.txt")
+	if e != nil {
+		t.Logf("TestFS failed for merged dir layer: %s\n", e)
+		t.FailNow()
+	}
+}
+
+func TestDirLayerHide(t *testing.T) {
+	dir := createSymlinkDir(t)
+	layer, e := DirLayer(dir, DirLayerOptions{Symlinks: HideSymlinks})
+	if e != nil {
+		t.Logf("Failed creating dir layer: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(layer, "sub/file.txt")
+	if e != nil {
+		t.Logf("TestFS failed when hiding symlinks: %s\n", e)
+		t.FailNow()
+	}
+	for _, p := range []string{"file_link.txt", "dir_link/file.txt"} {
+		_, e = layer.Open(p)
+		if !errors.Is(e, fs.ErrNotExist) {
+			t.Logf("Didn't get ErrNotExist opening %s: %v\n", p, e)
+			t.FailNow()
+		}
+	}
+	entries, e := fs.ReadDir(layer, ".")
+	if (e != nil) || (len(entries) != 1) {
+		t.Logf("Expected only 1 entry in the root dir, got %v, %v\n",
+			entries, e)
+		t.FailNow()
+	}
+}
+
+func TestDirLayerExpose(t *testing.T) {
+	dir := createSymlinkDir(t)
+	layer, e := DirLayer(dir, DirLayerOptions{Symlinks: ExposeSymlinks})
+	if e != nil {
+		t.Logf("Failed creating dir layer: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(layer, "sub/file.txt", "file_link.txt")
+	if e != nil {
+		t.Logf("TestFS failed when exposing symlinks: %s\n", e)
+		t.FailNow()
+	}
+	links, ok := layer.(interface {
+		ReadLink(name string) (string, error)
+		Lstat(name string) (fs.FileInfo, error)
+	})
+	if !ok {
+		t.Logf("The layer doesn't provide ReadLink and Lstat\n")
+		t.FailNow()
+	}
+	target, e := links.ReadLink("file_link.txt")
+	if (e != nil) || (target != "sub/file.txt") {
+		t.Logf("Got incorrect link target: %q, %v\n", target, e)
+		t.FailNow()
+	}
+	target, e = links.ReadLink("abs_link.txt")
+	if (e != nil) || (target != "sub/file.txt") {
+		t.Logf("Didn't get a relative target for an absolute link: %q, %v\n",
+			target, e)
+		t.FailNow()
+	}
+	_, e = links.ReadLink("escape_link.txt")
+	if e == nil {
+		t.Logf("Didn't get an error reading a link leading outside\n")
+		t.FailNow()
+	}
+	info, e := links.Lstat("dir_link")
+	if (e != nil) || (info.Mode()&fs.ModeSymlink == 0) {
+		t.Logf("Lstat didn't report a symlink: %v, %v\n", info, e)
+		t.FailNow()
+	}
+	data, e := fs.ReadFile(layer, "dir_link/file.txt")
+	if (e != nil) || (string(data) != "hi") {
+		t.Logf("Failed reading through a linked dir: %q, %v\n", data, e)
+		t.FailNow()
+	}
+	entries, e := fs.ReadDir(layer, ".")
+	if (e != nil) || (len(entries) != 4) {
+		t.Logf("Expected 4 entries in the root dir, got %v, %v\n", entries,
+			e)
+		t.FailNow()
+	}
+}
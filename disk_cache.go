@@ -0,0 +1,226 @@
+package merged_fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// May be implemented by the values returned by the Sys method of the
+// FileInfos from a layer, such as a layer backed by HTTP requests, to identify
+// the version of a file's content. DiskCache uses it to validate cached
+// copies.
+type ETagInfo interface {
+	ETag() string
+}
+
+// A read-through cache of an FS's regular files, stored in a local directory.
+// See DiskCache.
+type diskCacheFS struct {
+	fsys fs.FS
+	// The directory containing the cache's "blobs" and "index" directories.
+	dir string
+}
+
+// The information used to check whether a cached copy of a file is current.
+type diskCacheKey struct {
+	size    int64
+	modTime int64
+	etag    string
+}
+
+// Returns an FS serving the same content as fsys, but that saves copies of
+// the regular files read from fsys in the local directory dir, and serves
+// later opens of the same files from there. This is intended for layers that
+// are slow to read, such as remote or compressed filesystems:
+//
+//	cached, e := DiskCache(remoteAssets, "/var/cache/assets")
+//	merged := NewMergedFS(localOverrides, cached)
+//
+// Copies are stored under dir by the SHA-256 hash of their content, so
+// identical files are only stored once, and the cache persists across runs.
+// Each open of a regular file still gets the file's info from fsys, and the
+// cached copy is only used if fsys reports the same size, modification time,
+// and ETag (if the info's Sys value implements ETagInfo). Files with neither a
+// modification time nor an ETag, such as those in go:embed FSs, are never
+// cached, since changes to them can't be detected. A file that isn't cached
+// yet is read from fsys in its entirety before Open returns. Directories,
+// ReadDir, and Stat are passed through to fsys without caching.
+//
+// Creates dir if it doesn't exist. Errors writing to the cache are returned
+// from Open. The cache is never pruned, so it's up to the caller to remove
+// old files from dir if needed.
+func DiskCache(fsys fs.FS, dir string) (fs.FS, error) {
+	for _, sub := range []string{"blobs", "index"} {
+		e := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if e != nil {
+			return nil, fmt.Errorf("Failed creating cache directory: %w", e)
+		}
+	}
+	return &diskCacheFS{
+		fsys: fsys,
+		dir:  dir,
+	}, nil
+}
+
+// Returns the key to validate a cached copy of a file with the given info,
+// or false if it can't be cached.
+func newDiskCacheKey(info fs.FileInfo) (diskCacheKey, bool) {
+	key := diskCacheKey{size: info.Size()}
+	if !info.ModTime().IsZero() {
+		key.modTime = info.ModTime().UnixNano()
+	}
+	if tagged, ok := info.Sys().(ETagInfo); ok {
+		key.etag = tagged.ETag()
+	}
+	return key, (key.modTime != 0) || (key.etag != "")
+}
+
+// Returns the path to the index file for the given path in the FS.
+func (d *diskCacheFS) indexPath(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(d.dir, "index", hex.EncodeToString(sum[:]))
+}
+
+func (d *diskCacheFS) blobPath(hash string) string {
+	return filepath.Join(d.dir, "blobs", hash)
+}
+
+// Returns the hash of the cached content for path, or an empty string if
+// there's no cached copy matching the key.
+func (d *diskCacheFS) lookup(path string, key diskCacheKey) string {
+	data, e := os.ReadFile(d.indexPath(path))
+	if e != nil {
+		return ""
+	}
+	var hash string
+	var cached diskCacheKey
+	_, e = fmt.Sscanf(string(data), "%s %d %d %q", &hash, &cached.size,
+		&cached.modTime, &cached.etag)
+	if (e != nil) || (cached != key) {
+		return ""
+	}
+	return hash
+}
+
+// Writes data to the file at dst, using a temporary file so that readers
+// never see a partially written file.
+func writeFileAtomic(dst string, data []byte) error {
+	f, e := os.CreateTemp(filepath.Dir(dst), ".tmp-")
+	if e != nil {
+		return e
+	}
+	_, e = f.Write(data)
+	closeErr := f.Close()
+	if e == nil {
+		e = closeErr
+	}
+	if e == nil {
+		e = os.Rename(f.Name(), dst)
+	}
+	if e != nil {
+		os.Remove(f.Name())
+	}
+	return e
+}
+
+// Saves the content of path to the cache, returning its hash.
+func (d *diskCacheFS) store(path string, key diskCacheKey,
+	content []byte) (string, error) {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	blob := d.blobPath(hash)
+	if _, e := os.Stat(blob); e != nil {
+		e = writeFileAtomic(blob, content)
+		if e != nil {
+			return "", e
+		}
+	}
+	entry := fmt.Sprintf("%s %d %d %q\n", hash, key.size, key.modTime,
+		key.etag)
+	return hash, writeFileAtomic(d.indexPath(path), []byte(entry))
+}
+
+func (d *diskCacheFS) Open(path string) (fs.File, error) {
+	if !fs.ValidPath(path) {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrInvalid}
+	}
+	f, e := d.fsys.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if !info.Mode().IsRegular() {
+		return f, nil
+	}
+	key, ok := newDiskCacheKey(info)
+	if !ok {
+		return f, nil
+	}
+	hash := d.lookup(path, key)
+	if hash != "" {
+		cached, e := os.Open(d.blobPath(hash))
+		if e == nil {
+			f.Close()
+			return newDiskCachedFile(cached, info), nil
+		}
+	}
+	content, e := io.ReadAll(f)
+	f.Close()
+	if e != nil {
+		return nil, e
+	}
+	hash, e = d.store(path, key, content)
+	if e != nil {
+		return nil, osError("open", path, e)
+	}
+	cached, e := os.Open(d.blobPath(hash))
+	if e != nil {
+		return nil, osError("open", path, e)
+	}
+	return newDiskCachedFile(cached, info), nil
+}
+
+func (d *diskCacheFS) Stat(path string) (fs.FileInfo, error) {
+	return fs.Stat(d.fsys, path)
+}
+
+func (d *diskCacheFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(d.fsys, path)
+}
+
+// A regular file served from a DiskCache, which reports the info from the
+// underlying FS.
+type diskCachedFile struct {
+	f    *os.File
+	info fs.FileInfo
+}
+
+func newDiskCachedFile(f *os.File, info fs.FileInfo) fs.File {
+	return addFileInterfaces(&diskCachedFile{f, info}, nil, f, f, nil, nil)
+}
+
+func (f *diskCachedFile) Read(data []byte) (int, error) {
+	return f.f.Read(data)
+}
+
+func (f *diskCachedFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Returns the cached copy of the file on disk.
+func (f *diskCachedFile) Unwrap() fs.File {
+	return f.f
+}
+
+func (f *diskCachedFile) Close() error {
+	return f.f.Close()
+}
@@ -0,0 +1,111 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+type testETag string
+
+func (t testETag) ETag() string {
+	return string(t)
+}
+
+func TestDiskCache(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour)
+	remote := fstest.MapFS{
+		"assets/a.txt": &fstest.MapFile{Data: []byte("aaa"), ModTime: modTime},
+		"assets/b.txt": &fstest.MapFile{Data: []byte("aaa"), ModTime: modTime},
+		"tagged.txt": &fstest.MapFile{
+			Data: []byte("v1"),
+			Sys:  testETag("1"),
+		},
+		"embedded.txt": &fstest.MapFile{Data: []byte("embedded")},
+	}
+	dir := t.TempDir()
+	cached, e := DiskCache(remote, dir)
+	if e != nil {
+		t.Logf("Failed creating disk cache: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(cached, "assets/a.txt", "assets/b.txt", "tagged.txt",
+		"embedded.txt")
+	if e != nil {
+		t.Logf("Disk cache failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	// a.txt and b.txt have the same content, so only one copy is stored.
+	blobs, e := os.ReadDir(filepath.Join(dir, "blobs"))
+	if e != nil {
+		t.Logf("Failed reading blobs dir: %s\n", e)
+		t.FailNow()
+	}
+	if len(blobs) != 2 {
+		t.Logf("Expected 2 cached blobs, got %d\n", len(blobs))
+		t.FailNow()
+	}
+
+	// Changes that aren't reflected in the file info go unnoticed...
+	remote["assets/a.txt"].Data = []byte("bbb")
+	remote["embedded.txt"].Data = []byte("changed")
+	content, e := fs.ReadFile(cached, "assets/a.txt")
+	if e != nil {
+		t.Logf("Failed reading a.txt: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "aaa" {
+		t.Logf("Didn't get cached content for a.txt: got %s\n", content)
+		t.FailNow()
+	}
+	// ...except in files that can't be cached.
+	content, e = fs.ReadFile(cached, "embedded.txt")
+	if e != nil {
+		t.Logf("Failed reading embedded.txt: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "changed" {
+		t.Logf("Got stale content for uncacheable file: %s\n", content)
+		t.FailNow()
+	}
+
+	// The cache persists across instances, and is validated using the
+	// modification time and ETag.
+	cached, e = DiskCache(remote, dir)
+	if e != nil {
+		t.Logf("Failed reopening disk cache: %s\n", e)
+		t.FailNow()
+	}
+	remote["assets/a.txt"].ModTime = modTime.Add(time.Second)
+	remote["tagged.txt"].Data = []byte("v2")
+	remote["tagged.txt"].Sys = testETag("2")
+	expected := map[string]string{
+		"assets/a.txt": "bbb",
+		"assets/b.txt": "aaa",
+		"tagged.txt":   "v2",
+	}
+	for path, want := range expected {
+		content, e = fs.ReadFile(cached, path)
+		if e != nil {
+			t.Logf("Failed reading %s: %s\n", path, e)
+			t.FailNow()
+		}
+		if string(content) != want {
+			t.Logf("Got wrong content for %s: expected %s, got %s\n", path,
+				want, content)
+			t.FailNow()
+		}
+	}
+	info, e := fs.Stat(cached, "assets/a.txt")
+	if e != nil {
+		t.Logf("Failed getting stats: %s\n", e)
+		t.FailNow()
+	}
+	if !info.ModTime().Equal(modTime.Add(time.Second)) {
+		t.Logf("Got wrong modification time: %s\n", info.ModTime())
+		t.FailNow()
+	}
+}
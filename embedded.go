@@ -0,0 +1,192 @@
+package merged_fs
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A path that's a regular file in one layer of a stack built by BuildStack,
+// but a directory in another.
+type StackConflict struct {
+	Path string
+	// The names of the layers containing a file and a directory at Path,
+	// respectively. If more than one layer contains either, this is the
+	// highest-priority one.
+	FileLayer, DirLayer string
+}
+
+// Returned by BuildStack if any paths are files in one layer but directories
+// in another, which almost always means the layers weren't meant to be
+// combined.
+type StackConflictError struct {
+	Conflicts []StackConflict
+}
+
+func (e *StackConflictError) Error() string {
+	descriptions := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		descriptions[i] = fmt.Sprintf("%s is a file in %s but a directory "+
+			"in %s", c.Path, c.FileLayer, c.DirLayer)
+	}
+	return "Conflicting layers: " + strings.Join(descriptions, "; ")
+}
+
+// Holds layers registered by name, for BuildStack.
+type layerRegistry struct {
+	mutex  sync.Mutex
+	layers map[string]fs.FS
+}
+
+// The registry used by RegisterEmbedded and BuildStack.
+var embeddedLayers = &layerRegistry{layers: make(map[string]fs.FS)}
+
+// Panics if the name is empty or already registered, in the same way as
+// sql.Register, since both are programming errors.
+func (r *layerRegistry) register(name string, fsys fs.FS) {
+	if name == "" {
+		panic("merged_fs: registering a layer with an empty name")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.layers[name]; exists {
+		panic("merged_fs: layer " + name + " is already registered")
+	}
+	r.layers[name] = fsys
+}
+
+func (r *layerRegistry) names() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	toReturn := make([]string, 0, len(r.layers))
+	for name := range r.layers {
+		toReturn = append(toReturn, name)
+	}
+	sort.Strings(toReturn)
+	return toReturn
+}
+
+// Implements BuildStack using the layers in r.
+func (r *layerRegistry) build(names []string) (fs.FS, error) {
+	layers := make([]fs.FS, len(names))
+	var missing []string
+	r.mutex.Lock()
+	for i, name := range names {
+		fsys, ok := r.layers[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		layers[i] = &Layer{Name: name, FS: fsys}
+	}
+	r.mutex.Unlock()
+	if len(missing) != 0 {
+		return nil, fmt.Errorf("Layers not registered: %s",
+			strings.Join(missing, ", "))
+	}
+	stack := MergeMultiple(layers...)
+	if merged, ok := stack.(*MergedFS); ok {
+		e := merged.Validate()
+		if e != nil {
+			return nil, e
+		}
+	}
+	e := checkStackConflicts(layers)
+	if e != nil {
+		return nil, e
+	}
+	return stack, nil
+}
+
+// Walks each of the layers, returning a *StackConflictError if any path is a
+// file in one layer but a directory in another.
+func checkStackConflicts(layers []fs.FS) error {
+	type firstSeen struct {
+		dir   bool
+		layer string
+	}
+	seen := make(map[string]firstSeen)
+	// Each conflicting path is only reported once.
+	reported := make(map[string]bool)
+	var conflicts []StackConflict
+	for _, layer := range layers {
+		name := layer.(*Layer).Name
+		e := fs.WalkDir(layer, ".", func(p string, d fs.DirEntry,
+			e error) error {
+			if e != nil {
+				return e
+			}
+			previous, ok := seen[p]
+			if !ok {
+				seen[p] = firstSeen{dir: d.IsDir(), layer: name}
+				return nil
+			}
+			if (previous.dir == d.IsDir()) || reported[p] {
+				return nil
+			}
+			reported[p] = true
+			c := StackConflict{Path: p, FileLayer: previous.layer,
+				DirLayer: name}
+			if previous.dir {
+				c.FileLayer, c.DirLayer = name, previous.layer
+			}
+			conflicts = append(conflicts, c)
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		})
+		if e != nil {
+			return fmt.Errorf("Couldn't check layer %s: %w", name, e)
+		}
+	}
+	if len(conflicts) != 0 {
+		return &StackConflictError{Conflicts: conflicts}
+	}
+	return nil
+}
+
+// Registers an embedded FS under the given name, for use by BuildStack. This
+// is intended to be called from init functions in files with build
+// constraints, so that different builds of a program include different
+// layers, while the code assembling them stays the same:
+//
+//	//go:build enterprise
+//
+//	//go:embed assets
+//	var enterpriseAssets embed.FS
+//
+//	func init() {
+//		merged_fs.RegisterEmbedded("enterprise", enterpriseAssets)
+//	}
+//
+// Panics if the name is empty or has already been registered.
+func RegisterEmbedded(name string, fsys embed.FS) {
+	embeddedLayers.register(name, fsys)
+}
+
+// Returns the names of every layer registered using RegisterEmbedded, in
+// lexical order.
+func RegisteredEmbedded() []string {
+	return embeddedLayers.names()
+}
+
+// Merges the layers registered using RegisterEmbedded with the given names,
+// in priority order, as MergeMultiple does. Each is wrapped in a *Layer with
+// its registered name, so provenance reports which layer every file came
+// from. Use RegisteredEmbedded to check which optional layers a build
+// registered.
+//
+// Since stacks are usually built once at startup, this checks the stack
+// using MergedFS.Validate, returning its error if there are any problems, and
+// then walks every layer to check for paths that are files in one layer but
+// directories in another, returning a *StackConflictError listing them, if
+// there are any. Files at the same path in more than one layer aren't
+// conflicts, since overriding them is the point of layering. Returns an error
+// if any name isn't registered.
+func BuildStack(names ...string) (fs.FS, error) {
+	return embeddedLayers.build(names)
+}
@@ -0,0 +1,104 @@
+package merged_fs
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestBuildStack(t *testing.T) {
+	r := &layerRegistry{layers: make(map[string]fs.FS)}
+	r.register("oss", fstest.MapFS{
+		"static/app.js":   newMapFile("oss app"),
+		"static/logo.png": newMapFile("oss logo"),
+	})
+	r.register("enterprise", fstest.MapFS{
+		"static/app.js": newMapFile("enterprise app"),
+	})
+	r.register("nil", nil)
+	r.register("broken", fstest.MapFS{
+		"static/app.js/nested": newMapFile("conflicts with a file"),
+	})
+	stack, e := r.build([]string{"enterprise", "oss"})
+	if e != nil {
+		t.Logf("Failed building stack: %s\n", e)
+		t.FailNow()
+	}
+	content, e := fs.ReadFile(stack, "static/app.js")
+	if (e != nil) || (string(content) != "enterprise app") {
+		t.Logf("Got wrong content for app.js: %q, %v\n", content, e)
+		t.FailNow()
+	}
+	entries, e := fs.ReadDir(stack, "static")
+	if (e != nil) || (len(entries) != 2) {
+		t.Logf("Got wrong entries for static: %v, %v\n", entries, e)
+		t.FailNow()
+	}
+	_, name := entries[1].(ProvenanceEntry).Provenance()
+	if (entries[1].Name() != "logo.png") || (name != "oss") {
+		t.Logf("Got wrong provenance for %s: %q\n", entries[1].Name(), name)
+		t.FailNow()
+	}
+
+	_, e = r.build([]string{"enterprise", "missing", "oss"})
+	if e == nil {
+		t.Logf("Didn't get an error for an unregistered layer\n")
+		t.FailNow()
+	}
+	_, e = r.build([]string{"oss", "nil"})
+	if !errors.Is(e, ErrInvalidConfig) {
+		t.Logf("Didn't get expected error for an invalid layer: %v\n", e)
+		t.FailNow()
+	}
+	_, e = r.build([]string{"oss", "broken"})
+	var conflictError *StackConflictError
+	if !errors.As(e, &conflictError) {
+		t.Logf("Didn't get expected conflict error: %v\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected conflict error: %s\n", e)
+	expected := StackConflict{Path: "static/app.js", FileLayer: "oss",
+		DirLayer: "broken"}
+	if (len(conflictError.Conflicts) != 1) ||
+		(conflictError.Conflicts[0] != expected) {
+		t.Logf("Got wrong conflicts: %+v\n", conflictError.Conflicts)
+		t.FailNow()
+	}
+}
+
+func TestRegisterEmbedded(t *testing.T) {
+	var empty embed.FS
+	RegisterEmbedded("test empty", empty)
+	defer func() {
+		embeddedLayers.mutex.Lock()
+		delete(embeddedLayers.layers, "test empty")
+		embeddedLayers.mutex.Unlock()
+	}()
+	found := false
+	for _, name := range RegisteredEmbedded() {
+		found = found || (name == "test empty")
+	}
+	if !found {
+		t.Logf("Registered layer wasn't listed\n")
+		t.FailNow()
+	}
+	stack, e := BuildStack("test empty")
+	if e != nil {
+		t.Logf("Failed building stack of an empty embed.FS: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(stack)
+	if e != nil {
+		t.Logf("Stack of an empty embed.FS failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	defer func() {
+		if recover() == nil {
+			t.Logf("Registering a duplicate name didn't panic\n")
+			t.Fail()
+		}
+	}()
+	RegisterEmbedded("test empty", empty)
+}
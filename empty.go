@@ -0,0 +1,83 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"path"
+)
+
+// Implements the FS interface, but provides a filesystem containing no files.
+// The only path you can "Open" is ".", which provides an empty directory.
+// Also implements fs.ReadFileFS, fs.StatFS, fs.ReadDirFS, and fs.GlobFS, so
+// none of the functions in io/fs need to open anything.
+type EmptyFS struct{}
+
+// A canonical EmptyFS, returned by MergeMultiple when called without any
+// arguments. It can also be used as a placeholder for an optional layer that
+// isn't present, in place of checking for nil:
+//
+//	overrides := Empty
+//	if overrideDir != "" {
+//		overrides = os.DirFS(overrideDir)
+//	}
+//	merged := NewMergedFS(overrides, base)
+//
+// Opening "." in Empty always returns an empty directory, and every other
+// valid path fails with an error wrapping fs.ErrNotExist.
+var Empty fs.FS = &EmptyFS{}
+
+// Returns the empty root directory.
+func emptyRoot() *MergedDirectory {
+	return &MergedDirectory{
+		name:    ".",
+		mode:    0444 | fs.ModeDir,
+		entries: nil,
+	}
+}
+
+// Returns the error for any operation on a path other than ".".
+func emptyFSError(op, p string) error {
+	if !fs.ValidPath(p) {
+		return &fs.PathError{Op: op, Path: p, Err: fs.ErrInvalid}
+	}
+	return &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+}
+
+func (f *EmptyFS) Open(path string) (fs.File, error) {
+	if path != "." {
+		return nil, emptyFSError("open", path)
+	}
+	// Return an empty directory for "."
+	return emptyRoot(), nil
+}
+
+func (f *EmptyFS) ReadFile(path string) ([]byte, error) {
+	if path == "." {
+		return nil, &fs.PathError{Op: "readfile", Path: path,
+			Err: fs.ErrInvalid}
+	}
+	return nil, emptyFSError("readfile", path)
+}
+
+func (f *EmptyFS) Stat(path string) (fs.FileInfo, error) {
+	if path != "." {
+		return nil, emptyFSError("stat", path)
+	}
+	return emptyRoot(), nil
+}
+
+func (f *EmptyFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	if path != "." {
+		return nil, emptyFSError("readdir", path)
+	}
+	return nil, nil
+}
+
+func (f *EmptyFS) Glob(pattern string) ([]string, error) {
+	if _, e := path.Match(pattern, ""); e != nil {
+		return nil, e
+	}
+	if pattern == "." {
+		return []string{"."}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,63 @@
+package merged_fs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmpty(t *testing.T) {
+	entries, e := fs.ReadDir(Empty, ".")
+	if (e != nil) || (len(entries) != 0) {
+		t.Logf("Expected an empty root dir, got %v, %v\n", entries, e)
+		t.FailNow()
+	}
+	info, e := fs.Stat(Empty, ".")
+	if (e != nil) || !info.IsDir() {
+		t.Logf("\".\" wasn't a directory in Empty: %v\n", e)
+		t.FailNow()
+	}
+	for _, p := range []string{"a", "a/b", "a.txt", "dir/file.txt"} {
+		_, e = Empty.Open(p)
+		if !errors.Is(e, fs.ErrNotExist) {
+			t.Logf("Didn't get ErrNotExist opening %s: %v\n", p, e)
+			t.FailNow()
+		}
+		_, e = fs.Stat(Empty, p)
+		if !errors.Is(e, fs.ErrNotExist) {
+			t.Logf("Didn't get ErrNotExist from Stat(%s): %v\n", p, e)
+			t.FailNow()
+		}
+		_, e = fs.ReadFile(Empty, p)
+		if !errors.Is(e, fs.ErrNotExist) {
+			t.Logf("Didn't get ErrNotExist from ReadFile(%s): %v\n", p, e)
+			t.FailNow()
+		}
+		_, e = fs.ReadDir(Empty, p)
+		if !errors.Is(e, fs.ErrNotExist) {
+			t.Logf("Didn't get ErrNotExist from ReadDir(%s): %v\n", p, e)
+			t.FailNow()
+		}
+	}
+	_, e = Empty.Open("../a")
+	if !errors.Is(e, fs.ErrInvalid) {
+		t.Logf("Didn't get ErrInvalid for an invalid path: %v\n", e)
+		t.FailNow()
+	}
+
+	// Empty must work as a placeholder layer.
+	base := fstest.MapFS{"a.txt": newMapFile("a")}
+	merged := NewMergedFS(Empty, base)
+	e = fstest.TestFS(merged, "a.txt")
+	if e != nil {
+		t.Logf("TestFS failed with Empty as a layer: %s\n", e)
+		t.FailNow()
+	}
+	merged = NewMergedFS(base, Empty)
+	e = fstest.TestFS(merged, "a.txt")
+	if e != nil {
+		t.Logf("TestFS failed with Empty as the lower layer: %s\n", e)
+		t.FailNow()
+	}
+}
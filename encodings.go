@@ -0,0 +1,76 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"path"
+)
+
+// Describes a precompressed copy of a file, such as "app.js.br" for
+// "app.js". See EncodingVariants.
+type EncodingVariant struct {
+	// The content coding, as used in HTTP's Content-Encoding header, e.g.
+	// "br" or "gzip".
+	Encoding string
+	// The path of the precompressed file.
+	Path string
+	// The precompressed file's metadata.
+	Info fs.FileInfo
+}
+
+// The file extensions recognized by EncodingVariants, in the order they're
+// returned.
+var encodingExtensions = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// Returns the precompressed variants of the file at p that exist in m, i.e.
+// regular files named p+".br", p+".zst", or p+".gz", with their
+// encodings "br", "zstd", and "gzip", respectively. Variants are returned in
+// that order, which is roughly the order of preference when a client accepts
+// more than one. Each variant is resolved in the merged view like any other
+// path, so a variant may come from a different layer than the file itself.
+// This only reads p's parent directory once, rather than probing every
+// layer for each possible extension. Returns an empty slice, not an error, if
+// the parent directory exists but contains no variants.
+//
+// Servers can use this to serve "app.js.br" in response to a request for
+// "app.js", based on the request's Accept-Encoding header. Such servers
+// should also set the "Vary: Accept-Encoding" header, and use different ETags
+// for different variants.
+func (m *MergedFS) EncodingVariants(p string) ([]EncodingVariant, error) {
+	if !fs.ValidPath(p) || (p == ".") {
+		return nil, &fs.PathError{Op: "variants", Path: p, Err: fs.ErrInvalid}
+	}
+	dir := path.Dir(p)
+	entries, e := fs.ReadDir(m, dir)
+	if e != nil {
+		return nil, e
+	}
+	byName := make(map[string]fs.DirEntry, len(entries))
+	for _, entry := range entries {
+		byName[entry.Name()] = entry
+	}
+	base := path.Base(p)
+	toReturn := make([]EncodingVariant, 0, len(encodingExtensions))
+	for _, v := range encodingExtensions {
+		entry := byName[base+v.extension]
+		if (entry == nil) || !entry.Type().IsRegular() {
+			continue
+		}
+		info, e := entry.Info()
+		if e != nil {
+			return nil, e
+		}
+		toReturn = append(toReturn, EncodingVariant{
+			Encoding: v.encoding,
+			Path:     path.Join(dir, base+v.extension),
+			Info:     info,
+		})
+	}
+	return toReturn, nil
+}
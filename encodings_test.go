@@ -0,0 +1,49 @@
+package merged_fs
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestEncodingVariants(t *testing.T) {
+	fsA := fstest.MapFS{
+		"js/app.js":    newMapFile("app"),
+		"js/app.js.gz": newMapFile("gzipped app"),
+	}
+	fsB := fstest.MapFS{
+		"js/app.js.br":    newMapFile("brotli app"),
+		"js/app.js.gz":    newMapFile("old gzipped app"),
+		"js/other.js.zst": newMapFile("zstd other"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	variants, e := merged.EncodingVariants("js/app.js")
+	if e != nil {
+		t.Logf("Failed getting variants: %s\n", e)
+		t.FailNow()
+	}
+	if len(variants) != 2 {
+		t.Logf("Expected 2 variants, got %d\n", len(variants))
+		t.FailNow()
+	}
+	if (variants[0].Encoding != "br") || (variants[0].Path != "js/app.js.br") {
+		t.Logf("Got wrong first variant: %s, %s\n", variants[0].Encoding,
+			variants[0].Path)
+		t.FailNow()
+	}
+	if (variants[1].Encoding != "gzip") || (variants[1].Info.Size() != 11) {
+		t.Logf("Got wrong second variant: %s, %d bytes\n",
+			variants[1].Encoding, variants[1].Info.Size())
+		t.FailNow()
+	}
+	variants, e = merged.EncodingVariants("js/none.js")
+	if (e != nil) || (len(variants) != 0) {
+		t.Logf("Expected no variants for none.js, got %d (error %v)\n",
+			len(variants), e)
+		t.FailNow()
+	}
+	_, e = merged.EncodingVariants("missing/app.js")
+	if e == nil {
+		t.Logf("Didn't get expected error for a missing directory.\n")
+		t.FailNow()
+	}
+}
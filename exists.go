@@ -0,0 +1,110 @@
+package merged_fs
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"sort"
+	"sync/atomic"
+)
+
+// Returns true if a file or directory exists at the given path in m, which is
+// the case if and only if Open would succeed. Returns false, with a nil error,
+// if it doesn't exist. This is intended for callers that only need to know
+// whether a path exists, such as routers deciding between serving a static
+// file and falling back to a single-page app's index, and is cheaper than
+// opening and closing the path: if the parent directory is in m's directory
+// cache (see UseDirectoryCaching), the answer comes from its cached entries
+// without accessing any layer or creating any file handles.
+//
+// The cache can't be used while middleware, priority overrides, pins,
+// conflict resolvers, aliases, layer visibility hooks, a nesting limit, or
+// ResolveAcrossLayers are in effect, or for symbolic links, in which case
+// this opens and closes the path, without recording it for the audit sink.
+// The authorizer (see SetAuthorizer) is always consulted, with the op
+// "exists", and its errors are returned rather than treated as nonexistence.
+// Returns an error if the path is invalid or can't be checked for reasons
+// other than not existing.
+func (m *MergedFS) Exists(p string) (bool, error) {
+	if !fs.ValidPath(p) {
+		return false, &fs.PathError{Op: "exists", Path: p, Err: fs.ErrInvalid}
+	}
+	e := m.checkPathDepth("exists", p)
+	if e != nil {
+		return false, e
+	}
+	e = m.authorize(context.Background(), "exists", p)
+	if e != nil {
+		return false, e
+	}
+	if exists, ok := m.cachedExists(p); ok {
+		return exists, nil
+	}
+	f, e := m.openAudited(context.Background(), p, nil)
+	if e != nil {
+		if isBadPathError(e) {
+			return false, nil
+		}
+		return false, e
+	}
+	f.Close()
+	return true, nil
+}
+
+// Answers Exists using the cached entries of p's parent directory. Returns
+// false for ok if the parent directory isn't cached, or the answer may differ
+// from Open's.
+func (m *MergedFS) cachedExists(p string) (exists, ok bool) {
+	if p == "." {
+		return false, false
+	}
+	m.checkGates()
+	m.configMutex.RLock()
+	plain := (m.opener == nil) && (len(m.priorityOverrides) == 0) &&
+		(len(m.pins) == 0) && (len(m.conflictRules) == 0) &&
+		(len(m.aliases) == 0) && (atomic.LoadInt64(&m.maxNestingDepth) <= 0) &&
+		!m.resolvesAcrossLayers()
+	m.configMutex.RUnlock()
+	if !plain {
+		return false, false
+	}
+	m.dirCacheMutex.Lock()
+	var d *MergedDirectory
+	if (m.dirCache != nil) && !m.visibilityHooks {
+		d = m.dirCache[path.Dir(p)]
+	}
+	m.dirCacheMutex.Unlock()
+	if d == nil {
+		return false, false
+	}
+	name := baseName(p)
+	entries := d.entries
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Name() >= name
+	})
+	if (i == len(entries)) || (entries[i].Name() != name) {
+		return false, true
+	}
+	if entries[i].Type()&fs.ModeSymlink != 0 {
+		// The link's target may not exist.
+		return false, false
+	}
+	return true, true
+}
+
+// Returns true if a file or directory exists at the given path, answering
+// from the index without accessing any layer, unless the path is a symbolic
+// link or within one, in which case this uses the MergedFS's Exists.
+func (x *IndexFS) Exists(p string) (bool, error) {
+	r, e := x.lookup("exists", p, false)
+	if e != nil {
+		if isBadPathError(e) && fs.ValidPath(p) {
+			return false, nil
+		}
+		return false, e
+	}
+	if r == nil {
+		return x.source.Exists(p)
+	}
+	return true, nil
+}
@@ -0,0 +1,110 @@
+package merged_fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+// Fails the test if m.Exists doesn't return the expected result for p.
+func checkExists(t *testing.T, m interface {
+	Exists(p string) (bool, error)
+}, p string, expected bool) {
+	exists, e := m.Exists(p)
+	if e != nil {
+		t.Logf("Failed checking whether %s exists: %s\n", p, e)
+		t.FailNow()
+	}
+	if exists != expected {
+		t.Logf("Expected Exists(%q) to be %v, got %v\n", p, expected, exists)
+		t.FailNow()
+	}
+}
+
+func TestExists(t *testing.T) {
+	fsA := &openCountingFS{FS: fstest.MapFS{
+		"dir/a.txt": newMapFile("in A"),
+		"shared":    newMapFile("a file in A"),
+	}}
+	fsB := &openCountingFS{FS: fstest.MapFS{
+		"dir/b.txt":     newMapFile("in B"),
+		"shared/hidden": newMapFile("hidden by A"),
+	}}
+	m := NewMergedFS(fsA, fsB)
+
+	// Without the directory cache, each check opens the path.
+	checkExists(t, m, "dir/a.txt", true)
+	checkExists(t, m, "dir/b.txt", true)
+	checkExists(t, m, "dir/missing.txt", false)
+	checkExists(t, m, "shared/hidden", false)
+	checkExists(t, m, ".", true)
+	_, e := m.Exists("../invalid")
+	if !errors.Is(e, fs.ErrInvalid) {
+		t.Logf("Didn't get an error for an invalid path: %v\n", e)
+		t.FailNow()
+	}
+
+	m.UseDirectoryCaching(true)
+	_, e = m.ReadDir("dir")
+	if e != nil {
+		t.Logf("Failed reading dir: %s\n", e)
+		t.FailNow()
+	}
+	opens := atomic.LoadInt64(&fsA.opens) + atomic.LoadInt64(&fsB.opens)
+	checkExists(t, m, "dir/a.txt", true)
+	checkExists(t, m, "dir/b.txt", true)
+	checkExists(t, m, "dir/missing.txt", false)
+	checkExists(t, m, "dir", true)
+	if atomic.LoadInt64(&fsA.opens)+atomic.LoadInt64(&fsB.opens) != opens {
+		t.Logf("Accessed layers to check paths in a cached directory.\n")
+		t.FailNow()
+	}
+
+	m.SetAuthorizer(func(ctx context.Context, p, op string) error {
+		if op != "exists" {
+			t.Logf("Got unexpected op for Exists: %s\n", op)
+			t.FailNow()
+		}
+		return errors.New("denied")
+	})
+	_, e = m.Exists("dir/a.txt")
+	if !errors.Is(e, fs.ErrPermission) {
+		t.Logf("Didn't get a permission error for a denied path: %v\n", e)
+		t.FailNow()
+	}
+	m.SetAuthorizer(nil)
+
+	var buf bytes.Buffer
+	e = m.WriteIndex(&buf)
+	if e != nil {
+		t.Logf("Failed writing index: %s\n", e)
+		t.FailNow()
+	}
+	indexPath := filepath.Join(t.TempDir(), "merged.index")
+	e = os.WriteFile(indexPath, buf.Bytes(), 0644)
+	if e != nil {
+		t.Logf("Failed saving index: %s\n", e)
+		t.FailNow()
+	}
+	index, e := OpenIndex(m, indexPath)
+	if e != nil {
+		t.Logf("Failed opening index: %s\n", e)
+		t.FailNow()
+	}
+	defer index.Close()
+	opens = atomic.LoadInt64(&fsA.opens) + atomic.LoadInt64(&fsB.opens)
+	checkExists(t, index, "dir/b.txt", true)
+	checkExists(t, index, "shared", true)
+	checkExists(t, index, "shared/hidden", false)
+	checkExists(t, index, "missing/file.txt", false)
+	if atomic.LoadInt64(&fsA.opens)+atomic.LoadInt64(&fsB.opens) != opens {
+		t.Logf("Accessed layers to check paths in an index.\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,67 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+)
+
+// Returns every path that should be visible in m, other than ".", in lexical
+// order, for use as the list of expected files passed to fstest.TestFS:
+//
+//	expected, e := merged_fs.ExpectedPaths(merged)
+//	if e != nil {
+//		t.Fatal(e)
+//	}
+//	e = fstest.TestFS(merged, expected...)
+//
+// The list is computed by walking each of m's layers (see Layers), rather
+// than m itself, and applying the merge rules documented for NewMergedFS:
+// the highest-priority layer containing a path determines whether it's a
+// file or a directory, so a file shadows any lower-priority directories (and
+// their contents) at the same path, and a directory masks any lower-priority
+// files at the same path. This makes it an independent check of the merge,
+// which doesn't go stale when the layers' contents change.
+//
+// Only the layers' contents are considered, so the list doesn't reflect
+// settings such as priority overrides, pins, aliases, or tenant rules, of m
+// or any MergedFS nested within it. Returns an error if walking any layer
+// fails.
+func ExpectedPaths(m *MergedFS) ([]string, error) {
+	// Maps each visible path to whether it's a directory.
+	visible := map[string]bool{".": true}
+	for i, layer := range m.Layers() {
+		e := fs.WalkDir(layer, ".", func(p string, d fs.DirEntry,
+			e error) error {
+			if e != nil {
+				return e
+			}
+			isDir, decided := visible[p]
+			if !decided {
+				// Any parent directory of p must be visible and a directory
+				// in this layer, or we wouldn't have walked into it.
+				visible[p] = d.IsDir()
+				return nil
+			}
+			if isDir && d.IsDir() {
+				return nil
+			}
+			// A higher-priority layer has something else at p.
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		})
+		if e != nil {
+			return nil, fmt.Errorf("Couldn't walk layer %d: %w", i, e)
+		}
+	}
+	toReturn := make([]string, 0, len(visible)-1)
+	for p := range visible {
+		if p != "." {
+			toReturn = append(toReturn, p)
+		}
+	}
+	sort.Strings(toReturn)
+	return toReturn, nil
+}
@@ -0,0 +1,66 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExpectedPaths(t *testing.T) {
+	zip1 := openZip("test_data/test_a.zip", t)
+	zip2 := openZip("test_data/test_b.zip", t)
+	zip3 := openZip("test_data/test_c.zip", t)
+	merged := NewMergedFS(zip1, NewMergedFS(zip2, zip3))
+	expected, e := ExpectedPaths(merged)
+	if e != nil {
+		t.Logf("Failed getting expected paths: %s\n", e)
+		t.FailNow()
+	}
+	t.Logf("Expected paths: %v\n", expected)
+	// These must be included, as listed by TestMergedFS.
+	required := []string{"test1.txt", "test2.txt", "test3.txt", "b/0.txt",
+		"b/1.txt", "b", "a"}
+	for _, p := range required {
+		found := false
+		for _, q := range expected {
+			found = found || (p == q)
+		}
+		if !found {
+			t.Logf("Expected paths are missing %s\n", p)
+			t.FailNow()
+		}
+	}
+	for _, p := range expected {
+		if strings.HasPrefix(p, "a/") {
+			t.Logf("Expected paths include %s, within the file a\n", p)
+			t.FailNow()
+		}
+	}
+	e = fstest.TestFS(merged, expected...)
+	if e != nil {
+		t.Logf("TestFS failed with expected paths: %s\n", e)
+		t.FailNow()
+	}
+
+	fsA := fstest.MapFS{
+		"file_over_dir": newMapFile("shadows a directory"),
+		"dir_over_file": &fstest.MapFile{Mode: fs.ModeDir | 0755},
+		"dir/a.txt":     newMapFile("in A"),
+	}
+	fsB := fstest.MapFS{
+		"file_over_dir/hidden.txt": newMapFile("hidden"),
+		"dir_over_file":            newMapFile("masked"),
+		"dir/b.txt":                newMapFile("in B"),
+	}
+	expected, e = ExpectedPaths(NewMergedFS(fsA, fsB))
+	if e != nil {
+		t.Logf("Failed getting expected paths: %s\n", e)
+		t.FailNow()
+	}
+	joined := strings.Join(expected, " ")
+	if joined != "dir dir/a.txt dir/b.txt dir_over_file file_over_dir" {
+		t.Logf("Got wrong expected paths: %s\n", joined)
+		t.FailNow()
+	}
+}
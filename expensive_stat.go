@@ -0,0 +1,125 @@
+package merged_fs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sync/atomic"
+)
+
+// Implemented by FSs whose files are expensive to Stat, such as some remote
+// filesystems, in which Open is cheap but Stat requires a round trip. Setting
+// SlowStat on a Layer has the same effect for FSs that don't implement this.
+//
+// When A is such an FS, a MergedFS opening a path that exists in A first
+// checks B, and only calls Stat on A's file if B contains a directory at the
+// same path, which is the only case in which A's file type matters. This
+// means B is probed for paths that are regular files in A, which it
+// otherwise wouldn't be, so it's only worthwhile if B is cheaper to access
+// than Stat is for A.
+type ExpensiveStatFS interface {
+	fs.FS
+	// Returns true if calling Stat on files from the FS is expensive.
+	ExpensiveStat() bool
+}
+
+// Returns true if SlowStat is set, or if l.FS reports that Stat is
+// expensive.
+func (l *Layer) ExpensiveStat() bool {
+	return l.SlowStat || hasExpensiveStat(l.FS)
+}
+
+// Returns true if Stat is expensive for files from fsys. For a MergedFS or
+// Group, this is true if it's true for either side.
+func hasExpensiveStat(fsys fs.FS) bool {
+	switch v := fsys.(type) {
+	case *MergedFS:
+		return hasExpensiveStat(v.A) || hasExpensiveStat(v.B)
+	case *Group:
+		return hasExpensiveStat(v.MergedFS)
+	case ExpensiveStatFS:
+		return v.ExpensiveStat()
+	}
+	return false
+}
+
+// Returns true if m should avoid calling Stat on files it opens from A.
+func (m *MergedFS) avoidsStat() bool {
+	m.expensiveStatOnce.Do(func() {
+		m.expensiveStatA = hasExpensiveStat(m.A)
+	})
+	// Read fallback needs to know whether the file in A is a regular file.
+	return m.expensiveStatA && (atomic.LoadInt32(&m.readFallback) == 0)
+}
+
+// Finishes opening a path that was successfully opened as fA in A, when Stat
+// is expensive for A. This only calls Stat on fA if B has a directory at the
+// same path. Otherwise, A takes priority regardless of what fA is.
+func (m *MergedFS) openAvoidingStat(ctx context.Context, fA fs.File,
+	path string, generation uint64) (fs.File, error) {
+	traceStep(ctx, "probe", m.layerName(0), "found something; checking %s "+
+		"before calling Stat", m.layerName(1))
+	// Set if B couldn't be checked, in which case fB is nil.
+	var bError error
+	fB, e := m.openLayer(ctx, 1, path)
+	if e != nil {
+		if isBadPathError(e) {
+			traceStep(ctx, "decision", m.layerName(0), "using %s's copy, "+
+				"since the path doesn't exist in %s", m.layerName(0),
+				m.layerName(1))
+			return m.withProvenance(0, fA), nil
+		}
+		fB = nil
+		bError = fmt.Errorf("Couldn't open %s in FS B: %w", path, e)
+	}
+	var infoB fs.FileInfo
+	if fB != nil {
+		infoB, e = m.statLayerFile(1, fB, path)
+		if e != nil {
+			fB.Close()
+			fB = nil
+			bError = fmt.Errorf("Couldn't stat %s in FS B: %w", path, e)
+		}
+	}
+	if (fB != nil) && !infoB.IsDir() {
+		fB.Close()
+		traceStep(ctx, "decision", m.layerName(0), "using %s's copy, which "+
+			"shadows a file in %s", m.layerName(0), m.layerName(1))
+		return m.withProvenance(0, fA), nil
+	}
+	// B has a directory or failed, so we need to know what A has.
+	infoA, statError := m.statLayerFile(0, fA, path)
+	if statError != nil {
+		fA.Close()
+		if fB != nil {
+			fB.Close()
+		}
+		return nil, fmt.Errorf("Couldn't stat %s in FS A: %w", path,
+			statError)
+	}
+	if !infoA.IsDir() {
+		if fB != nil {
+			fB.Close()
+		}
+		traceStep(ctx, "decision", m.layerName(0), "found a file, which "+
+			"takes priority over %s", m.layerName(1))
+		return m.withProvenance(0, fA), nil
+	}
+	if fB == nil {
+		fA.Close()
+		return nil, bError
+	}
+	if m.symlinksShadow() && m.isLayerSymlink(0, path) {
+		fB.Close()
+		traceStep(ctx, "decision", m.layerName(0), "using the linked "+
+			"directory, which shadows %s", m.layerName(1))
+		return m.withProvenance(0, fA), nil
+	}
+	traceStep(ctx, "decision", "", "merging the directories in %s and %s",
+		m.layerName(0), m.layerName(1))
+	d, e := m.newMergedDirectory(ctx, fA, fB, path)
+	if e != nil {
+		return nil, e
+	}
+	return m.cacheDirectory(path, d, generation), nil
+}
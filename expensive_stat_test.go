@@ -0,0 +1,78 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+// An FS that counts calls to Stat on its files.
+type statCountingFS struct {
+	fs.FS
+	stats int64
+}
+
+type statCountingFile struct {
+	fs.File
+	stats *int64
+}
+
+func (f *statCountingFile) Stat() (fs.FileInfo, error) {
+	atomic.AddInt64(f.stats, 1)
+	return f.File.Stat()
+}
+
+func (f *statCountingFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	return f.File.(fs.ReadDirFile).ReadDir(n)
+}
+
+func (f *statCountingFS) Open(path string) (fs.File, error) {
+	file, e := f.FS.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	return &statCountingFile{File: file, stats: &f.stats}, nil
+}
+
+func TestExpensiveStat(t *testing.T) {
+	remote := &statCountingFS{FS: fstest.MapFS{
+		"remote.txt":     newMapFile("remote"),
+		"dir/remote.txt": newMapFile("remote"),
+		"shadow":         newMapFile("a file shadowing B's directory"),
+	}}
+	local := fstest.MapFS{
+		"dir/local.txt":    newMapFile("local"),
+		"shadow/local.txt": newMapFile("hidden"),
+	}
+	merged := NewMergedFS(&Layer{FS: remote, SlowStat: true}, local)
+	e := fstest.TestFS(merged, "remote.txt", "dir/remote.txt",
+		"dir/local.txt", "shadow")
+	if e != nil {
+		t.Logf("Merge with slow Stat failed fstest: %s\n", e)
+		t.FailNow()
+	}
+
+	expectStats := func(path string, expected int64) {
+		before := atomic.LoadInt64(&remote.stats)
+		f, e := merged.Open(path)
+		if e != nil {
+			t.Logf("Failed opening %s: %s\n", path, e)
+			t.FailNow()
+		}
+		f.Close()
+		stats := atomic.LoadInt64(&remote.stats) - before
+		if stats != expected {
+			t.Logf("Expected %d Stats opening %s, got %d\n", expected, path,
+				stats)
+			t.FailNow()
+		}
+	}
+	// Nothing in B could conflict, so Stat isn't needed.
+	expectStats("remote.txt", 0)
+	expectStats("dir/remote.txt", 0)
+	// B has a directory at these paths, so A's file type matters.
+	expectStats("shadow", 1)
+	// Merging the directories reads the info for A's directory again.
+	expectStats("dir", 2)
+}
@@ -0,0 +1,119 @@
+package merged_fs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// A single step taken while resolving a path. See Explain.
+type ResolutionStep struct {
+	// How deeply nested the MergedFS taking this step is within the one
+	// Explain was called on, which has depth 0. MergeMultiple creates nested
+	// MergedFS instances.
+	Depth int
+	// The kind of step, e.g. "probe", "cache", "shadow check", "alias",
+	// "override", "hidden", or "decision".
+	Action string
+	// The name of the layer involved in the step, as used in errors and
+	// debug output, or an empty string if the step didn't involve a layer.
+	Layer string
+	// A human-readable description of the step.
+	Detail string
+}
+
+// Describes how a MergedFS resolved a path. See Explain.
+type Resolution struct {
+	// The path that was resolved.
+	Path string
+	// The steps taken, in order.
+	Steps []ResolutionStep
+	// The error returned by Open, or nil if the path was opened
+	// successfully.
+	Err error
+}
+
+// Returns a multi-line, human-readable description of the resolution.
+func (r *Resolution) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "Resolving %q:\n", r.Path)
+	for i, step := range r.Steps {
+		fmt.Fprintf(&s, "%s%d. %s", strings.Repeat("  ", step.Depth+1), i+1,
+			step.Action)
+		if step.Layer != "" {
+			fmt.Fprintf(&s, " [%s]", step.Layer)
+		}
+		fmt.Fprintf(&s, ": %s\n", step.Detail)
+	}
+	if r.Err != nil {
+		fmt.Fprintf(&s, "Result: error: %s\n", r.Err)
+	} else {
+		s.WriteString("Result: opened successfully\n")
+	}
+	return s.String()
+}
+
+// The key for a *resolutionTrace stored in a context.
+type traceKey struct{}
+
+// Records the steps taken while resolving a path for Explain.
+type resolutionTrace struct {
+	// The current nesting depth, incremented by each nested MergedFS.
+	depth int
+	steps []ResolutionStep
+}
+
+// Returns the trace stored in ctx, or nil if there isn't one.
+func traceFromContext(ctx context.Context) *resolutionTrace {
+	t, _ := ctx.Value(traceKey{}).(*resolutionTrace)
+	return t
+}
+
+// Records a resolution step if ctx is being traced by Explain. Does nothing
+// (including formatting the detail) otherwise.
+func traceStep(ctx context.Context, action, layer, format string,
+	args ...interface{}) {
+	t := traceFromContext(ctx)
+	if t == nil {
+		return
+	}
+	t.steps = append(t.steps, ResolutionStep{
+		Depth:  t.depth - 1,
+		Action: action,
+		Layer:  layer,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+// Opens path in m in the same way as Open, and returns a description of each
+// step taken along the way: layers probed, cache hits, checks that a file in
+// a higher-priority layer doesn't shadow the path, and the final decision. The
+// file is closed before Explain returns. This is intended to help answer
+// questions such as "why am I getting this copy of the file?"
+//
+// Middleware, read quotas, and open-file tracking aren't applied, but Explain
+// otherwise uses (and may fill) m's caches exactly as Open would, so calling
+// it twice may show cache hits the second time. The authorizer (see
+// SetAuthorizer) is called with the op "open" and context.Background(), and
+// if it denies the path, the Resolution has no steps, so Explain never
+// reveals which layers hold a path the caller can't open.
+func (m *MergedFS) Explain(path string) *Resolution {
+	e := m.authorize(context.Background(), "open", path)
+	if e != nil {
+		return &Resolution{
+			Path: path,
+			Err:  e,
+		}
+	}
+	t := &resolutionTrace{}
+	ctx := context.WithValue(context.Background(), traceKey{}, t)
+	f, e := m.openInternal(ctx, path)
+	if f != nil {
+		f.Close()
+	}
+	return &Resolution{
+		Path:  path,
+		Steps: t.steps,
+		Err:   e,
+	}
+}
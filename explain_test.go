@@ -0,0 +1,79 @@
+package merged_fs
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExplain(t *testing.T) {
+	fsA := &Layer{
+		Name: "mods",
+		FS: fstest.MapFS{
+			"textures":     newMapFile("not a dir"),
+			"sounds/a.wav": newMapFile("a"),
+		},
+	}
+	fsB := &Layer{
+		Name: "base",
+		FS: fstest.MapFS{
+			"textures/wall.png": newMapFile("wall"),
+			"sounds/b.wav":      newMapFile("b"),
+		},
+	}
+	merged := NewMergedFS(fsA, fsB)
+	r := merged.Explain("textures/wall.png")
+	if r.Err == nil {
+		t.Logf("Expected an error for a shadowed path.\n")
+		t.FailNow()
+	}
+	var actions []string
+	for _, step := range r.Steps {
+		actions = append(actions, step.Action)
+	}
+	expected := "probe probe shadow check"
+	if strings.Join(actions, " ") != expected {
+		t.Logf("Got steps %v, expected %s\n%s", actions, expected, r)
+		t.FailNow()
+	}
+	last := r.Steps[len(r.Steps)-1]
+	if (last.Layer != "mods") || !strings.Contains(last.Detail, "textures") {
+		t.Logf("Got wrong shadow check step: %+v\n", last)
+		t.FailNow()
+	}
+
+	r = merged.Explain("sounds/b.wav")
+	if r.Err != nil {
+		t.Logf("Failed explaining sounds/b.wav: %s\n", r.Err)
+		t.FailNow()
+	}
+	last = r.Steps[len(r.Steps)-1]
+	if (last.Action != "decision") || (last.Layer != "base") {
+		t.Logf("Got wrong final step: %+v\n%s", last, r)
+		t.FailNow()
+	}
+
+	// Nested merges should show up with greater depths.
+	nested := MergeMultiple(fstest.MapFS{}, fstest.MapFS{},
+		fstest.MapFS{"x.txt": newMapFile("x")}).(*MergedFS)
+	r = nested.Explain("x.txt")
+	if r.Err != nil {
+		t.Logf("Failed explaining x.txt: %s\n", r.Err)
+		t.FailNow()
+	}
+	maxDepth := 0
+	for _, step := range r.Steps {
+		if step.Depth > maxDepth {
+			maxDepth = step.Depth
+		}
+	}
+	if maxDepth != 1 {
+		t.Logf("Expected steps with depth 1, got max depth %d\n%s", maxDepth,
+			r)
+		t.FailNow()
+	}
+	if !strings.Contains(r.String(), "Result: opened successfully") {
+		t.Logf("Got wrong string for resolution:\n%s", r)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,225 @@
+package merged_fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// Writes the contents of fsys, which is typically a MergedFS, to w as a zip
+// archive. Files are written in the order that fs.WalkDir visits them.
+//
+// Metadata is preserved from the layer each file came from where possible: if
+// a file's FileInfo.Sys() returns a *zip.FileHeader (as it does for files in a
+// *zip.Reader), the entry uses that header's compression method and comment.
+// Other fields of the original header, such as its flags and extra fields,
+// describe how the original archive was written, so they aren't copied. In
+// all cases, the entry's header is created using zip.FileInfoHeader, so it
+// keeps the file's mode and modification time, and files without an original
+// header are compressed using zip.Deflate. Only regular files and directories
+// are exported.
+func ExportZip(w io.Writer, fsys fs.FS) error {
+	return ExportZipWithOptions(w, fsys, ExportOptions{})
+}
+
+// The same as ExportZip, but files without original zip headers may be
+// stored uncompressed instead of being deflated, and the files to export may
+// be filtered, according to the options. If any Include or Exclude patterns
+// are set, directories are only exported if they contain an exported file.
+func ExportZipWithOptions(w io.Writer, fsys fs.FS, opts ExportOptions) error {
+	filter, e := newExportFilter(&opts)
+	if e != nil {
+		return e
+	}
+	zw := zip.NewWriter(w)
+	e = filter.walk(fsys, func(p string, info fs.FileInfo) error {
+		header, e := zipHeaderForExport(p, info)
+		if e != nil {
+			return e
+		}
+		if info.IsDir() {
+			_, e = zw.CreateHeader(header)
+			if e != nil {
+				return fmt.Errorf("Couldn't create zip entry for %s: %w", p, e)
+			}
+			return nil
+		}
+		f, e := fsys.Open(p)
+		if e != nil {
+			return e
+		}
+		defer f.Close()
+		var content io.Reader = f
+		if _, preserved := info.Sys().(*zip.FileHeader); !preserved {
+			var store bool
+			store, content, e = opts.shouldStore(p, info.Size(), f)
+			if e != nil {
+				return fmt.Errorf("Couldn't export %s: %w", p, e)
+			}
+			if store {
+				header.Method = zip.Store
+			}
+		}
+		dst, e := zw.CreateHeader(header)
+		if e != nil {
+			return fmt.Errorf("Couldn't create zip entry for %s: %w", p, e)
+		}
+		_, e = io.Copy(filter.hashing(dst), content)
+		if e != nil {
+			return fmt.Errorf("Couldn't export %s: %w", p, e)
+		}
+		return nil
+	})
+	if e != nil {
+		zw.Close()
+		return e
+	}
+	e = zw.Close()
+	if e != nil {
+		return e
+	}
+	return filter.finish()
+}
+
+// Returns the header to use for exporting the file at p to a zip archive.
+func zipHeaderForExport(p string, info fs.FileInfo) (*zip.FileHeader,
+	error) {
+	header, e := zip.FileInfoHeader(info)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't create zip header for %s: %w", p, e)
+	}
+	header.Method = zip.Deflate
+	if original, ok := info.Sys().(*zip.FileHeader); ok {
+		header.Method = original.Method
+		header.Comment = original.Comment
+	}
+	header.Name = p
+	if info.IsDir() {
+		header.Name += "/"
+		header.Method = zip.Store
+	}
+	// The sizes and checksum will be recomputed by the zip.Writer.
+	header.CRC32 = 0
+	header.CompressedSize64 = 0
+	header.UncompressedSize64 = 0
+	return header, nil
+}
+
+// Writes the contents of fsys to w as a tar archive. Files are written in the
+// order that fs.WalkDir visits them.
+//
+// As with ExportZip, metadata is preserved from the layer each file came from
+// where possible: if a file's FileInfo.Sys() returns a *tar.Header, the
+// entry's mode, owner, times, and PAX records are taken from it. Otherwise,
+// the header is created using tar.FileInfoHeader, which preserves the file's
+// mode and modification time. Only regular files and directories are
+// exported.
+func ExportTar(w io.Writer, fsys fs.FS) error {
+	return ExportTarWithOptions(w, fsys, ExportOptions{})
+}
+
+// The same as ExportTar, but the tar stream may be compressed, and the files
+// to export may be filtered, according to the options. If any Include or
+// Exclude patterns are set, directories are only exported if they contain an
+// exported file. Returns an error without writing anything if the options
+// name a compressor that isn't registered or contain a malformed pattern.
+func ExportTarWithOptions(w io.Writer, fsys fs.FS, opts ExportOptions) error {
+	filter, e := newExportFilter(&opts)
+	if e != nil {
+		return e
+	}
+	if opts.TarCompressor == "" {
+		e = exportTar(w, fsys, filter)
+	} else {
+		e = exportCompressedTar(w, fsys, filter, opts.TarCompressor)
+	}
+	if e != nil {
+		return e
+	}
+	return filter.finish()
+}
+
+// Writes a tar stream compressed using the named compressor.
+func exportCompressedTar(w io.Writer, fsys fs.FS, filter *exportFilter,
+	name string) error {
+	compressor, e := getCompressor(name)
+	if e != nil {
+		return e
+	}
+	cw, e := compressor(w)
+	if e != nil {
+		return fmt.Errorf("Couldn't create %s compressor: %w", name, e)
+	}
+	e = exportTar(cw, fsys, filter)
+	closeErr := cw.Close()
+	if e != nil {
+		return e
+	}
+	return closeErr
+}
+
+// Writes the uncompressed tar stream for ExportTarWithOptions.
+func exportTar(w io.Writer, fsys fs.FS, filter *exportFilter) error {
+	tw := tar.NewWriter(w)
+	e := filter.walk(fsys, func(p string, info fs.FileInfo) error {
+		header, e := tarHeaderForExport(p, info)
+		if e != nil {
+			return e
+		}
+		e = tw.WriteHeader(header)
+		if e != nil {
+			return fmt.Errorf("Couldn't write tar header for %s: %w", p, e)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return copyFileForExport(filter.hashing(tw), fsys, p)
+	})
+	if e != nil {
+		tw.Close()
+		return e
+	}
+	return tw.Close()
+}
+
+// Returns the header to use for exporting the file at p to a tar archive.
+func tarHeaderForExport(p string, info fs.FileInfo) (*tar.Header, error) {
+	var header *tar.Header
+	if original, ok := info.Sys().(*tar.Header); ok {
+		copied := *original
+		header = &copied
+	} else {
+		var e error
+		header, e = tar.FileInfoHeader(info, "")
+		if e != nil {
+			return nil, fmt.Errorf("Couldn't create tar header for %s: %w", p,
+				e)
+		}
+	}
+	header.Name = p
+	if info.IsDir() {
+		header.Name += "/"
+		header.Typeflag = tar.TypeDir
+		header.Size = 0
+	} else {
+		header.Typeflag = tar.TypeReg
+		header.Size = info.Size()
+	}
+	return header, nil
+}
+
+// Copies the contents of the file at p in fsys to w.
+func copyFileForExport(w io.Writer, fsys fs.FS, p string) error {
+	f, e := fsys.Open(p)
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+	_, e = io.Copy(w, f)
+	if e != nil {
+		return fmt.Errorf("Couldn't export %s: %w", p, e)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package merged_fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// The manifest written to ExportOptions.Manifest, listing every regular file
+// that was exported.
+type ExportManifest struct {
+	Files []ManifestEntry `json:"files"`
+}
+
+// Describes a single exported file in an ExportManifest.
+type ManifestEntry struct {
+	Path string `json:"path"`
+	// The index of the layer the file came from, as returned by
+	// ProvenanceEntry, or -1 if the exported FS didn't report it (e.g.,
+	// because it isn't a MergedFS).
+	Layer int `json:"layer"`
+	// The name of the layer the file came from, if it's a named *Layer.
+	LayerName string `json:"layer_name,omitempty"`
+	Size      int64  `json:"size"`
+	// The hex-encoded SHA-256 hash of the file's content. Provided by the
+	// layer without reading the content, if the layer implements HashFS.
+	SHA256 string `json:"sha256"`
+}
+
+// Applies an ExportOptions' patterns and builds its manifest during an
+// export.
+type exportFilter struct {
+	opts *ExportOptions
+	// The directories visited so far, and whether each has been exported.
+	// Only used if any patterns are set, in which case directories are only
+	// exported when something within them is.
+	dirs     map[string]fs.FileInfo
+	exported map[string]bool
+	manifest ExportManifest
+	// Hashes the content of the file currently being exported, if a
+	// manifest is being written.
+	hash hash.Hash
+}
+
+func newExportFilter(opts *ExportOptions) (*exportFilter, error) {
+	for _, patterns := range [][]string{opts.Include, opts.Exclude} {
+		for _, p := range patterns {
+			e := validatePattern(p)
+			if e != nil {
+				return nil, fmt.Errorf("Invalid pattern %q: %w", p, e)
+			}
+		}
+	}
+	return &exportFilter{
+		opts:     opts,
+		dirs:     make(map[string]fs.FileInfo),
+		exported: make(map[string]bool),
+	}, nil
+}
+
+func (f *exportFilter) filtering() bool {
+	return (len(f.opts.Include) != 0) || (len(f.opts.Exclude) != 0)
+}
+
+func (f *exportFilter) excluded(p string) bool {
+	for _, pattern := range f.opts.Exclude {
+		if matchPattern(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if p matches an Include pattern, or if prefixOK is set and a
+// path within p could match one.
+func (f *exportFilter) included(p string, prefixOK bool) bool {
+	if len(f.opts.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.opts.Include {
+		if matchPattern(pattern, p) {
+			return true
+		}
+		if prefixOK && matchesWithin(pathComponents(pattern),
+			pathComponents(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Walks fsys, calling fn for each regular file and directory that should be
+// exported, in the order fs.WalkDir visits them. If any patterns are set,
+// directories are only passed to fn just before the first file within them.
+func (f *exportFilter) walk(fsys fs.FS, fn func(p string,
+	info fs.FileInfo) error) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if p == "." {
+			return nil
+		}
+		if f.excluded(p) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, e := d.Info()
+		if e != nil {
+			return e
+		}
+		if info.IsDir() {
+			if !f.filtering() {
+				return fn(p, info)
+			}
+			if !f.included(p, true) {
+				return fs.SkipDir
+			}
+			f.dirs[p] = info
+			return nil
+		}
+		if !info.Mode().IsRegular() || !f.included(p, false) {
+			return nil
+		}
+		if f.filtering() {
+			e = f.exportParents(path.Dir(p), fn)
+			if e != nil {
+				return e
+			}
+		}
+		e = f.addToManifest(fsys, p, d, info)
+		if e != nil {
+			return e
+		}
+		e = fn(p, info)
+		if f.hash != nil {
+			last := &f.manifest.Files[len(f.manifest.Files)-1]
+			last.SHA256 = hex.EncodeToString(f.hash.Sum(nil))
+			f.hash = nil
+		}
+		return e
+	})
+}
+
+// Passes dir and its parents to fn, if they haven't been already.
+func (f *exportFilter) exportParents(dir string, fn func(p string,
+	info fs.FileInfo) error) error {
+	if (dir == ".") || f.exported[dir] {
+		return nil
+	}
+	e := f.exportParents(path.Dir(dir), fn)
+	if e != nil {
+		return e
+	}
+	f.exported[dir] = true
+	return fn(dir, f.dirs[dir])
+}
+
+// Adds a file to the manifest, if one is being written. If fsys is a HashFS
+// that knows the file's hash, it's used. Otherwise, the hash is filled in
+// after the file is exported, using the content written through hashing.
+func (f *exportFilter) addToManifest(fsys fs.FS, p string, d fs.DirEntry,
+	info fs.FileInfo) error {
+	if f.opts.Manifest == nil {
+		return nil
+	}
+	entry := ManifestEntry{
+		Path:  p,
+		Layer: -1,
+		Size:  info.Size(),
+	}
+	if provenance, ok := d.(ProvenanceEntry); ok {
+		entry.Layer, entry.LayerName = provenance.Provenance()
+	}
+	var sum []byte
+	e := hashUnavailable(p)
+	if m, ok := fsys.(*MergedFS); ok {
+		// Avoids listing the parent directory again to find the layer.
+		sum, e = m.entryHash(p, d, "sha256")
+	} else if hashFS, ok := fsys.(HashFS); ok {
+		sum, e = hashFS.Hash(p, "sha256")
+	}
+	if e == nil {
+		entry.SHA256 = hex.EncodeToString(sum)
+		f.manifest.Files = append(f.manifest.Files, entry)
+		return nil
+	}
+	if !errors.Is(e, ErrHashUnavailable) {
+		return fmt.Errorf("Couldn't get the hash of %s: %w", p, e)
+	}
+	f.manifest.Files = append(f.manifest.Files, entry)
+	f.hash = sha256.New()
+	return nil
+}
+
+// Returns a writer that hashes the content of the file being exported for the
+// manifest, in addition to writing it to w. Returns w if no manifest is being
+// written.
+func (f *exportFilter) hashing(w io.Writer) io.Writer {
+	if f.hash == nil {
+		return w
+	}
+	return io.MultiWriter(w, f.hash)
+}
+
+// Writes the manifest, if requested.
+func (f *exportFilter) finish() error {
+	if f.opts.Manifest == nil {
+		return nil
+	}
+	if f.manifest.Files == nil {
+		f.manifest.Files = []ManifestEntry{}
+	}
+	encoder := json.NewEncoder(f.opts.Manifest)
+	encoder.SetIndent("", "  ")
+	e := encoder.Encode(&f.manifest)
+	if e != nil {
+		return fmt.Errorf("Couldn't write export manifest: %w", e)
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package merged_fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestExportZip(t *testing.T) {
+	zip1 := openZip("test_data/test_a.zip", t)
+	zip2 := openZip("test_data/test_b.zip", t)
+	merged := NewMergedFS(zip1, zip2)
+	var buf bytes.Buffer
+	e := ExportZip(&buf, merged)
+	if e != nil {
+		t.Logf("Failed exporting zip: %s\n", e)
+		t.FailNow()
+	}
+	exported, e := zip.NewReader(bytes.NewReader(buf.Bytes()),
+		int64(buf.Len()))
+	if e != nil {
+		t.Logf("Failed reading exported zip: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(exported, "test1.txt", "test2.txt", "test3.txt",
+		"b/1.txt")
+	if e != nil {
+		t.Logf("Exported zip failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	for _, p := range []string{"test1.txt", "b/1.txt"} {
+		original, e := fs.Stat(merged, p)
+		if e != nil {
+			t.Logf("Failed getting original info for %s: %s\n", p, e)
+			t.FailNow()
+		}
+		copied, e := fs.Stat(exported, p)
+		if e != nil {
+			t.Logf("Failed getting exported info for %s: %s\n", p, e)
+			t.FailNow()
+		}
+		originalHeader := original.Sys().(*zip.FileHeader)
+		copiedHeader := copied.Sys().(*zip.FileHeader)
+		if copiedHeader.Method != originalHeader.Method {
+			t.Logf("Compression method of %s wasn't preserved\n", p)
+			t.FailNow()
+		}
+		if !copied.ModTime().Equal(original.ModTime()) {
+			t.Logf("Modification time of %s wasn't preserved: %s vs %s\n",
+				p, copied.ModTime(), original.ModTime())
+			t.FailNow()
+		}
+		if copied.Mode() != original.Mode() {
+			t.Logf("Mode of %s wasn't preserved: %s vs %s\n", p,
+				copied.Mode(), original.Mode())
+			t.FailNow()
+		}
+	}
+}
+
+func TestExportZipHeaderFields(t *testing.T) {
+	// An extra field with an unregistered ID, which mustn't be copied.
+	extra := []byte{0xfe, 0xca, 0x02, 0x00, 0x01, 0x02}
+	var original bytes.Buffer
+	w := zip.NewWriter(&original)
+	header := &zip.FileHeader{
+		Name:     "a.txt",
+		Method:   zip.Store,
+		Comment:  "a comment",
+		Extra:    extra,
+		Modified: time.Unix(1000000, 0),
+	}
+	header.SetMode(0640)
+	dst, e := w.CreateHeader(header)
+	if e != nil {
+		t.Logf("Failed creating zip entry: %s\n", e)
+		t.FailNow()
+	}
+	dst.Write([]byte("a"))
+	w.Close()
+	zr, e := zip.NewReader(bytes.NewReader(original.Bytes()),
+		int64(original.Len()))
+	if e != nil {
+		t.Logf("Failed reading original zip: %s\n", e)
+		t.FailNow()
+	}
+	var buf bytes.Buffer
+	e = ExportZip(&buf, zr)
+	if e != nil {
+		t.Logf("Failed exporting zip: %s\n", e)
+		t.FailNow()
+	}
+	exported, e := zip.NewReader(bytes.NewReader(buf.Bytes()),
+		int64(buf.Len()))
+	if e != nil {
+		t.Logf("Failed reading exported zip: %s\n", e)
+		t.FailNow()
+	}
+	copied := exported.File[0].FileHeader
+	if (copied.Method != zip.Store) || (copied.Comment != "a comment") ||
+		(copied.Mode() != 0640) {
+		t.Logf("Didn't preserve the header fields: %+v\n", copied)
+		t.FailNow()
+	}
+	if bytes.Contains(copied.Extra, extra) {
+		t.Logf("Copied the original header's extra fields\n")
+		t.FailNow()
+	}
+}
+
+func TestExportTar(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsA := fstest.MapFS{
+		"bin/run.sh": &fstest.MapFile{
+			Data:    []byte("#!/bin/sh\n"),
+			Mode:    0755,
+			ModTime: modTime,
+		},
+	}
+	fsB := fstest.MapFS{"README": newMapFile("readme")}
+	var buf bytes.Buffer
+	e := ExportTar(&buf, NewMergedFS(fsA, fsB))
+	if e != nil {
+		t.Logf("Failed exporting tar: %s\n", e)
+		t.FailNow()
+	}
+	r := tar.NewReader(&buf)
+	found := make(map[string]*tar.Header)
+	for {
+		header, e := r.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			t.Logf("Failed reading exported tar: %s\n", e)
+			t.FailNow()
+		}
+		found[header.Name] = header
+	}
+	if len(found) != 3 {
+		t.Logf("Expected 3 entries in the tar, got %d\n", len(found))
+		t.FailNow()
+	}
+	header := found["bin/run.sh"]
+	if (header == nil) || (header.Mode != 0755) ||
+		!header.ModTime.Equal(modTime) {
+		t.Logf("Metadata for bin/run.sh wasn't preserved: %+v\n", header)
+		t.FailNow()
+	}
+	if found["bin/"].Typeflag != tar.TypeDir {
+		t.Logf("bin/ wasn't exported as a directory.\n")
+		t.FailNow()
+	}
+}
+
+func TestExportZipWithOptions(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1337)).Read(random)
+	text := bytes.Repeat([]byte("compressible text\n"), 1000)
+	fsys := fstest.MapFS{
+		"text.txt":   &fstest.MapFile{Data: text},
+		"random.bin": &fstest.MapFile{Data: random},
+		"image.png":  &fstest.MapFile{Data: text},
+		"tiny.txt":   &fstest.MapFile{Data: []byte("tiny")},
+	}
+	var buf bytes.Buffer
+	e := ExportZipWithOptions(&buf, fsys, ExportOptions{
+		StoreSmallerThan: 100,
+		DetectCompressed: true,
+	})
+	if e != nil {
+		t.Logf("Failed exporting zip: %s\n", e)
+		t.FailNow()
+	}
+	exported, e := zip.NewReader(bytes.NewReader(buf.Bytes()),
+		int64(buf.Len()))
+	if e != nil {
+		t.Logf("Failed reading exported zip: %s\n", e)
+		t.FailNow()
+	}
+	expected := map[string]uint16{
+		"text.txt":   zip.Deflate,
+		"random.bin": zip.Store,
+		"image.png":  zip.Store,
+		"tiny.txt":   zip.Store,
+	}
+	for _, f := range exported.File {
+		if f.Method != expected[f.Name] {
+			t.Logf("Got wrong compression method for %s: %d\n", f.Name,
+				f.Method)
+			t.FailNow()
+		}
+		content, e := fs.ReadFile(exported, f.Name)
+		if e != nil {
+			t.Logf("Failed reading exported %s: %s\n", f.Name, e)
+			t.FailNow()
+		}
+		if !bytes.Equal(content, fsys[f.Name].Data) {
+			t.Logf("Exported %s has the wrong content\n", f.Name)
+			t.FailNow()
+		}
+	}
+	if len(exported.File) != len(expected) {
+		t.Logf("Expected %d files in the zip, got %d\n", len(expected),
+			len(exported.File))
+		t.FailNow()
+	}
+}
+
+func TestExportTarWithOptions(t *testing.T) {
+	fsys := fstest.MapFS{"README": newMapFile("readme")}
+	var buf bytes.Buffer
+	e := ExportTarWithOptions(&buf, fsys, ExportOptions{
+		TarCompressor: "gzip",
+	})
+	if e != nil {
+		t.Logf("Failed exporting compressed tar: %s\n", e)
+		t.FailNow()
+	}
+	gr, e := gzip.NewReader(&buf)
+	if e != nil {
+		t.Logf("Exported tar wasn't gzipped: %s\n", e)
+		t.FailNow()
+	}
+	header, e := tar.NewReader(gr).Next()
+	if e != nil {
+		t.Logf("Failed reading compressed tar: %s\n", e)
+		t.FailNow()
+	}
+	if header.Name != "README" {
+		t.Logf("Got wrong file in compressed tar: %s\n", header.Name)
+		t.FailNow()
+	}
+
+	buf.Reset()
+	e = ExportTarWithOptions(&buf, fsys, ExportOptions{
+		TarCompressor: "not a compressor",
+	})
+	if e == nil {
+		t.Logf("Didn't get expected error for an unknown compressor.\n")
+		t.FailNow()
+	}
+	if buf.Len() != 0 {
+		t.Logf("Wrote data despite an unknown compressor.\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for an unknown compressor: %s\n", e)
+}
+
+func TestExportManifest(t *testing.T) {
+	fsA := &Layer{
+		Name: "patch",
+		FS: fstest.MapFS{
+			"static/app.js":    newMapFile("patched"),
+			"static/debug.map": newMapFile("map"),
+		},
+	}
+	fsB := fstest.MapFS{
+		"static/img/logo.png": newMapFile("logo"),
+		"static/app.js":       newMapFile("original"),
+		"docs/readme.md":      newMapFile("docs"),
+		"config.json":         newMapFile("{}"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	var buf, manifestBuf bytes.Buffer
+	e := ExportZipWithOptions(&buf, merged, ExportOptions{
+		Include:  []string{"static/**", "*.json"},
+		Exclude:  []string{"**/*.map"},
+		Manifest: &manifestBuf,
+	})
+	if e != nil {
+		t.Logf("Failed exporting zip: %s\n", e)
+		t.FailNow()
+	}
+	exported, e := zip.NewReader(bytes.NewReader(buf.Bytes()),
+		int64(buf.Len()))
+	if e != nil {
+		t.Logf("Failed reading exported zip: %s\n", e)
+		t.FailNow()
+	}
+	var names []string
+	for _, f := range exported.File {
+		names = append(names, f.Name)
+	}
+	expectedNames := "config.json static/ static/app.js static/img/ " +
+		"static/img/logo.png"
+	if strings.Join(names, " ") != expectedNames {
+		t.Logf("Exported wrong files: %v\n", names)
+		t.FailNow()
+	}
+
+	var manifest ExportManifest
+	e = json.Unmarshal(manifestBuf.Bytes(), &manifest)
+	if e != nil {
+		t.Logf("Failed parsing manifest: %s\n", e)
+		t.FailNow()
+	}
+	if len(manifest.Files) != 3 {
+		t.Logf("Expected 3 files in the manifest, got %d\n",
+			len(manifest.Files))
+		t.FailNow()
+	}
+	appJS := manifest.Files[1]
+	sum := sha256.Sum256([]byte("patched"))
+	if (appJS.Path != "static/app.js") || (appJS.Layer != 0) ||
+		(appJS.LayerName != "patch") ||
+		(appJS.SHA256 != hex.EncodeToString(sum[:])) {
+		t.Logf("Got wrong manifest entry for app.js: %+v\n", appJS)
+		t.FailNow()
+	}
+	if manifest.Files[2].Layer != 1 {
+		t.Logf("Got wrong layer for logo.png: %d\n", manifest.Files[2].Layer)
+		t.FailNow()
+	}
+
+	e = ExportTarWithOptions(&buf, merged, ExportOptions{
+		Include: []string{"["},
+	})
+	if e == nil {
+		t.Logf("Didn't get expected error for a malformed pattern.\n")
+		t.FailNow()
+	}
+}
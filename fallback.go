@@ -0,0 +1,251 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+)
+
+// Enables or disables read fallback. When enabled, if a regular file from A
+// returns an error (other than io.EOF) on its first call to Read, before
+// returning any data, then the same path is opened in B and the read is
+// retried using B's copy, which replaces A's copy for the remainder of the
+// file's lifetime. This is intended for layers that mirror one another, where
+// it masks corruption in a single layer, such as a damaged zip archive member
+// or a truncated download. The error from A is returned if B doesn't contain
+// a regular file at the same path, or if opening it fails.
+//
+// Only a file's first Read, or a WriteTo before any Read, may fall back;
+// errors after data has been returned are returned as usual, as are errors
+// from Seek, ReadAt, or Mapped. If Seek was called before the first Read, B's
+// copy is seeked to the same offset before the read is retried, and A's error
+// is returned if that fails. Since B is treated as a replacement for A, its
+// copy should have identical content. Like UseSyntheticRoot, this applies the
+// setting to any MergedFS directly nested within m, so a failed read falls
+// back through each lower-priority layer in turn. Read fallback is disabled
+// by default.
+func (m *MergedFS) UseReadFallback(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&m.readFallback, value)
+	for side := 0; side < 2; side++ {
+		nested, ok := m.layer(side).(*MergedFS)
+		if ok {
+			nested.UseReadFallback(enabled)
+		}
+	}
+}
+
+// Wraps a regular file from A, replacing it with B's copy if its first Read
+// fails.
+type fallbackFile struct {
+	// Protects all of the following fields.
+	mutex sync.Mutex
+	// The file currently being read.
+	current fs.File
+	// Opens the replacement file, or is nil if the first Read has already
+	// happened.
+	open func() (fs.File, error)
+	// The offset set by the last Seek before the first Read, which the
+	// replacement file is seeked to.
+	offset int64
+}
+
+// Returns f wrapped so that its first Read falls back to B's copy of path if
+// it fails. The returned file has the same optional interfaces as f, other
+// than ReadDir, which regular files don't need.
+func (m *MergedFS) newFallbackFile(ctx context.Context, f fs.File,
+	path string) fs.File {
+	wrapped := &fallbackFile{
+		current: f,
+		open: func() (fs.File, error) {
+			return m.openFallback(ctx, path)
+		},
+	}
+	var seeker io.Seeker
+	if _, ok := f.(io.Seeker); ok {
+		seeker = fallbackSeeker{wrapped}
+	}
+	var readerAt io.ReaderAt
+	if _, ok := f.(io.ReaderAt); ok {
+		readerAt = fallbackReaderAt{wrapped}
+	}
+	var mapped mapper
+	if _, ok := f.(mapper); ok {
+		mapped = fallbackMapper{wrapped}
+	}
+	var writerTo io.WriterTo
+	if _, ok := f.(io.WriterTo); ok {
+		writerTo = fallbackWriterTo{wrapped}
+	}
+	return addFileInterfaces(wrapped, nil, seeker, readerAt, mapped, writerTo)
+}
+
+// Opens the regular file at path in B, for use by a fallbackFile.
+func (m *MergedFS) openFallback(ctx context.Context, path string) (fs.File,
+	error) {
+	f, e := m.openLayer(ctx, 1, path)
+	if e != nil {
+		return nil, e
+	}
+	info, e := m.statLayerFile(1, f, path)
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if !info.IsDir() {
+		return f, nil
+	}
+	f.Close()
+	return nil, fmt.Errorf("%s is a directory in %s", path, m.layerName(1))
+}
+
+func (f *fallbackFile) Read(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	n, e := f.current.Read(p)
+	if f.open == nil {
+		return n, e
+	}
+	open := f.open
+	f.open = nil
+	if (n != 0) || (e == nil) || (e == io.EOF) {
+		return n, e
+	}
+	replacement, openError := open()
+	if openError != nil {
+		return n, e
+	}
+	if f.offset != 0 {
+		seeker, ok := replacement.(io.Seeker)
+		if !ok {
+			replacement.Close()
+			return n, e
+		}
+		_, seekError := seeker.Seek(f.offset, io.SeekStart)
+		if seekError != nil {
+			replacement.Close()
+			return n, e
+		}
+	}
+	f.current.Close()
+	f.current = replacement
+	return f.current.Read(p)
+}
+
+func (f *fallbackFile) Stat() (fs.FileInfo, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.current.Stat()
+}
+
+func (f *fallbackFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.open = nil
+	return f.current.Close()
+}
+
+// Returns the file currently being read, which is B's copy if the first Read
+// fell back to it.
+func (f *fallbackFile) Unwrap() fs.File {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.current
+}
+
+// Provides Seek for a fallbackFile wrapping an io.Seeker.
+type fallbackSeeker struct {
+	f *fallbackFile
+}
+
+func (s fallbackSeeker) Seek(offset int64, whence int) (int64, error) {
+	s.f.mutex.Lock()
+	defer s.f.mutex.Unlock()
+	seeker, ok := s.f.current.(io.Seeker)
+	if !ok {
+		return 0, errors.New("the fallback file doesn't support seeking")
+	}
+	position, e := seeker.Seek(offset, whence)
+	if (e == nil) && (s.f.open != nil) {
+		s.f.offset = position
+	}
+	return position, e
+}
+
+// Provides ReadAt for a fallbackFile wrapping an io.ReaderAt.
+type fallbackReaderAt struct {
+	f *fallbackFile
+}
+
+func (r fallbackReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.f.mutex.Lock()
+	current := r.f.current
+	r.f.mutex.Unlock()
+	readerAt, ok := current.(io.ReaderAt)
+	if !ok {
+		return 0, errors.New("the fallback file doesn't support ReadAt")
+	}
+	return readerAt.ReadAt(p, off)
+}
+
+// Provides Mapped for a fallbackFile wrapping a MappedFile.
+type fallbackMapper struct {
+	f *fallbackFile
+}
+
+func (m fallbackMapper) Mapped() ([]byte, error) {
+	m.f.mutex.Lock()
+	current := m.f.current
+	m.f.mutex.Unlock()
+	mapped, ok := current.(mapper)
+	if !ok {
+		return nil, errors.New("the fallback file doesn't support mapping")
+	}
+	return mapped.Mapped()
+}
+
+// Provides WriteTo for a fallbackFile wrapping an io.WriterTo.
+type fallbackWriterTo struct {
+	f *fallbackFile
+}
+
+func (w fallbackWriterTo) WriteTo(dst io.Writer) (int64, error) {
+	w.f.mutex.Lock()
+	pending := w.f.open != nil
+	w.f.mutex.Unlock()
+	var total int64
+	if pending {
+		// The first read goes through Read so that it can still fall back.
+		buffer := make([]byte, 32*1024)
+		n, e := w.f.Read(buffer)
+		if n > 0 {
+			written, writeError := dst.Write(buffer[:n])
+			total += int64(written)
+			if writeError != nil {
+				return total, writeError
+			}
+		}
+		if e == io.EOF {
+			return total, nil
+		}
+		if e != nil {
+			return total, e
+		}
+	}
+	w.f.mutex.Lock()
+	current := w.f.current
+	w.f.mutex.Unlock()
+	if writerTo, ok := current.(io.WriterTo); ok {
+		n, e := writerTo.WriteTo(dst)
+		return total + n, e
+	}
+	n, e := io.Copy(dst, current)
+	return total + n, e
+}
@@ -0,0 +1,254 @@
+package merged_fs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+var errCorrupt = errors.New("corrupt file")
+
+// Wraps an FS so that reads from any of its regular files fail.
+type corruptFS struct {
+	fs.FS
+}
+
+type corruptFile struct {
+	fs.File
+}
+
+func (f corruptFile) Read(p []byte) (int, error) {
+	return 0, errCorrupt
+}
+
+// A corruptFile that also supports Seek, used by corruptSeekFS.
+type corruptSeekFile struct {
+	corruptFile
+}
+
+func (f corruptSeekFile) Seek(offset int64, whence int) (int64, error) {
+	return f.File.(io.Seeker).Seek(offset, whence)
+}
+
+// Like corruptFS, but its regular files can seek.
+type corruptSeekFS struct {
+	fs.FS
+}
+
+func (c corruptSeekFS) Open(path string) (fs.File, error) {
+	f, e := c.FS.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	if _, ok := f.(fs.ReadDirFile); ok {
+		return f, nil
+	}
+	return corruptSeekFile{corruptFile{f}}, nil
+}
+
+func (c corruptFS) Open(path string) (fs.File, error) {
+	f, e := c.FS.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	if _, ok := f.(fs.ReadDirFile); ok {
+		return f, nil
+	}
+	return corruptFile{f}, nil
+}
+
+func TestReadFallback(t *testing.T) {
+	mirror := fstest.MapFS{
+		"a.txt":     newMapFile("mirrored content"),
+		"dir/b.txt": newMapFile("b"),
+	}
+	fsA := corruptFS{mirror}
+	fsB := fstest.MapFS{
+		"a.txt": newMapFile("mirrored content"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	_, e := merged.ReadFile("a.txt")
+	if !errors.Is(e, errCorrupt) {
+		t.Logf("Didn't get expected error without fallback: %v\n", e)
+		t.FailNow()
+	}
+	merged.UseReadFallback(true)
+	data, e := merged.ReadFile("a.txt")
+	if e != nil {
+		t.Logf("Failed reading a.txt with fallback enabled: %s\n", e)
+		t.FailNow()
+	}
+	if string(data) != "mirrored content" {
+		t.Logf("Got incorrect content: %q\n", data)
+		t.FailNow()
+	}
+	f, e := merged.Open("a.txt")
+	if e != nil {
+		t.Logf("Failed opening a.txt: %s\n", e)
+		t.FailNow()
+	}
+	data, e = io.ReadAll(f)
+	f.Close()
+	if (e != nil) || (string(data) != "mirrored content") {
+		t.Logf("Failed reading opened a.txt: %q, %v\n", data, e)
+		t.FailNow()
+	}
+
+	// B doesn't have dir/b.txt, so the original error must be returned.
+	_, e = merged.ReadFile("dir/b.txt")
+	if !errors.Is(e, errCorrupt) {
+		t.Logf("Didn't get expected error with nothing to fall back to: %v\n",
+			e)
+		t.FailNow()
+	}
+}
+
+func TestReadFallbackMultiple(t *testing.T) {
+	content := "same everywhere"
+	layers := []fs.FS{
+		corruptFS{fstest.MapFS{"f.txt": newMapFile(content)}},
+		corruptFS{fstest.MapFS{"f.txt": newMapFile(content)}},
+		fstest.MapFS{"f.txt": newMapFile(content)},
+	}
+	merged := MergeMultiple(layers...).(*MergedFS)
+	merged.UseReadFallback(true)
+	data, e := merged.ReadFile("f.txt")
+	if e != nil {
+		t.Logf("Failed reading through two corrupt layers: %s\n", e)
+		t.FailNow()
+	}
+	if string(data) != content {
+		t.Logf("Got incorrect content: %q\n", data)
+		t.FailNow()
+	}
+	e = fstest.TestFS(merged, "f.txt")
+	if e != nil {
+		t.Logf("TestFS failed with read fallback: %s\n", e)
+		t.FailNow()
+	}
+}
+
+func TestReadFallbackAfterSeek(t *testing.T) {
+	content := "0123456789"
+	fsA := corruptSeekFS{fstest.MapFS{"f.txt": newMapFile(content)}}
+	fsB := fstest.MapFS{"f.txt": newMapFile(content)}
+	merged := NewMergedFS(fsA, fsB)
+	merged.UseReadFallback(true)
+	f, e := merged.Open("f.txt")
+	if e != nil {
+		t.Logf("Failed opening f.txt: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	seeker, ok := f.(io.Seeker)
+	if !ok {
+		t.Logf("The fallback file doesn't support Seek\n")
+		t.FailNow()
+	}
+	_, e = seeker.Seek(4, io.SeekStart)
+	if e != nil {
+		t.Logf("Failed seeking: %s\n", e)
+		t.FailNow()
+	}
+	_, e = seeker.Seek(2, io.SeekCurrent)
+	if e != nil {
+		t.Logf("Failed seeking relative to the current offset: %s\n", e)
+		t.FailNow()
+	}
+	data, e := io.ReadAll(f)
+	if e != nil {
+		t.Logf("Failed reading after seeking: %s\n", e)
+		t.FailNow()
+	}
+	if string(data) != "6789" {
+		t.Logf("Replacement didn't start at the seek offset: got %q\n",
+			data)
+		t.FailNow()
+	}
+}
+
+// A corruptFile whose WriteTo fails in the same way as Read.
+type corruptWriterToFile struct {
+	corruptFile
+}
+
+func (f corruptWriterToFile) WriteTo(w io.Writer) (int64, error) {
+	return 0, errCorrupt
+}
+
+// Like corruptFS, but its regular files implement io.WriterTo.
+type corruptWriterToFS struct {
+	fs.FS
+}
+
+func (c corruptWriterToFS) Open(path string) (fs.File, error) {
+	f, e := c.FS.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	if _, ok := f.(fs.ReadDirFile); ok {
+		return f, nil
+	}
+	return corruptWriterToFile{corruptFile{f}}, nil
+}
+
+func TestReadFallbackWriterTo(t *testing.T) {
+	content := "mirrored content"
+	for _, fsA := range []fs.FS{
+		writerToFS{fstest.MapFS{"f.txt": newMapFile(content)}},
+		corruptWriterToFS{fstest.MapFS{"f.txt": newMapFile(content)}},
+	} {
+		merged := NewMergedFS(fsA, writerToFS{fstest.MapFS{
+			"f.txt": newMapFile(content),
+		}})
+		merged.UseReadFallback(true)
+		f, e := merged.Open("f.txt")
+		if e != nil {
+			t.Logf("Failed opening f.txt: %s\n", e)
+			t.FailNow()
+		}
+		writerTo, ok := f.(io.WriterTo)
+		if !ok {
+			f.Close()
+			t.Logf("The fallback file doesn't support WriteTo\n")
+			t.FailNow()
+		}
+		var buffer bytes.Buffer
+		n, e := writerTo.WriteTo(&buffer)
+		f.Close()
+		if e != nil {
+			t.Logf("WriteTo failed for %T: %s\n", fsA, e)
+			t.FailNow()
+		}
+		if (buffer.String() != content) || (n != int64(len(content))) {
+			t.Logf("WriteTo wrote %d bytes, %q, for %T\n", n,
+				buffer.String(), fsA)
+			t.FailNow()
+		}
+	}
+}
+
+func TestReadFallbackMapped(t *testing.T) {
+	fsA := mappingFS{fstest.MapFS{"f.bin": newMapFile("0123456789")}}
+	merged := NewMergedFS(fsA, fstest.MapFS{"f.bin": newMapFile("0123456789")})
+	merged.UseReadFallback(true)
+	f, e := merged.Open("f.bin")
+	if e != nil {
+		t.Logf("Failed opening f.bin: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	mapped, ok := f.(MappedFile)
+	if !ok {
+		t.Logf("The fallback file doesn't support Mapped\n")
+		t.FailNow()
+	}
+	data, e := mapped.Mapped()
+	if (e != nil) || (&data[0] != &fsA.MapFS["f.bin"].Data[0]) {
+		t.Logf("Didn't get A's mapped data: %v\n", e)
+		t.FailNow()
+	}
+}
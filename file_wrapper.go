@@ -0,0 +1,205 @@
+package merged_fs
+
+import (
+	"io"
+	"io/fs"
+)
+
+// Satisfied by directories; the non-File half of fs.ReadDirFile.
+type dirReader interface {
+	ReadDir(n int) ([]fs.DirEntry, error)
+}
+
+// A File with an Unwrap method, embedded in the structs returned by
+// addFileInterfaces so that they can be unwrapped.
+type wrappedFile interface {
+	fs.File
+	Unwrap() fs.File
+}
+
+// Adds an Unwrap method returning the File it embeds.
+type unwrappableFile struct {
+	fs.File
+}
+
+func (f unwrappableFile) Unwrap() fs.File {
+	return f.File
+}
+
+// Returns f if it already has an Unwrap method. Otherwise, returns f wrapped
+// so that Unwrap returns f.
+func toWrappedFile(f fs.File) wrappedFile {
+	if w, ok := f.(wrappedFile); ok {
+		return w
+	}
+	return unwrappableFile{f}
+}
+
+// Returns the file wrapped by f, if f is a wrapper created by this package (or
+// anything else with an Unwrap method returning an fs.File). Otherwise,
+// returns nil. This mirrors errors.Unwrap, and allows callers to reach
+// optional interfaces of an underlying file that a wrapper doesn't provide,
+// such as io.ByteReader:
+//
+//	for u := f; u != nil; u = merged_fs.Unwrap(u) {
+//		if r, ok := u.(io.ByteReader); ok {
+//			return r.ReadByte()
+//		}
+//	}
+//
+// Using the underlying file bypasses whatever the wrapper does, such as
+// metering reads against a quota or falling back to another layer if a read
+// fails, so prefer calling methods on the wrapper itself where possible.
+func Unwrap(f fs.File) fs.File {
+	u, ok := f.(interface{ Unwrap() fs.File })
+	if !ok {
+		return nil
+	}
+	return u.Unwrap()
+}
+
+// Returns a File that uses base for Read, Stat, and Close, and additionally
+// provides ReadDir, Seek, ReadAt, Mapped, or WriteTo using dir, seeker,
+// readerAt, mapped, or writerTo, respectively, if they are non-nil. Since
+// directories are never mapped or copied using WriteTo, mapped and writerTo
+// are ignored if dir is non-nil. This lets file wrappers preserve exactly
+// the optional interfaces of the file they wrap, which matters because callers
+// such as net/http and testing/fstest check for these interfaces using type
+// assertions, and io.Copy avoids an intermediate buffer for files that
+// implement io.WriterTo.
+func addFileInterfaces(base fs.File, dir dirReader, seeker io.Seeker,
+	readerAt io.ReaderAt, mapped mapper, writerTo io.WriterTo) fs.File {
+	if ((mapped != nil) || (writerTo != nil)) && (dir == nil) {
+		return addRegularFileInterfaces(base, seeker, readerAt, mapped,
+			writerTo)
+	}
+	wrapped := toWrappedFile(base)
+	switch {
+	case (dir != nil) && (seeker != nil) && (readerAt != nil):
+		return &struct {
+			wrappedFile
+			dirReader
+			io.Seeker
+			io.ReaderAt
+		}{wrapped, dir, seeker, readerAt}
+	case (dir != nil) && (seeker != nil):
+		return &struct {
+			wrappedFile
+			dirReader
+			io.Seeker
+		}{wrapped, dir, seeker}
+	case (dir != nil) && (readerAt != nil):
+		return &struct {
+			wrappedFile
+			dirReader
+			io.ReaderAt
+		}{wrapped, dir, readerAt}
+	case (seeker != nil) && (readerAt != nil):
+		return &struct {
+			wrappedFile
+			io.Seeker
+			io.ReaderAt
+		}{wrapped, seeker, readerAt}
+	case dir != nil:
+		return &struct {
+			wrappedFile
+			dirReader
+		}{wrapped, dir}
+	case seeker != nil:
+		return &struct {
+			wrappedFile
+			io.Seeker
+		}{wrapped, seeker}
+	case readerAt != nil:
+		return &struct {
+			wrappedFile
+			io.ReaderAt
+		}{wrapped, readerAt}
+	}
+	return base
+}
+
+// Implements addFileInterfaces for regular files providing Mapped or
+// WriteTo.
+func addRegularFileInterfaces(base fs.File, seeker io.Seeker,
+	readerAt io.ReaderAt, mapped mapper, writerTo io.WriterTo) fs.File {
+	wrapped := toWrappedFile(base)
+	switch {
+	case (seeker != nil) && (readerAt != nil) && (mapped != nil) &&
+		(writerTo != nil):
+		return &struct {
+			wrappedFile
+			io.Seeker
+			io.ReaderAt
+			mapper
+			io.WriterTo
+		}{wrapped, seeker, readerAt, mapped, writerTo}
+	case (seeker != nil) && (readerAt != nil) && (mapped != nil):
+		return &struct {
+			wrappedFile
+			io.Seeker
+			io.ReaderAt
+			mapper
+		}{wrapped, seeker, readerAt, mapped}
+	case (seeker != nil) && (readerAt != nil) && (writerTo != nil):
+		return &struct {
+			wrappedFile
+			io.Seeker
+			io.ReaderAt
+			io.WriterTo
+		}{wrapped, seeker, readerAt, writerTo}
+	case (seeker != nil) && (mapped != nil) && (writerTo != nil):
+		return &struct {
+			wrappedFile
+			io.Seeker
+			mapper
+			io.WriterTo
+		}{wrapped, seeker, mapped, writerTo}
+	case (seeker != nil) && (mapped != nil):
+		return &struct {
+			wrappedFile
+			io.Seeker
+			mapper
+		}{wrapped, seeker, mapped}
+	case (seeker != nil) && (writerTo != nil):
+		return &struct {
+			wrappedFile
+			io.Seeker
+			io.WriterTo
+		}{wrapped, seeker, writerTo}
+	case (readerAt != nil) && (mapped != nil) && (writerTo != nil):
+		return &struct {
+			wrappedFile
+			io.ReaderAt
+			mapper
+			io.WriterTo
+		}{wrapped, readerAt, mapped, writerTo}
+	case (readerAt != nil) && (mapped != nil):
+		return &struct {
+			wrappedFile
+			io.ReaderAt
+			mapper
+		}{wrapped, readerAt, mapped}
+	case (readerAt != nil) && (writerTo != nil):
+		return &struct {
+			wrappedFile
+			io.ReaderAt
+			io.WriterTo
+		}{wrapped, readerAt, writerTo}
+	case (mapped != nil) && (writerTo != nil):
+		return &struct {
+			wrappedFile
+			mapper
+			io.WriterTo
+		}{wrapped, mapped, writerTo}
+	case mapped != nil:
+		return &struct {
+			wrappedFile
+			mapper
+		}{wrapped, mapped}
+	}
+	return &struct {
+		wrappedFile
+		io.WriterTo
+	}{wrapped, writerTo}
+}
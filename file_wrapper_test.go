@@ -0,0 +1,191 @@
+package merged_fs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// A regular file that implements io.WriterTo, and io.ByteReader, which no
+// wrapper provides.
+type writerToFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *writerToFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *writerToFile) Close() error {
+	return nil
+}
+
+// Opens every path in the underlying MapFS as a writerToFile, if it's a
+// regular file.
+type writerToFS struct {
+	fstest.MapFS
+}
+
+func (w writerToFS) Open(path string) (fs.File, error) {
+	f, e := w.MapFS.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	info, e := f.Stat()
+	if (e != nil) || info.IsDir() {
+		return f, e
+	}
+	f.Close()
+	return &writerToFile{bytes.NewReader(w.MapFS[path].Data), info}, nil
+}
+
+func TestUnwrap(t *testing.T) {
+	fsA := writerToFS{fstest.MapFS{"a.txt": newMapFile("in A")}}
+	fsB := fstest.MapFS{"b.txt": newMapFile("in B")}
+	merged := NewMergedFS(fsA, fsB)
+	merged.TrackOpenFiles(true, nil)
+	merged.SetReadQuota(1000)
+	f, e := merged.Open("a.txt")
+	if e != nil {
+		t.Logf("Failed opening a.txt: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	if _, ok := f.(io.ByteReader); ok {
+		t.Logf("Expected the wrapped file to not implement io.ByteReader\n")
+		t.FailNow()
+	}
+	if _, ok := f.(io.Seeker); !ok {
+		t.Logf("Expected the wrapped file to implement io.Seeker\n")
+		t.FailNow()
+	}
+	var byteReader io.ByteReader
+	depth := 0
+	for u := f; u != nil; u = Unwrap(u) {
+		if r, ok := u.(io.ByteReader); ok {
+			byteReader = r
+			break
+		}
+		depth++
+	}
+	if byteReader == nil {
+		t.Logf("Didn't find io.ByteReader by unwrapping the file\n")
+		t.FailNow()
+	}
+	if depth < 2 {
+		t.Logf("Expected at least 2 wrappers, got %d\n", depth)
+		t.FailNow()
+	}
+	c, e := byteReader.ReadByte()
+	if e != nil {
+		t.Logf("Failed reading the underlying file: %s\n", e)
+		t.FailNow()
+	}
+	if c != 'i' {
+		t.Logf("Got incorrect content from the underlying file: %q\n", c)
+		t.FailNow()
+	}
+
+	plain, e := fsB.Open("b.txt")
+	if e != nil {
+		t.Logf("Failed opening b.txt: %s\n", e)
+		t.FailNow()
+	}
+	defer plain.Close()
+	if Unwrap(plain) != nil {
+		t.Logf("Expected Unwrap to return nil for an unwrapped file\n")
+		t.FailNow()
+	}
+}
+
+func TestWriterToPassthrough(t *testing.T) {
+	fsA := writerToFS{fstest.MapFS{"a.txt": newMapFile("0123456789")}}
+	merged := NewMergedFS(fsA, fstest.MapFS{})
+	merged.TrackOpenFiles(true, nil)
+	merged.SetReadQuota(15)
+	for i := 0; i < 2; i++ {
+		f, e := merged.Open("a.txt")
+		if e != nil {
+			t.Logf("Failed opening a.txt: %s\n", e)
+			t.FailNow()
+		}
+		writerTo, ok := f.(io.WriterTo)
+		if !ok {
+			f.Close()
+			t.Logf("The wrapped file doesn't implement io.WriterTo\n")
+			t.FailNow()
+		}
+		output := &bytes.Buffer{}
+		n, e := writerTo.WriteTo(output)
+		f.Close()
+		if i == 0 {
+			if (e != nil) || (n != 10) || (output.String() != "0123456789") {
+				t.Logf("WriteTo returned %d, %s, with content %q\n", n, e,
+					output.String())
+				t.FailNow()
+			}
+			continue
+		}
+		var quotaError *QuotaExceededError
+		if !errors.As(e, &quotaError) {
+			t.Logf("Didn't get expected quota error from WriteTo: %s\n", e)
+			t.FailNow()
+		}
+		if output.Len() != 0 {
+			t.Logf("Got content after exceeding the quota: %q\n",
+				output.String())
+			t.FailNow()
+		}
+	}
+	if merged.BytesRead() != 10 {
+		t.Logf("Expected 10 bytes read, got %d\n", merged.BytesRead())
+		t.FailNow()
+	}
+}
+
+// Returns a MergedFS with a 16 MB file, "big.bin", in a layer created by
+// wrapLayer. Tracks open files, so files from the layer are always wrapped.
+func generateLargeFileFS(wrapLayer func(fstest.MapFS) fs.FS) *MergedFS {
+	layer := fstest.MapFS{
+		"big.bin": &fstest.MapFile{Data: make([]byte, 16*1024*1024)},
+	}
+	merged := NewMergedFS(wrapLayer(layer), fstest.MapFS{})
+	merged.TrackOpenFiles(true, nil)
+	return merged
+}
+
+// Copies big.bin to a countingWriter, which doesn't implement io.ReaderFrom,
+// so io.Copy only avoids its own buffer if the file implements io.WriterTo.
+func runCopyBenchmark(b *testing.B, merged *MergedFS) {
+	b.SetBytes(16 * 1024 * 1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, e := merged.Open("big.bin")
+		if e != nil {
+			b.Logf("Failed opening big.bin: %s\n", e)
+			b.FailNow()
+		}
+		_, e = io.Copy(new(countingWriter), f)
+		f.Close()
+		if e != nil {
+			b.Logf("Failed copying big.bin: %s\n", e)
+			b.FailNow()
+		}
+	}
+}
+
+func BenchmarkCopyWithWriterTo(b *testing.B) {
+	runCopyBenchmark(b, generateLargeFileFS(func(m fstest.MapFS) fs.FS {
+		return writerToFS{m}
+	}))
+}
+
+func BenchmarkCopyWithoutWriterTo(b *testing.B) {
+	runCopyBenchmark(b, generateLargeFileFS(func(m fstest.MapFS) fs.FS {
+		return m
+	}))
+}
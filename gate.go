@@ -0,0 +1,180 @@
+package merged_fs
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// Returns true if the layer is currently invisible due to VisibleFrom,
+// VisibleUntil, or Enabled.
+func (l *Layer) gateClosed() bool {
+	l.init()
+	if !l.gated {
+		return false
+	}
+	return !l.gateOpenAt(time.Now())
+}
+
+// Returns true if the layer's time window and Enabled function allow it to be
+// visible at time t.
+func (l *Layer) gateOpenAt(t time.Time) bool {
+	if !l.VisibleFrom.IsZero() && t.Before(l.VisibleFrom) {
+		return false
+	}
+	if !l.VisibleUntil.IsZero() && !t.Before(l.VisibleUntil) {
+		return false
+	}
+	if (l.Enabled != nil) && !l.Enabled() {
+		return false
+	}
+	return true
+}
+
+// Returns true if the path is hidden while the layer is invisible.
+func (l *Layer) hiddenPath(p string) bool {
+	if len(l.GatedPatterns) == 0 {
+		return true
+	}
+	for _, pattern := range l.GatedPatterns {
+		if (validatePattern(pattern) == nil) && matchPattern(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the path is hidden, given the result of l.gateClosed().
+func (l *Layer) hidden(ctx context.Context, gateClosed bool,
+	p string) bool {
+	if gateClosed && l.hiddenPath(p) {
+		return true
+	}
+	if l.Visible == nil {
+		return false
+	}
+	return !l.Visible(ctx, p) || ((p != ".") && !l.Visible(ctx, "."))
+}
+
+// Returns true if directory listings from l may need to be filtered, given
+// the result of l.gateClosed().
+func (l *Layer) filtering(gateClosed bool) bool {
+	return gateClosed || (l.Visible != nil)
+}
+
+// Returns the result of opening a hidden path: an empty directory for ".", or
+// an error for anything else.
+func (l *Layer) hiddenResult(p string) (fs.File, error) {
+	if p == "." {
+		return newSyntheticRoot(), nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+}
+
+// Removes hidden paths from a list of entries in dirPath.
+func (l *Layer) filterEntries(ctx context.Context, gateClosed bool,
+	dirPath string, entries []fs.DirEntry) []fs.DirEntry {
+	toReturn := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !l.hidden(ctx, gateClosed, path.Join(dirPath, entry.Name())) {
+			toReturn = append(toReturn, entry)
+		}
+	}
+	return toReturn
+}
+
+// If f is a directory, returns a directory with the same metadata but without
+// any hidden entries, closing f. Otherwise, returns f.
+func (l *Layer) filterDir(ctx context.Context, gateClosed bool,
+	dirPath string, f fs.File) (fs.File, error) {
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return f, nil
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if !info.IsDir() {
+		return f, nil
+	}
+	entries, e := dir.ReadDir(-1)
+	f.Close()
+	if e != nil {
+		return nil, e
+	}
+	return &completedDir{
+		MergedDirectory: &MergedDirectory{
+			name:    info.Name(),
+			mode:    info.Mode(),
+			entries: l.filterEntries(ctx, gateClosed, dirPath, entries),
+		},
+		info: info,
+	}, nil
+}
+
+// Returns every gated Layer within fsys, or Layer with a circuit breaker,
+// including within any nested MergedFS, and whether any Layer within fsys has
+// a Visible function.
+func collectGatedLayers(fsys fs.FS) ([]*Layer, bool) {
+	switch v := fsys.(type) {
+	case *MergedFS:
+		layersA, hooksA := collectGatedLayers(v.A)
+		layersB, hooksB := collectGatedLayers(v.B)
+		return append(layersA, layersB...), hooksA || hooksB
+	case *Group:
+		return collectGatedLayers(v.MergedFS)
+	case *Layer:
+		v.init()
+		if v.gated || (v.BreakerThreshold > 0) {
+			return []*Layer{v}, v.Visible != nil
+		}
+		return nil, v.Visible != nil
+	}
+	return nil, false
+}
+
+// If the visibility of any gated Layer within m, or the state of any Layer's
+// circuit breaker, has changed since the last call, this clears m's caches,
+// since their contents may depend on the visibility of the changed layers.
+func (m *MergedFS) checkGates() {
+	m.gatesOnce.Do(func() {
+		m.gatedLayers, m.visibilityHooks = collectGatedLayers(m)
+	})
+	if len(m.gatedLayers) == 0 {
+		return
+	}
+	var state strings.Builder
+	for _, l := range m.gatedLayers {
+		if l.gateClosed() || l.breakerOpen() {
+			state.WriteByte('0')
+		} else {
+			state.WriteByte('1')
+		}
+	}
+	m.gateMutex.Lock()
+	defer m.gateMutex.Unlock()
+	if state.String() == m.gateState {
+		return
+	}
+	firstCheck := m.gateState == ""
+	m.gateState = state.String()
+	m.clearCaches()
+	if !firstCheck {
+		m.publish(Event{Path: ".", Reason: "visibility"})
+	}
+}
+
+// Clears the contents of m's caches, without changing whether caching is
+// enabled.
+func (m *MergedFS) clearCaches() {
+	m.okPrefixesMutex.Lock()
+	m.knownOKPrefixes = make(map[string]bool)
+	m.okPrefixesMutex.Unlock()
+	m.dirCacheMutex.Lock()
+	m.invalidateDirCacheLocked()
+	m.dirCacheMutex.Unlock()
+}
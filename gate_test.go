@@ -0,0 +1,143 @@
+package merged_fs
+
+import (
+	"context"
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLayerTimeWindow(t *testing.T) {
+	fsA := fstest.MapFS{"seasonal/banner.txt": newMapFile("holiday")}
+	fsB := fstest.MapFS{"seasonal/banner.txt": newMapFile("default")}
+	now := time.Now()
+	future := &Layer{FS: fsA, VisibleFrom: now.Add(time.Hour)}
+	merged := NewMergedFS(future, fsB)
+	content, e := fs.ReadFile(merged, "seasonal/banner.txt")
+	if e != nil {
+		t.Logf("Failed reading banner: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "default" {
+		t.Logf("Layer was visible before its window: got %s\n", content)
+		t.FailNow()
+	}
+	e = fstest.TestFS(future)
+	if e != nil {
+		t.Logf("Hidden layer failed fstest: %s\n", e)
+		t.FailNow()
+	}
+
+	current := &Layer{
+		FS:           fsA,
+		VisibleFrom:  now.Add(-time.Hour),
+		VisibleUntil: now.Add(time.Hour),
+	}
+	merged = NewMergedFS(current, fsB)
+	content, e = fs.ReadFile(merged, "seasonal/banner.txt")
+	if e != nil {
+		t.Logf("Failed reading banner: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "holiday" {
+		t.Logf("Layer wasn't visible during its window: got %s\n", content)
+		t.FailNow()
+	}
+}
+
+func TestLayerEnabled(t *testing.T) {
+	var enabled int32
+	fsA := &Layer{
+		FS: fstest.MapFS{
+			"beta/feature.txt": newMapFile("beta"),
+			"stable.txt":       newMapFile("stable"),
+		},
+		Enabled:       func() bool { return atomic.LoadInt32(&enabled) != 0 },
+		GatedPatterns: []string{"beta/**"},
+	}
+	fsB := fstest.MapFS{"other.txt": newMapFile("other")}
+	merged := NewMergedFS(fsA, fsB)
+	merged.UseDirectoryCaching(true)
+	_, e := fs.Stat(merged, "beta/feature.txt")
+	if e == nil {
+		t.Logf("Didn't get expected error for a disabled path.\n")
+		t.FailNow()
+	}
+	_, e = fs.Stat(merged, "stable.txt")
+	if e != nil {
+		t.Logf("Ungated path wasn't visible: %s\n", e)
+		t.FailNow()
+	}
+	entries, e := fs.ReadDir(merged, ".")
+	if e != nil {
+		t.Logf("Failed reading root dir: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 2 {
+		t.Logf("Expected 2 entries in the root dir, got %d.\n", len(entries))
+		t.FailNow()
+	}
+
+	// Enabling the layer must be noticed despite the cached root directory.
+	atomic.StoreInt32(&enabled, 1)
+	content, e := fs.ReadFile(merged, "beta/feature.txt")
+	if e != nil {
+		t.Logf("Failed reading enabled path: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "beta" {
+		t.Logf("Got wrong content for enabled path: %s\n", content)
+		t.FailNow()
+	}
+	entries, e = fs.ReadDir(merged, ".")
+	if e != nil {
+		t.Logf("Failed reading root dir: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 3 {
+		t.Logf("Expected 3 entries in the root dir, got %d.\n", len(entries))
+		t.FailNow()
+	}
+}
+
+func TestLayerVisibleHook(t *testing.T) {
+	var showBeta int32
+	fsA := &Layer{
+		FS: fstest.MapFS{
+			"beta.txt": newMapFile("beta"),
+			"a.txt":    newMapFile("a"),
+		},
+		Visible: func(ctx context.Context, path string) bool {
+			return (path != "beta.txt") || (atomic.LoadInt32(&showBeta) != 0)
+		},
+	}
+	fsB := fstest.MapFS{"b.txt": newMapFile("b")}
+	merged := NewMergedFS(fsA, fsB)
+	merged.UseDirectoryCaching(true)
+	e := fstest.TestFS(merged, "a.txt", "b.txt")
+	if e != nil {
+		t.Logf("Merged FS with a visibility hook failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	_, e = fs.Stat(merged, "beta.txt")
+	if e == nil {
+		t.Logf("Didn't get expected error for a hidden path.\n")
+		t.FailNow()
+	}
+	atomic.StoreInt32(&showBeta, 1)
+	entries, e := fs.ReadDir(merged, ".")
+	if e != nil {
+		t.Logf("Failed reading root dir: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 3 {
+		t.Logf("Expected 3 entries in the root dir, got %d.\n", len(entries))
+		t.FailNow()
+	}
+	if len(merged.dirCache) != 0 {
+		t.Logf("Directories were cached despite the visibility hook.\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,105 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"sync/atomic"
+)
+
+// A set of layers that's merged and configured once, then shared as a unit by
+// any number of parent merges. Returned by NewGroup. The group's MergedFS
+// methods, such as UseDirectoryCaching and SetSymlinkShadowing, configure the
+// group itself.
+//
+// Unlike a MergedFS nested directly within a parent, a Group keeps its own
+// configuration: settings applied to a parent aren't passed on to the group,
+// so one parent can't change how the group behaves for the others. The
+// group's caches are shared by every parent, so content merged for one parent
+// doesn't need to be merged again for another. In every other respect, such
+// as Layers and ProvenanceEntry, a Group behaves like any nested MergedFS.
+type Group struct {
+	*MergedFS
+	name string
+}
+
+// Returns a new Group merging the given layers, in priority order as with
+// MergeMultiple. The name is only used to describe the group, e.g. in
+// DebugDump. If fewer than two layers are given, the merge is filled out
+// using Empty, which is then included in the group's Layers.
+//
+// For example, to share everything but the top layer between two
+// environments:
+//
+//	shared := NewGroup("shared", themeFS, pluginsFS, baseFS)
+//	shared.UseDirectoryCaching(true)
+//	staging := shared.Merge(stagingFS)
+//	production := shared.Merge(productionFS)
+func NewGroup(name string, layers ...fs.FS) *Group {
+	return &Group{
+		MergedFS: MergeAll(layers...),
+		name:     name,
+	}
+}
+
+// Returns the group's name.
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Returns a new MergedFS in which the given layers, in priority order, take
+// priority over the group. Nil layers are skipped, and if no layers remain,
+// Empty is used in their place. The new MergedFS, and any MergedFS created to
+// merge the given layers, starts out with the group's current configuration:
+// its path and directory caching, panic recovery, synthetic roots, read
+// fallback, strict mode and integrity checks, symlink policy, merge and depth
+// limits, and collation. Later changes to the group's configuration aren't
+// copied to parents that already exist.
+//
+// If any of the group's layers change, call NotifyChanged on each parent;
+// this clears the caches of the group along with those of the parent.
+func (g *Group) Merge(layers ...fs.FS) *MergedFS {
+	// Copy the layers rather than appending to the caller's slice, which may
+	// have spare capacity.
+	toMerge := make([]fs.FS, 0, len(layers)+1)
+	for _, layer := range layers {
+		if layer != nil {
+			toMerge = append(toMerge, layer)
+		}
+	}
+	if len(toMerge) == 0 {
+		toMerge = append(toMerge, Empty)
+	}
+	toReturn := MergeAll(append(toMerge, g)...)
+	g.inheritConfig(toReturn)
+	return toReturn
+}
+
+// Applies the group's configuration to parent, and any MergedFS directly
+// nested within it, other than the group itself.
+func (g *Group) inheritConfig(parent *MergedFS) {
+	m := g.MergedFS
+	m.okPrefixesMutex.Lock()
+	pathCaching := m.prefixCachingEnabled
+	m.okPrefixesMutex.Unlock()
+	m.dirCacheMutex.Lock()
+	dirCaching := m.dirCache != nil
+	m.dirCacheMutex.Unlock()
+	m.configMutex.RLock()
+	strictHandler := m.strictHandler
+	collation := m.collation
+	m.configMutex.RUnlock()
+
+	parent.UsePathCaching(pathCaching)
+	parent.UseDirectoryCaching(dirCaching)
+	parent.UsePanicRecovery(m.recoveringPanics())
+	parent.UseSyntheticRoot(atomic.LoadInt32(&m.syntheticRoot) != 0)
+	parent.UseReadFallback(atomic.LoadInt32(&m.readFallback) != 0)
+	parent.UseStrictMode(strictHandler)
+	parent.UseIntegrityChecks(atomic.LoadInt32(&m.integrityChecks) != 0)
+	parent.SetSymlinkShadowing(SymlinkShadowing(
+		atomic.LoadInt32(&m.symlinkShadowing)))
+	parent.SetMergeLimits(atomic.LoadInt64(&m.maxDirEntries),
+		atomic.LoadInt64(&m.maxMergeWork))
+	parent.SetDepthLimits(atomic.LoadInt64(&m.maxPathDepth),
+		atomic.LoadInt64(&m.maxNestingDepth))
+	parent.SetCollation(collation)
+}
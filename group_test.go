@@ -0,0 +1,131 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGroup(t *testing.T) {
+	base := &openCountingFS{FS: fstest.MapFS{
+		"dir/base.txt":   newMapFile("base"),
+		"dir/shared.txt": newMapFile("base"),
+	}}
+	theme := &openCountingFS{FS: fstest.MapFS{
+		"dir/shared.txt": newMapFile("theme"),
+	}}
+	shared := NewGroup("shared", &Layer{FS: theme, Name: "theme"},
+		&Layer{FS: base, Name: "base"})
+	shared.UseDirectoryCaching(true)
+	shared.SetSymlinkShadowing(SymlinksShadow)
+	staging := shared.Merge(fstest.MapFS{
+		"env.txt": newMapFile("staging"),
+	})
+	production := shared.Merge(fstest.MapFS{
+		"env.txt": newMapFile("production"),
+	})
+
+	e := fstest.TestFS(staging, "env.txt", "dir/base.txt", "dir/shared.txt")
+	if e != nil {
+		t.Logf("Staging merge failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	content, e := fs.ReadFile(production, "dir/shared.txt")
+	if (e != nil) || (string(content) != "theme") {
+		t.Logf("Got wrong shared.txt content: %q, %v\n", content, e)
+		t.FailNow()
+	}
+	content, e = fs.ReadFile(production, "env.txt")
+	if (e != nil) || (string(content) != "production") {
+		t.Logf("Got wrong env.txt content: %q, %v\n", content, e)
+		t.FailNow()
+	}
+
+	// The parents inherit the group's configuration...
+	if (production.dirCache == nil) || (SymlinkShadowing(atomic.LoadInt32(
+		&production.symlinkShadowing)) != SymlinksShadow) {
+		t.Logf("Parent didn't inherit the group's configuration\n")
+		t.FailNow()
+	}
+	// ...but can't change it.
+	staging.UseDirectoryCaching(false)
+	staging.SetSymlinkShadowing(ResolveBeforeShadowing)
+	if (shared.dirCache == nil) || (SymlinkShadowing(atomic.LoadInt32(
+		&shared.symlinkShadowing)) != SymlinksShadow) {
+		t.Logf("Parent changed the group's configuration\n")
+		t.FailNow()
+	}
+
+	// The merged "dir" is cached by the group, so listing it through either
+	// parent doesn't touch the group's layers.
+	opens := atomic.LoadInt64(&base.opens) + atomic.LoadInt64(&theme.opens)
+	for _, parent := range []*MergedFS{staging, production} {
+		entries, e := parent.ReadDir("dir")
+		if e != nil {
+			t.Logf("Failed reading dir: %s\n", e)
+			t.FailNow()
+		}
+		if len(entries) != 2 {
+			t.Logf("Expected 2 entries in dir, got %d\n", len(entries))
+			t.FailNow()
+		}
+	}
+	newOpens := atomic.LoadInt64(&base.opens) + atomic.LoadInt64(&theme.opens)
+	if newOpens != opens {
+		t.Logf("Group's layers were opened %d times despite caching\n",
+			newOpens-opens)
+		t.FailNow()
+	}
+
+	// Layer indices and provenance include the group's layers.
+	layers := staging.Layers()
+	if len(layers) != 3 {
+		t.Logf("Expected 3 layers, got %d\n", len(layers))
+		t.FailNow()
+	}
+	entries, e := staging.ReadDir("dir")
+	if e != nil {
+		t.Logf("Failed reading dir: %s\n", e)
+		t.FailNow()
+	}
+	for _, entry := range entries {
+		index, name := entry.(ProvenanceEntry).Provenance()
+		if (entry.Name() == "base.txt") && ((index != 2) || (name != "base")) {
+			t.Logf("Got wrong provenance for base.txt: %d, %q\n", index, name)
+			t.FailNow()
+		}
+	}
+	if !strings.Contains(staging.String(), `Group "shared"`) {
+		t.Logf("Group missing from description: %s\n", staging)
+		t.FailNow()
+	}
+}
+
+func TestGroupMergeWithoutLayers(t *testing.T) {
+	shared := NewGroup("shared", fstest.MapFS{
+		"a.txt": newMapFile("a"),
+	}, fstest.MapFS{
+		"b.txt": newMapFile("b"),
+	})
+	for _, merged := range []*MergedFS{shared.Merge(), shared.Merge(nil),
+		shared.Merge(nil, nil)} {
+		e := fstest.TestFS(merged, "a.txt", "b.txt")
+		if e != nil {
+			t.Logf("Merge without layers failed fstest: %s\n", e)
+			t.FailNow()
+		}
+	}
+
+	// Merge mustn't write to spare capacity in the caller's slice.
+	layers := make([]fs.FS, 1, 2)
+	layers[0] = fstest.MapFS{"c.txt": newMapFile("c")}
+	spare := layers[:2]
+	spare[1] = Empty
+	shared.Merge(layers...)
+	if spare[1] != Empty {
+		t.Logf("Merge modified the caller's slice\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,167 @@
+package merged_fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// Returned, possibly wrapped, by a HashFS that doesn't already know the hash
+// of a file, so callers know to fall back to hashing the content themselves.
+var ErrHashUnavailable = errors.New("hash unavailable")
+
+// May be implemented by FSs that already know the hashes of their files, such
+// as content-addressed stores, so that computing a hash doesn't require
+// reading the file. HashFile and ExportManifest use it when available.
+type HashFS interface {
+	fs.FS
+	// Returns the hash of the regular file at path, using the named
+	// algorithm, such as "sha256". Returns an error wrapping
+	// ErrHashUnavailable if the hash isn't already known, or the algorithm
+	// isn't supported. Implementations shouldn't compute hashes by reading
+	// the file; callers do that themselves if needed.
+	Hash(path, algorithm string) ([]byte, error)
+}
+
+// Returns a new hash for the given algorithm name: "sha256", "sha384", or
+// "sha512".
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha384":
+		return sha512.New384(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("Unsupported hash algorithm %q", algorithm)
+}
+
+// Returns the hash of the regular file at p in fsys, using the named
+// algorithm. If fsys implements HashFS and knows the hash, it's returned
+// without reading the file. Otherwise, this reads and hashes the file's
+// content, which supports "sha256", "sha384", and "sha512".
+func HashFile(fsys fs.FS, p, algorithm string) ([]byte, error) {
+	if hashFS, ok := fsys.(HashFS); ok {
+		sum, e := hashFS.Hash(p, algorithm)
+		if !errors.Is(e, ErrHashUnavailable) {
+			return sum, e
+		}
+	}
+	h, e := newHash(algorithm)
+	if e != nil {
+		return nil, &fs.PathError{Op: "hash", Path: p, Err: e}
+	}
+	f, e := fsys.Open(p)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	info, e := f.Stat()
+	if e != nil {
+		return nil, e
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "hash", Path: p,
+			Err: fmt.Errorf("%w: is a directory", fs.ErrInvalid)}
+	}
+	_, e = io.Copy(h, f)
+	if e != nil {
+		return nil, e
+	}
+	return h.Sum(nil), nil
+}
+
+// Returns the error a HashFS returns when it doesn't know a hash.
+func hashUnavailable(p string) error {
+	return &fs.PathError{Op: "hash", Path: p, Err: ErrHashUnavailable}
+}
+
+// Implements HashFS by asking the layer that serves the file at p for its
+// hash. The layer is found from the provenance of p's entry in its parent
+// directory (see ProvenanceEntry), so this honors priority overrides, pins,
+// and conflict resolvers, but requires listing the parent directory. Returns
+// an error wrapping ErrHashUnavailable if the layer doesn't implement HashFS
+// or doesn't know the hash, if p is an alias or symbolic link, or if
+// middleware is installed, since middleware may change a file's content. Use
+// HashFile to fall back to reading the file. The authorizer (see
+// SetAuthorizer) is called for p, with the op "hash", and not for its parent
+// directory.
+func (m *MergedFS) Hash(p, algorithm string) ([]byte, error) {
+	if !fs.ValidPath(p) || (p == ".") {
+		return nil, &fs.PathError{Op: "hash", Path: p, Err: fs.ErrInvalid}
+	}
+	e := m.authorize(context.Background(), "hash", p)
+	if e != nil {
+		return nil, e
+	}
+	entries, e := m.readDirUnauthorized(path.Dir(p))
+	if e != nil {
+		return nil, e
+	}
+	name := path.Base(p)
+	for _, entry := range entries {
+		if entry.Name() == name {
+			return m.entryHash(p, entry, algorithm)
+		}
+	}
+	return nil, &fs.PathError{Op: "hash", Path: p, Err: fs.ErrNotExist}
+}
+
+// Implements Hash given the entry for p from its parent directory in m.
+func (m *MergedFS) entryHash(p string, entry fs.DirEntry,
+	algorithm string) ([]byte, error) {
+	if entry.IsDir() {
+		return nil, &fs.PathError{Op: "hash", Path: p,
+			Err: fmt.Errorf("%w: is a directory", fs.ErrInvalid)}
+	}
+	m.configMutex.RLock()
+	middleware := m.opener != nil
+	m.configMutex.RUnlock()
+	provenance, ok := entry.(ProvenanceEntry)
+	if middleware || !ok || (entry.Type()&fs.ModeSymlink != 0) {
+		return nil, hashUnavailable(p)
+	}
+	index, _ := provenance.Provenance()
+	layers := m.Layers()
+	if (index < 0) || (index >= len(layers)) {
+		return nil, hashUnavailable(p)
+	}
+	hashFS, ok := layers[index].(HashFS)
+	if !ok {
+		return nil, hashUnavailable(p)
+	}
+	return hashFS.Hash(p, algorithm)
+}
+
+// Implements HashFS if the underlying FS does, subject to the layer's
+// limits, circuit breaker, and hidden paths.
+func (l *Layer) Hash(path, algorithm string) ([]byte, error) {
+	hashFS, ok := l.FS.(HashFS)
+	if !ok {
+		return nil, hashUnavailable(path)
+	}
+	release, e := l.acquireChecked(context.Background(), "hash", path)
+	if e != nil {
+		return nil, e
+	}
+	defer release()
+	if l.hidden(context.Background(), l.gateClosed(), path) {
+		return nil, &fs.PathError{Op: "hash", Path: path, Err: fs.ErrNotExist}
+	}
+	fullPath, e := l.fsPath("hash", path)
+	if e != nil {
+		return nil, e
+	}
+	sum, e := hashFS.Hash(fullPath, algorithm)
+	if !errors.Is(e, ErrHashUnavailable) {
+		l.recordResult(e)
+	}
+	return sum, l.fixError(e)
+}
@@ -0,0 +1,127 @@
+package merged_fs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// A HashFS that reports fixed "hashes" for some of its files, so tests can
+// tell whether a hash came from the layer or from reading the content.
+type knownHashFS struct {
+	fstest.MapFS
+	hashes map[string][]byte
+}
+
+func (k *knownHashFS) Hash(path, algorithm string) ([]byte, error) {
+	sum := k.hashes[path]
+	if (sum == nil) || (algorithm != "sha256") {
+		return nil, &fs.PathError{Op: "hash", Path: path,
+			Err: ErrHashUnavailable}
+	}
+	return sum, nil
+}
+
+func TestHash(t *testing.T) {
+	fsA := &knownHashFS{
+		MapFS: fstest.MapFS{
+			"assets/known.bin":   newMapFile("known"),
+			"assets/unknown.bin": newMapFile("unknown"),
+		},
+		hashes: map[string][]byte{"assets/known.bin": {1, 2, 3}},
+	}
+	fsB := &Layer{
+		Root: "root",
+		FS: &knownHashFS{
+			MapFS: fstest.MapFS{
+				"root/assets/known.bin": newMapFile("shadowed"),
+				"root/b.bin":            newMapFile("in B"),
+			},
+			hashes: map[string][]byte{"root/b.bin": {4, 5}},
+		},
+	}
+	merged := NewMergedFS(fsA, fsB)
+	sum, e := merged.Hash("assets/known.bin", "sha256")
+	if (e != nil) || !bytes.Equal(sum, []byte{1, 2, 3}) {
+		t.Logf("Didn't get the layer's hash for known.bin: %x, %v\n", sum, e)
+		t.FailNow()
+	}
+	sum, e = merged.Hash("b.bin", "sha256")
+	if (e != nil) || !bytes.Equal(sum, []byte{4, 5}) {
+		t.Logf("Didn't get the Layer's hash for b.bin: %x, %v\n", sum, e)
+		t.FailNow()
+	}
+	_, e = merged.Hash("assets/unknown.bin", "sha256")
+	if !errors.Is(e, ErrHashUnavailable) {
+		t.Logf("Didn't get expected error for an unknown hash: %v\n", e)
+		t.FailNow()
+	}
+	_, e = merged.Hash("assets/known.bin", "sha512")
+	if !errors.Is(e, ErrHashUnavailable) {
+		t.Logf("Didn't get expected error for an unknown algorithm: %v\n", e)
+		t.FailNow()
+	}
+	_, e = merged.Hash("missing.bin", "sha256")
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Didn't get expected error for a missing file: %v\n", e)
+		t.FailNow()
+	}
+
+	sum, e = HashFile(merged, "assets/unknown.bin", "sha256")
+	expected := sha256.Sum256([]byte("unknown"))
+	if (e != nil) || !bytes.Equal(sum, expected[:]) {
+		t.Logf("HashFile didn't hash unknown.bin's content: %x, %v\n", sum,
+			e)
+		t.FailNow()
+	}
+	sum, e = HashFile(merged, "assets/known.bin", "sha256")
+	if (e != nil) || !bytes.Equal(sum, []byte{1, 2, 3}) {
+		t.Logf("HashFile didn't use the layer's hash: %x, %v\n", sum, e)
+		t.FailNow()
+	}
+	_, e = HashFile(merged, "assets", "sha256")
+	if !errors.Is(e, fs.ErrInvalid) {
+		t.Logf("Didn't get expected error hashing a directory: %v\n", e)
+		t.FailNow()
+	}
+	_, e = HashFile(merged, "b.bin", "md4")
+	if e == nil {
+		t.Logf("Didn't get an error for an unsupported algorithm\n")
+		t.FailNow()
+	}
+
+	var buf, manifestBuf bytes.Buffer
+	e = ExportZipWithOptions(&buf, merged, ExportOptions{
+		Manifest: &manifestBuf,
+	})
+	if e != nil {
+		t.Logf("Failed exporting zip: %s\n", e)
+		t.FailNow()
+	}
+	var manifest ExportManifest
+	e = json.Unmarshal(manifestBuf.Bytes(), &manifest)
+	if e != nil {
+		t.Logf("Failed parsing manifest: %s\n", e)
+		t.FailNow()
+	}
+	expectedHashes := map[string]string{
+		"assets/known.bin":   "010203",
+		"assets/unknown.bin": hex.EncodeToString(expected[:]),
+		"b.bin":              "0405",
+	}
+	if len(manifest.Files) != len(expectedHashes) {
+		t.Logf("Got wrong manifest: %+v\n", manifest.Files)
+		t.FailNow()
+	}
+	for _, f := range manifest.Files {
+		if f.SHA256 != expectedHashes[f.Path] {
+			t.Logf("Got wrong manifest hash for %s: %s\n", f.Path, f.SHA256)
+			t.FailNow()
+		}
+	}
+}
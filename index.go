@@ -0,0 +1,616 @@
+package merged_fs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The on-disk index format written by WriteIndex. All integers are
+// little-endian. The file starts with a header:
+//
+//	magic       [8]byte  "MFSINDEX"
+//	version     uint32   indexVersion
+//	layers      uint32   the number of layers in the MergedFS
+//	entries     uint32   the number of entry records
+//	children    uint32   the number of child indices
+//	stringsSize uint64   the size of the string table, in bytes
+//	reserved    uint64   zero
+//
+// followed by a uint64 fingerprint of each layer, in order, as computed by
+// layerFingerprint, followed by the entry records, one per path, sorted by
+// path:
+//
+//	pathOffset  uint32   the path's offset in the string table
+//	pathSize    uint32   the path's length in the string table
+//	mode        uint32   the fs.FileMode
+//	layer       int32    the layer index reported by ProvenanceEntry, or -1
+//	size        int64
+//	modSeconds  int64    modification time, as time.Time.Unix
+//	modNanos    uint32   modification time, as time.Time.Nanosecond
+//	childStart  uint32   a directory's first index into the child indices
+//	childCount  uint32   the number of entries in a directory
+//	class       uint32   a regular file's FileClass, or zero
+//
+// followed by the child indices, each a uint32 index of an entry record, in
+// the order the directories list them, followed by the string table. Any
+// change to this layout must increment indexVersion.
+const (
+	indexMagic      = "MFSINDEX"
+	indexVersion    = 3
+	indexHeaderSize = 40
+	indexRecordSize = 48
+)
+
+// Returns the records for a compiled FS's paths, sorted by path, along with
+// the child indices and string table. Regular files are recorded with their
+// classes from the given map.
+func encodeIndex(c *compiledFS, classes map[string]FileClass) (records,
+	children, strings []byte, e error) {
+	paths := make([]string, 0, len(c.paths))
+	for p := range c.paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	if len(paths) > math.MaxUint32 {
+		return nil, nil, nil, fmt.Errorf("Too many paths to index: %d",
+			len(paths))
+	}
+	indices := make(map[string]uint32, len(paths))
+	for i, p := range paths {
+		indices[p] = uint32(i)
+	}
+	var recordBuf, childBuf, stringBuf bytes.Buffer
+	childCount := 0
+	for _, p := range paths {
+		entry := c.paths[p]
+		childStart := childCount
+		for _, child := range entry.entries {
+			index, ok := indices[path.Join(p, child.Name())]
+			if !ok {
+				return nil, nil, nil, fmt.Errorf("Missing entry %s in %s",
+					child.Name(), p)
+			}
+			binary.Write(&childBuf, binary.LittleEndian, index)
+			childCount++
+		}
+		if (stringBuf.Len()+len(p) > math.MaxUint32) ||
+			(childCount > math.MaxUint32) {
+			return nil, nil, nil, fmt.Errorf("The index is too large")
+		}
+		modTime := entry.info.ModTime()
+		fields := []interface{}{
+			uint32(stringBuf.Len()), uint32(len(p)),
+			uint32(entry.info.Mode()), int32(entry.layerIndex),
+			entry.info.Size(), modTime.Unix(), uint32(modTime.Nanosecond()),
+			uint32(childStart), uint32(childCount - childStart),
+			uint32(classes[p]),
+		}
+		for _, field := range fields {
+			binary.Write(&recordBuf, binary.LittleEndian, field)
+		}
+		stringBuf.WriteString(p)
+	}
+	return recordBuf.Bytes(), childBuf.Bytes(), stringBuf.Bytes(), nil
+}
+
+// Returns a fingerprint of the layer's root directory listing: the name, mode,
+// size, and modification time of each entry. A layer whose root doesn't exist
+// is treated as empty.
+func layerFingerprint(layer fs.FS) (uint64, error) {
+	entries, e := fs.ReadDir(layer, ".")
+	if (e != nil) && !isBadPathError(e) {
+		return 0, e
+	}
+	h := fnv.New64a()
+	for _, entry := range entries {
+		info, e := entry.Info()
+		if e != nil {
+			return 0, e
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d\x00", entry.Name(),
+			uint32(info.Mode()), info.Size(), info.ModTime().UnixNano())
+	}
+	return h.Sum64(), nil
+}
+
+// Returns the fingerprints of the given layers, encoded as in an index.
+func encodeFingerprints(layers []fs.FS) ([]byte, error) {
+	toReturn := make([]byte, 8*len(layers))
+	for i, layer := range layers {
+		fingerprint, e := layerFingerprint(layer)
+		if e != nil {
+			return nil, fmt.Errorf("Couldn't fingerprint layer %d: %w", i, e)
+		}
+		binary.LittleEndian.PutUint64(toReturn[8*i:], fingerprint)
+	}
+	return toReturn, nil
+}
+
+// Compiles m, as Compile does, and writes the result to w in a stable,
+// versioned binary format. Any process can then use OpenIndex to serve the
+// same content from the index, without walking m itself. Since OpenIndex
+// memory-maps the file where possible, processes serving the same layers
+// share a single copy of the index rather than each holding its own.
+//
+// The index also records whether each regular file is text or binary, as
+// reported by Classify, so IndexFS.Classify doesn't need to read any files.
+// This requires reading the start of every file whose extension isn't
+// recognized, so writing an index takes longer than compiling m. Returns
+// ConfigErrors if m's settings can't be compiled, as Compile does.
+//
+// The index records a fingerprint of each layer's root directory listing, so
+// that OpenIndex can reject an index whose layers have since changed. Changes
+// deeper within a layer that leave its root listing, including modification
+// times, untouched can't be detected this way, so write a new index whenever
+// a layer's content changes.
+func (m *MergedFS) WriteIndex(w io.Writer) error {
+	e := m.validateFor("WriteIndex")
+	if e != nil {
+		return e
+	}
+	compiled, e := m.compile()
+	if e != nil {
+		return e
+	}
+	classes, e := m.classifyCompiled(compiled)
+	if e != nil {
+		return e
+	}
+	records, children, strings, e := encodeIndex(compiled, classes)
+	if e != nil {
+		return e
+	}
+	fingerprints, e := encodeFingerprints(m.Layers())
+	if e != nil {
+		return e
+	}
+	header := make([]byte, indexHeaderSize)
+	copy(header, indexMagic)
+	binary.LittleEndian.PutUint32(header[8:], indexVersion)
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(m.Layers())))
+	binary.LittleEndian.PutUint32(header[16:],
+		uint32(len(records)/indexRecordSize))
+	binary.LittleEndian.PutUint32(header[20:], uint32(len(children)/4))
+	binary.LittleEndian.PutUint64(header[24:], uint64(len(strings)))
+	for _, data := range [][]byte{header, fingerprints, records, children,
+		strings} {
+		_, e = w.Write(data)
+		if e != nil {
+			return fmt.Errorf("Couldn't write index: %w", e)
+		}
+	}
+	return nil
+}
+
+// A single entry record in an index. See WriteIndex.
+type indexRecord []byte
+
+func (r indexRecord) pathOffset() uint32 {
+	return binary.LittleEndian.Uint32(r)
+}
+
+func (r indexRecord) pathSize() uint32 {
+	return binary.LittleEndian.Uint32(r[4:])
+}
+
+func (r indexRecord) mode() fs.FileMode {
+	return fs.FileMode(binary.LittleEndian.Uint32(r[8:]))
+}
+
+func (r indexRecord) layer() int {
+	return int(int32(binary.LittleEndian.Uint32(r[12:])))
+}
+
+func (r indexRecord) childStart() uint32 {
+	return binary.LittleEndian.Uint32(r[36:])
+}
+
+func (r indexRecord) childCount() uint32 {
+	return binary.LittleEndian.Uint32(r[40:])
+}
+
+func (r indexRecord) class() FileClass {
+	return FileClass(binary.LittleEndian.Uint32(r[44:]))
+}
+
+// The FileInfo of a path in an index.
+type indexInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *indexInfo) Name() string {
+	return i.name
+}
+
+func (i *indexInfo) Size() int64 {
+	return i.size
+}
+
+func (i *indexInfo) Mode() fs.FileMode {
+	return i.mode
+}
+
+func (i *indexInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i *indexInfo) IsDir() bool {
+	return i.mode.IsDir()
+}
+
+func (i *indexInfo) Sys() interface{} {
+	return nil
+}
+
+// Serves a MergedFS's content using an index written by WriteIndex. Returned
+// by OpenIndex.
+type IndexFS struct {
+	source *MergedFS
+	layers []fs.FS
+	// The entire index, which may be memory-mapped. Never modified.
+	data     []byte
+	records  []byte
+	children []byte
+	strings  []byte
+	// Unmaps data, if it's mapped.
+	unmap func() error
+	// Held for reading while data is in use, and for writing by Close, so
+	// data isn't unmapped while it's being read.
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// Serves m's content using the index at indexPath, which must have been
+// written by WriteIndex for a MergedFS with the same layers, in the same
+// order. The index is memory-mapped read-only where the platform supports
+// it, and read into memory otherwise.
+//
+// Like the FS returned by Compile, the returned FS serves directories from the
+// index without accessing any layer, and opens regular files directly from
+// the layers that provide them. Paths without provenance, and symbolic links,
+// are opened through m. FileInfos from the index don't preserve the values
+// returned by Sys. The index must not be modified while it's in use; write a
+// new file and replace the old one instead. Returns an error if the index is
+// malformed, was written by an incompatible version of this package, or was
+// written for a different number of layers, or for layers whose fingerprints
+// (see WriteIndex) don't match m's. Like Compile, this also returns
+// ConfigErrors if m's settings are invalid or can't be snapshotted.
+func OpenIndex(m *MergedFS, indexPath string) (*IndexFS, error) {
+	e := m.validateFor("OpenIndex")
+	if e != nil {
+		return nil, e
+	}
+	data, unmap, e := mapIndexFile(indexPath)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't read index %s: %w", indexPath, e)
+	}
+	toReturn := &IndexFS{
+		source: m,
+		layers: m.Layers(),
+		data:   data,
+		unmap:  unmap,
+	}
+	e = toReturn.validate()
+	if e != nil {
+		unmap()
+		return nil, fmt.Errorf("Invalid index %s: %w", indexPath, e)
+	}
+	return toReturn, nil
+}
+
+// Checks the index's header and records, so that later lookups can't go out
+// of bounds.
+func (x *IndexFS) validate() error {
+	if (len(x.data) < indexHeaderSize) ||
+		(string(x.data[:8]) != indexMagic) {
+		return fmt.Errorf("Not an index file")
+	}
+	version := binary.LittleEndian.Uint32(x.data[8:])
+	if version != indexVersion {
+		return fmt.Errorf("Unsupported index version %d (expected %d)",
+			version, indexVersion)
+	}
+	layers := binary.LittleEndian.Uint32(x.data[12:])
+	if uint64(layers) != uint64(len(x.layers)) {
+		return fmt.Errorf("The index is for %d layers, but the FS has %d",
+			layers, len(x.layers))
+	}
+	entries := uint64(binary.LittleEndian.Uint32(x.data[16:]))
+	children := uint64(binary.LittleEndian.Uint32(x.data[20:]))
+	stringsSize := binary.LittleEndian.Uint64(x.data[24:])
+	recordsStart := indexHeaderSize + 8*uint64(layers)
+	if recordsStart > uint64(len(x.data)) {
+		return fmt.Errorf("The index's size doesn't match its header")
+	}
+	fingerprints, e := encodeFingerprints(x.layers)
+	if e != nil {
+		return e
+	}
+	for i := range x.layers {
+		stored := x.data[indexHeaderSize+8*i : indexHeaderSize+8*(i+1)]
+		if !bytes.Equal(stored, fingerprints[8*i:8*(i+1)]) {
+			return fmt.Errorf("Layer %d has changed since the index was "+
+				"written", i)
+		}
+	}
+	recordsEnd := recordsStart + entries*indexRecordSize
+	childrenEnd := recordsEnd + children*4
+	if (stringsSize > uint64(len(x.data))) ||
+		(childrenEnd+stringsSize != uint64(len(x.data))) {
+		return fmt.Errorf("The index's size doesn't match its header")
+	}
+	x.records = x.data[recordsStart:recordsEnd]
+	x.children = x.data[recordsEnd:childrenEnd]
+	x.strings = x.data[childrenEnd:]
+	var previous []byte
+	for i := 0; i < int(entries); i++ {
+		r := x.record(i)
+		end := uint64(r.pathOffset()) + uint64(r.pathSize())
+		if end > stringsSize {
+			return fmt.Errorf("Entry %d's path is out of bounds", i)
+		}
+		p := x.strings[r.pathOffset():end]
+		if (i != 0) && (bytes.Compare(previous, p) >= 0) {
+			return fmt.Errorf("Entry %d is out of order", i)
+		}
+		previous = p
+		if (r.layer() < -1) || (r.layer() >= len(x.layers)) {
+			return fmt.Errorf("Entry %d has invalid layer %d", i, r.layer())
+		}
+		start := uint64(r.childStart())
+		if start+uint64(r.childCount()) > children {
+			return fmt.Errorf("Entry %d's children are out of bounds", i)
+		}
+		for j := uint64(0); j < uint64(r.childCount()); j++ {
+			if uint64(x.child(start+j)) >= entries {
+				return fmt.Errorf("Entry %d has an invalid child", i)
+			}
+		}
+	}
+	root := x.find(".")
+	if (root < 0) || !x.record(root).mode().IsDir() {
+		return fmt.Errorf("The index has no root directory")
+	}
+	return nil
+}
+
+func (x *IndexFS) record(i int) indexRecord {
+	start := i * indexRecordSize
+	return indexRecord(x.records[start : start+indexRecordSize])
+}
+
+func (x *IndexFS) child(i uint64) uint32 {
+	return binary.LittleEndian.Uint32(x.children[i*4:])
+}
+
+func (x *IndexFS) recordPath(r indexRecord) []byte {
+	return x.strings[r.pathOffset() : r.pathOffset()+r.pathSize()]
+}
+
+// Returns the index of the record for p, or -1 if there isn't one.
+func (x *IndexFS) find(p string) int {
+	count := len(x.records) / indexRecordSize
+	target := []byte(p)
+	i := sort.Search(count, func(i int) bool {
+		return bytes.Compare(x.recordPath(x.record(i)), target) >= 0
+	})
+	if (i < count) && bytes.Equal(x.recordPath(x.record(i)), target) {
+		return i
+	}
+	return -1
+}
+
+// Returns the info for the given record. Copies everything out of the
+// index, so the info remains valid after the index is closed.
+func (x *IndexFS) info(r indexRecord) *indexInfo {
+	return &indexInfo{
+		name: baseName(string(x.recordPath(r))),
+		size: int64(binary.LittleEndian.Uint64(r[16:])),
+		mode: r.mode(),
+		modTime: time.Unix(int64(binary.LittleEndian.Uint64(r[24:])),
+			int64(binary.LittleEndian.Uint32(r[32:]))),
+	}
+}
+
+// Returns the entries of the directory with the given record, with
+// provenance where it's known.
+func (x *IndexFS) entries(r indexRecord) []fs.DirEntry {
+	toReturn := make([]fs.DirEntry, r.childCount())
+	start := uint64(r.childStart())
+	for i := range toReturn {
+		child := x.record(int(x.child(start + uint64(i))))
+		var entry fs.DirEntry = infoDirEntry{x.info(child)}
+		if layer := child.layer(); layer >= 0 {
+			entry = &provenanceEntry{
+				DirEntry:   entry,
+				layerIndex: layer,
+				layerName:  layerNameForProvenance(x.layers[layer]),
+			}
+		}
+		toReturn[i] = entry
+	}
+	return toReturn
+}
+
+// What an index records about a path, copied out of the index so that it
+// remains valid after the index is closed.
+type indexResult struct {
+	info  *indexInfo
+	layer int
+	class FileClass
+	// The directory's entries, if requested and the path is a directory.
+	entries []fs.DirEntry
+}
+
+// Returns what the index records about the path, including a directory's
+// entries if withEntries is true, or nil if the path must be resolved through
+// the MergedFS because it's a symbolic link or within one. Returns an error if
+// the path doesn't exist, or if x has been closed.
+func (x *IndexFS) lookup(op, p string, withEntries bool) (*indexResult,
+	error) {
+	if !fs.ValidPath(p) {
+		return nil, &fs.PathError{Op: op, Path: p, Err: fs.ErrInvalid}
+	}
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	if x.closed {
+		return nil, &fs.PathError{Op: op, Path: p, Err: fs.ErrClosed}
+	}
+	if i := x.find(p); i >= 0 {
+		r := x.record(i)
+		if r.mode()&fs.ModeSymlink != 0 {
+			return nil, nil
+		}
+		toReturn := &indexResult{
+			info:  x.info(r),
+			layer: r.layer(),
+			class: r.class(),
+		}
+		if withEntries && r.mode().IsDir() {
+			toReturn.entries = x.entries(r)
+		}
+		return toReturn, nil
+	}
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if i := x.find(dir); i >= 0 {
+			if x.record(i).mode()&fs.ModeSymlink != 0 {
+				return nil, nil
+			}
+			break
+		}
+	}
+	return nil, &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+}
+
+func (x *IndexFS) Open(p string) (fs.File, error) {
+	r, e := x.lookup("open", p, true)
+	if e != nil {
+		return nil, e
+	}
+	switch {
+	case r == nil:
+		return x.source.Open(p)
+	case r.info.IsDir():
+		return &completedDir{
+			MergedDirectory: &MergedDirectory{
+				name:    r.info.Name(),
+				mode:    r.info.Mode(),
+				entries: r.entries,
+			},
+			info: r.info,
+		}, nil
+	case r.layer < 0:
+		return x.source.Open(p)
+	}
+	return x.layers[r.layer].Open(p)
+}
+
+func (x *IndexFS) ReadFile(p string) ([]byte, error) {
+	r, e := x.lookup("readfile", p, false)
+	if e != nil {
+		return nil, e
+	}
+	if (r == nil) || (r.layer < 0) {
+		return x.source.ReadFile(p)
+	}
+	return fs.ReadFile(x.layers[r.layer], p)
+}
+
+func (x *IndexFS) Stat(p string) (fs.FileInfo, error) {
+	r, e := x.lookup("stat", p, false)
+	if e != nil {
+		return nil, e
+	}
+	if r == nil {
+		return fs.Stat(x.source, p)
+	}
+	return r.info, nil
+}
+
+func (x *IndexFS) ReadDir(p string) ([]fs.DirEntry, error) {
+	r, e := x.lookup("readdir", p, true)
+	if e != nil {
+		return nil, e
+	}
+	if r == nil {
+		return x.source.ReadDir(p)
+	}
+	if !r.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: p,
+			Err: fmt.Errorf("%w: not a directory", fs.ErrInvalid)}
+	}
+	return r.entries, nil
+}
+
+// Releases the index. Files and directories already opened from x remain
+// usable, and operations on x already in progress finish before the index is
+// released, but later operations on x fail with an error wrapping
+// fs.ErrClosed.
+func (x *IndexFS) Close() error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.closed {
+		return nil
+	}
+	x.closed = true
+	return x.unmap()
+}
+
+// Classifies every regular file in the compiled FS, using m.Classify, for
+// WriteIndex.
+func (m *MergedFS) classifyCompiled(c *compiledFS) (map[string]FileClass,
+	error) {
+	var paths []string
+	for p, entry := range c.paths {
+		if entry.info.Mode().IsRegular() {
+			paths = append(paths, p)
+		}
+	}
+	classes := make([]FileClass, len(paths))
+	errs := make([]error, len(paths))
+	runConcurrently(len(paths), func(i int) {
+		classes[i], errs[i] = m.Classify(paths[i])
+	})
+	toReturn := make(map[string]FileClass, len(paths))
+	for i, p := range paths {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("Couldn't classify %s: %w", p, errs[i])
+		}
+		toReturn[p] = classes[i]
+	}
+	return toReturn, nil
+}
+
+// Implements ClassifyFS, returning the class recorded in the index for the
+// regular file at p. Paths without a recorded class, such as those within
+// symbolic links, are classified using the MergedFS's Classify method
+// instead.
+func (x *IndexFS) Classify(p string) (FileClass, error) {
+	r, e := x.lookup("classify", p, false)
+	if e != nil {
+		return unclassified, e
+	}
+	if r == nil {
+		return x.source.Classify(p)
+	}
+	if r.info.IsDir() {
+		return unclassified, &fs.PathError{Op: "classify", Path: p,
+			Err: fmt.Errorf("%s is a directory", p)}
+	}
+	if c := r.class; (c == TextFile) || (c == BinaryFile) {
+		return c, nil
+	}
+	return x.source.Classify(p)
+}
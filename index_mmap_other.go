@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package merged_fs
+
+import (
+	"os"
+)
+
+// Reads the file at path into memory, since memory-mapping isn't supported
+// on this platform. Returns its contents and a function that does nothing.
+func mapIndexFile(path string) ([]byte, func() error, error) {
+	data, e := os.ReadFile(path)
+	if e != nil {
+		return nil, nil, e
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,36 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package merged_fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// Maps the file at path into memory read-only, returning its contents and a
+// function to unmap it.
+func mapIndexFile(path string) ([]byte, func() error, error) {
+	f, e := os.Open(path)
+	if e != nil {
+		return nil, nil, e
+	}
+	defer f.Close()
+	info, e := f.Stat()
+	if e != nil {
+		return nil, nil, e
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, func() error { return nil }, nil
+	}
+	if int64(int(size)) != size {
+		return nil, nil, syscall.EFBIG
+	}
+	data, e := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ,
+		syscall.MAP_SHARED)
+	if e != nil {
+		return nil, nil, &os.PathError{Op: "mmap", Path: path, Err: e}
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,174 @@
+package merged_fs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIndex(t *testing.T) {
+	fsA := &openCountingFS{FS: fstest.MapFS{
+		"dir/a.txt": newMapFile("in A"),
+		"shared":    newMapFile("a file in A"),
+	}}
+	fsB := &openCountingFS{FS: fstest.MapFS{
+		"dir/b.txt":        newMapFile("in B"),
+		"shared/hidden":    newMapFile("hidden by A"),
+		"only_b/child.txt": newMapFile("only in B"),
+	}}
+	// Modification times are read back from the index without a monotonic
+	// clock reading, so they'd differ from the layers' times otherwise.
+	for _, layer := range []*openCountingFS{fsA, fsB} {
+		for _, f := range layer.FS.(fstest.MapFS) {
+			f.ModTime = f.ModTime.Round(0)
+		}
+	}
+	m := NewMergedFS(fsA, &Layer{Name: "base", FS: fsB})
+	e := m.Alias("alias.txt", "dir/b.txt")
+	if e != nil {
+		t.Logf("Failed adding alias: %s\n", e)
+		t.FailNow()
+	}
+	var buf bytes.Buffer
+	e = m.WriteIndex(&buf)
+	if e != nil {
+		t.Logf("Failed writing index: %s\n", e)
+		t.FailNow()
+	}
+	indexPath := filepath.Join(t.TempDir(), "merged.index")
+	e = os.WriteFile(indexPath, buf.Bytes(), 0644)
+	if e != nil {
+		t.Logf("Failed saving index: %s\n", e)
+		t.FailNow()
+	}
+	index, e := OpenIndex(m, indexPath)
+	if e != nil {
+		t.Logf("Failed opening index: %s\n", e)
+		t.FailNow()
+	}
+	defer index.Close()
+	e = fstest.TestFS(index, "dir/a.txt", "dir/b.txt", "shared",
+		"only_b/child.txt", "alias.txt")
+	if e != nil {
+		t.Logf("Index FS failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	_, e = index.Open("shared/hidden")
+	if e == nil {
+		t.Logf("Didn't get an error opening a shadowed path\n")
+		t.FailNow()
+	}
+
+	opensA := atomic.LoadInt64(&fsA.opens)
+	entries, e := index.ReadDir("dir")
+	if (e != nil) || (len(entries) != 2) {
+		t.Logf("Failed reading dir: %v, %v\n", entries, e)
+		t.FailNow()
+	}
+	layer, name := entries[1].(ProvenanceEntry).Provenance()
+	if (entries[1].Name() != "b.txt") || (layer != 1) || (name != "base") {
+		t.Logf("Got wrong entry for b.txt: %s, %d, %q\n", entries[1].Name(),
+			layer, name)
+		t.FailNow()
+	}
+	content, e := index.ReadFile("dir/b.txt")
+	if (e != nil) || (string(content) != "in B") {
+		t.Logf("Failed reading dir/b.txt: %q, %v\n", content, e)
+		t.FailNow()
+	}
+	if atomic.LoadInt64(&fsA.opens) != opensA {
+		t.Logf("Index FS opened a file in A unnecessarily\n")
+		t.FailNow()
+	}
+	info, e := index.Stat("dir/a.txt")
+	if (e != nil) || (info.Size() != 4) || info.IsDir() {
+		t.Logf("Got wrong info for dir/a.txt: %v, %v\n", info, e)
+		t.FailNow()
+	}
+
+	// Indexes for a different set of layers, or of a different version, must
+	// be rejected.
+	_, e = OpenIndex(NewMergedFS(MergeMultiple(fsA, fsB), fsB), indexPath)
+	if e == nil {
+		t.Logf("Didn't get an error opening an index for other layers\n")
+		t.FailNow()
+	}
+	data := buf.Bytes()
+	data[8]++
+	e = os.WriteFile(indexPath, data, 0644)
+	if e != nil {
+		t.Logf("Failed saving modified index: %s\n", e)
+		t.FailNow()
+	}
+	_, e = OpenIndex(m, indexPath)
+	if e == nil {
+		t.Logf("Didn't get an error opening an index of another version\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for a different version: %s\n", e)
+	data[8]--
+	e = os.WriteFile(indexPath, data[:len(data)-1], 0644)
+	if e != nil {
+		t.Logf("Failed saving truncated index: %s\n", e)
+		t.FailNow()
+	}
+	_, e = OpenIndex(m, indexPath)
+	if e == nil {
+		t.Logf("Didn't get an error opening a truncated index\n")
+		t.FailNow()
+	}
+}
+
+func TestIndexStaleAndClosed(t *testing.T) {
+	fsA := fstest.MapFS{"a.txt": newMapFile("a")}
+	fsB := fstest.MapFS{"dir/b.txt": newMapFile("b")}
+	m := NewMergedFS(fsA, fsB)
+	var buf bytes.Buffer
+	e := m.WriteIndex(&buf)
+	if e != nil {
+		t.Logf("Failed writing index: %s\n", e)
+		t.FailNow()
+	}
+	indexPath := filepath.Join(t.TempDir(), "merged.index")
+	e = os.WriteFile(indexPath, buf.Bytes(), 0644)
+	if e != nil {
+		t.Logf("Failed saving index: %s\n", e)
+		t.FailNow()
+	}
+	index, e := OpenIndex(m, indexPath)
+	if e != nil {
+		t.Logf("Failed opening index: %s\n", e)
+		t.FailNow()
+	}
+
+	// Operations after Close must fail rather than reading unmapped memory.
+	e = index.Close()
+	if e != nil {
+		t.Logf("Failed closing index: %s\n", e)
+		t.FailNow()
+	}
+	_, e = index.ReadFile("a.txt")
+	if !errors.Is(e, fs.ErrClosed) {
+		t.Logf("Expected ErrClosed reading a closed index, got %v\n", e)
+		t.FailNow()
+	}
+	_, e = index.ReadDir("dir")
+	if !errors.Is(e, fs.ErrClosed) {
+		t.Logf("Expected ErrClosed listing a closed index, got %v\n", e)
+		t.FailNow()
+	}
+
+	// An index for layers that have changed since must be rejected.
+	fsB["c.txt"] = newMapFile("c")
+	_, e = OpenIndex(m, indexPath)
+	if e == nil {
+		t.Logf("Didn't get an error opening an index for changed layers\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for changed layers: %s\n", e)
+}
@@ -0,0 +1,518 @@
+package merged_fs
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Layer wraps a single filesystem taking part in a merge, and holds settings
+// that apply only to that filesystem. A *Layer implements fs.FS, so it can be
+// passed to NewMergedFS or MergeMultiple anywhere an ordinary FS can:
+//
+//	merged := MergeMultiple(
+//		&Layer{FS: localFS, Name: "local"},
+//		&Layer{FS: remoteFS, Name: "remote", MaxConcurrent: 4},
+//	)
+//
+// A Layer's exported fields must not be modified, and the Layer must not be
+// copied, after it's first used.
+type Layer struct {
+	// The underlying filesystem. Must not be nil.
+	FS fs.FS
+
+	// An optional human-readable name for the layer.
+	Name string
+
+	// If non-empty, only the subtree of FS rooted at this directory takes
+	// part in the merge, e.g. "payload/assets". This is equivalent to using
+	// fs.Sub(FS, Root), except that FS isn't wrapped, so its ReadFile, Stat,
+	// and Glob methods are still used if it has them. KnownPaths are still
+	// relative to the root of FS. Must be a valid path.
+	Root string
+
+	// If positive, this is the maximum number of operations (Open, Stat,
+	// ReadFile, ReadDir, or Glob) that may be running in FS at once.
+	// Additional operations block until one of the running operations
+	// completes. This only limits the operations themselves; it places no
+	// limit on how many files from FS may be open at once.
+	MaxConcurrent int
+
+	// If positive, this is the maximum number of operations per second that
+	// will be started in FS. Operations beyond this rate are delayed.
+	OpsPerSecond float64
+
+	// If positive, reads from the layer's files fail with a
+	// *QuotaExceededError rather than allowing more than this many bytes to
+	// be read from the layer in total. Use BytesRead to check the number of
+	// bytes read so far.
+	ReadQuota int64
+
+	// If non-nil, this lists the paths of regular files in FS. This is
+	// intended for "sparse" filesystems, such as some zip files, which can
+	// open a file like "a/b/c.txt" but fail to open the directories "a" and
+	// "a/b". If FS is unable to open a directory containing one of these
+	// paths, the Layer will instead provide a synthesized read-only directory
+	// listing the known paths within it. Directories that FS can open will
+	// also list any known paths that FS omitted.
+	//
+	// If this is nil and FS is a *zip.Reader, then the paths of the files in
+	// the zip archive are used.
+	KnownPaths []string
+
+	// If either of these is non-zero, the layer is only visible at or after
+	// VisibleFrom, and before VisibleUntil. When the layer isn't visible, it
+	// behaves as an empty FS, or only hides the paths matching GatedPatterns
+	// if any are provided.
+	VisibleFrom, VisibleUntil time.Time
+
+	// If non-nil, this is called before every operation on the layer, and the
+	// layer is treated as invisible (in the same way as outside of the time
+	// window above) if it returns false. It must be safe to call from
+	// multiple goroutines, and should be fast.
+	Enabled func() bool
+
+	// If non-empty, only paths matching these patterns are hidden when the
+	// layer is invisible due to VisibleFrom, VisibleUntil, or Enabled; the
+	// rest of the layer remains visible. Patterns use the syntax of
+	// path.Match, except that a "**" component matches zero or more path
+	// components. Malformed patterns never match anything.
+	GatedPatterns []string
+
+	// If non-nil, this is called with each path before it's resolved in the
+	// layer, including the paths of entries in directory listings, and the
+	// path is treated as nonexistent in the layer if it returns false. This
+	// is intended for use with feature-flag systems that decide visibility
+	// per request. Returning false for "." hides the layer's entire
+	// contents. It must be safe to call from multiple goroutines.
+	//
+	// The context is the one passed to OpenContext, or context.Background()
+	// for operations that don't take a context, so values attached to it
+	// (such as a tenant ID) can be used to resolve the same path differently
+	// for different requests.
+	//
+	// A MergedFS can't know when the results of this function change, so a
+	// MergedFS containing a Layer with a Visible function doesn't use its
+	// path or directory caches.
+	Visible func(ctx context.Context, path string) bool
+
+	// If positive, this enables a circuit breaker: after this many
+	// consecutive errors from FS, other than those indicating a path doesn't
+	// exist, the layer stops using FS for BreakerBackoff, and behaves as an
+	// empty FS in the meantime. Operations fail with an error wrapping a
+	// *LayerUnavailableError while the breaker is open, and BreakerState
+	// reports its state. Once the backoff period has passed, FS is tried
+	// again; a single further error reopens the breaker, and a success closes
+	// it. Note that paths in lower-priority layers may become visible while
+	// the breaker is open, since the layer no longer hides them.
+	BreakerThreshold int
+
+	// The period for which the circuit breaker stays open. Defaults to 30
+	// seconds if BreakerThreshold is set.
+	BreakerBackoff time.Duration
+
+	// Set this if calling Stat on the layer's files is expensive, e.g.
+	// because it requires a round trip to a remote server that Open doesn't.
+	// A MergedFS then avoids calling Stat on files from this layer when it
+	// has priority, unless the result is needed. See ExpensiveStatFS.
+	SlowStat bool
+
+	// Used to lazily initialize the fields below.
+	initOnce sync.Once
+	// Holds a token for each running operation, if MaxConcurrent is set.
+	slots chan struct{}
+	// The earliest time the next operation may start, if OpsPerSecond is
+	// set. Protected by rateMutex.
+	nextStart time.Time
+	rateMutex sync.Mutex
+	// Counts the bytes read from the layer.
+	meter *readMeter
+	// The directories implied by KnownPaths. Nil if KnownPaths is nil.
+	sparse *sparseIndex
+	// True if the layer's visibility is controlled by VisibleFrom,
+	// VisibleUntil, or Enabled.
+	gated bool
+	// The circuit breaker's state, protected by breakerMutex.
+	breakerMutex      sync.Mutex
+	consecutiveErrors int
+	retryAt           time.Time
+}
+
+func (l *Layer) init() {
+	l.initOnce.Do(func() {
+		if l.MaxConcurrent > 0 {
+			l.slots = make(chan struct{}, l.MaxConcurrent)
+		}
+		l.meter = &readMeter{
+			limit:     l.ReadQuota,
+			layerName: l.Name,
+		}
+		knownPaths := l.KnownPaths
+		if r, ok := l.FS.(*zip.Reader); ok && (knownPaths == nil) {
+			knownPaths = zipKnownPaths(r)
+		}
+		if knownPaths != nil {
+			l.sparse = newSparseIndex(knownPaths)
+		}
+		l.gated = !l.VisibleFrom.IsZero() || !l.VisibleUntil.IsZero() ||
+			(l.Enabled != nil)
+	})
+}
+
+// The key marking the context of a speculative operation, which gives up
+// rather than waiting for a Layer's limits. See MergedFS.SetPredictor.
+type speculativeKey struct{}
+
+// Returned by acquire for a speculative operation if the layer's limits don't
+// permit it to start immediately.
+var errLayerBusy = errors.New("the layer's limits don't permit another " +
+	"operation right now")
+
+// Waits until the layer's limits permit another operation to start. Returns a
+// function that must be called when the operation completes. Returns an error
+// without waiting further if ctx is canceled first, or without waiting at all
+// if ctx is for a speculative operation.
+func (l *Layer) acquire(ctx context.Context) (func(), error) {
+	l.init()
+	if ctx.Value(speculativeKey{}) != nil {
+		return l.tryAcquire()
+	}
+	if l.OpsPerSecond > 0 {
+		interval := time.Duration(float64(time.Second) / l.OpsPerSecond)
+		l.rateMutex.Lock()
+		now := time.Now()
+		start := l.nextStart
+		if start.Before(now) {
+			start = now
+		}
+		next := start.Add(interval)
+		l.nextStart = next
+		l.rateMutex.Unlock()
+		if delay := start.Sub(now); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				// Give up the reserved start time, unless a later operation
+				// has already reserved the one after it.
+				l.rateMutex.Lock()
+				if l.nextStart.Equal(next) {
+					l.nextStart = start
+				}
+				l.rateMutex.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+	}
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return func() { <-l.slots }, nil
+}
+
+// Like acquire, but returns errLayerBusy rather than waiting if the layer's
+// limits don't permit another operation to start immediately, so that
+// speculative operations never delay the ones they're predicting.
+func (l *Layer) tryAcquire() (func(), error) {
+	release := func() {}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			return nil, errLayerBusy
+		}
+		release = func() { <-l.slots }
+	}
+	if l.OpsPerSecond > 0 {
+		interval := time.Duration(float64(time.Second) / l.OpsPerSecond)
+		l.rateMutex.Lock()
+		now := time.Now()
+		if l.nextStart.After(now) {
+			l.rateMutex.Unlock()
+			release()
+			return nil, errLayerBusy
+		}
+		l.nextStart = now.Add(interval)
+		l.rateMutex.Unlock()
+	}
+	return release, nil
+}
+
+// Checks the layer's circuit breaker, then waits for its limits as acquire
+// does, for the given operation and path. Checking the breaker first means
+// callers fail fast rather than queueing for a slot while it's open. The
+// breaker is checked again once the limits allow the operation, in case it
+// opened during the wait, in which case the slot is released.
+func (l *Layer) acquireChecked(ctx context.Context, op, path string) (func(),
+	error) {
+	e := l.checkBreaker(op, path)
+	if e != nil {
+		return nil, e
+	}
+	release, e := l.acquire(ctx)
+	if e != nil {
+		return nil, limitError(op, path, e)
+	}
+	e = l.checkBreaker(op, path)
+	if e != nil {
+		release()
+		return nil, e
+	}
+	return release, nil
+}
+
+// Returns the path within FS corresponding to the given path within the layer.
+// Returns an error if either path or Root is invalid.
+func (l *Layer) fsPath(op, path string) (string, error) {
+	if (l.Root == "") || (l.Root == ".") {
+		return path, nil
+	}
+	if !fs.ValidPath(path) || !fs.ValidPath(l.Root) {
+		return "", &fs.PathError{Op: op, Path: path, Err: fs.ErrInvalid}
+	}
+	if path == "." {
+		return l.Root, nil
+	}
+	return l.Root + "/" + path, nil
+}
+
+// Removes Root from the path in e, if it's a *fs.PathError, so that errors
+// refer to paths within the layer rather than within FS.
+func (l *Layer) fixError(e error) error {
+	if (e == nil) || (l.Root == "") || (l.Root == ".") {
+		return e
+	}
+	pathError, ok := e.(*fs.PathError)
+	if !ok {
+		return e
+	}
+	fixed := *pathError
+	if fixed.Path == l.Root {
+		fixed.Path = "."
+	} else if strings.HasPrefix(fixed.Path, l.Root+"/") {
+		fixed.Path = fixed.Path[len(l.Root)+1:]
+	}
+	return &fixed
+}
+
+// Returns the error to report if waiting for the layer's limits failed.
+func limitError(op, path string, e error) error {
+	return &fs.PathError{Op: op, Path: path, Err: e}
+}
+
+func (l *Layer) Open(path string) (fs.File, error) {
+	return l.OpenContext(context.Background(), path)
+}
+
+// The same as Open, but waits for the layer's limits using ctx, and passes
+// ctx to the Visible function if there is one.
+func (l *Layer) OpenContext(ctx context.Context, path string) (fs.File,
+	error) {
+	release, e := l.acquireChecked(ctx, "open", path)
+	if e != nil {
+		return nil, e
+	}
+	defer release()
+	return l.openInternal(ctx, path)
+}
+
+// Opens the path without waiting for the layer's limits.
+func (l *Layer) openInternal(ctx context.Context, path string) (fs.File,
+	error) {
+	gateClosed := l.gateClosed()
+	if l.hidden(ctx, gateClosed, path) {
+		traceStep(ctx, "hidden", l.Name, "the layer's visibility settings "+
+			"hide the path")
+		return l.hiddenResult(path)
+	}
+	fullPath, e := l.fsPath("open", path)
+	if e != nil {
+		return nil, e
+	}
+	f, e := l.FS.Open(fullPath)
+	l.recordResult(e)
+	if e != nil {
+		if (l.sparse != nil) && isBadPathError(e) {
+			if d := l.sparse.directory(l.FS, fullPath); d != nil {
+				copyParentListingInfo(l.FS, fullPath, d)
+				if l.filtering(gateClosed) {
+					return l.filterDir(ctx, gateClosed, path, d)
+				}
+				return d, nil
+			}
+		}
+		return nil, l.fixError(e)
+	}
+	if l.sparse != nil {
+		f, e = l.sparse.completeDir(l.FS, fullPath, f)
+		if e != nil {
+			return nil, e
+		}
+	}
+	if l.filtering(gateClosed) {
+		f, e = l.filterDir(ctx, gateClosed, path, f)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return newMeteredFile(f, l.meter), nil
+}
+
+func (l *Layer) Stat(path string) (fs.FileInfo, error) {
+	release, e := l.acquireChecked(context.Background(), "stat", path)
+	if e != nil {
+		return nil, e
+	}
+	defer release()
+	if l.hidden(context.Background(), l.gateClosed(), path) {
+		f, e := l.hiddenResult(path)
+		if e != nil {
+			return nil, e
+		}
+		return f.Stat()
+	}
+	fullPath, e := l.fsPath("stat", path)
+	if e != nil {
+		return nil, e
+	}
+	info, e := fs.Stat(l.FS, fullPath)
+	l.recordResult(e)
+	if (e != nil) && (l.sparse != nil) && isBadPathError(e) {
+		if d := l.sparse.directory(l.FS, fullPath); d != nil {
+			copyParentListingInfo(l.FS, fullPath, d)
+			return d, nil
+		}
+	}
+	return info, l.fixError(e)
+}
+
+func (l *Layer) ReadFile(path string) ([]byte, error) {
+	release, e := l.acquireChecked(context.Background(), "readfile", path)
+	if e != nil {
+		return nil, e
+	}
+	defer release()
+	if l.hidden(context.Background(), l.gateClosed(), path) {
+		return nil, &fs.PathError{Op: "readfile", Path: path,
+			Err: fs.ErrNotExist}
+	}
+	fullPath, e := l.fsPath("readfile", path)
+	if e != nil {
+		return nil, e
+	}
+	data, e := fs.ReadFile(l.FS, fullPath)
+	l.recordResult(e)
+	return l.meter.readAll(data, l.fixError(e))
+}
+
+func (l *Layer) ReadDir(path string) ([]fs.DirEntry, error) {
+	release, e := l.acquireChecked(context.Background(), "readdir", path)
+	if e != nil {
+		return nil, e
+	}
+	defer release()
+	return l.readDirInternal(context.Background(), path)
+}
+
+// Reads the directory without waiting for the layer's limits.
+func (l *Layer) readDirInternal(ctx context.Context, path string) (
+	[]fs.DirEntry, error) {
+	gateClosed := l.gateClosed()
+	if l.hidden(ctx, gateClosed, path) {
+		if path == "." {
+			return nil, nil
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: path,
+			Err: fs.ErrNotExist}
+	}
+	fullPath, e := l.fsPath("readdir", path)
+	if e != nil {
+		return nil, e
+	}
+	entries, e := fs.ReadDir(l.FS, fullPath)
+	l.recordResult(e)
+	if l.sparse != nil {
+		if e == nil {
+			entries = l.sparse.complete(l.FS, fullPath, entries)
+		} else if isBadPathError(e) {
+			if d := l.sparse.directory(l.FS, fullPath); d != nil {
+				entries, e = d.entries, nil
+			}
+		}
+	}
+	e = l.fixError(e)
+	if (e == nil) && l.filtering(gateClosed) {
+		entries = l.filterEntries(ctx, gateClosed, path, entries)
+	}
+	return entries, e
+}
+
+func (l *Layer) Glob(pattern string) ([]string, error) {
+	if l.breakerOpen() {
+		// The layer behaves as an empty FS while its breaker is open.
+		return nil, nil
+	}
+	release, e := l.acquire(context.Background())
+	if e != nil {
+		return nil, e
+	}
+	defer release()
+	if l.breakerOpen() {
+		return nil, nil
+	}
+	if (l.sparse != nil) || l.gated || (l.Visible != nil) {
+		return fs.Glob(layerGlobFS{l}, pattern)
+	}
+	if strings.ContainsAny(l.Root, "*?[\\") {
+		// Root can't be used as part of a pattern.
+		return fs.Glob(layerGlobFS{l}, pattern)
+	}
+	// Check the pattern first, so that a malformed pattern isn't recorded as
+	// a failure of the layer.
+	if _, e := path.Match(pattern, ""); e != nil {
+		return nil, e
+	}
+	if (l.Root == "") || (l.Root == ".") {
+		matches, e := fs.Glob(l.FS, pattern)
+		l.recordResult(e)
+		return matches, e
+	}
+	matches, e := fs.Glob(l.FS, l.Root+"/"+pattern)
+	l.recordResult(e)
+	for i := range matches {
+		matches[i] = matches[i][len(l.Root)+1:]
+	}
+	return matches, e
+}
+
+// Returns the filesystems making up m, in priority order: the first FS in the
+// returned slice takes priority over all others. Any MergedFS nested within m
+// (such as those created by MergeMultiple) is expanded into its own layers, so
+// for a MergedFS created using MergeMultiple, this returns the FSs that were
+// passed to it, in the same order. Functions taking a layer index refer to
+// the FS at that index in this slice.
+func (m *MergedFS) Layers() []fs.FS {
+	var toReturn []fs.FS
+	for side := 0; side < 2; side++ {
+		fsys := m.layer(side)
+		if nested, ok := fsys.(*MergedFS); ok {
+			toReturn = append(toReturn, nested.Layers()...)
+		} else if group, ok := fsys.(*Group); ok {
+			toReturn = append(toReturn, group.Layers()...)
+		} else {
+			toReturn = append(toReturn, fsys)
+		}
+	}
+	return toReturn
+}
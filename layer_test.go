@@ -0,0 +1,192 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Wraps an FS, tracking the maximum number of Open calls that were running at
+// the same time.
+type concurrencyTrackingFS struct {
+	fs.FS
+	mutex   sync.Mutex
+	running int
+	maxSeen int
+}
+
+func (f *concurrencyTrackingFS) Open(path string) (fs.File, error) {
+	f.mutex.Lock()
+	f.running++
+	if f.running > f.maxSeen {
+		f.maxSeen = f.running
+	}
+	f.mutex.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	f.mutex.Lock()
+	f.running--
+	f.mutex.Unlock()
+	return f.FS.Open(path)
+}
+
+func TestLayerMaxConcurrent(t *testing.T) {
+	tracker := &concurrencyTrackingFS{FS: fstest.MapFS{
+		"b.txt": newMapFile("in B"),
+	}}
+	fsA := fstest.MapFS{"a.txt": newMapFile("in A")}
+	merged := NewMergedFS(fsA, &Layer{FS: tracker, MaxConcurrent: 2})
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, e := merged.Open("b.txt")
+			if e != nil {
+				t.Logf("Failed opening b.txt: %s\n", e)
+				t.Fail()
+				return
+			}
+			f.Close()
+		}()
+	}
+	wg.Wait()
+	if tracker.maxSeen > 2 {
+		t.Logf("Expected at most 2 concurrent opens, saw %d.\n",
+			tracker.maxSeen)
+		t.FailNow()
+	}
+	t.Logf("Saw at most %d concurrent opens.\n", tracker.maxSeen)
+}
+
+func TestLayerOpsPerSecond(t *testing.T) {
+	fsA := fstest.MapFS{"a.txt": newMapFile("in A")}
+	layer := &Layer{FS: fsA, Name: "limited", OpsPerSecond: 200}
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		f, e := layer.Open("a.txt")
+		if e != nil {
+			t.Logf("Failed opening a.txt: %s\n", e)
+			t.FailNow()
+		}
+		f.Close()
+	}
+	// The first operation starts immediately, the remaining 10 are spaced 5ms
+	// apart.
+	elapsed := time.Since(start)
+	if elapsed < 45*time.Millisecond {
+		t.Logf("11 operations at 200/s only took %s.\n", elapsed)
+		t.FailNow()
+	}
+	e := fstest.TestFS(NewMergedFS(layer, fstest.MapFS{}), "a.txt")
+	if e != nil {
+		t.Logf("TestFS failed for a rate-limited layer: %s\n", e)
+		t.FailNow()
+	}
+}
+
+func TestLayerOpsPerSecondCanceled(t *testing.T) {
+	fsA := fstest.MapFS{"a.txt": newMapFile("in A")}
+	layer := &Layer{FS: fsA, Name: "limited", OpsPerSecond: 10}
+	start := time.Now()
+	f, e := layer.Open("a.txt")
+	if e != nil {
+		t.Logf("Failed opening a.txt: %s\n", e)
+		t.FailNow()
+	}
+	f.Close()
+	// Canceling an operation waiting for its turn must give the turn back.
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	_, e = layer.OpenContext(ctx, "a.txt")
+	if !errors.Is(e, context.DeadlineExceeded) {
+		t.Logf("Didn't get expected error for a canceled open: %v\n", e)
+		t.FailNow()
+	}
+	f, e = layer.Open("a.txt")
+	if e != nil {
+		t.Logf("Failed opening a.txt: %s\n", e)
+		t.FailNow()
+	}
+	f.Close()
+	// The second operation should start 100ms after the first, rather than
+	// waiting for the canceled one's turn as well.
+	elapsed := time.Since(start)
+	if elapsed > 180*time.Millisecond {
+		t.Logf("The canceled operation's turn wasn't released: took %s\n",
+			elapsed)
+		t.FailNow()
+	}
+}
+
+// Wraps a MapFS, counting calls to its ReadFile and Glob methods.
+type fastPathCountingFS struct {
+	fstest.MapFS
+	readFiles int
+	globs     int
+}
+
+func (f *fastPathCountingFS) ReadFile(path string) ([]byte, error) {
+	f.readFiles++
+	return f.MapFS.ReadFile(path)
+}
+
+func (f *fastPathCountingFS) Glob(pattern string) ([]string, error) {
+	f.globs++
+	return f.MapFS.Glob(pattern)
+}
+
+func TestLayerRoot(t *testing.T) {
+	fsA := &fastPathCountingFS{MapFS: fstest.MapFS{
+		"payload/assets/a.txt":     newMapFile("a"),
+		"payload/assets/sub/b.txt": newMapFile("b"),
+		"payload/other.txt":        newMapFile("other"),
+	}}
+	layer := &Layer{FS: fsA, Root: "payload/assets"}
+	e := fstest.TestFS(layer, "a.txt", "sub/b.txt")
+	if e != nil {
+		t.Logf("Layer with a root failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	fsA.readFiles = 0
+	fsA.globs = 0
+	merged := NewMergedFS(layer, fstest.MapFS{"c.txt": newMapFile("c")})
+	content, e := fs.ReadFile(merged, "sub/b.txt")
+	if e != nil {
+		t.Logf("Failed reading sub/b.txt: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "b" {
+		t.Logf("Got wrong content for sub/b.txt: %s\n", content)
+		t.FailNow()
+	}
+	matches, e := fs.Glob(layer, "*.txt")
+	if e != nil {
+		t.Logf("Glob failed: %s\n", e)
+		t.FailNow()
+	}
+	if (len(matches) != 1) || (matches[0] != "a.txt") {
+		t.Logf("Got wrong glob matches: %v\n", matches)
+		t.FailNow()
+	}
+	if (fsA.readFiles != 1) || (fsA.globs != 1) {
+		t.Logf("FS's ReadFile and Glob were called %d and %d times, "+
+			"expected once each\n", fsA.readFiles, fsA.globs)
+		t.FailNow()
+	}
+	_, e = merged.Open("other.txt")
+	if e == nil {
+		t.Logf("Didn't get expected error opening a path outside root.\n")
+		t.FailNow()
+	}
+	var pathError *fs.PathError
+	_, e = layer.Stat("missing.txt")
+	if !errors.As(e, &pathError) || (pathError.Path != "missing.txt") {
+		t.Logf("Got wrong error for a missing path: %v\n", e)
+		t.FailNow()
+	}
+}
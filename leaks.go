@@ -0,0 +1,170 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Describes a file that was opened using a MergedFS with open-file tracking
+// enabled, and hasn't yet been closed.
+type OpenFileRecord struct {
+	// The path that was opened.
+	Path string
+	// The time at which the file was opened.
+	Opened time.Time
+	// The stack trace of the goroutine that opened the file.
+	Stack []byte
+}
+
+// Keeps track of files that are currently open. Safe for concurrent use.
+type openFileTracker struct {
+	mutex   sync.Mutex
+	nextID  uint64
+	records map[uint64]*OpenFileRecord
+	// If non-nil, this is called if a file is garbage collected without being
+	// closed.
+	leakHandler func(OpenFileRecord)
+}
+
+// Wraps a File, removing it from an openFileTracker when it's closed.
+type trackedFile struct {
+	fs.File
+	tracker *openFileTracker
+	id      uint64
+	// Used to make Close idempotent with respect to the tracker.
+	closeOnce sync.Once
+}
+
+func (f *trackedFile) Unwrap() fs.File {
+	return f.File
+}
+
+func (f *trackedFile) Close() error {
+	f.closeOnce.Do(func() {
+		f.tracker.remove(f.id)
+		runtime.SetFinalizer(f, nil)
+	})
+	return f.File.Close()
+}
+
+func (t *openFileTracker) remove(id uint64) *OpenFileRecord {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	record := t.records[id]
+	delete(t.records, id)
+	return record
+}
+
+// Called when a trackedFile is garbage collected without being closed.
+func leakedFileFinalizer(f *trackedFile) {
+	record := f.tracker.remove(f.id)
+	if (record == nil) || (f.tracker.leakHandler == nil) {
+		return
+	}
+	f.tracker.leakHandler(*record)
+}
+
+// Records that f was opened at the given path, and returns a wrapper around
+// it that must be used in place of f.
+func (t *openFileTracker) track(f fs.File, path string) fs.File {
+	record := &OpenFileRecord{
+		Path:   path,
+		Opened: time.Now(),
+		Stack:  debug.Stack(),
+	}
+	t.mutex.Lock()
+	id := t.nextID
+	t.nextID++
+	t.records[id] = record
+	t.mutex.Unlock()
+	tracked := &trackedFile{
+		File:    f,
+		tracker: t,
+		id:      id,
+	}
+	if t.leakHandler != nil {
+		runtime.SetFinalizer(tracked, leakedFileFinalizer)
+	}
+	dir, _ := f.(dirReader)
+	seeker, _ := f.(io.Seeker)
+	readerAt, _ := f.(io.ReaderAt)
+	mapped, _ := f.(mapper)
+	writerTo, _ := f.(io.WriterTo)
+	return addFileInterfaces(tracked, dir, seeker, readerAt, mapped,
+		writerTo)
+}
+
+// Enables or disables tracking of open files, which is intended to help debug
+// file-handle leaks. When enabled, m records the path and stack trace for
+// every file opened using m.Open (including files opened by m.ReadFile), until
+// the file is closed. Use OpenFiles or DumpOpenFiles to see which files are
+// still open. Enabling tracking, even if it was already enabled, discards any
+// existing records.
+//
+// If leakHandler is non-nil, it will be called with the record for any file
+// that is garbage collected without being closed. It's called from the
+// garbage collector's finalizer goroutine, so it must not block for long.
+//
+// Tracking is expensive, as it requires capturing a stack trace on every
+// Open. It's disabled by default.
+func (m *MergedFS) TrackOpenFiles(enabled bool,
+	leakHandler func(OpenFileRecord)) {
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+	if !enabled {
+		m.openFiles = nil
+		return
+	}
+	m.openFiles = &openFileTracker{
+		records:     make(map[uint64]*OpenFileRecord),
+		leakHandler: leakHandler,
+	}
+}
+
+// Returns records for all files opened using m that haven't been closed, in
+// the order they were opened. Returns nil if open-file tracking isn't
+// enabled.
+func (m *MergedFS) OpenFiles() []OpenFileRecord {
+	m.configMutex.RLock()
+	tracker := m.openFiles
+	m.configMutex.RUnlock()
+	if tracker == nil {
+		return nil
+	}
+	tracker.mutex.Lock()
+	ids := make([]uint64, 0, len(tracker.records))
+	for id := range tracker.records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	toReturn := make([]OpenFileRecord, len(ids))
+	for i, id := range ids {
+		toReturn[i] = *(tracker.records[id])
+	}
+	tracker.mutex.Unlock()
+	return toReturn
+}
+
+// Writes a human-readable description of each file returned by OpenFiles,
+// including the stack trace where it was opened, to w.
+func (m *MergedFS) DumpOpenFiles(w io.Writer) error {
+	records := m.OpenFiles()
+	_, e := fmt.Fprintf(w, "%d open files\n", len(records))
+	if e != nil {
+		return e
+	}
+	for _, r := range records {
+		_, e = fmt.Fprintf(w, "\n%s, opened at %s:\n%s", r.Path,
+			r.Opened.Format(time.RFC3339Nano), r.Stack)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package merged_fs
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestTrackOpenFiles(t *testing.T) {
+	fsA := fstest.MapFS{"a.txt": newMapFile("in A")}
+	fsB := fstest.MapFS{"dir/b.txt": newMapFile("in B")}
+	merged := NewMergedFS(fsA, fsB)
+	merged.TrackOpenFiles(true, nil)
+	e := fstest.TestFS(merged, "a.txt", "dir/b.txt")
+	if e != nil {
+		t.Logf("TestFS failed with open-file tracking: %s\n", e)
+		t.FailNow()
+	}
+	if len(merged.OpenFiles()) != 0 {
+		t.Logf("TestFS leaked files? Got %d open files.\n",
+			len(merged.OpenFiles()))
+		t.FailNow()
+	}
+
+	f, e := merged.Open("dir/b.txt")
+	if e != nil {
+		t.Logf("Failed opening dir/b.txt: %s\n", e)
+		t.FailNow()
+	}
+	records := merged.OpenFiles()
+	if (len(records) != 1) || (records[0].Path != "dir/b.txt") {
+		t.Logf("Got incorrect open file records: %v\n", records)
+		t.FailNow()
+	}
+	output := &bytes.Buffer{}
+	e = merged.DumpOpenFiles(output)
+	if e != nil {
+		t.Logf("Failed dumping open files: %s\n", e)
+		t.FailNow()
+	}
+	if !strings.Contains(output.String(), "TestTrackOpenFiles") {
+		t.Logf("Dumped open files didn't include the stack trace: %s\n",
+			output.String())
+		t.FailNow()
+	}
+	f.Close()
+	f.Close()
+	if len(merged.OpenFiles()) != 0 {
+		t.Logf("File was still tracked after closing it.\n")
+		t.FailNow()
+	}
+}
+
+func TestLeakHandler(t *testing.T) {
+	fsA := fstest.MapFS{"a.txt": newMapFile("in A")}
+	merged := NewMergedFS(fsA, fstest.MapFS{})
+	leaked := make(chan OpenFileRecord, 1)
+	merged.TrackOpenFiles(true, func(r OpenFileRecord) {
+		leaked <- r
+	})
+	func() {
+		_, e := merged.Open("a.txt")
+		if e != nil {
+			t.Logf("Failed opening a.txt: %s\n", e)
+			t.FailNow()
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		select {
+		case r := <-leaked:
+			t.Logf("Got expected leak report for %s\n", r.Path)
+			if len(merged.OpenFiles()) != 0 {
+				t.Logf("Leaked file is still reported as open.\n")
+				t.FailNow()
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Logf("Didn't get a leak report for an unclosed file.\n")
+	t.FailNow()
+}
@@ -0,0 +1,297 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrMergeLimitExceeded is wrapped by every *MergeLimitError, so callers can
+// check for them using errors.Is.
+var ErrMergeLimitExceeded = errors.New("merge limit exceeded")
+
+// Returned when merging a directory would exceed a limit set using
+// MergedFS.SetMergeLimits.
+type MergeLimitError struct {
+	// The directory being merged.
+	Path string
+	// Which limit was exceeded: "entries" or "work".
+	Kind string
+	// The limit that was exceeded.
+	Limit int64
+}
+
+func (e *MergeLimitError) Error() string {
+	return fmt.Sprintf("%s: merging %s needs more than %d %s",
+		ErrMergeLimitExceeded, e.Path, e.Limit, e.Kind)
+}
+
+func (e *MergeLimitError) Unwrap() error {
+	return ErrMergeLimitExceeded
+}
+
+// Tracks the merge work remaining for a single Open.
+type mergeBudget struct {
+	// Only access this atomically.
+	remaining int64
+	limit     int64
+}
+
+// The key for a *mergeBudget stored in a context.
+type mergeBudgetKey struct{}
+
+// Sets limits that protect m from pathological layers, such as a hostile zip
+// archive with millions of entries in one directory. If maxEntries is
+// positive, merging a directory fails with a *MergeLimitError if either
+// layer's copy of the directory, or the merged result, has more than
+// maxEntries entries. If maxWork is positive, an Open call fails with a
+// *MergeLimitError if it requires reading more than maxWork directory
+// entries in total, across all of the directories it merges, including those
+// merged by any nested MergedFS.
+//
+// Layers' directories are read in small batches when either limit is set, so
+// that a limit is detected before an oversized directory is read in full.
+// Limits only apply to directories that m reads in order to merge them; a
+// directory present in only one layer is returned without being read. Like
+// UsePanicRecovery, this applies the setting to any MergedFS directly nested
+// within m. Zero or negative values disable the respective limits, which is
+// the default.
+func (m *MergedFS) SetMergeLimits(maxEntries, maxWork int64) {
+	atomic.StoreInt64(&m.maxDirEntries, maxEntries)
+	atomic.StoreInt64(&m.maxMergeWork, maxWork)
+	for side := 0; side < 2; side++ {
+		nested, ok := m.layer(side).(*MergedFS)
+		if ok {
+			nested.SetMergeLimits(maxEntries, maxWork)
+		}
+	}
+}
+
+// Returns ctx with a new merge budget for an Open, if m has a work limit and
+// ctx doesn't already carry a budget from an enclosing MergedFS.
+func (m *MergedFS) withMergeBudget(ctx context.Context) context.Context {
+	limit := atomic.LoadInt64(&m.maxMergeWork)
+	if limit <= 0 {
+		return ctx
+	}
+	if ctx.Value(mergeBudgetKey{}) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, mergeBudgetKey{}, &mergeBudget{
+		remaining: limit,
+		limit:     limit,
+	})
+}
+
+// Reads all entries from dir in batches, enforcing m's merge limits.
+func (m *MergedFS) readDirLimited(ctx context.Context, dir fs.ReadDirFile,
+	path string) ([]fs.DirEntry, error) {
+	maxEntries := atomic.LoadInt64(&m.maxDirEntries)
+	budget, _ := ctx.Value(mergeBudgetKey{}).(*mergeBudget)
+	if (maxEntries <= 0) && (budget == nil) {
+		return dir.ReadDir(-1)
+	}
+	var entries []fs.DirEntry
+	for {
+		batch, e := dir.ReadDir(256)
+		entries = append(entries, batch...)
+		if (maxEntries > 0) && (int64(len(entries)) > maxEntries) {
+			return nil, &MergeLimitError{
+				Path:  path,
+				Kind:  "entries",
+				Limit: maxEntries,
+			}
+		}
+		if budget != nil {
+			remaining := atomic.AddInt64(&budget.remaining, -int64(len(batch)))
+			if remaining < 0 {
+				return nil, &MergeLimitError{
+					Path:  path,
+					Kind:  "work",
+					Limit: budget.limit,
+				}
+			}
+		}
+		if e == io.EOF {
+			return entries, nil
+		}
+		if e != nil {
+			return nil, e
+		}
+		if len(batch) == 0 {
+			// Shouldn't happen, but we don't want to loop forever.
+			return entries, nil
+		}
+	}
+}
+
+// Returns an error if the merged entries of the directory at path exceed m's
+// limit on directory size.
+func (m *MergedFS) checkMergedSize(path string, entries []fs.DirEntry) error {
+	maxEntries := atomic.LoadInt64(&m.maxDirEntries)
+	if (maxEntries > 0) && (int64(len(entries)) > maxEntries) {
+		return &MergeLimitError{
+			Path:  path,
+			Kind:  "entries",
+			Limit: maxEntries,
+		}
+	}
+	return nil
+}
+
+// Wrapped in an *fs.PathError when a path exceeds a limit set using
+// MergedFS.SetDepthLimits. Unwraps to fs.ErrInvalid.
+type DepthLimitError struct {
+	// Which limit was exceeded: "path" or "nesting".
+	Kind string
+	// The limit that was exceeded.
+	Limit int64
+}
+
+func (e *DepthLimitError) Error() string {
+	if e.Kind == "nesting" {
+		return fmt.Sprintf("%s: more than %d nested merges", fs.ErrInvalid,
+			e.Limit)
+	}
+	return fmt.Sprintf("%s: path has more than %d components", fs.ErrInvalid,
+		e.Limit)
+}
+
+func (e *DepthLimitError) Unwrap() error {
+	return fs.ErrInvalid
+}
+
+// The key for the number of MergedFS opens enclosing the current one, stored
+// in a context as an int64.
+type nestingDepthKey struct{}
+
+// Sets limits on the depth of paths that m will open, and on the number of
+// nested MergedFS layers an Open may pass through. If maxPathDepth is
+// positive, opening a path with more than maxPathDepth components fails with
+// an error wrapping fs.ErrInvalid, before any layer is accessed. This protects
+// against untrusted paths, since opening a path in a MergedFS requires
+// checking every one of its prefixes in the higher-priority layer. If
+// maxNesting is positive, an Open that passes through more than maxNesting
+// MergedFS instances, including m itself, fails in the same way. Like
+// UsePanicRecovery, this applies the setting to any MergedFS directly nested
+// within m. Zero or negative values disable the respective limits, which is
+// the default.
+//
+// Errors from exceeding these limits wrap a *DepthLimitError. Unlike other
+// errors wrapping fs.ErrInvalid, they aren't treated as a path being absent
+// from a layer, so a nested MergedFS exceeding a limit causes the entire Open
+// to fail.
+func (m *MergedFS) SetDepthLimits(maxPathDepth, maxNesting int64) {
+	atomic.StoreInt64(&m.maxPathDepth, maxPathDepth)
+	atomic.StoreInt64(&m.maxNestingDepth, maxNesting)
+	for side := 0; side < 2; side++ {
+		nested, ok := m.layer(side).(*MergedFS)
+		if ok {
+			nested.SetDepthLimits(maxPathDepth, maxNesting)
+		}
+	}
+}
+
+// Returns the number of components in the given valid path.
+func pathDepth(path string) int64 {
+	if path == "." {
+		return 0
+	}
+	return int64(strings.Count(path, "/") + 1)
+}
+
+// Returns an error if the given valid path exceeds m's path depth limit.
+func (m *MergedFS) checkPathDepth(op, path string) error {
+	limit := atomic.LoadInt64(&m.maxPathDepth)
+	if (limit > 0) && (pathDepth(path) > limit) {
+		return &fs.PathError{
+			Op:   op,
+			Path: path,
+			Err:  &DepthLimitError{Kind: "path", Limit: limit},
+		}
+	}
+	return nil
+}
+
+// Returns ctx updated to count one more level of nesting, or an error if this
+// exceeds m's nesting limit. Returns ctx unchanged if m has no nesting limit.
+func (m *MergedFS) enterNested(ctx context.Context, path string) (
+	context.Context, error) {
+	limit := atomic.LoadInt64(&m.maxNestingDepth)
+	if limit <= 0 {
+		return ctx, nil
+	}
+	depth, _ := ctx.Value(nestingDepthKey{}).(int64)
+	depth++
+	if depth > limit {
+		return nil, &fs.PathError{
+			Op:   "open",
+			Path: path,
+			Err:  &DepthLimitError{Kind: "nesting", Limit: limit},
+		}
+	}
+	return context.WithValue(ctx, nestingDepthKey{}, depth), nil
+}
+
+// Returned by CheckViewLimits when the merged view exceeds a limit. Wraps
+// ErrMergeLimitExceeded.
+type ViewLimitError struct {
+	// Which limit was exceeded: "files" or "bytes".
+	Kind string
+	// The limit that was exceeded.
+	Limit int64
+	// The path at which the limit was found to be exceeded.
+	Path string
+}
+
+func (e *ViewLimitError) Error() string {
+	return fmt.Sprintf("%s: the merged view has more than %d %s (found at "+
+		"%s)", ErrMergeLimitExceeded, e.Limit, e.Kind, e.Path)
+}
+
+func (e *ViewLimitError) Unwrap() error {
+	return ErrMergeLimitExceeded
+}
+
+// Walks m's merged view, returning a *ViewLimitError as soon as it finds more
+// than maxFiles files and directories (not counting the root), or regular
+// files with a total size of more than maxBytes. Zero or negative values
+// disable the respective limits. This is intended as a guardrail before
+// serving layers from untrusted sources, such as user-provided archives: the
+// walk stops as soon as a limit is exceeded, so it's never more expensive
+// than the limits allow, particularly if combined with SetMergeLimits to
+// bound the cost of merging each directory. Any other error from walking m is
+// returned as-is.
+func (m *MergedFS) CheckViewLimits(maxFiles, maxBytes int64) error {
+	var files, size int64
+	return fs.WalkDir(m, ".", func(p string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if p == "." {
+			return nil
+		}
+		files++
+		if (maxFiles > 0) && (files > maxFiles) {
+			return &ViewLimitError{Kind: "files", Limit: maxFiles, Path: p}
+		}
+		if (maxBytes <= 0) || !d.Type().IsRegular() {
+			return nil
+		}
+		info, e := d.Info()
+		if e != nil {
+			return e
+		}
+		if info.Size() > 0 {
+			size += info.Size()
+		}
+		if size > maxBytes {
+			return &ViewLimitError{Kind: "bytes", Limit: maxBytes, Path: p}
+		}
+		return nil
+	})
+}
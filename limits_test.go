@@ -0,0 +1,190 @@
+package merged_fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMergeLimits(t *testing.T) {
+	fsA := fstest.MapFS{
+		"small/a.txt": newMapFile("a"),
+	}
+	fsB := fstest.MapFS{
+		"small/b.txt": newMapFile("b"),
+	}
+	for i := 0; i < 1000; i++ {
+		fsA[fmt.Sprintf("big/a%04d.txt", i)] = newMapFile("a")
+		fsB[fmt.Sprintf("big/b%04d.txt", i)] = newMapFile("b")
+	}
+	merged := NewMergedFS(fsA, fsB)
+	merged.SetMergeLimits(1500, 0)
+	entries, e := fs.ReadDir(merged, "small")
+	if e != nil {
+		t.Logf("Failed reading small dir: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 2 {
+		t.Logf("Expected 2 entries in small dir, got %d\n", len(entries))
+		t.FailNow()
+	}
+	_, e = fs.ReadDir(merged, "big")
+	var limitError *MergeLimitError
+	if !errors.As(e, &limitError) || !errors.Is(e, ErrMergeLimitExceeded) {
+		t.Logf("Didn't get expected merge limit error: %v\n", e)
+		t.FailNow()
+	}
+	if (limitError.Kind != "entries") || (limitError.Path != "big") {
+		t.Logf("Got incorrect limit error: %s\n", limitError)
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+
+	// Each layer's directory is within the work limit, but both combined
+	// aren't.
+	merged.SetMergeLimits(0, 1500)
+	_, e = fs.ReadDir(merged, "big")
+	if !errors.As(e, &limitError) || (limitError.Kind != "work") {
+		t.Logf("Didn't get expected work limit error: %v\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+
+	// The work budget is per Open, so it must not carry over.
+	_, e = fs.ReadDir(merged, "small")
+	if e != nil {
+		t.Logf("Failed reading small dir with a work limit: %s\n", e)
+		t.FailNow()
+	}
+	merged.SetMergeLimits(0, 0)
+	entries, e = fs.ReadDir(merged, "big")
+	if e != nil {
+		t.Logf("Failed reading big dir without limits: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 2000 {
+		t.Logf("Expected 2000 entries, got %d\n", len(entries))
+		t.FailNow()
+	}
+}
+
+func TestMergeLimitsNested(t *testing.T) {
+	layers := make([]fs.FS, 4)
+	for i := range layers {
+		m := fstest.MapFS{}
+		for j := 0; j < 100; j++ {
+			m[fmt.Sprintf("dir/%d_%03d.txt", i, j)] = newMapFile("x")
+		}
+		layers[i] = m
+	}
+	merged := MergeMultiple(layers...).(*MergedFS)
+	// No individual merge reads more than 200 entries, but the nested merges
+	// share a single budget for the Open.
+	merged.SetMergeLimits(0, 300)
+	_, e := fs.ReadDir(merged, "dir")
+	if !errors.Is(e, ErrMergeLimitExceeded) {
+		t.Logf("Didn't get expected error for nested merges: %v\n", e)
+		t.FailNow()
+	}
+	merged.SetMergeLimits(0, 800)
+	entries, e := fs.ReadDir(merged, "dir")
+	if e != nil {
+		t.Logf("Failed reading dir within the limit: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 400 {
+		t.Logf("Expected 400 entries, got %d\n", len(entries))
+		t.FailNow()
+	}
+}
+
+func TestDepthLimits(t *testing.T) {
+	deepPath := generateDeepDir(1, 20) + "test.txt"
+	fsA := fstest.MapFS{
+		deepPath:    newMapFile("deep"),
+		"a/b/c.txt": newMapFile("shallow"),
+	}
+	fsB := fstest.MapFS{
+		"b.txt": newMapFile("b"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	merged.SetDepthLimits(10, 0)
+	data, e := merged.ReadFile("a/b/c.txt")
+	if (e != nil) || (string(data) != "shallow") {
+		t.Logf("Failed reading shallow file: %q, %v\n", data, e)
+		t.FailNow()
+	}
+	_, e = merged.Open(deepPath)
+	if !errors.Is(e, fs.ErrInvalid) {
+		t.Logf("Didn't get expected error opening deep path: %v\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+	_, e = merged.ReadFile(deepPath)
+	if !errors.Is(e, fs.ErrInvalid) {
+		t.Logf("Didn't get expected error reading deep path: %v\n", e)
+		t.FailNow()
+	}
+
+	// Three merges deep, counting the outermost one.
+	nested := NewMergedFS(NewMergedFS(NewMergedFS(fsA, fsB), fstest.MapFS{}),
+		fstest.MapFS{})
+	nested.SetDepthLimits(0, 2)
+	_, e = nested.Open("b.txt")
+	if !errors.Is(e, fs.ErrInvalid) {
+		t.Logf("Didn't get expected error for nested merges: %v\n", e)
+		t.FailNow()
+	}
+	_, e = nested.ReadFile("b.txt")
+	if !errors.Is(e, fs.ErrInvalid) {
+		t.Logf("Didn't get expected error from ReadFile: %v\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+	nested.SetDepthLimits(0, 3)
+	data, e = nested.ReadFile("b.txt")
+	if (e != nil) || (string(data) != "b") {
+		t.Logf("Failed reading b.txt within the nesting limit: %q, %v\n",
+			data, e)
+		t.FailNow()
+	}
+}
+
+func TestViewLimits(t *testing.T) {
+	fsA := fstest.MapFS{
+		"a.txt":     &fstest.MapFile{Data: []byte("aaaa")},
+		"dir/b.txt": &fstest.MapFile{Data: []byte("bbbb")},
+	}
+	fsB := fstest.MapFS{
+		"a.txt":     &fstest.MapFile{Data: []byte("hidden by A")},
+		"dir/c.txt": &fstest.MapFile{Data: []byte("cccc")},
+	}
+	merged := NewMergedFS(fsA, fsB)
+	// There are 4 entries: a.txt, dir, dir/b.txt, and dir/c.txt.
+	e := merged.CheckViewLimits(4, 12)
+	if e != nil {
+		t.Logf("Got unexpected error within limits: %s\n", e)
+		t.FailNow()
+	}
+	e = merged.CheckViewLimits(0, 0)
+	if e != nil {
+		t.Logf("Got unexpected error without limits: %s\n", e)
+		t.FailNow()
+	}
+	e = merged.CheckViewLimits(3, 0)
+	var limitError *ViewLimitError
+	if !errors.As(e, &limitError) || (limitError.Kind != "files") ||
+		!errors.Is(e, ErrMergeLimitExceeded) {
+		t.Logf("Expected a file limit error, got %v\n", e)
+		t.FailNow()
+	}
+	e = merged.CheckViewLimits(0, 11)
+	if !errors.As(e, &limitError) || (limitError.Kind != "bytes") ||
+		(limitError.Path != "dir/c.txt") {
+		t.Logf("Expected a byte limit error at dir/c.txt, got %v\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+}
@@ -0,0 +1,91 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// Returns an FS that wraps fsys, converting the line endings in text files
+// to lineEnding as they're read, which must be either "\n" or "\r\n". This
+// lets layers checked out or packaged on different platforms be merged
+// without the merged files' line endings depending on which layer they came
+// from. Since it's a wrapper, it can be applied to only the layers that need
+// it, and each layer can be converted to a different line ending.
+//
+// If any patterns are given, only regular files matching at least one of them
+// are converted, e.g. "**/*.html" or "templates/**"; otherwise every regular
+// file is converted, which will corrupt any binary files. Patterns use the
+// same syntax as AddPriorityOverride. Returns an error if lineEnding isn't
+// one of the supported values or any pattern is malformed.
+//
+// When converting to "\n", each "\r\n" is replaced with "\n". When converting
+// to "\r\n", each "\n" that isn't already preceded by "\r" is replaced with
+// "\r\n". A "\r" that isn't followed by "\n" is left alone in both cases.
+//
+// The sizes reported for converted files are exact, and converted files can
+// be seeked, with the same costs as the files returned by Transcode.
+func NormalizeLineEndings(fsys fs.FS, lineEnding string,
+	patterns ...string) (fs.FS, error) {
+	var decoder Decoder
+	var detail string
+	switch lineEnding {
+	case "\n":
+		decoder = func(r io.Reader) io.Reader {
+			return &decodingReader{r: r, decode: crlfToLF()}
+		}
+		detail = "to LF"
+	case "\r\n":
+		decoder = func(r io.Reader) io.Reader {
+			return &decodingReader{r: r, decode: lfToCRLF()}
+		}
+		detail = "to CRLF"
+	default:
+		return nil, fmt.Errorf("Unsupported line ending %q", lineEnding)
+	}
+	return newTranscodeFS(fsys, "NormalizeLineEndings", detail, decoder,
+		patterns)
+}
+
+// Returns a decodeFunc replacing "\r\n" with "\n" in a single file.
+func crlfToLF() decodeFunc {
+	// Set if the last byte of the previous input was "\r", which hasn't been
+	// written yet.
+	pendingCR := false
+	return func(out, in []byte, eof bool) ([]byte, int) {
+		for _, b := range in {
+			if pendingCR {
+				pendingCR = false
+				if b != '\n' {
+					out = append(out, '\r')
+				}
+			}
+			if b == '\r' {
+				pendingCR = true
+				continue
+			}
+			out = append(out, b)
+		}
+		if eof && pendingCR {
+			pendingCR = false
+			out = append(out, '\r')
+		}
+		return out, len(in)
+	}
+}
+
+// Returns a decodeFunc replacing "\n" with "\r\n" in a single file, unless
+// it's already preceded by "\r".
+func lfToCRLF() decodeFunc {
+	var previous byte
+	return func(out, in []byte, eof bool) ([]byte, int) {
+		for _, b := range in {
+			if (b == '\n') && (previous != '\r') {
+				out = append(out, '\r')
+			}
+			out = append(out, b)
+			previous = b
+		}
+		return out, len(in)
+	}
+}
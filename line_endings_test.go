@@ -0,0 +1,136 @@
+package merged_fs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+)
+
+func TestNormalizeLineEndings(t *testing.T) {
+	windows := fstest.MapFS{
+		"templates/page.html": newMapFile("<p>\r\nhi\r\n</p>\r"),
+		"logo.bin":            newMapFile("\r\n"),
+	}
+	unix := fstest.MapFS{
+		"templates/footer.html": newMapFile("a\nb\r\nc\n"),
+	}
+	_, e := NormalizeLineEndings(windows, "\r")
+	if e == nil {
+		t.Logf("Didn't get expected error for an unsupported line ending\n")
+		t.FailNow()
+	}
+	toLF, e := NormalizeLineEndings(windows, "\n", "**/*.html")
+	if e != nil {
+		t.Logf("Failed normalizing line endings: %s\n", e)
+		t.FailNow()
+	}
+	toCRLF, e := NormalizeLineEndings(unix, "\r\n", "**/*.html")
+	if e != nil {
+		t.Logf("Failed normalizing line endings: %s\n", e)
+		t.FailNow()
+	}
+	merged := NewMergedFS(toLF, toCRLF)
+	e = fstest.TestFS(merged, "templates/page.html", "templates/footer.html",
+		"logo.bin")
+	if e != nil {
+		t.Logf("TestFS failed: %s\n", e)
+		t.FailNow()
+	}
+	expected := map[string]string{
+		"templates/page.html":   "<p>\nhi\n</p>\r",
+		"templates/footer.html": "a\r\nb\r\nc\r\n",
+		"logo.bin":              "\r\n",
+	}
+	for p, want := range expected {
+		f, e := merged.Open(p)
+		if e != nil {
+			t.Logf("Failed opening %s: %s\n", p, e)
+			t.FailNow()
+		}
+		content, e := io.ReadAll(f)
+		f.Close()
+		if e != nil {
+			t.Logf("Failed reading %s: %s\n", p, e)
+			t.FailNow()
+		}
+		if string(content) != want {
+			t.Logf("Expected %s to contain %q, got %q\n", p, want, content)
+			t.FailNow()
+		}
+		info, e := fs.Stat(merged, p)
+		if e != nil {
+			t.Logf("Failed getting info for %s: %s\n", p, e)
+			t.FailNow()
+		}
+		if info.Size() != int64(len(want)) {
+			t.Logf("Expected %s's size to be %d, got %d\n", p, len(want),
+				info.Size())
+			t.FailNow()
+		}
+	}
+}
+
+func TestLineEndingsAcrossReads(t *testing.T) {
+	// Reading a byte at a time splits each "\r\n" between reads.
+	r := &decodingReader{
+		r:      iotest.OneByteReader(strings.NewReader("a\r\nb\r\r\n\r")),
+		decode: crlfToLF(),
+	}
+	content, e := io.ReadAll(r)
+	if e != nil {
+		t.Logf("Failed converting to LF: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "a\nb\r\n\r" {
+		t.Logf("Got incorrect content converting to LF: %q\n", content)
+		t.FailNow()
+	}
+	r = &decodingReader{
+		r:      iotest.OneByteReader(strings.NewReader("a\r\nb\n\n")),
+		decode: lfToCRLF(),
+	}
+	content, e = io.ReadAll(r)
+	if e != nil {
+		t.Logf("Failed converting to CRLF: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "a\r\nb\r\n\r\n" {
+		t.Logf("Got incorrect content converting to CRLF: %q\n", content)
+		t.FailNow()
+	}
+}
+
+func TestNormalizeLineEndingsOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	e := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a\r\nb\r\n"), 0644)
+	if e != nil {
+		t.Logf("Failed creating a.txt: %s\n", e)
+		t.FailNow()
+	}
+	layer, e := DirLayer(dir, DirLayerOptions{})
+	if e != nil {
+		t.Logf("Failed creating dir layer: %s\n", e)
+		t.FailNow()
+	}
+	for _, fsys := range []fs.FS{os.DirFS(dir), layer} {
+		toLF, e := NormalizeLineEndings(fsys, "\n")
+		if e != nil {
+			t.Logf("Failed normalizing line endings: %s\n", e)
+			t.FailNow()
+		}
+		content, e := fs.ReadFile(toLF, "a.txt")
+		if e != nil {
+			t.Logf("Failed reading a.txt from %T: %s\n", fsys, e)
+			t.FailNow()
+		}
+		if string(content) != "a\nb\n" {
+			t.Logf("Got unconverted content from %T: %q\n", fsys, content)
+			t.FailNow()
+		}
+	}
+}
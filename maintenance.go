@@ -0,0 +1,169 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The interval used by a Maintainer if MaintenanceOptions.Interval isn't set.
+const defaultMaintenanceInterval = time.Second
+
+// Configures the housekeeping done by a Maintainer. The zero value only
+// checks the visibility of gated layers.
+type MaintenanceOptions struct {
+	// How often the maintenance tasks run. Defaults to one second.
+	Interval time.Duration
+	// If positive, the caches of the MergedFS, and of any MergedFS nested
+	// within it, are cleared whenever they've been in use for this long.
+	CacheTTL time.Duration
+	// If true, each Layer with a tripped circuit breaker is probed by
+	// calling its Stat method on "." once its retry time is reached, rather
+	// than waiting for the next request to the layer to find out whether it
+	// has recovered.
+	HealthChecks bool
+	// If non-nil, this is called on each run and the paths it returns are
+	// passed to NotifyChanged. This is intended for invalidating caches when
+	// a layer changes, e.g. by draining events from a filesystem watcher or
+	// checking a version file.
+	Poll func(ctx context.Context) ([]string, error)
+	// If non-nil, this is called with any error returned by Poll.
+	OnError func(e error)
+}
+
+// Runs housekeeping for a MergedFS in a background goroutine, as configured
+// by MaintenanceOptions. Returned by NewMaintainer. The package never starts
+// goroutines on its own; they only run between calls to Start and Stop.
+type Maintainer struct {
+	m    *MergedFS
+	opts MaintenanceOptions
+	// Protects the fields below.
+	mutex sync.Mutex
+	// Cancels the running loop. Nil if the loop isn't running.
+	cancel context.CancelFunc
+	// Closed when the running loop exits.
+	done chan struct{}
+	// When the caches were last cleared due to CacheTTL.
+	lastClear time.Time
+}
+
+// Returns a Maintainer for m, which doesn't do anything until Start is
+// called. Maintenance keeps m's view current without waiting for requests:
+// layers becoming visible or invisible due to VisibleFrom or VisibleUntil
+// are noticed, and m's subscribers notified, within one interval, even if m
+// isn't being used.
+func NewMaintainer(m *MergedFS, opts MaintenanceOptions) *Maintainer {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultMaintenanceInterval
+	}
+	return &Maintainer{
+		m:    m,
+		opts: opts,
+	}
+}
+
+// Starts running the maintenance tasks in a new goroutine, once per
+// interval, until Stop is called or ctx is canceled. Returns an error if the
+// maintenance loop is already running.
+func (x *Maintainer) Start(ctx context.Context) error {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.done != nil {
+		select {
+		case <-x.done:
+		default:
+			return errors.New("Maintenance is already running")
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	x.cancel = cancel
+	x.done = done
+	x.lastClear = time.Now()
+	go x.loop(ctx, done)
+	return nil
+}
+
+func (x *Maintainer) loop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(x.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			x.RunOnce(ctx)
+		}
+	}
+}
+
+// Stops the maintenance loop, waiting for it to exit. Does nothing if the
+// loop isn't running.
+func (x *Maintainer) Stop() {
+	x.mutex.Lock()
+	cancel := x.cancel
+	done := x.done
+	x.cancel = nil
+	x.mutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Runs each of the maintenance tasks once, in the calling goroutine. Start
+// calls this once per interval; it may also be called directly by
+// applications that prefer to schedule maintenance themselves.
+func (x *Maintainer) RunOnce(ctx context.Context) {
+	m := x.m
+	if x.opts.HealthChecks {
+		// This also collects the gated layers, which include every layer with
+		// a circuit breaker.
+		m.checkGates()
+		for _, l := range m.gatedLayers {
+			if l.breakerRetryDue() {
+				// Stat records the result with the layer's breaker.
+				l.Stat(".")
+			}
+		}
+	}
+	m.checkGates()
+	if x.opts.CacheTTL > 0 {
+		now := time.Now()
+		x.mutex.Lock()
+		expired := now.Sub(x.lastClear) >= x.opts.CacheTTL
+		if expired {
+			x.lastClear = now
+		}
+		x.mutex.Unlock()
+		if expired {
+			m.clearAllCaches()
+			m.publish(Event{Path: ".", Reason: "cache"})
+		}
+	}
+	if x.opts.Poll != nil {
+		changed, e := x.opts.Poll(ctx)
+		if (e != nil) && (x.opts.OnError != nil) {
+			x.opts.OnError(e)
+		}
+		if len(changed) != 0 {
+			m.NotifyChanged(changed...)
+		}
+	}
+}
+
+// Returns true if the layer's circuit breaker has tripped and its retry time
+// has been reached, so the next operation will be let through to test
+// whether the layer has recovered.
+func (l *Layer) breakerRetryDue() bool {
+	if l.BreakerThreshold <= 0 {
+		return false
+	}
+	l.breakerMutex.Lock()
+	defer l.breakerMutex.Unlock()
+	return (l.consecutiveErrors >= l.BreakerThreshold) &&
+		!time.Now().Before(l.retryAt)
+}
@@ -0,0 +1,113 @@
+package merged_fs
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMaintainerHealthChecks(t *testing.T) {
+	remote := &flakyFS{FS: fstest.MapFS{
+		"remote.txt": newMapFile("remote"),
+	}}
+	layerA := &Layer{
+		FS:               remote,
+		Name:             "remote",
+		BreakerThreshold: 1,
+		BreakerBackoff:   time.Millisecond,
+	}
+	merged := NewMergedFS(layerA, fstest.MapFS{})
+	maintainer := NewMaintainer(merged, MaintenanceOptions{
+		HealthChecks: true,
+	})
+	remote.down = true
+	merged.ReadFile("remote.txt")
+	if !layerA.BreakerState().Open {
+		t.Logf("The layer's breaker didn't open\n")
+		t.FailNow()
+	}
+	time.Sleep(5 * time.Millisecond)
+	// The layer is still down, so the probe should trip the breaker again.
+	opens := remote.opens
+	maintainer.RunOnce(context.Background())
+	if remote.opens == opens {
+		t.Logf("The maintainer didn't probe the layer\n")
+		t.FailNow()
+	}
+	if !layerA.BreakerState().Open {
+		t.Logf("The breaker didn't reopen after a failed probe\n")
+		t.FailNow()
+	}
+	time.Sleep(5 * time.Millisecond)
+	remote.down = false
+	maintainer.RunOnce(context.Background())
+	state := layerA.BreakerState()
+	if state.Open || (state.ConsecutiveErrors != 0) {
+		t.Logf("The breaker wasn't reset by a successful probe: %+v\n", state)
+		t.FailNow()
+	}
+}
+
+func TestMaintainerStartStop(t *testing.T) {
+	m := NewMergedFS(fstest.MapFS{"a.txt": newMapFile("a")}, fstest.MapFS{})
+	events, unsubscribe := m.Subscribe("**")
+	defer unsubscribe()
+	polled := make(chan bool, 1)
+	maintainer := NewMaintainer(m, MaintenanceOptions{
+		Interval: time.Millisecond,
+		Poll: func(ctx context.Context) ([]string, error) {
+			select {
+			case polled <- true:
+				return []string{"a.txt"}, nil
+			default:
+			}
+			return nil, nil
+		},
+	})
+	// Nothing runs until Start is called.
+	time.Sleep(5 * time.Millisecond)
+	if len(polled) != 0 {
+		t.Logf("Maintenance ran before being started\n")
+		t.FailNow()
+	}
+	e := maintainer.Start(context.Background())
+	if e != nil {
+		t.Logf("Failed starting maintenance: %s\n", e)
+		t.FailNow()
+	}
+	e = maintainer.Start(context.Background())
+	if e == nil {
+		t.Logf("Didn't get an error starting maintenance twice\n")
+		t.FailNow()
+	}
+	select {
+	case event := <-events:
+		if (event.Path != "a.txt") || (event.Reason != "changed") {
+			t.Logf("Got unexpected event: %+v\n", event)
+			t.FailNow()
+		}
+	case <-time.After(5 * time.Second):
+		t.Logf("Timed out waiting for the polled change\n")
+		t.FailNow()
+	}
+	maintainer.Stop()
+	maintainer.Stop()
+
+	// The maintainer can be restarted after it stops, including if it stops
+	// because its context was canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	e = maintainer.Start(ctx)
+	if e != nil {
+		t.Logf("Failed restarting maintenance: %s\n", e)
+		t.FailNow()
+	}
+	cancel()
+	maintainer.Stop()
+	e = maintainer.Start(context.Background())
+	if e != nil {
+		t.Logf("Failed restarting canceled maintenance: %s\n", e)
+		t.FailNow()
+	}
+	maintainer.Stop()
+}
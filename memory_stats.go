@@ -0,0 +1,113 @@
+package merged_fs
+
+// Rough estimates of the memory used by Go's runtime structures on 64-bit
+// platforms, used by MemoryStats.
+const (
+	stringHeaderSize = 16
+	// The overhead of a single map entry beyond its key and value.
+	mapEntryOverhead = 16
+	// A MergedDirectory, excluding its name and entries.
+	mergedDirectorySize = 80
+	// A directory entry from a layer, excluding its name. This is a guess;
+	// the actual size depends on the layer's fs.DirEntry implementation.
+	dirEntrySize = 64
+)
+
+// Estimates of the memory held by the caches of a MergedFS, as returned by
+// MemoryStats. Sizes are in bytes, and include the keys, values, and an
+// approximation of the overhead of the maps holding them, but not memory
+// held by the layers themselves.
+type MemoryStats struct {
+	// The number of prefixes cached by path caching (see UsePathCaching),
+	// and their estimated size.
+	PrefixCacheEntries int
+	PrefixCacheBytes   int64
+	// The number of merged directories cached by directory caching (see
+	// UseDirectoryCaching), and their estimated size, including their
+	// entries.
+	DirCacheEntries int
+	DirCacheBytes   int64
+	// The number of paths whose MIME types are cached by ContentType, and
+	// their estimated size.
+	ContentTypeCacheEntries int
+	ContentTypeCacheBytes   int64
+	// The number of paths whose classes are cached by Classify, and their
+	// estimated size.
+	ClassCacheEntries int
+	ClassCacheBytes   int64
+}
+
+// Returns the estimated total size of every cache, in bytes.
+func (s *MemoryStats) TotalBytes() int64 {
+	return s.PrefixCacheBytes + s.DirCacheBytes + s.ContentTypeCacheBytes +
+		s.ClassCacheBytes
+}
+
+func (s *MemoryStats) add(other *MemoryStats) {
+	s.PrefixCacheEntries += other.PrefixCacheEntries
+	s.PrefixCacheBytes += other.PrefixCacheBytes
+	s.DirCacheEntries += other.DirCacheEntries
+	s.DirCacheBytes += other.DirCacheBytes
+	s.ContentTypeCacheEntries += other.ContentTypeCacheEntries
+	s.ContentTypeCacheBytes += other.ContentTypeCacheBytes
+	s.ClassCacheEntries += other.ClassCacheEntries
+	s.ClassCacheBytes += other.ClassCacheBytes
+}
+
+// Returns the estimated size of a string stored in a map.
+func stringMemory(s string) int64 {
+	return stringHeaderSize + int64(len(s))
+}
+
+// Returns estimates of the memory held by the caches of m and of any MergedFS
+// nested within it, so long-running servers can monitor it and decide when
+// to clear the caches. The estimates are approximate: they're computed from
+// the number and length of cached paths and directory entries, without
+// accounting for allocator overhead or memory shared between entries. Cached
+// directory handles that are still open may keep memory alive after their
+// cache is cleared, which isn't counted.
+func (m *MergedFS) MemoryStats() MemoryStats {
+	var toReturn MemoryStats
+	m.okPrefixesMutex.Lock()
+	for p := range m.knownOKPrefixes {
+		toReturn.PrefixCacheEntries++
+		toReturn.PrefixCacheBytes += stringMemory(p) + 1 + mapEntryOverhead
+	}
+	m.okPrefixesMutex.Unlock()
+
+	m.dirCacheMutex.Lock()
+	for p, d := range m.dirCache {
+		toReturn.DirCacheEntries++
+		size := stringMemory(p) + mapEntryOverhead +
+			mergedDirectorySize + int64(len(d.name))
+		for _, entry := range d.entries {
+			size += dirEntrySize + int64(len(entry.Name()))
+		}
+		toReturn.DirCacheBytes += size
+	}
+	m.dirCacheMutex.Unlock()
+
+	m.contentTypes.mutex.Lock()
+	for p, mimeType := range m.contentTypes.cache {
+		toReturn.ContentTypeCacheEntries++
+		toReturn.ContentTypeCacheBytes += stringMemory(p) +
+			stringMemory(mimeType) + mapEntryOverhead
+	}
+	m.contentTypes.mutex.Unlock()
+
+	m.fileClasses.mutex.Lock()
+	for p := range m.fileClasses.cache {
+		toReturn.ClassCacheEntries++
+		toReturn.ClassCacheBytes += stringMemory(p) + 1 + mapEntryOverhead
+	}
+	m.fileClasses.mutex.Unlock()
+
+	for side := 0; side < 2; side++ {
+		nested, ok := m.layer(side).(*MergedFS)
+		if ok {
+			nestedStats := nested.MemoryStats()
+			toReturn.add(&nestedStats)
+		}
+	}
+	return toReturn
+}
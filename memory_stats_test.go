@@ -0,0 +1,66 @@
+package merged_fs
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestMemoryStats(t *testing.T) {
+	a := fstest.MapFS{
+		"dir/a.txt": newMapFile("a"),
+	}
+	b := fstest.MapFS{
+		"dir/b.txt":     newMapFile("b"),
+		"dir/sub/c.txt": newMapFile("c"),
+	}
+	nested := NewMergedFS(a, b)
+	m := NewMergedFS(nested, fstest.MapFS{"d.txt": newMapFile("d")})
+	stats := m.MemoryStats()
+	if stats.TotalBytes() != 0 {
+		t.Logf("Expected empty caches, got %d bytes\n", stats.TotalBytes())
+		t.FailNow()
+	}
+	m.UseDirectoryCaching(true)
+	_, e := m.ReadDir("dir")
+	if e == nil {
+		_, e = m.Open("dir/sub/c.txt")
+	}
+	if e == nil {
+		_, e = m.ContentType("d.txt")
+	}
+	if e != nil {
+		t.Logf("Failed populating caches: %s\n", e)
+		t.FailNow()
+	}
+	stats = m.MemoryStats()
+	t.Logf("Memory stats: %+v\n", stats)
+	// Only the nested MergedFS needs to merge "dir", so the directory is
+	// only cached there.
+	if stats.DirCacheEntries != 1 {
+		t.Logf("Expected 1 cached directory, got %d\n",
+			stats.DirCacheEntries)
+		t.FailNow()
+	}
+	if (stats.PrefixCacheEntries == 0) || (stats.PrefixCacheBytes == 0) {
+		t.Logf("Expected cached prefixes to be counted\n")
+		t.FailNow()
+	}
+	if stats.ContentTypeCacheEntries != 1 {
+		t.Logf("Expected 1 cached content type, got %d\n",
+			stats.ContentTypeCacheEntries)
+		t.FailNow()
+	}
+	expected := stats.PrefixCacheBytes + stats.DirCacheBytes +
+		stats.ContentTypeCacheBytes
+	if stats.TotalBytes() != expected {
+		t.Logf("Bad total: expected %d, got %d\n", expected,
+			stats.TotalBytes())
+		t.FailNow()
+	}
+	m.UseDirectoryCaching(true)
+	stats = m.MemoryStats()
+	if (stats.DirCacheEntries != 0) || (stats.DirCacheBytes != 0) {
+		t.Logf("Directory cache wasn't empty after clearing: %+v\n", stats)
+		t.FailNow()
+	}
+}
@@ -12,13 +12,14 @@
 package merged_fs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"sort"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -39,10 +40,120 @@ type MergedFS struct {
 	knownOKPrefixes      map[string]bool
 	// Protects knownOKPrefixes from concurrent accesses.
 	okPrefixesMutex sync.Mutex
+
+	// Nonzero if panics in A or B must be converted to errors. Only access
+	// this atomically.
+	recoverPanics int32
+	// Nonzero if errors opening "." in A or B should be ignored. Only access
+	// this atomically.
+	syntheticRoot int32
+	// Nonzero if failed reads from A should be retried using B. Only access
+	// this atomically.
+	readFallback int32
+	// Nonzero if strict mode should check directory entries against the
+	// files they refer to. Only access this atomically.
+	integrityChecks int32
+	// The SymlinkShadowing policy. Only access this atomically.
+	symlinkShadowing int32
+	// True if Stat is expensive for files from A. Set once, by avoidsStat.
+	expensiveStatOnce sync.Once
+	expensiveStatA    bool
+
+	// Maps paths to merged directories, if directory caching is enabled.
+	// Nil if directory caching is disabled. The cached directories must never
+	// be modified or returned directly; use their newHandle method instead.
+	dirCache map[string]*MergedDirectory
+	// Incremented whenever dirCache is cleared, so that directories merged
+	// before the cache was cleared aren't added to it afterwards.
+	dirGeneration uint64
+	// Protects dirCache from concurrent accesses.
+	dirCacheMutex sync.Mutex
+
+	// Limits set using SetMergeLimits. Only access these atomically.
+	maxDirEntries int64
+	maxMergeWork  int64
+
+	// Limits set using SetDepthLimits. Only access these atomically.
+	maxPathDepth    int64
+	maxNestingDepth int64
+
+	// The number of goroutines currently resolving paths chosen by the
+	// predictor. Only access this atomically.
+	speculating int32
+
+	// Every gated Layer within m, computed once, and a string recording
+	// whether each was visible the last time we checked. If the visibility
+	// changes, m's caches are cleared.
+	gatesOnce   sync.Once
+	gatedLayers []*Layer
+	gateState   string
+	gateMutex   sync.Mutex
+	// True if any Layer within m has a Visible function, in which case m's
+	// caches aren't used. Set along with gatedLayers.
+	visibilityHooks bool
+
+	// The middleware installed using Use(), outermost first, and the chain
+	// of Openers built from it. opener is nil if no middleware is installed.
+	middleware []Middleware
+	opener     Opener
+	// Counts bytes read from files opened using m. Nil if counting is
+	// disabled.
+	readMeter *readMeter
+	// Tracks files opened using m. Nil if tracking is disabled.
+	openFiles *openFileTracker
+	// Rules for overriding the priority of layers for certain paths.
+	priorityOverrides []priorityOverride
+	// Paths pinned to a layer using Pin. Replaced rather than modified when a
+	// pin is added or removed.
+	pins []priorityOverride
+	// Rules added using AddConflictResolver, in the order they were added.
+	conflictRules []conflictRule
+	// Virtual paths added using Alias. Replaced rather than modified when an
+	// alias is added.
+	aliases []pathAlias
+	// The rules used by TenantView, keyed by tenant ID.
+	tenants map[string]*TenantRule
+	// Called with contract violations by layers. Nil unless strict mode is
+	// enabled.
+	strictHandler func(v *ContractViolation) error
+	// Orders listings returned by ReadDirPage and CollatedReadDir. Nil to
+	// use byte order.
+	collation func(a, b string) int
+	// Called with each path opened using m, to choose paths to resolve
+	// speculatively. Nil unless set using SetPredictor.
+	predictor func(opened string) []string
+	// Called before each operation on m to check whether it's allowed. Nil
+	// unless set using SetAuthorizer.
+	authorizer Authorizer
+	// Receives records of sampled operations. Nil unless set using
+	// SetAuditSink.
+	auditor *auditor
+	// Protects the above fields from concurrent accesses.
+	configMutex sync.RWMutex
+
+	// The overrides and cache used by ContentType, which have their own
+	// mutex.
+	contentTypes contentTypes
+
+	// The cache used by Classify, which has its own mutex.
+	fileClasses fileClasses
+
+	// The channels returned by Subscribe, which have their own mutex.
+	subscribers subscribers
+
+	// The times recorded by NotifyChanged, which have their own mutex.
+	changeTimes changeTimes
 }
 
-// Takes two FS instances and returns an initialized MergedFS.
+// Takes two FS instances and returns an initialized MergedFS. A nil FS is
+// replaced with Empty, so optional layers don't need to be checked for nil.
 func NewMergedFS(a, b fs.FS) *MergedFS {
+	if a == nil {
+		a = Empty
+	}
+	if b == nil {
+		b = Empty
+	}
 	return &MergedFS{
 		A:                    a,
 		B:                    b,
@@ -66,6 +177,10 @@ type MergedDirectory struct {
 	entries []fs.DirEntry
 	// The next entry to return with ReadDir.
 	readOffset int
+	// The entries sorted by their offsets, for ReadDirOffsets. Shared by
+	// every handle to a cached directory, and nil for directories that
+	// aren't merged from the layers' contents.
+	offsets *dirOffsets
 }
 
 func (d *MergedDirectory) Name() string {
@@ -111,53 +226,44 @@ func (d *MergedDirectory) Read(data []byte) (int, error) {
 func (d *MergedDirectory) Close() error {
 	// Note: Do *not* clear the rest of the fields here, since the
 	// MergedDirectory also serves as a DirEntry or FileInfo, which must be
-	// able to outlive the File itself being closed.
+	// able to outlive the File itself being closed. Clearing the entries slice
+	// is safe even for cached directories, as each handle to a cached
+	// directory is a separate copy of the MergedDirectory struct.
 	d.entries = nil
 	d.readOffset = 0
 	return nil
 }
 
 func (d *MergedDirectory) ReadDir(n int) ([]fs.DirEntry, error) {
-	if d.readOffset >= len(d.entries) {
+	return readEntries(d.entries, &d.readOffset, n)
+}
+
+// Implements the ReadDir function of a ReadDirFile, given the directory's full
+// list of entries and a pointer to the offset of the next entry to return.
+func readEntries(entries []fs.DirEntry, offset *int, n int) ([]fs.DirEntry,
+	error) {
+	if *offset >= len(entries) {
 		if n <= 0 {
 			// A special case required by the FS interface.
 			return nil, nil
 		}
 		return nil, io.EOF
 	}
-	startEntry := d.readOffset
+	startEntry := *offset
 	var endEntry int
 	if n <= 0 {
-		endEntry = len(d.entries)
+		endEntry = len(entries)
 	} else {
 		endEntry = startEntry + n
 	}
-	if endEntry > len(d.entries) {
-		endEntry = len(d.entries)
+	if endEntry > len(entries) {
+		endEntry = len(entries)
 	}
-	toReturn := d.entries[startEntry:endEntry]
-	d.readOffset = endEntry
+	toReturn := entries[startEntry:endEntry]
+	*offset = endEntry
 	return toReturn, nil
 }
 
-// Returns the final element of the path. The path must be valid according to
-// the rules of fs.ValidPath.
-func baseName(path string) string {
-	d := []byte(path)
-	i := len(d)
-	if i <= 1 {
-		return path
-	}
-	i--
-	for i >= 0 {
-		if d[i] == '/' {
-			break
-		}
-		i--
-	}
-	return string(d[i+1:])
-}
-
 // Returns a MergedDirectory, but doesn't set the entries slice or anything.
 // (Intended to be used solely as a DirEntry, created with the same metadata
 // as a MergedDirectory "File".
@@ -199,26 +305,34 @@ func (s dirEntrySlice) Swap(a, b int) {
 	s[a], s[b] = s[b], s[a]
 }
 
-// Takes two files that must be directories, and combines their contents into
-// a single slice, sorted by name.
-func mergeDirEntries(a, b fs.File) ([]fs.DirEntry, error) {
-	dirA, ok := a.(fs.ReadDirFile)
+// Reads all entries from f, which must be a directory from the given side of
+// the merge.
+func (m *MergedFS) readLayerDir(ctx context.Context, side int, f fs.File,
+	path string) (
+	entries []fs.DirEntry, e error) {
+	dir, ok := f.(fs.ReadDirFile)
 	if !ok {
 		return nil, fmt.Errorf("Directories must implement ReadDirFile")
 	}
-	dirB, ok := b.(fs.ReadDirFile)
-	if !ok {
-		return nil, fmt.Errorf("Directories must implement ReadDirFile")
+	if m.recoveringPanics() {
+		defer m.recoverLayerPanic(side, "readdir", path, &e)
 	}
-	entriesA, e := dirA.ReadDir(-1)
+	entries, e = m.readDirLimited(ctx, dir, path)
 	if e != nil {
-		return nil, fmt.Errorf("Failed reading entries from dir A: %w", e)
+		return nil, fmt.Errorf("Failed reading entries from dir %s: %w",
+			m.layerName(side), e)
 	}
-	entriesB, e := dirB.ReadDir(-1)
+	e = m.checkDirEntries(side, path, entries)
 	if e != nil {
-		return nil, fmt.Errorf("Failed reading entries from dir B: %w", e)
+		return nil, e
 	}
+	return entries, nil
+}
 
+// Takes the contents of two directories, and combines them into a single
+// slice, sorted by name.
+func mergeDirEntries(entriesA, entriesB []fs.DirEntry) ([]fs.DirEntry,
+	error) {
 	// Maps the name to an existing index in toReturn.
 	nameConflicts := make(map[string]int)
 	toReturn := make([]fs.DirEntry, 0, len(entriesA)+len(entriesB))
@@ -279,46 +393,177 @@ func mergeDirEntries(a, b fs.File) ([]fs.DirEntry, error) {
 // of both files a and b. Both a and b must be directories at the same
 // specified path in m.A and m.B, respectively. Closes files a and b before
 // returning, since they aren't needed by the MergedDirectory pseudo-file.
-func (m *MergedFS) newMergedDirectory(a, b fs.File, path string) (fs.File,
-	error) {
+func (m *MergedFS) newMergedDirectory(ctx context.Context, a, b fs.File,
+	path string) (
+	*MergedDirectory, error) {
 	defer a.Close()
 	defer b.Close()
-	sA, e := a.Stat()
+	sA, e := m.statLayerFile(0, a, path)
 	if e != nil {
-		return nil, fmt.Errorf("Couldn't stat dir %s from FS A: %w", path, e)
+		return nil, fmt.Errorf("Couldn't stat dir %s from FS %s: %w", path,
+			m.layerName(0), e)
 	}
-	sB, e := b.Stat()
+	sB, e := m.statLayerFile(1, b, path)
 	if e != nil {
-		return nil, fmt.Errorf("Couldn't stat dir %s from FS B: %w", path, e)
+		return nil, fmt.Errorf("Couldn't stat dir %s from FS %s: %w", path,
+			m.layerName(1), e)
 	}
 	modTime := sA.ModTime().Unix()
 	modTimeB := sB.ModTime().Unix()
 	if modTimeB > modTime {
 		modTime = modTimeB
 	}
-	entries, e := mergeDirEntries(a, b)
+	modTime = m.changeTimes.latest(path, modTime)
+	entriesA, e := m.readLayerDir(ctx, 0, a, path)
+	if e != nil {
+		return nil, fmt.Errorf("Error merging directory contents: %w", e)
+	}
+	entriesB, e := m.readLayerDir(ctx, 1, b, path)
+	if e != nil {
+		return nil, fmt.Errorf("Error merging directory contents: %w", e)
+	}
+	entriesB = m.removeLinkShadowed(path, entriesA, entriesB)
+	entriesA = m.addProvenance(0, entriesA)
+	entries, e := mergeDirEntries(entriesA, m.addProvenance(1, entriesB))
 	if e != nil {
 		return nil, fmt.Errorf("Error merging directory contents: %w", e)
 	}
+	e = m.checkMergedSize(path, entries)
+	if e != nil {
+		return nil, e
+	}
+	m.updateChangedEntries(path, entries)
+	m.restoreProvenance(entriesA, entries)
 	return &MergedDirectory{
 		name:       baseName(path),
 		mode:       sA.Mode(),
 		modTime:    uint64(modTime),
 		entries:    entries,
 		readOffset: 0,
+		offsets:    &dirOffsets{},
 	}, nil
 }
 
+// Returns m.A if side is 0, or m.B if side is 1.
+func (m *MergedFS) layer(side int) fs.FS {
+	if side == 0 {
+		return m.A
+	}
+	return m.B
+}
+
+// Returns the name to use for the given side of m in error messages: the Name
+// of the underlying Layer if it has one, or "A" or "B" otherwise.
+func (m *MergedFS) layerName(side int) string {
+	if l, ok := m.layer(side).(*Layer); ok && (l.Name != "") {
+		return l.Name
+	}
+	if side == 0 {
+		return "A"
+	}
+	return "B"
+}
+
+// Opens the path in fsys, passing ctx along if fsys is a Layer or MergedFS.
+func openContext(ctx context.Context, fsys fs.FS, path string) (fs.File,
+	error) {
+	switch v := fsys.(type) {
+	case *MergedFS:
+		return v.OpenContext(ctx, path)
+	case *Group:
+		return v.OpenContext(ctx, path)
+	case *Layer:
+		return v.OpenContext(ctx, path)
+	case *replicaFS:
+		return v.openContext(ctx, path)
+	}
+	return fsys.Open(path)
+}
+
+func (m *MergedFS) openLayer(ctx context.Context, side int,
+	path string) (f fs.File, e error) {
+	if m.recoveringPanics() {
+		defer m.recoverLayerPanic(side, "open", path, &e)
+	}
+	f, e = openContext(ctx, m.layer(side), path)
+	return m.checkLayerOpen(ctx, side, path, f, e)
+}
+
+// Opens the path in the layer at the given index (see Layers), which may be
+// within a MergedFS nested in m. Like openLayer, this goes through the
+// layer's limits and each MergedFS's checks and bookkeeping, but skips the
+// merging done by the nested MergedFS.
+func (m *MergedFS) openFlattenedLayer(ctx context.Context, index int,
+	path string) (f fs.File, e error) {
+	side := 0
+	if countA := layerCount(m.A); index >= countA {
+		side = 1
+		index -= countA
+	}
+	var nested *MergedFS
+	switch v := m.layer(side).(type) {
+	case *MergedFS:
+		nested = v
+	case *Group:
+		nested = v.MergedFS
+	default:
+		return m.openLayer(ctx, side, path)
+	}
+	if m.recoveringPanics() {
+		defer m.recoverLayerPanic(side, "open", path, &e)
+	}
+	f, e = nested.openFlattenedLayer(ctx, index, path)
+	return m.checkLayerOpen(ctx, side, path, f, e)
+}
+
+// Checks the result of opening the path in the given side of m, and records
+// the layer that served it. Closes f and returns an error if the strict-mode
+// handler rejects the result.
+func (m *MergedFS) checkLayerOpen(ctx context.Context, side int, path string,
+	f fs.File, e error) (fs.File, error) {
+	if violation := m.checkOpenResult(side, path, f, e); violation != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, violation
+	}
+	if e == nil {
+		m.recordServed(ctx, side, f)
+	}
+	return f, e
+}
+
+// Calls Stat on f, which must have been opened from the given side of m.
+func (m *MergedFS) statLayerFile(side int, f fs.File, path string) (
+	info fs.FileInfo, e error) {
+	if m.recoveringPanics() {
+		defer m.recoverLayerPanic(side, "stat", path, &e)
+	}
+	info, e = f.Stat()
+	if e != nil {
+		return nil, e
+	}
+	e = m.checkStatResult(side, path, info)
+	if e != nil {
+		return nil, e
+	}
+	return info, nil
+}
+
 // Returns true if the given error is one that a filesystem may return when a
 // path is invalid.
 func isBadPathError(e error) bool {
+	var depthError *DepthLimitError
+	if errors.As(e, &depthError) {
+		return false
+	}
 	return errors.Is(e, fs.ErrNotExist) || errors.Is(e, fs.ErrInvalid)
 }
 
 // Returns true if the given prefix p is in the cache of known OK prefixes.
 // Only call this while holding m.okPrefixesMutex.
 func (m *MergedFS) checkCachedPrefix(p string) bool {
-	if !m.prefixCachingEnabled {
+	if !m.prefixCachingEnabled || m.visibilityHooks {
 		return false
 	}
 	return m.knownOKPrefixes[p]
@@ -327,7 +572,7 @@ func (m *MergedFS) checkCachedPrefix(p string) bool {
 // Adds a known OK prefix to the cache. Does nothing if caching is disabled.
 // Only call this while holding m.okPrefixesMutes.
 func (m *MergedFS) addPrefixToCache(p string) {
-	if !m.prefixCachingEnabled {
+	if !m.prefixCachingEnabled || m.visibilityHooks {
 		return
 	}
 	m.knownOKPrefixes[p] = true
@@ -337,34 +582,42 @@ func (m *MergedFS) addPrefixToCache(p string) {
 // non-directory in m.A. Prefix components must therefore be either directories
 // or nonexistent. Returns an error wrapping fs.ErrNotExist if any error is
 // returned.
-func (m *MergedFS) validatePathPrefix(path string) error {
+func (m *MergedFS) validatePathPrefix(ctx context.Context, path string) error {
 	m.okPrefixesMutex.Lock()
 	defer m.okPrefixesMutex.Unlock()
 
 	// Return immediately if we've already seen that this path is OK.
 	if m.checkCachedPrefix(path) {
+		traceStep(ctx, "cache", m.layerName(0), "already known not to "+
+			"shadow the path")
 		return nil
 	}
-	components := strings.Split(path, "/")
-	for i := range components {
-		prefix := strings.Join(components[0:i+1], "/")
-		if m.checkCachedPrefix(path) {
+	return ForEachPrefix(path, func(prefix string) error {
+		if m.checkCachedPrefix(prefix) {
 			// We've already checked this and it's a directory or nonexistent.
-			continue
+			return nil
+		}
+		if m.symlinksShadow() && m.isLayerSymlink(0, prefix) {
+			traceStep(ctx, "shadow check", m.layerName(0), "%s is a "+
+				"symlink, which hides the path", prefix)
+			return fmt.Errorf("%w: %s is a symlink in A", fs.ErrNotExist,
+				prefix)
 		}
-		f, e := m.A.Open(prefix)
+		f, e := m.openLayer(ctx, 0, prefix)
 		if e != nil {
 			if isBadPathError(e) {
 				// The path doesn't conflict--it doesn't exist in A.
+				traceStep(ctx, "shadow check", m.layerName(0), "%s doesn't "+
+					"exist", prefix)
 				m.addPrefixToCache(prefix)
 				m.addPrefixToCache(path)
-				return nil
+				return StopPrefixes
 			}
 			// We can't handle opening this path in A for some reason.
 			return fmt.Errorf("%w: Error opening %s in A: %s", fs.ErrNotExist,
 				path, e)
 		}
-		info, e := f.Stat()
+		info, e := m.statLayerFile(0, f, prefix)
 		// We don't need the file handle after reading its info.
 		f.Close()
 		if e != nil {
@@ -372,14 +625,15 @@ func (m *MergedFS) validatePathPrefix(path string) error {
 		}
 		if !info.IsDir() {
 			// We found a non-dir file in A with the same name as the path.
+			traceStep(ctx, "shadow check", m.layerName(0), "%s is a file, "+
+				"which hides the path", prefix)
 			return fmt.Errorf("%w: %s is a file in A", fs.ErrNotExist,
 				prefix)
 		}
 		// The prefix doesn't conflict (so far)--it is a directory in A.
 		m.addPrefixToCache(prefix)
-	}
-	// The path's prefix is only directories in both FS's
-	return nil
+		return nil
+	})
 }
 
 // Enables or disables path prefix caching, and clears the cache.
@@ -420,6 +674,7 @@ func (m *MergedFS) UsePathCaching(enabled bool) {
 	// Clear the cache
 	m.knownOKPrefixes = make(map[string]bool)
 	m.prefixCachingEnabled = enabled
+	m.publish(Event{Path: ".", Reason: "cache"})
 	// If either sub-FS is a MergedFS, then set the prefix caching on it, too.
 	// Note that this is not necessarily exhaustive, for example if a MergedFS
 	// is wrapped by some other FS, it will be missed. Nonetheless, this will
@@ -439,15 +694,172 @@ func (m *MergedFS) UsePathCaching(enabled bool) {
 // a MergedDirectory file. If it's present in both A and B, but isn't a
 // directory in both, then this will simply return the copy in A. Otherwise,
 // it returns the copy in B, so long as some prefix of the path doesn't
-// correspond to a regular file in A.
+// correspond to a regular file in A. Any middleware installed with Use() runs
+// before this logic.
 func (m *MergedFS) Open(path string) (fs.File, error) {
+	return m.OpenContext(context.Background(), path)
+}
+
+// The same as Open, but passes ctx through to the Layers and nested MergedFS
+// instances making up m, and to any hooks that take a context, such as
+// Layer.Visible. This allows a single MergedFS to be shared by requests that
+// need to resolve paths differently, e.g. for different tenants. Layers also
+// use ctx when waiting for their MaxConcurrent or OpsPerSecond limits, so
+// canceling it abandons the wait. Middleware runs as it does for Open.
+func (m *MergedFS) OpenContext(ctx context.Context, path string) (fs.File,
+	error) {
+	e := m.authorize(ctx, "open", path)
+	if e != nil {
+		return nil, e
+	}
+	return m.openAuthorized(ctx, "open", path)
+}
+
+// Implements OpenContext after the authorizer, if any, allowed the given
+// operation, which is recorded if m's audit sink samples it.
+func (m *MergedFS) openAuthorized(ctx context.Context, op,
+	path string) (fs.File, error) {
+	return m.openAudited(ctx, path, m.startAudit(ctx, op, path))
+}
+
+// Implements OpenContext, sending the operation to m's audit sink if audit
+// is non-nil.
+func (m *MergedFS) openAudited(ctx context.Context, path string,
+	audit *auditEntry) (fs.File, error) {
+	if audit != nil {
+		ctx = audit.context(ctx)
+	}
+	m.configMutex.RLock()
+	opener := m.opener
+	meter := m.readMeter
+	tracker := m.openFiles
+	predictor := m.predictor
+	m.configMutex.RUnlock()
+	if opener == nil {
+		opener = m.openInternal
+	}
+	f, e := opener(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if audit != nil {
+		f = audit.wrap(f)
+	}
+	if meter != nil {
+		f = newMeteredFile(f, meter)
+	}
+	if tracker != nil {
+		f = tracker.track(f, path)
+	}
+	if predictor != nil {
+		m.speculate(predictor, path)
+	}
+	return f, nil
+}
+
+// Implements the actual merging logic for Open, without any middleware.
+func (m *MergedFS) openInternal(ctx context.Context, path string) (fs.File,
+	error) {
+	if !fs.ValidPath(path) {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrInvalid}
+	}
+	e := m.checkPathDepth("open", path)
+	if e != nil {
+		return nil, e
+	}
+	ctx, e = m.enterNested(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	ctx = m.withMergeBudget(ctx)
+	if t := traceFromContext(ctx); t != nil {
+		t.depth++
+		defer func() { t.depth-- }()
+	}
+	m.configMutex.RLock()
+	aliases := m.aliases
+	m.configMutex.RUnlock()
+	if len(aliases) != 0 {
+		return m.openAliased(ctx, aliases, path)
+	}
+	return m.openResolved(ctx, path)
+}
+
+// Opens the path, which must be valid, ignoring any aliases.
+func (m *MergedFS) openResolved(ctx context.Context, path string) (fs.File,
+	error) {
+	m.configMutex.RLock()
+	overrides := m.priorityOverrides
+	pins := m.pins
+	conflictRules := m.conflictRules
+	m.configMutex.RUnlock()
+	if len(pins) != 0 {
+		// Pins take precedence over all overrides.
+		rules := make([]priorityOverride, 0, len(pins)+len(overrides))
+		overrides = append(append(rules, pins...), overrides...)
+	}
+	if (len(overrides) == 0) && (len(conflictRules) == 0) {
+		return m.openDefault(ctx, path)
+	}
+	if len(overrides) != 0 {
+		f, e := m.openOverride(ctx, overrides, path)
+		if (f != nil) || (e != nil) {
+			return f, e
+		}
+	}
+	if len(conflictRules) != 0 {
+		f, e := m.openConflict(ctx, conflictRules, path)
+		if (f != nil) || (e != nil) {
+			return f, e
+		}
+	}
+	f, e := m.openDefault(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	if len(overrides) != 0 {
+		f, e = applyOverridesToDir(overrides, path, f)
+		if e != nil {
+			return nil, e
+		}
+	}
+	if len(conflictRules) != 0 {
+		return m.applyConflictsToDir(ctx, conflictRules, path, f)
+	}
+	return f, nil
+}
+
+// Opens the path following the normal priority order, ignoring any priority
+// overrides.
+func (m *MergedFS) openDefault(ctx context.Context, path string) (fs.File,
+	error) {
 	if !fs.ValidPath(path) {
-		return nil, &fs.PathError{"open", "path", fs.ErrInvalid}
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrInvalid}
+	}
+	m.checkGates()
+	if (path == ".") && (atomic.LoadInt32(&m.syntheticRoot) != 0) {
+		traceStep(ctx, "decision", "", "merging roots, synthesizing any "+
+			"that can't be opened")
+		return m.openSyntheticRoot(ctx)
+	}
+	if m.resolvesAcrossLayers() {
+		f, e := m.openAcrossLayers(ctx, path)
+		if (f != nil) || (e != nil) {
+			return f, e
+		}
+	}
+	if d := m.cachedDirectory(path); d != nil {
+		traceStep(ctx, "cache", "", "found merged directory in cache")
+		return d, nil
 	}
+	generation := m.dirCacheGeneration()
 
-	fA, e := m.A.Open(path)
+	fA, e := m.openLayer(ctx, 0, path)
+	if (e == nil) && m.avoidsStat() {
+		return m.openAvoidingStat(ctx, fA, path, generation)
+	}
 	if e == nil {
-		fileInfo, e := fA.Stat()
+		fileInfo, e := m.statLayerFile(0, fA, path)
 		if e != nil {
 			fA.Close()
 			return nil, fmt.Errorf("Couldn't stat %s in FS A: %w", path, e)
@@ -455,23 +867,36 @@ func (m *MergedFS) Open(path string) (fs.File, error) {
 		if !fileInfo.IsDir() {
 			// If the file isn't a directory, we know it always overrides FS B,
 			// so we don't even need to check FS B.
+			traceStep(ctx, "decision", m.layerName(0), "found a file, which "+
+				"takes priority over %s", m.layerName(1))
+			if atomic.LoadInt32(&m.readFallback) != 0 {
+				return m.newFallbackFile(ctx, fA, path), nil
+			}
 			return fA, nil
 		}
+		traceStep(ctx, "probe", m.layerName(0), "found a directory")
+		if m.symlinksShadow() && m.isLayerSymlink(0, path) {
+			traceStep(ctx, "decision", m.layerName(0), "using the linked "+
+				"directory, which shadows %s", m.layerName(1))
+			return m.withProvenance(0, fA), nil
+		}
 
 		// The file is a directory in A, so we need to see if a directory with
 		// the same name exists in B.
-		fB, e := m.B.Open(path)
+		fB, e := m.openLayer(ctx, 1, path)
 		if e != nil {
 			if isBadPathError(e) {
 				// The file doesn't exist in B, so return the copy in A.
-				return fA, nil
+				traceStep(ctx, "decision", m.layerName(0), "using the "+
+					"directory, which doesn't exist in %s", m.layerName(1))
+				return m.withProvenance(0, fA), nil
 			}
 			// Treat any non-path errors in A or B as fatal.
 			fA.Close()
 			return nil, fmt.Errorf("Couldn't open %s in FS B: %w", path, e)
 		}
 		// Check if the file in B is a directory.
-		fileInfo, e = fB.Stat()
+		fileInfo, e = m.statLayerFile(1, fB, path)
 		if e != nil {
 			fA.Close()
 			fB.Close()
@@ -481,40 +906,95 @@ func (m *MergedFS) Open(path string) (fs.File, error) {
 			// The file wasn't a dir in B, so ignore it in favor of the dir in
 			// A.
 			fB.Close()
-			return fA, nil
+			traceStep(ctx, "decision", m.layerName(0), "using the "+
+				"directory, which shadows a file in %s", m.layerName(1))
+			return m.withProvenance(0, fA), nil
 		}
 		// Finally, we know that the file is a directory in both A and B, so
 		// return a MergedDirectory. This takes care of closing fA and fB.
-		return m.newMergedDirectory(fA, fB, path)
+		traceStep(ctx, "decision", "", "merging the directories in %s and "+
+			"%s", m.layerName(0), m.layerName(1))
+		d, e := m.newMergedDirectory(ctx, fA, fB, path)
+		if e != nil {
+			return nil, e
+		}
+		return m.cacheDirectory(path, d, generation), nil
 	}
 	if !isBadPathError(e) {
 		return nil, fmt.Errorf("Couldn't open %s in FS A: %w", path, e)
 	}
+	traceStep(ctx, "probe", m.layerName(0), "not found: %s", e)
 
 	// validatePathPrefix can be kind of expensive, so we'll try to open the
 	// file in m.B *first*. This prevents a possible DoS where someone requests
 	// paths that don't exist in either FS, but require checking and caching a
 	// bunch of pointless path prefixes.
-	fB, e := m.B.Open(path)
+	fB, e := m.openLayer(ctx, 1, path)
 	if e != nil {
+		traceStep(ctx, "probe", m.layerName(1), "not found: %s", e)
 		return nil, e
 	}
+	traceStep(ctx, "probe", m.layerName(1), "found")
 	// The file exists in B, so make sure a file in A doesn't override a
 	// directory in B, rendering this path unreachable.
-	e = m.validatePathPrefix(path)
+	e = m.validatePathPrefix(ctx, path)
 	if e != nil {
 		fB.Close()
-		return nil, &fs.PathError{"open", path, e}
+		return nil, &fs.PathError{Op: "open", Path: path, Err: e}
 	}
-	return fB, nil
+	traceStep(ctx, "decision", m.layerName(1), "using %s's copy",
+		m.layerName(1))
+	return m.withProvenance(1, fB), nil
 }
 
 // ReadFile reads the named file and returns the contents. A successful call
 // returns err == nil, not err == EOF. Because ReadFile reads the whole file, it
 // does not treat an EOF from Read as an error to be reported. This fulfills the
 // io/fs.ReadFileFS interface. https://pkg.go.dev/io/fs#ReadFileFS
+//
+// When no middleware, priority overrides, or aliases are installed, ReadFile
+// calls fs.ReadFile on the layer that serves the path, and returns the
+// resulting slice without copying it. Per the fs.ReadFileFS contract, the
+// caller may modify the returned slice, so layers implementing ReadFile must
+// return a slice they don't retain (as embed.FS and fstest.MapFS do).
 func (m *MergedFS) ReadFile(name string) ([]byte, error) {
-	f, err := m.Open(name)
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name,
+			Err: fs.ErrInvalid}
+	}
+	e := m.checkPathDepth("readfile", name)
+	if e != nil {
+		return nil, e
+	}
+	e = m.authorize(context.Background(), "readfile", name)
+	if e != nil {
+		return nil, e
+	}
+	audit := m.startAudit(context.Background(), "readfile", name)
+	// Nesting depth is only tracked by Open, so the direct path is only
+	// available without a nesting limit.
+	m.configMutex.RLock()
+	direct := (m.opener == nil) && (len(m.priorityOverrides) == 0) &&
+		(len(m.pins) == 0) && (len(m.conflictRules) == 0) &&
+		(len(m.aliases) == 0) && (atomic.LoadInt64(&m.maxNestingDepth) <= 0) &&
+		!m.resolvesAcrossLayers() && (audit == nil)
+	meter := m.readMeter
+	predictor := m.predictor
+	m.configMutex.RUnlock()
+	if direct {
+		data, ok, e := m.readFileDirect(name)
+		if ok {
+			if (e == nil) && (predictor != nil) {
+				m.speculate(predictor, name)
+			}
+			if meter != nil {
+				return meter.readAll(data, e)
+			}
+			return data, e
+		}
+	}
+
+	f, err := m.openAudited(context.Background(), name, audit)
 	if err != nil {
 		return nil, err
 	}
@@ -526,20 +1006,41 @@ func (m *MergedFS) ReadFile(name string) ([]byte, error) {
 	return io.ReadAll(f) // shortest
 }
 
-// Implements the FS interface, but provides a filesystem containing no files.
-// The only path you can "Open" is ".", which provides an empty directory.
-type EmptyFS struct{}
+// Reads the file at path from the given side of m using fs.ReadFile.
+func (m *MergedFS) readLayerFile(side int, path string) (data []byte,
+	e error) {
+	if m.recoveringPanics() {
+		defer m.recoverLayerPanic(side, "readfile", path, &e)
+	}
+	return fs.ReadFile(m.layer(side), path)
+}
 
-func (f *EmptyFS) Open(path string) (fs.File, error) {
-	if path != "." {
-		return nil, &fs.PathError{"open", path, fs.ErrNotExist}
+// Attempts to read the file at path directly from the layer that serves it.
+// Returns false if the file must be read by opening it instead, e.g. because
+// it's a directory in A.
+func (m *MergedFS) readFileDirect(path string) ([]byte, bool, error) {
+	m.checkGates()
+	data, e := m.readLayerFile(0, path)
+	if e == nil {
+		return data, true, nil
 	}
-	// Return an empty directory for "."
-	return &MergedDirectory{
-		name:    ".",
-		mode:    0444 | fs.ModeDir,
-		entries: nil,
-	}, nil
+	if !errors.Is(e, fs.ErrNotExist) {
+		// Among other things, this may happen if the path is a directory in
+		// A, so let Open sort it out.
+		return nil, false, nil
+	}
+	data, e = m.readLayerFile(1, path)
+	if e != nil {
+		if errors.Is(e, fs.ErrNotExist) {
+			return nil, true, e
+		}
+		return nil, false, nil
+	}
+	e = m.validatePathPrefix(context.Background(), path)
+	if e != nil {
+		return nil, true, e
+	}
+	return data, true, nil
 }
 
 // Used internally to build a balanced tree of merged filesystems. Must never
@@ -564,11 +1065,36 @@ func balancedMergeRecursive(content []fs.FS) fs.FS {
 // part of its path is a regular file in *any* higher-priority FS.  For now,
 // this function simply constructs a balanced tree of MergedFS instances. In
 // the future, it may use a different underlying implementation with the same
-// semantics. Returns a valid empty filesystem (see the EmptyFS type) if no
-// filesystem arguments are provided.
+// semantics. Returns Empty if no filesystem arguments are provided, and
+// returns the FS itself, without wrapping it, if only one is provided, so
+// merging a single layer adds no overhead.
+//
+// Nil filesystems are skipped, so optional layers can be passed without
+// checking them first. Note that this means the layer indices used by
+// functions such as AddPriorityOverride don't count the skipped layers.
 func MergeMultiple(filesystems ...fs.FS) fs.FS {
+	toMerge := make([]fs.FS, 0, len(filesystems))
+	for _, f := range filesystems {
+		if f != nil {
+			toMerge = append(toMerge, f)
+		}
+	}
+	filesystems = toMerge
 	if len(filesystems) == 0 {
-		return &EmptyFS{}
+		return Empty
 	}
 	return balancedMergeRecursive(filesystems)
 }
+
+// The same as MergeMultiple, except that it always returns a *MergedFS, so
+// the result has the same methods and optional interfaces (see Capabilities)
+// however many filesystems are merged. If fewer than two non-nil filesystems
+// are given, the merge is filled out using Empty, which is then included in
+// Layers.
+func MergeAll(filesystems ...fs.FS) *MergedFS {
+	merged := MergeMultiple(filesystems...)
+	if m, ok := merged.(*MergedFS); ok {
+		return m
+	}
+	return NewMergedFS(merged, Empty)
+}
@@ -149,6 +149,60 @@ func TestReadFileFS(t *testing.T) {
 	}
 }
 
+// An FS that records the last slice returned by ReadFile, so tests can check
+// that the slice wasn't copied.
+type lastReadFileFS struct {
+	fstest.MapFS
+	last []byte
+}
+
+func (f *lastReadFileFS) ReadFile(path string) ([]byte, error) {
+	data, e := f.MapFS.ReadFile(path)
+	f.last = data
+	return data, e
+}
+
+func TestReadFileNoCopy(t *testing.T) {
+	fsA := fstest.MapFS{
+		"a.txt":     newMapFile("A"),
+		"dir/a.txt": newMapFile("A"),
+		"file":      newMapFile("not a dir"),
+	}
+	fsB := &lastReadFileFS{
+		MapFS: fstest.MapFS{
+			"b.txt":      newMapFile("B"),
+			"dir":        newMapFile("not a dir"),
+			"file/b.txt": newMapFile("B"),
+		},
+	}
+	merged := NewMergedFS(fsA, fsB)
+	data, e := merged.ReadFile("b.txt")
+	if e != nil {
+		t.Logf("Failed reading b.txt: %s\n", e)
+		t.FailNow()
+	}
+	if &data[0] != &fsB.last[0] {
+		t.Logf("ReadFile copied the layer's slice unnecessarily.\n")
+		t.FailNow()
+	}
+	_, e = merged.ReadFile("file/b.txt")
+	if e == nil {
+		t.Logf("Didn't get expected error reading a file under a file in " +
+			"A.\n")
+		t.FailNow()
+	}
+	_, e = merged.ReadFile("dir")
+	if e == nil {
+		t.Logf("Didn't get expected error reading a directory in A.\n")
+		t.FailNow()
+	}
+	e = fstest.TestFS(merged, "a.txt", "b.txt", "dir/a.txt")
+	if e != nil {
+		t.Logf("Merged FS failed fstest: %s\n", e)
+		t.FailNow()
+	}
+}
+
 func TestGlob(t *testing.T) {
 	zip1 := openZip("test_data/test_a.zip", t)
 	zip2 := openZip("test_data/test_b.zip", t)
@@ -0,0 +1,145 @@
+package mergedtemplate
+
+import (
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yalue/merged_fs"
+)
+
+// The number of hex digits of a file's hash used to fingerprint its URL.
+const fingerprintLength = 12
+
+// A file's fingerprint, along with the info used to check that it's current.
+type assetFingerprint struct {
+	size        int64
+	modTime     time.Time
+	fingerprint string
+}
+
+// Provides template functions for linking to assets served from a merged FS.
+// Safe for concurrent use. See NewAssets.
+type Assets struct {
+	fsys      fs.FS
+	urlPrefix string
+	// Protects fingerprints.
+	mutex        sync.Mutex
+	fingerprints map[string]assetFingerprint
+}
+
+// Returns a new Assets, serving assets from fsys under the given URL prefix,
+// such as "/static/". The fsys is typically a MergedFS, or the FS returned by
+// its Compile method or merged_fs.OpenIndex, since those report provenance
+// without accessing any layer. Asset paths passed to the template functions
+// are paths within fsys.
+func NewAssets(fsys fs.FS, urlPrefix string) *Assets {
+	return &Assets{
+		fsys:         fsys,
+		urlPrefix:    urlPrefix,
+		fingerprints: make(map[string]assetFingerprint),
+	}
+}
+
+// Returns the entry for the asset at p, from its parent directory, so that it
+// includes provenance if fsys reports it.
+func (a *Assets) entry(p string) (fs.DirEntry, error) {
+	if !fs.ValidPath(p) || (p == ".") {
+		return nil, fmt.Errorf("Invalid asset path %q", p)
+	}
+	entries, e := fs.ReadDir(a.fsys, path.Dir(p))
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't find asset %s: %w", p, e)
+	}
+	name := path.Base(p)
+	for _, entry := range entries {
+		if entry.Name() != name {
+			continue
+		}
+		if entry.IsDir() {
+			return nil, fmt.Errorf("Asset %s is a directory", p)
+		}
+		return entry, nil
+	}
+	return nil, fmt.Errorf("Asset %s doesn't exist", p)
+}
+
+// Returns the URL for the asset at p, with a fingerprint of its content in
+// the query string, so that the URL changes whenever the content does, e.g.
+// "/static/app.js?v=0123456789ab". Fingerprints are hashes computed using
+// merged_fs.HashFile, so layers implementing merged_fs.HashFS don't need to be
+// read. They're cached until the file's size or modification time changes.
+func (a *Assets) AssetPath(p string) (string, error) {
+	info, e := fs.Stat(a.fsys, p)
+	if e != nil {
+		return "", fmt.Errorf("Couldn't find asset %s: %w", p, e)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("Asset %s is a directory", p)
+	}
+	a.mutex.Lock()
+	cached, ok := a.fingerprints[p]
+	a.mutex.Unlock()
+	if !ok || (cached.size != info.Size()) ||
+		!cached.modTime.Equal(info.ModTime()) {
+		sum, e := merged_fs.HashFile(a.fsys, p, "sha256")
+		if e != nil {
+			return "", fmt.Errorf("Couldn't fingerprint asset %s: %w", p, e)
+		}
+		fingerprint := hex.EncodeToString(sum)
+		if len(fingerprint) > fingerprintLength {
+			fingerprint = fingerprint[:fingerprintLength]
+		}
+		cached = assetFingerprint{
+			size:        info.Size(),
+			modTime:     info.ModTime(),
+			fingerprint: fingerprint,
+		}
+		a.mutex.Lock()
+		a.fingerprints[p] = cached
+		a.mutex.Unlock()
+	}
+	return a.urlPrefix + p + "?v=" + cached.fingerprint, nil
+}
+
+// Returns the name of the layer providing the asset at p, as reported by
+// merged_fs.ProvenanceEntry. If the layer isn't a named *merged_fs.Layer,
+// returns its index instead, e.g. "2". Returns an empty string if fsys
+// doesn't report provenance for the asset, e.g. because it's an alias.
+func (a *Assets) AssetSource(p string) (string, error) {
+	entry, e := a.entry(p)
+	if e != nil {
+		return "", e
+	}
+	provenance, ok := entry.(merged_fs.ProvenanceEntry)
+	if !ok {
+		return "", nil
+	}
+	index, name := provenance.Provenance()
+	if name != "" {
+		return name, nil
+	}
+	return strconv.Itoa(index), nil
+}
+
+// Returns template functions named "assetPath" and "assetSource", which call
+// AssetPath and AssetSource, respectively:
+//
+//	tmpl := htmltemplate.New("").Funcs(assets.FuncMap())
+//	// In a template:
+//	// <script src="{{assetPath "js/app.js"}}"></script>
+//	// <!-- from {{assetSource "js/app.js"}} -->
+//
+// The map can be converted to a texttemplate.FuncMap for use with
+// text/template.
+func (a *Assets) FuncMap() htmltemplate.FuncMap {
+	return htmltemplate.FuncMap{
+		"assetPath":   a.AssetPath,
+		"assetSource": a.AssetSource,
+	}
+}
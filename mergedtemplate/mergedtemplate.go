@@ -0,0 +1,91 @@
+// The mergedtemplate package provides helpers for parsing html/template and
+// text/template templates from a merged filesystem. It's a separate package so
+// that users of merged_fs who don't need templates don't need to import the
+// template packages.
+//
+// The main difference from the standard library's ParseFS functions is that
+// templates are named using their full paths (e.g. "layouts/base.html") rather
+// than their base names. In a layered template tree, it's common for files in
+// different directories to share a base name, and naming them by base name
+// would cause later files to silently replace earlier ones.
+//
+// The package also provides template functions for linking to assets in a
+// merged FS, with cache-busting fingerprints; see Assets.
+package mergedtemplate
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"sort"
+	texttemplate "text/template"
+)
+
+// Returns the sorted, de-duplicated list of paths in fsys matching any of the
+// given patterns, using the syntax of fs.Glob. Unlike ParseFS in the template
+// packages, it's not an error for an individual pattern to match nothing, but
+// it is an error if no patterns match anything.
+func Glob(fsys fs.FS, patterns ...string) ([]string, error) {
+	seen := make(map[string]bool)
+	var toReturn []string
+	for _, pattern := range patterns {
+		matches, e := fs.Glob(fsys, pattern)
+		if e != nil {
+			return nil, fmt.Errorf("Error matching pattern %q: %w", pattern, e)
+		}
+		for _, m := range matches {
+			if seen[m] {
+				continue
+			}
+			seen[m] = true
+			toReturn = append(toReturn, m)
+		}
+	}
+	if len(toReturn) == 0 {
+		return nil, fmt.Errorf("No files match the patterns %q", patterns)
+	}
+	sort.Strings(toReturn)
+	return toReturn, nil
+}
+
+// Parses the files in fsys matching the given patterns into t, which must not
+// be nil. Each file becomes a template named by its full path within fsys.
+// Returns t on success.
+func ParseMerged(t *htmltemplate.Template, fsys fs.FS,
+	patterns ...string) (*htmltemplate.Template, error) {
+	paths, e := Glob(fsys, patterns...)
+	if e != nil {
+		return nil, e
+	}
+	for _, p := range paths {
+		content, e := fs.ReadFile(fsys, p)
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading template %s: %w", p, e)
+		}
+		_, e = t.New(p).Parse(string(content))
+		if e != nil {
+			return nil, e
+		}
+	}
+	return t, nil
+}
+
+// The same as ParseMerged, but for text/template templates.
+func ParseMergedText(t *texttemplate.Template, fsys fs.FS,
+	patterns ...string) (*texttemplate.Template, error) {
+	paths, e := Glob(fsys, patterns...)
+	if e != nil {
+		return nil, e
+	}
+	for _, p := range paths {
+		content, e := fs.ReadFile(fsys, p)
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading template %s: %w", p, e)
+		}
+		_, e = t.New(p).Parse(string(content))
+		if e != nil {
+			return nil, e
+		}
+	}
+	return t, nil
+}
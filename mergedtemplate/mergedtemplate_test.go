@@ -0,0 +1,188 @@
+package mergedtemplate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	htmltemplate "html/template"
+	"testing"
+	"testing/fstest"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/yalue/merged_fs"
+)
+
+func newMapFile(content string) *fstest.MapFile {
+	return &fstest.MapFile{
+		Data: []byte(content),
+		Mode: 0666,
+	}
+}
+
+func testFS() *merged_fs.MergedFS {
+	theme := fstest.MapFS{
+		"pages/index.html": newMapFile(`{{template "parts/header.html" .}}` +
+			`theme index`),
+	}
+	base := fstest.MapFS{
+		"pages/index.html":  newMapFile("base index"),
+		"parts/header.html": newMapFile("<h1>{{.}}</h1>"),
+		"parts/index.html":  newMapFile("a part with a conflicting name"),
+	}
+	return merged_fs.NewMergedFS(theme, base)
+}
+
+func TestParseMerged(t *testing.T) {
+	tmpl, e := ParseMerged(htmltemplate.New("root"), testFS(), "pages/*.html",
+		"parts/*.html", "nothing/*.html")
+	if e != nil {
+		t.Logf("Failed parsing templates: %s\n", e)
+		t.FailNow()
+	}
+	output := &bytes.Buffer{}
+	e = tmpl.ExecuteTemplate(output, "pages/index.html", "<hi>")
+	if e != nil {
+		t.Logf("Failed executing template: %s\n", e)
+		t.FailNow()
+	}
+	expected := "<h1>&lt;hi&gt;</h1>theme index"
+	if output.String() != expected {
+		t.Logf("Got output %q, expected %q\n", output.String(), expected)
+		t.FailNow()
+	}
+	if tmpl.Lookup("parts/index.html") == nil {
+		t.Logf("Missing template with a duplicate base name.\n")
+		t.FailNow()
+	}
+
+	_, e = ParseMerged(htmltemplate.New("root"), testFS(), "nothing/*")
+	if e == nil {
+		t.Logf("Didn't get expected error when nothing matched.\n")
+		t.FailNow()
+	}
+}
+
+func TestParseMergedText(t *testing.T) {
+	tmpl, e := ParseMergedText(texttemplate.New("root"), testFS(), "*/*.html")
+	if e != nil {
+		t.Logf("Failed parsing templates: %s\n", e)
+		t.FailNow()
+	}
+	output := &bytes.Buffer{}
+	e = tmpl.ExecuteTemplate(output, "pages/index.html", "<hi>")
+	if e != nil {
+		t.Logf("Failed executing template: %s\n", e)
+		t.FailNow()
+	}
+	if output.String() != "<h1><hi></h1>theme index" {
+		t.Logf("Got incorrect output: %q\n", output.String())
+		t.FailNow()
+	}
+}
+
+func TestReloader(t *testing.T) {
+	files := fstest.MapFS{
+		"index.html": &fstest.MapFile{
+			Data:    []byte("version 1"),
+			ModTime: time.Now().Add(-time.Hour),
+		},
+	}
+	merged := merged_fs.NewMergedFS(files, fstest.MapFS{})
+	reloader, e := NewReloader(merged, nil, "*.html")
+	if e != nil {
+		t.Logf("Failed creating reloader: %s\n", e)
+		t.FailNow()
+	}
+	tmpl1, e := reloader.Template()
+	if e != nil {
+		t.Logf("Failed getting templates: %s\n", e)
+		t.FailNow()
+	}
+	tmpl2, _ := reloader.Template()
+	if tmpl1 != tmpl2 {
+		t.Logf("Templates were re-parsed without any changes.\n")
+		t.FailNow()
+	}
+	files["index.html"] = &fstest.MapFile{
+		Data:    []byte("version 2"),
+		ModTime: time.Now().Add(time.Minute),
+	}
+	tmpl2, e = reloader.Template()
+	if e != nil {
+		t.Logf("Failed getting reloaded templates: %s\n", e)
+		t.FailNow()
+	}
+	output := &bytes.Buffer{}
+	tmpl2.ExecuteTemplate(output, "index.html", nil)
+	if output.String() != "version 2" {
+		t.Logf("Templates weren't reloaded. Got output %q\n", output.String())
+		t.FailNow()
+	}
+}
+
+func TestAssets(t *testing.T) {
+	theme := &merged_fs.Layer{
+		Name: "theme",
+		FS:   fstest.MapFS{"css/site.css": newMapFile("theme css")},
+	}
+	base := fstest.MapFS{
+		"css/site.css": newMapFile("base css"),
+		"js/app.js":    newMapFile("app"),
+	}
+	assets := NewAssets(merged_fs.NewMergedFS(theme, base), "/static/")
+	tmpl, e := htmltemplate.New("page").Funcs(assets.FuncMap()).Parse(
+		`<link href="{{assetPath "css/site.css"}}">` +
+			`{{assetSource "css/site.css"}} {{assetSource "js/app.js"}}`)
+	if e != nil {
+		t.Logf("Failed parsing template: %s\n", e)
+		t.FailNow()
+	}
+	output := &bytes.Buffer{}
+	e = tmpl.Execute(output, nil)
+	if e != nil {
+		t.Logf("Failed executing template: %s\n", e)
+		t.FailNow()
+	}
+	sum := sha256.Sum256([]byte("theme css"))
+	expected := `<link href="/static/css/site.css?v=` +
+		hex.EncodeToString(sum[:])[:12] + `">theme 1`
+	if output.String() != expected {
+		t.Logf("Got output %q, expected %q\n", output.String(), expected)
+		t.FailNow()
+	}
+
+	// Fingerprints must change when the content does.
+	base["js/app.js"] = &fstest.MapFile{
+		Data:    []byte("app v1"),
+		ModTime: time.Now().Add(-time.Hour),
+	}
+	first, e := assets.AssetPath("js/app.js")
+	if e != nil {
+		t.Logf("Failed getting asset path: %s\n", e)
+		t.FailNow()
+	}
+	base["js/app.js"] = &fstest.MapFile{
+		Data:    []byte("app v2"),
+		ModTime: time.Now(),
+	}
+	second, e := assets.AssetPath("js/app.js")
+	if e != nil {
+		t.Logf("Failed getting asset path: %s\n", e)
+		t.FailNow()
+	}
+	if first == second {
+		t.Logf("Asset path didn't change with its content: %s\n", first)
+		t.FailNow()
+	}
+	_, e = assets.AssetPath("missing.js")
+	if e == nil {
+		t.Logf("Didn't get an error for a missing asset\n")
+		t.FailNow()
+	}
+	_, e = assets.AssetSource("css")
+	if e == nil {
+		t.Logf("Didn't get an error for a directory\n")
+		t.FailNow()
+	}
+}
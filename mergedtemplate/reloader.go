@@ -0,0 +1,84 @@
+package mergedtemplate
+
+import (
+	htmltemplate "html/template"
+	"sync"
+	"time"
+
+	"github.com/yalue/merged_fs"
+)
+
+// A Reloader holds html templates parsed from a MergedFS, and re-parses them
+// if any files in the MergedFS have changed, according to
+// MergedFS.ChangedSince. It's intended for development servers, where
+// templates are edited while the server is running. Safe for concurrent use.
+type Reloader struct {
+	fsys     *merged_fs.MergedFS
+	patterns []string
+	// Called to create the new root template before each parse.
+	newRoot func() *htmltemplate.Template
+	// Protects the fields below.
+	mutex    sync.Mutex
+	tmpl     *htmltemplate.Template
+	parsedAt time.Time
+}
+
+// Returns a new Reloader, which parses the files in fsys matching the given
+// patterns using ParseMerged. The newRoot function is called to create the
+// template into which the files will be parsed, e.g. to set Funcs or Delims;
+// if it's nil then htmltemplate.New("") is used. Returns an error if the
+// initial parse fails.
+func NewReloader(fsys *merged_fs.MergedFS,
+	newRoot func() *htmltemplate.Template, patterns ...string) (*Reloader,
+	error) {
+	if newRoot == nil {
+		newRoot = func() *htmltemplate.Template {
+			return htmltemplate.New("")
+		}
+	}
+	toReturn := &Reloader{
+		fsys:     fsys,
+		patterns: patterns,
+		newRoot:  newRoot,
+	}
+	e := toReturn.parse()
+	if e != nil {
+		return nil, e
+	}
+	return toReturn, nil
+}
+
+// Parses the templates. Must be called while holding r.mutex, if r may be
+// accessed concurrently.
+func (r *Reloader) parse() error {
+	// Record the time before parsing, so that we don't miss changes made
+	// during the parse.
+	start := time.Now()
+	tmpl, e := ParseMerged(r.newRoot(), r.fsys, r.patterns...)
+	if e != nil {
+		return e
+	}
+	r.tmpl = tmpl
+	r.parsedAt = start
+	return nil
+}
+
+// Returns the parsed templates, first re-parsing them if any files have
+// changed since they were last parsed. If re-parsing fails, this returns the
+// error; the previously parsed templates will be kept, and re-parsing will be
+// attempted again on the next call.
+func (r *Reloader) Template() (*htmltemplate.Template, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	changed, e := r.fsys.ChangedSince(r.parsedAt)
+	if e != nil {
+		return nil, e
+	}
+	if len(changed) != 0 {
+		e = r.parse()
+		if e != nil {
+			return nil, e
+		}
+	}
+	return r.tmpl, nil
+}
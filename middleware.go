@@ -0,0 +1,45 @@
+package merged_fs
+
+import (
+	"context"
+	"io/fs"
+)
+
+// An Opener opens a path, in the same manner as fs.FS's Open function. The
+// context is the one passed to OpenContext, or context.Background() for Open
+// and other methods that don't take one.
+type Opener func(ctx context.Context, path string) (fs.File, error)
+
+// A Middleware wraps an Opener, returning a new Opener that may run arbitrary
+// code before or after calling next. Middleware may, for example, log requests,
+// record metrics, reject certain paths, or wrap the returned fs.File. It should
+// pass the context it receives, or one derived from it, on to next. It must be
+// safe to call the returned Opener from multiple goroutines.
+type Middleware func(next Opener) Opener
+
+// Installs one or more middleware functions around m.Open. Middleware installed
+// by earlier calls to Use (or earlier in the list of arguments) is "outermost",
+// meaning that it runs first and receives the results of later middleware.
+//
+// Middleware only applies to the MergedFS that Use was called on, and not to
+// any MergedFS instances nested within it; calling Use on the value returned
+// by MergeMultiple is sufficient to intercept every Open. Each Middleware is
+// called once, when it's installed, rather than for every Open.
+func (m *MergedFS) Use(middleware ...Middleware) {
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+	// Middleware from earlier calls must remain outermost, so we rebuild the
+	// entire chain starting from the innermost function.
+	m.middleware = append(m.middleware, middleware...)
+	m.opener = m.buildChain()
+}
+
+// Returns an Opener that runs m's middleware around m.openInternal. Only call
+// this while holding m.configMutex.
+func (m *MergedFS) buildChain() Opener {
+	opener := Opener(m.openInternal)
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		opener = m.middleware[i](opener)
+	}
+	return opener
+}
@@ -0,0 +1,88 @@
+package merged_fs
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMiddleware(t *testing.T) {
+	zip1 := openZip("test_data/test_a.zip", t)
+	zip2 := openZip("test_data/test_b.zip", t)
+	zip3 := openZip("test_data/test_c.zip", t)
+	merged := MergeMultiple(zip1, zip2, zip3).(*MergedFS)
+
+	// Records the order in which middleware runs, and counts the opens.
+	var order []string
+	opens := 0
+	merged.Use(func(next Opener) Opener {
+		return func(ctx context.Context, path string) (fs.File, error) {
+			order = append(order, "outer")
+			opens++
+			return next(ctx, path)
+		}
+	}, func(next Opener) Opener {
+		return func(ctx context.Context, path string) (fs.File, error) {
+			order = append(order, "inner")
+			return next(ctx, path)
+		}
+	})
+	// A deny-list middleware installed later must run after the others.
+	merged.Use(func(next Opener) Opener {
+		return func(ctx context.Context, path string) (fs.File, error) {
+			order = append(order, "deny")
+			if strings.HasPrefix(path, "b/") {
+				return nil, &fs.PathError{Op: "open", Path: path,
+					Err: fs.ErrPermission}
+			}
+			return next(ctx, path)
+		}
+	})
+
+	f, e := merged.Open("test1.txt")
+	if e != nil {
+		t.Logf("Failed opening test1.txt: %s\n", e)
+		t.FailNow()
+	}
+	f.Close()
+	if strings.Join(order, ",") != "outer,inner,deny" {
+		t.Logf("Middleware ran in the wrong order: %v\n", order)
+		t.FailNow()
+	}
+	_, e = merged.Open("b/0.txt")
+	if e == nil {
+		t.Logf("Didn't get expected error from deny-list middleware.\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error from middleware: %s\n", e)
+
+	// ReadFile must go through the middleware, too.
+	opens = 0
+	_, e = merged.ReadFile("test2.txt")
+	if e != nil {
+		t.Logf("Failed reading test2.txt: %s\n", e)
+		t.FailNow()
+	}
+	if opens != 1 {
+		t.Logf("Expected ReadFile to run middleware once, got %d.\n", opens)
+		t.FailNow()
+	}
+}
+
+func TestMiddlewareFSCompliance(t *testing.T) {
+	zip1 := openZip("test_data/test_a.zip", t)
+	zip2 := openZip("test_data/test_b.zip", t)
+	merged := NewMergedFS(zip1, zip2)
+	merged.Use(func(next Opener) Opener {
+		return func(ctx context.Context, path string) (fs.File, error) {
+			return next(ctx, path)
+		}
+	})
+	e := fstest.TestFS(merged, "test1.txt", "test2.txt", "b/1.txt", "a")
+	if e != nil {
+		t.Logf("TestFS failed with pass-through middleware: %s\n", e)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,40 @@
+package merged_fs
+
+import (
+	"io/fs"
+)
+
+// MappedFile may be implemented by regular files that can provide their
+// entire contents as a byte slice without copying, typically because the
+// contents are memory mapped. Files returned by a MergedFS or Layer implement
+// MappedFile whenever the underlying file does, so callers serving large
+// files can check for it and avoid copying data through Read.
+type MappedFile interface {
+	fs.File
+	// Returns the file's contents. The caller must not modify the returned
+	// slice, which is only valid until the file is closed.
+	Mapped() ([]byte, error)
+}
+
+// The non-File half of MappedFile.
+type mapper interface {
+	Mapped() ([]byte, error)
+}
+
+// Provides a metered Mapped function for a meteredFile whose underlying File
+// supports it. The entire mapping counts as having been read.
+type meteredMapper struct {
+	f *meteredFile
+}
+
+func (m meteredMapper) Mapped() ([]byte, error) {
+	data, e := m.f.File.(mapper).Mapped()
+	if e != nil {
+		return nil, e
+	}
+	e = reserveAll(m.f.meters, len(data))
+	if e != nil {
+		return nil, e
+	}
+	return data, nil
+}
@@ -0,0 +1,79 @@
+package merged_fs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// Wraps a MapFS, returning files that implement MappedFile.
+type mappingFS struct {
+	fstest.MapFS
+}
+
+type mappingFile struct {
+	fs.File
+	data []byte
+}
+
+func (f *mappingFile) Mapped() ([]byte, error) {
+	return f.data, nil
+}
+
+func (m mappingFS) Open(path string) (fs.File, error) {
+	f, e := m.MapFS.Open(path)
+	if (e != nil) || (m.MapFS[path] == nil) || m.MapFS[path].Mode.IsDir() {
+		return f, e
+	}
+	return &mappingFile{f, m.MapFS[path].Data}, nil
+}
+
+func TestMappedFile(t *testing.T) {
+	fsA := mappingFS{fstest.MapFS{"big.bin": newMapFile("0123456789")}}
+	fsB := fstest.MapFS{"small.txt": newMapFile("small")}
+	merged := NewMergedFS(&Layer{FS: fsA}, fsB)
+	merged.TrackOpenFiles(true, nil)
+	merged.SetReadQuota(15)
+	f, e := merged.Open("big.bin")
+	if e != nil {
+		t.Logf("Failed opening big.bin: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	mapped, ok := f.(MappedFile)
+	if !ok {
+		t.Logf("The MappedFile interface wasn't preserved.\n")
+		t.FailNow()
+	}
+	data, e := mapped.Mapped()
+	if e != nil {
+		t.Logf("Failed getting mapped data: %s\n", e)
+		t.FailNow()
+	}
+	if &data[0] != &fsA.MapFS["big.bin"].Data[0] {
+		t.Logf("Mapped data was copied.\n")
+		t.FailNow()
+	}
+	if merged.BytesRead() != 10 {
+		t.Logf("Expected mapping to count as 10 bytes read, got %d\n",
+			merged.BytesRead())
+		t.FailNow()
+	}
+	_, e = mapped.Mapped()
+	if !errors.Is(e, ErrQuotaExceeded) {
+		t.Logf("Didn't get expected quota error, got %v\n", e)
+		t.FailNow()
+	}
+
+	f2, e := merged.Open("small.txt")
+	if e != nil {
+		t.Logf("Failed opening small.txt: %s\n", e)
+		t.FailNow()
+	}
+	defer f2.Close()
+	if _, ok := f2.(MappedFile); ok {
+		t.Logf("A file that can't be mapped implements MappedFile.\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,214 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// Presents an FS's contents in a subdirectory. See Mount.
+type mountFS struct {
+	fsys fs.FS
+	// The directory at which fsys's contents appear. Never ".".
+	prefix string
+}
+
+// Returns an FS containing the contents of fsys at the given prefix. For
+// example, if fsys contains "a.txt", then Mount(fsys, "x/y") returns an FS
+// containing "x/y/a.txt". The directories leading up to the prefix ("." and
+// "x" in the example) are synthesized read-only directories containing only the
+// next directory in the prefix. Returns fsys itself if prefix is "." or "".
+// Returns an error if prefix isn't a valid path.
+//
+// Mounts compose predictably with fs.Sub and with each other:
+//
+//   - fs.Sub(Mount(x, p), p) returns x itself, and fs.Sub(Mount(x, p), d)
+//     returns fs.Sub(x, d') or Mount(x, p') when d is within p, or leads up
+//     to it, respectively, where d' and p' are the rest of d or p.
+//   - Mount(Mount(x, p), q) is the same as Mount(x, path.Join(q, p)).
+//   - A MergedFS doesn't change paths, so fs.Sub(MergeMultiple(Mount(x, p),
+//     Mount(y, p)), p) has the same contents as MergeMultiple(x, y), and
+//     Mount(MergeMultiple(x, y), p) the same contents as
+//     MergeMultiple(Mount(x, p), Mount(y, p)). Note that the layers of a
+//     MergedFS aren't rewritten, so they're still mounted layers.
+//
+// See Rebase to move an FS's contents from one prefix to another.
+func Mount(fsys fs.FS, prefix string) (fs.FS, error) {
+	if (prefix == "") || (prefix == ".") {
+		return fsys, nil
+	}
+	if !fs.ValidPath(prefix) {
+		return nil, fmt.Errorf("Invalid mount point %q: %w", prefix,
+			fs.ErrInvalid)
+	}
+	if inner, ok := fsys.(*mountFS); ok {
+		return &mountFS{
+			fsys:   inner.fsys,
+			prefix: prefix + "/" + inner.prefix,
+		}, nil
+	}
+	return &mountFS{
+		fsys:   fsys,
+		prefix: prefix,
+	}, nil
+}
+
+// Returns the metadata for the mounted FS's root directory, renamed to match
+// the final component of the prefix.
+func (m *mountFS) rootInfo() (fs.FileInfo, error) {
+	info, e := fs.Stat(m.fsys, ".")
+	if e != nil {
+		return nil, e
+	}
+	return renamedInfo{info, baseName(m.prefix)}, nil
+}
+
+func (m *mountFS) Open(path string) (fs.File, error) {
+	if !fs.ValidPath(path) {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrInvalid}
+	}
+	if path == m.prefix {
+		f, e := m.fsys.Open(".")
+		if e != nil {
+			return nil, e
+		}
+		return renameFile(f, baseName(path)), nil
+	}
+	if strings.HasPrefix(path, m.prefix+"/") {
+		return m.fsys.Open(path[len(m.prefix)+1:])
+	}
+	// The only other paths that exist are directories leading up to the
+	// prefix.
+	var child string
+	if path == "." {
+		child = m.prefix
+	} else if strings.HasPrefix(m.prefix, path+"/") {
+		child = m.prefix[len(path)+1:]
+	} else {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	childName, _ := SplitFirst(child)
+	var entry fs.DirEntry
+	if childName == child {
+		// The child is the mount point itself, so its entry must match its
+		// real metadata.
+		info, e := m.rootInfo()
+		if e != nil {
+			return nil, e
+		}
+		entry = infoDirEntry{info}
+	} else {
+		entry = newSyntheticDir(childName)
+	}
+	d := newSyntheticDir(baseName(path))
+	d.entries = []fs.DirEntry{entry}
+	return d, nil
+}
+
+// Implements fs.SubFS, so that fs.Sub undoes a mount rather than wrapping
+// it again. See Mount.
+func (m *mountFS) Sub(dir string) (fs.FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	switch {
+	case dir == ".":
+		return m, nil
+	case dir == m.prefix:
+		return m.fsys, nil
+	case strings.HasPrefix(dir, m.prefix+"/"):
+		return fs.Sub(m.fsys, dir[len(m.prefix)+1:])
+	case strings.HasPrefix(m.prefix, dir+"/"):
+		return Mount(m.fsys, m.prefix[len(dir)+1:])
+	}
+	return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrNotExist}
+}
+
+// Returns an FS containing the contents of the directory from in fsys at the
+// prefix to, and nothing else. It's the same as Mount(fs.Sub(fsys, from), to),
+// so rebasing a mounted FS from its mount point returns a mount of the
+// original FS, rather than wrapping it twice. An empty string or "." in
+// either argument refers to the root. For example, to serve a MergedFS's
+// "v1" directory under "api/v2":
+//
+//	rebased, e := Rebase(merged, "v1", "api/v2")
+//
+// Returns an error if either path is invalid.
+func Rebase(fsys fs.FS, from, to string) (fs.FS, error) {
+	if (from != "") && (from != ".") {
+		sub, e := fs.Sub(fsys, from)
+		if e != nil {
+			return nil, e
+		}
+		fsys = sub
+	}
+	return Mount(fsys, to)
+}
+
+// Merges several filesystems, each mounted at a prefix given by its key in the
+// map. For example, the following produces an FS with the contents of
+// staticFS under "static", mediaFS under "media", and templatesFS at the root:
+//
+//	merged, e := NewFromMap(map[string]fs.FS{
+//		"static": staticFS,
+//		"media":  mediaFS,
+//		"":       templatesFS,
+//	})
+//
+// The empty string and "." both refer to the root. When paths from more than
+// one FS conflict, the FS with the longer mount point (measured in path
+// components) takes priority, as it's the more specific of the two. For
+// example, if templatesFS above contained "static/style.css", it would only be
+// visible if staticFS didn't contain "style.css". Mount points with the same
+// length can't conflict, but are merged in lexicographic order for
+// determinism.
+//
+// Returns an error if any key isn't a valid path, if two keys refer to the
+// same mount point, or if any value is nil. Returns an empty FS if the map is
+// empty.
+func NewFromMap(mounts map[string]fs.FS) (fs.FS, error) {
+	type mountPoint struct {
+		prefix     string
+		components int
+		fsys       fs.FS
+	}
+	points := make([]mountPoint, 0, len(mounts))
+	seen := make(map[string]bool)
+	for prefix, fsys := range mounts {
+		if prefix == "" {
+			prefix = "."
+		}
+		if seen[prefix] {
+			return nil, fmt.Errorf("Multiple FSs are mounted at %q", prefix)
+		}
+		seen[prefix] = true
+		if fsys == nil {
+			return nil, fmt.Errorf("The FS mounted at %q is nil", prefix)
+		}
+		mounted, e := Mount(fsys, prefix)
+		if e != nil {
+			return nil, e
+		}
+		components := 0
+		if prefix != "." {
+			components = strings.Count(prefix, "/") + 1
+		}
+		points = append(points, mountPoint{
+			prefix:     prefix,
+			components: components,
+			fsys:       mounted,
+		})
+	}
+	sort.Slice(points, func(a, b int) bool {
+		if points[a].components != points[b].components {
+			return points[a].components > points[b].components
+		}
+		return points[a].prefix < points[b].prefix
+	})
+	filesystems := make([]fs.FS, len(points))
+	for i := range points {
+		filesystems[i] = points[i].fsys
+	}
+	return MergeMultiple(filesystems...), nil
+}
@@ -0,0 +1,231 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMount(t *testing.T) {
+	fsA := fstest.MapFS{"a.txt": newMapFile("in A")}
+	mounted, e := Mount(fsA, "x/y")
+	if e != nil {
+		t.Logf("Failed mounting FS: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(mounted, "x/y/a.txt")
+	if e != nil {
+		t.Logf("TestFS failed for mounted FS: %s\n", e)
+		t.FailNow()
+	}
+	_, e = Mount(fsA, "/bad")
+	if e == nil {
+		t.Logf("Didn't get expected error for invalid mount point.\n")
+		t.FailNow()
+	}
+}
+
+func TestNewFromMap(t *testing.T) {
+	static := fstest.MapFS{
+		"style.css": newMapFile("static style"),
+		"app.js":    newMapFile("app"),
+	}
+	media := fstest.MapFS{"cat.png": newMapFile("meow")}
+	templates := fstest.MapFS{
+		"index.html":       newMapFile("index"),
+		"static/style.css": newMapFile("overridden"),
+		"static/other.css": newMapFile("other"),
+	}
+	merged, e := NewFromMap(map[string]fs.FS{
+		"static":       static,
+		"assets/media": media,
+		"":             templates,
+	})
+	if e != nil {
+		t.Logf("Failed creating FS from map: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(merged, "static/style.css", "static/app.js",
+		"static/other.css", "assets/media/cat.png", "index.html")
+	if e != nil {
+		t.Logf("TestFS failed for FS created from map: %s\n", e)
+		t.FailNow()
+	}
+	content, e := fs.ReadFile(merged, "static/style.css")
+	if e != nil {
+		t.Logf("Failed reading static/style.css: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "static style" {
+		t.Logf("The more specific mount point didn't take priority. Got "+
+			"content %q.\n", content)
+		t.FailNow()
+	}
+
+	_, e = NewFromMap(map[string]fs.FS{
+		"":  static,
+		".": media,
+	})
+	if e == nil {
+		t.Logf("Didn't get expected error for duplicate mount points.\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error for duplicate mount points: %s\n", e)
+
+	_, e = NewFromMap(map[string]fs.FS{
+		"static": static,
+		"media":  nil,
+	})
+	if (e == nil) || !strings.Contains(e.Error(), `"media"`) {
+		t.Logf("Didn't get expected error for a nil FS: %v\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected error for a nil FS: %s\n", e)
+}
+
+// Returns the content of every regular file in fsys, and "<dir>" for every
+// directory, keyed by path.
+func fsContents(t *testing.T, fsys fs.FS) map[string]string {
+	toReturn := make(map[string]string)
+	e := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if d.IsDir() {
+			toReturn[p] = "<dir>"
+			return nil
+		}
+		content, e := fs.ReadFile(fsys, p)
+		toReturn[p] = string(content)
+		return e
+	})
+	if e != nil {
+		t.Logf("Failed walking FS: %s\n", e)
+		t.FailNow()
+	}
+	return toReturn
+}
+
+// Fails the test if a and b don't have the same contents.
+func expectSameContents(t *testing.T, a, b fs.FS, description string) {
+	contentsA := fsContents(t, a)
+	contentsB := fsContents(t, b)
+	if len(contentsA) != len(contentsB) {
+		t.Logf("%s: expected %v, got %v\n", description, contentsB,
+			contentsA)
+		t.FailNow()
+	}
+	for p, content := range contentsB {
+		if contentsA[p] != content {
+			t.Logf("%s: expected %v, got %v\n", description, contentsB,
+				contentsA)
+			t.FailNow()
+		}
+	}
+}
+
+func TestMountSub(t *testing.T) {
+	x := fstest.MapFS{
+		"a.txt":     newMapFile("x a"),
+		"dir/b.txt": newMapFile("x b"),
+	}
+	y := fstest.MapFS{
+		"a.txt":     newMapFile("y a"),
+		"dir/c.txt": newMapFile("y c"),
+	}
+	mounted, e := Mount(x, "p/q")
+	if e != nil {
+		t.Logf("Failed mounting FS: %s\n", e)
+		t.FailNow()
+	}
+	sub, e := fs.Sub(mounted, "p/q")
+	if e != nil {
+		t.Logf("Failed getting sub FS: %s\n", e)
+		t.FailNow()
+	}
+	if _, ok := sub.(fstest.MapFS); !ok {
+		t.Logf("Sub of a mount didn't return the original FS: %T\n", sub)
+		t.FailNow()
+	}
+	sub, e = fs.Sub(mounted, "p")
+	if e != nil {
+		t.Logf("Failed getting sub FS of p: %s\n", e)
+		t.FailNow()
+	}
+	expected, _ := Mount(x, "q")
+	expectSameContents(t, sub, expected, "Sub leading up to the mount")
+	sub, e = fs.Sub(mounted, "p/q/dir")
+	if e != nil {
+		t.Logf("Failed getting sub FS of p/q/dir: %s\n", e)
+		t.FailNow()
+	}
+	expected, _ = fs.Sub(x, "dir")
+	expectSameContents(t, sub, expected, "Sub within the mount")
+	_, e = fs.Sub(mounted, "other")
+	if e == nil {
+		t.Logf("Didn't get expected error for Sub outside the mount\n")
+		t.FailNow()
+	}
+
+	nested, _ := Mount(x, "q")
+	nested, _ = Mount(nested, "p")
+	expectSameContents(t, nested, mounted, "Nested mounts")
+	if inner, ok := nested.(*mountFS); !ok || (inner.prefix != "p/q") {
+		t.Logf("Nested mounts weren't combined: %#v\n", nested)
+		t.FailNow()
+	}
+
+	mountedY, _ := Mount(y, "p/q")
+	sub, e = fs.Sub(MergeMultiple(mounted, mountedY), "p/q")
+	if e != nil {
+		t.Logf("Failed getting sub FS of merged mounts: %s\n", e)
+		t.FailNow()
+	}
+	expectSameContents(t, sub, MergeMultiple(x, y), "Sub of merged mounts")
+	merged, _ := Mount(MergeMultiple(x, y), "p/q")
+	expectSameContents(t, merged, MergeMultiple(mounted, mountedY),
+		"Mount of a merge")
+	e = fstest.TestFS(sub, "a.txt", "dir/b.txt", "dir/c.txt")
+	if e != nil {
+		t.Logf("TestFS failed for sub FS of merged mounts: %s\n", e)
+		t.FailNow()
+	}
+}
+
+func TestRebase(t *testing.T) {
+	x := fstest.MapFS{"v1/a.txt": newMapFile("a")}
+	rebased, e := Rebase(x, "v1", "api/v2")
+	if e != nil {
+		t.Logf("Failed rebasing FS: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(rebased, "api/v2/a.txt")
+	if e != nil {
+		t.Logf("TestFS failed for rebased FS: %s\n", e)
+		t.FailNow()
+	}
+	mounted, _ := Mount(x, "old")
+	rebased, e = Rebase(mounted, "old", "new")
+	if e != nil {
+		t.Logf("Failed rebasing mounted FS: %s\n", e)
+		t.FailNow()
+	}
+	if m, ok := rebased.(*mountFS); !ok || (m.prefix != "new") {
+		t.Logf("Rebasing a mount didn't produce a single mount: %#v\n",
+			rebased)
+		t.FailNow()
+	}
+	expected, _ := Mount(x, "new")
+	expectSameContents(t, rebased, expected, "Rebased mount")
+	rebased, e = Rebase(x, "", ".")
+	if (e != nil) || (rebased == nil) {
+		t.Logf("Failed rebasing FS to the same root: %v\n", e)
+		t.FailNow()
+	}
+	_, e = Rebase(x, "/bad", "ok")
+	if e == nil {
+		t.Logf("Didn't get expected error for an invalid path\n")
+		t.FailNow()
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"os"
 	"testing"
+	"testing/fstest"
 )
 
 // This package benchmarks performance for accessing a many-way FS merge. It's
@@ -126,3 +127,55 @@ func TestEmptyMergeMultiple(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestEmptyAndSingleLayerFastPaths(t *testing.T) {
+	if MergeMultiple() != Empty {
+		t.Logf("MergeMultiple with no args didn't return Empty.\n")
+		t.FailNow()
+	}
+	e := fstest.TestFS(Empty)
+	if e != nil {
+		t.Logf("TestFS failed for the empty FS: %s\n", e)
+		t.FailNow()
+	}
+	matches, e := fs.Glob(Empty, "*")
+	if (e != nil) || (len(matches) != 0) {
+		t.Logf("Got unexpected glob results: %v, %v\n", matches, e)
+		t.FailNow()
+	}
+	layer := fstest.MapFS{"a.txt": newMapFile("a")}
+	single, ok := MergeMultiple(layer).(fstest.MapFS)
+	if !ok || (single["a.txt"] != layer["a.txt"]) {
+		t.Logf("MergeMultiple with one arg wrapped the FS.\n")
+		t.FailNow()
+	}
+}
+
+func TestNilLayers(t *testing.T) {
+	a := fstest.MapFS{"a.txt": newMapFile("a")}
+	b := fstest.MapFS{"b.txt": newMapFile("b")}
+	merged := MergeMultiple(nil, a, nil, b, nil)
+	e := fstest.TestFS(merged, "a.txt", "b.txt")
+	if e != nil {
+		t.Logf("TestFS failed with nil layers: %s\n", e)
+		t.FailNow()
+	}
+	if len(merged.(*MergedFS).Layers()) != 2 {
+		t.Logf("Nil layers weren't skipped\n")
+		t.FailNow()
+	}
+	if MergeMultiple(nil, nil) != Empty {
+		t.Logf("Merging only nil layers didn't return Empty\n")
+		t.FailNow()
+	}
+	e = fstest.TestFS(NewMergedFS(nil, a), "a.txt")
+	if e != nil {
+		t.Logf("TestFS failed for NewMergedFS with a nil layer: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(NewMergedFS(b, nil), "b.txt")
+	if e != nil {
+		t.Logf("TestFS failed for NewMergedFS with a nil layer: %s\n", e)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,79 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// Reads a byte range of a file, closing the file when closed. Returned by
+// OpenRange.
+type rangeReader struct {
+	io.Reader
+	f fs.File
+}
+
+func (r *rangeReader) Close() error {
+	return r.f.Close()
+}
+
+// Opens the regular file at the path, and returns a reader for at most length
+// bytes of it starting at offset off. Reading stops early, without an error,
+// if the file ends before the range does, so a range starting past the end of
+// the file is empty. The caller must close the returned reader, which closes
+// the file.
+//
+// The range is read using the file's ReadAt method if it has one, or Seek
+// otherwise. If the file supports neither, the bytes before off are read and
+// discarded, which is slow for large offsets, but still works for layers such
+// as compressed archives. See Capabilities to check which layers support
+// efficient access. Returns an error if off or length is negative, or if the
+// path is a directory.
+func (m *MergedFS) OpenRange(path string, off, length int64) (io.ReadCloser,
+	error) {
+	if (off < 0) || (length < 0) {
+		return nil, &fs.PathError{Op: "openrange", Path: path,
+			Err: fmt.Errorf("%w: invalid range (offset %d, length %d)",
+				fs.ErrInvalid, off, length)}
+	}
+	f, e := m.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, &fs.PathError{Op: "openrange", Path: path,
+			Err: fmt.Errorf("%w: is a directory", fs.ErrInvalid)}
+	}
+	// Some files, such as fstest.MapFS's, fail to ReadAt or Seek to offsets
+	// past the end, rather than reporting io.EOF.
+	start := off
+	if start > info.Size() {
+		start = info.Size()
+	}
+	if readerAt, ok := f.(io.ReaderAt); ok {
+		return &rangeReader{io.NewSectionReader(readerAt, start, length), f},
+			nil
+	}
+	if seeker, ok := f.(io.Seeker); ok {
+		_, e = seeker.Seek(start, io.SeekStart)
+		if e != nil {
+			f.Close()
+			return nil, fmt.Errorf("Couldn't seek to offset %d in %s: %w",
+				off, path, e)
+		}
+		return &rangeReader{io.LimitReader(f, length), f}, nil
+	}
+	_, e = io.CopyN(io.Discard, f, off)
+	if (e != nil) && (e != io.EOF) {
+		f.Close()
+		return nil, fmt.Errorf("Couldn't skip to offset %d in %s: %w", off,
+			path, e)
+	}
+	return &rangeReader{io.LimitReader(f, length), f}, nil
+}
@@ -0,0 +1,87 @@
+package merged_fs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// Opens files that support Seek, but not ReadAt.
+type seekOnlyFS struct {
+	fsys fs.FS
+}
+
+type seekOnlyFile struct {
+	minimalFile
+}
+
+func (f seekOnlyFile) Seek(offset int64, whence int) (int64, error) {
+	return f.File.(io.Seeker).Seek(offset, whence)
+}
+
+func (s seekOnlyFS) Open(path string) (fs.File, error) {
+	f, e := s.fsys.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	return seekOnlyFile{minimalFile{f}}, nil
+}
+
+func TestOpenRange(t *testing.T) {
+	content := "0123456789"
+	merged := MergeMultiple(
+		fstest.MapFS{"a.txt": newMapFile(content)},
+		seekOnlyFS{fstest.MapFS{"b.txt": newMapFile(content)}},
+		minimalFS{fstest.MapFS{"c.txt": newMapFile(content)}},
+	).(*MergedFS)
+	ranges := []struct {
+		off, length int64
+		expected    string
+	}{
+		{0, 10, content},
+		{3, 4, "3456"},
+		{8, 100, "89"},
+		{20, 5, ""},
+		{5, 0, ""},
+	}
+	for _, p := range []string{"a.txt", "b.txt", "c.txt"} {
+		for _, r := range ranges {
+			reader, e := merged.OpenRange(p, r.off, r.length)
+			if e != nil {
+				t.Logf("Failed opening range %d+%d of %s: %s\n", r.off,
+					r.length, p, e)
+				t.FailNow()
+			}
+			data, e := io.ReadAll(reader)
+			reader.Close()
+			if e != nil {
+				t.Logf("Failed reading range %d+%d of %s: %s\n", r.off,
+					r.length, p, e)
+				t.FailNow()
+			}
+			if string(data) != r.expected {
+				t.Logf("Expected %q for range %d+%d of %s, got %q\n",
+					r.expected, r.off, r.length, p, data)
+				t.FailNow()
+			}
+		}
+	}
+
+	_, e := merged.OpenRange("a.txt", -1, 5)
+	if !errors.Is(e, fs.ErrInvalid) {
+		t.Logf("Didn't get expected error for a negative offset: %v\n", e)
+		t.FailNow()
+	}
+	_, e = merged.OpenRange(".", 0, 5)
+	if !errors.Is(e, fs.ErrInvalid) {
+		t.Logf("Didn't get expected error for a directory: %v\n", e)
+		t.FailNow()
+	}
+	_, e = merged.OpenRange("missing.txt", 0, 5)
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Didn't get expected error for a missing file: %v\n", e)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,234 @@
+package merged_fs
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// Sets a function used to order directory listings for display, e.g., in
+// file-manager UIs, or nil to use byte order, which is the default. The
+// function must return a negative number if a sorts before b, a positive
+// number if it sorts after b, and 0 if they're equal; names that it
+// considers equal are ordered by bytes. This only affects ReadDirPage and
+// CollatedReadDir: ReadDir and directory handles always list entries in byte
+// order, as io/fs requires.
+//
+// This can be used with golang.org/x/text/collate to sort non-ASCII names
+// correctly for the user's language. The function must be safe for
+// concurrent use, which a *collate.Collator isn't, so one must be protected
+// by a mutex:
+//
+//	c := collate.New(language.German)
+//	var mutex sync.Mutex
+//	merged.SetCollation(func(a, b string) int {
+//		mutex.Lock()
+//		defer mutex.Unlock()
+//		return c.CompareString(a, b)
+//	})
+func (m *MergedFS) SetCollation(compare func(a, b string) int) {
+	m.configMutex.Lock()
+	m.collation = compare
+	m.configMutex.Unlock()
+}
+
+// Returns a function reporting whether a sorts before b, according to m's
+// collation.
+func (m *MergedFS) collatedLess() func(a, b string) bool {
+	m.configMutex.RLock()
+	compare := m.collation
+	m.configMutex.RUnlock()
+	if compare == nil {
+		return func(a, b string) bool {
+			return a < b
+		}
+	}
+	return func(a, b string) bool {
+		c := compare(a, b)
+		if c != 0 {
+			return c < 0
+		}
+		return a < b
+	}
+}
+
+// Returns the entries in the directory at path, sorted using the collation
+// set with SetCollation, or by name if there is none.
+func (m *MergedFS) CollatedReadDir(path string) ([]fs.DirEntry, error) {
+	entries, e := m.ReadDir(path)
+	if e != nil {
+		return nil, e
+	}
+	less := m.collatedLess()
+	sort.Slice(entries, func(i, j int) bool {
+		return less(entries[i].Name(), entries[j].Name())
+	})
+	return entries, nil
+}
+
+// Returns a page of up to n entries from the directory at path, sorted by
+// name (or using the collation set with SetCollation), along with a cursor
+// that can be passed to a later call to continue listing the directory after
+// the returned entries. Pass an empty cursor to start from the beginning. The
+// returned cursor is empty if there are no more entries. If n is 0 or
+// negative, all remaining entries are returned.
+//
+// Cursors are opaque, but they record a position in the sorted listing rather
+// than an offset into a particular open handle, so they remain valid across
+// calls even if the directory is re-opened or its contents change in the
+// meantime; entries added or removed before the cursor's position are simply
+// not seen. Combined with UseDirectoryCaching, this allows UIs to page through
+// huge merged directories without merging their contents on every request.
+func (m *MergedFS) ReadDirPage(path, cursor string, n int) ([]fs.DirEntry,
+	string, error) {
+	after, e := decodeDirCursor(cursor)
+	if e != nil {
+		return nil, "", &fs.PathError{Op: "readdir", Path: path, Err: e}
+	}
+	entries, e := m.CollatedReadDir(path)
+	if e != nil {
+		return nil, "", e
+	}
+	start := 0
+	if cursor != "" {
+		less := m.collatedLess()
+		start = sort.Search(len(entries), func(i int) bool {
+			return less(after, entries[i].Name())
+		})
+	}
+	entries = entries[start:]
+	if (n <= 0) || (n >= len(entries)) {
+		return entries, "", nil
+	}
+	entries = entries[:n]
+	return entries, encodeDirCursor(entries[n-1].Name()), nil
+}
+
+// Returns a cursor for continuing a listing after the given name.
+func encodeDirCursor(name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+// Returns the name encoded in a cursor from encodeDirCursor.
+func decodeDirCursor(cursor string) (string, error) {
+	name, e := base64.RawURLEncoding.DecodeString(cursor)
+	if e != nil {
+		return "", fmt.Errorf("%w: malformed cursor %q", fs.ErrInvalid,
+			cursor)
+	}
+	return string(name), nil
+}
+
+// A directory entry returned by ReadDirOffsets, along with its offset.
+type OffsetDirEntry struct {
+	fs.DirEntry
+	// Passing this to ReadDirOffsets continues the listing after this entry.
+	// Always positive.
+	Offset int64
+}
+
+// Returns the offset of the entry with the given name. Offsets only depend on
+// names, and fit in 63 bits, since some consumers treat them as signed.
+func dirOffset(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	offset := int64(h.Sum64() >> 1)
+	if offset == 0 {
+		offset = 1
+	}
+	return offset
+}
+
+// Returns the entries sorted by their offsets.
+func sortByOffset(entries []fs.DirEntry) []OffsetDirEntry {
+	toReturn := make([]OffsetDirEntry, len(entries))
+	for i, entry := range entries {
+		toReturn[i] = OffsetDirEntry{entry, dirOffset(entry.Name())}
+	}
+	sort.Slice(toReturn, func(i, j int) bool {
+		a, b := &toReturn[i], &toReturn[j]
+		if a.Offset != b.Offset {
+			return a.Offset < b.Offset
+		}
+		return a.Name() < b.Name()
+	})
+	return toReturn
+}
+
+// Holds a merged directory's entries sorted by their offsets, which are
+// computed the first time they're needed.
+type dirOffsets struct {
+	once    sync.Once
+	entries []OffsetDirEntry
+}
+
+// Returns the given entries, which must be the same on every call, sorted by
+// their offsets.
+func (d *dirOffsets) get(entries []fs.DirEntry) []OffsetDirEntry {
+	d.once.Do(func() {
+		d.entries = sortByOffset(entries)
+	})
+	return d.entries
+}
+
+// Returns up to n entries from the directory at path, following the entry
+// with the given offset, or from the beginning if offset is 0. Each entry
+// carries its own offset, which can be passed to a later call to continue the
+// listing after it. If n is 0 or negative, all remaining entries are
+// returned. This is intended for FUSE or NFS adapters, whose readdir
+// operations resume listings from 64-bit offsets or cookies across separate
+// calls, possibly using different handles.
+//
+// Offsets are derived from entry names, and entries are listed in the order
+// of their offsets, not by name. So, unlike the read position of a directory
+// handle, an offset remains valid if the directory is re-opened or re-merged,
+// e.g. after NotifyChanged, or if entries are added or removed: the listing
+// continues after the entry that returned it, without skipping or repeating
+// any entries still present, although entries added before that position
+// aren't seen. With UseDirectoryCaching, the sorted listing of a merged
+// directory is computed once and shared by later calls. Distinct names with
+// the same offset are possible, though vanishingly unlikely (as with the hash
+// cookies of local filesystems); a listing continued from such an offset
+// skips the remaining entries that share it.
+func (m *MergedFS) ReadDirOffsets(path string, offset int64,
+	n int) ([]OffsetDirEntry, error) {
+	e := m.authorize(context.Background(), "readdir", path)
+	if e != nil {
+		return nil, e
+	}
+	f, e := m.openAuthorized(context.Background(), "readdir", path)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	var listing []OffsetDirEntry
+	// Only use the shared listing if f is the merged directory itself, since
+	// a wrapper may change the entries it lists.
+	if d, ok := f.(*MergedDirectory); ok && (d.offsets != nil) {
+		listing = d.offsets.get(d.entries)
+	} else {
+		dir, ok := f.(fs.ReadDirFile)
+		if !ok {
+			return nil, &fs.PathError{Op: "readdir", Path: path,
+				Err: errors.New("not implemented")}
+		}
+		entries, e := dir.ReadDir(-1)
+		if e != nil {
+			return nil, e
+		}
+		listing = sortByOffset(entries)
+	}
+	start := sort.Search(len(listing), func(i int) bool {
+		return listing[i].Offset > offset
+	})
+	listing = listing[start:]
+	if (n > 0) && (n < len(listing)) {
+		listing = listing[:n]
+	}
+	return append([]OffsetDirEntry(nil), listing...), nil
+}
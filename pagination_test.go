@@ -0,0 +1,289 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadDirPage(t *testing.T) {
+	fsA := fstest.MapFS{}
+	fsB := fstest.MapFS{}
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("dir/%02d.txt", i)
+		if (i % 2) == 0 {
+			fsA[name] = newMapFile("A")
+		} else {
+			fsB[name] = newMapFile("B")
+		}
+	}
+	merged := NewMergedFS(fsA, fsB)
+	merged.UseDirectoryCaching(true)
+	var found []string
+	cursor := ""
+	pages := 0
+	for {
+		entries, next, e := merged.ReadDirPage("dir", cursor, 10)
+		if e != nil {
+			t.Logf("Failed reading page %d: %s\n", pages, e)
+			t.FailNow()
+		}
+		pages++
+		for _, entry := range entries {
+			found = append(found, entry.Name())
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if (pages != 3) || (len(found) != 25) {
+		t.Logf("Expected 25 entries in 3 pages, got %d in %d\n", len(found),
+			pages)
+		t.FailNow()
+	}
+	for i, name := range found {
+		if name != fmt.Sprintf("%02d.txt", i) {
+			t.Logf("Entry %d was %s\n", i, name)
+			t.FailNow()
+		}
+	}
+
+	// A cursor should still work after the directory changes, skipping
+	// entries that were added before it.
+	_, cursor, _ = merged.ReadDirPage("dir", "", 5)
+	merged.UseDirectoryCaching(true)
+	fsB["dir/00a.txt"] = newMapFile("B")
+	fsB["dir/05a.txt"] = newMapFile("B")
+	entries, _, e := merged.ReadDirPage("dir", cursor, 2)
+	if e != nil {
+		t.Logf("Failed reading page after changes: %s\n", e)
+		t.FailNow()
+	}
+	if (entries[0].Name() != "05.txt") || (entries[1].Name() != "05a.txt") {
+		t.Logf("Expected 05.txt and 05a.txt after the cursor, got %s and "+
+			"%s\n", entries[0].Name(), entries[1].Name())
+		t.FailNow()
+	}
+	_, _, e = merged.ReadDirPage("dir", "!!", 1)
+	if !errors.Is(e, fs.ErrInvalid) {
+		t.Logf("Didn't get expected error for a malformed cursor.\n")
+		t.FailNow()
+	}
+}
+
+func TestCollation(t *testing.T) {
+	fsA := fstest.MapFS{
+		"b.txt":      newMapFile("A"),
+		"Apple.txt":  newMapFile("A"),
+		"éclair.txt": newMapFile("A"),
+	}
+	fsB := fstest.MapFS{
+		"Zebra.txt": newMapFile("B"),
+		"apple.txt": newMapFile("B"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	listNames := func(entries []fs.DirEntry) string {
+		names := make([]string, len(entries))
+		for i, entry := range entries {
+			names[i] = entry.Name()
+		}
+		return strings.Join(names, " ")
+	}
+	entries, e := merged.CollatedReadDir(".")
+	if e != nil {
+		t.Logf("Failed reading root dir: %s\n", e)
+		t.FailNow()
+	}
+	expected := "Apple.txt Zebra.txt apple.txt b.txt éclair.txt"
+	if listNames(entries) != expected {
+		t.Logf("Got wrong default order: %s\n", listNames(entries))
+		t.FailNow()
+	}
+
+	// A simple collation ignoring case and accents.
+	fold := strings.NewReplacer("é", "e")
+	merged.SetCollation(func(a, b string) int {
+		return strings.Compare(fold.Replace(strings.ToLower(a)),
+			fold.Replace(strings.ToLower(b)))
+	})
+	expected = "Apple.txt apple.txt b.txt éclair.txt Zebra.txt"
+	entries, e = merged.CollatedReadDir(".")
+	if e != nil {
+		t.Logf("Failed reading collated root dir: %s\n", e)
+		t.FailNow()
+	}
+	if listNames(entries) != expected {
+		t.Logf("Got wrong collated order: %s\n", listNames(entries))
+		t.FailNow()
+	}
+	var paged []fs.DirEntry
+	cursor := ""
+	for {
+		entries, next, e := merged.ReadDirPage(".", cursor, 2)
+		if e != nil {
+			t.Logf("Failed reading collated page: %s\n", e)
+			t.FailNow()
+		}
+		paged = append(paged, entries...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if listNames(paged) != expected {
+		t.Logf("Got wrong collated pages: %s\n", listNames(paged))
+		t.FailNow()
+	}
+
+	// ReadDir must still use byte order.
+	entries, e = fs.ReadDir(merged, ".")
+	if (e != nil) || (entries[0].Name() != "Apple.txt") ||
+		(entries[1].Name() != "Zebra.txt") {
+		t.Logf("Collation affected ReadDir: %v\n", e)
+		t.FailNow()
+	}
+}
+
+// Lists the directory at path in m using ReadDirOffsets, n entries at a time,
+// and returns the names of the entries in a set.
+func readAllOffsets(t *testing.T, m *MergedFS, path string,
+	n int) map[string]bool {
+	toReturn := make(map[string]bool)
+	var offset int64
+	for {
+		entries, e := m.ReadDirOffsets(path, offset, n)
+		if e != nil {
+			t.Logf("Failed reading %s at offset %d: %s\n", path, offset, e)
+			t.FailNow()
+		}
+		if len(entries) == 0 {
+			return toReturn
+		}
+		for _, entry := range entries {
+			if entry.Offset <= offset {
+				t.Logf("Offsets didn't increase: %d after %d\n", entry.Offset,
+					offset)
+				t.FailNow()
+			}
+			if toReturn[entry.Name()] {
+				t.Logf("Got %s more than once\n", entry.Name())
+				t.FailNow()
+			}
+			toReturn[entry.Name()] = true
+			offset = entry.Offset
+		}
+	}
+}
+
+func TestReadDirOffsets(t *testing.T) {
+	fsA := fstest.MapFS{"only_a/x.txt": newMapFile("x")}
+	fsB := fstest.MapFS{}
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("dir/%02d.txt", i)
+		if (i % 2) == 0 {
+			fsA[name] = newMapFile("A")
+		} else {
+			fsB[name] = newMapFile("B")
+		}
+	}
+	merged := NewMergedFS(fsA, fsB)
+	merged.UseDirectoryCaching(true)
+	found := readAllOffsets(t, merged, "dir", 7)
+	if len(found) != 25 {
+		t.Logf("Expected 25 entries, got %d\n", len(found))
+		t.FailNow()
+	}
+	found = readAllOffsets(t, merged, "only_a", 0)
+	if !found["x.txt"] || (len(found) != 1) {
+		t.Logf("Got wrong entries for unmerged dir: %v\n", found)
+		t.FailNow()
+	}
+
+	// Continue a listing after the directory changes: the remaining entries
+	// must still be listed exactly once.
+	first, e := merged.ReadDirOffsets("dir", 0, 10)
+	if e != nil {
+		t.Logf("Failed reading first entries: %s\n", e)
+		t.FailNow()
+	}
+	delete(fsA, "dir/"+first[2].Name())
+	delete(fsB, "dir/"+first[2].Name())
+	merged.NotifyChanged("dir")
+	rest, e := merged.ReadDirOffsets("dir", first[9].Offset, 0)
+	if e != nil {
+		t.Logf("Failed continuing listing: %s\n", e)
+		t.FailNow()
+	}
+	if len(rest) != 15 {
+		t.Logf("Expected 15 remaining entries, got %d\n", len(rest))
+		t.FailNow()
+	}
+	for _, entry := range rest {
+		for _, seen := range first {
+			if entry.Name() == seen.Name() {
+				t.Logf("Listed %s again after continuing\n", entry.Name())
+				t.FailNow()
+			}
+		}
+	}
+
+	_, e = merged.ReadDirOffsets("dir/00.txt", 0, 0)
+	if e == nil {
+		t.Logf("Didn't get an error listing a regular file.\n")
+		t.FailNow()
+	}
+	_, e = merged.ReadDirOffsets("missing", 0, 0)
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Didn't get a not-exist error for a missing dir: %v\n", e)
+		t.FailNow()
+	}
+}
+
+// Wraps a directory, hiding any entries whose names start with a dot.
+type dotHidingDir struct {
+	fs.ReadDirFile
+}
+
+func (d *dotHidingDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, e := d.ReadDirFile.ReadDir(n)
+	var toReturn []fs.DirEntry
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			toReturn = append(toReturn, entry)
+		}
+	}
+	return toReturn, e
+}
+
+func (d *dotHidingDir) Unwrap() fs.File {
+	return d.ReadDirFile
+}
+
+func TestReadDirOffsetsWrapped(t *testing.T) {
+	merged := NewMergedFS(fstest.MapFS{
+		"dir/.hidden": newMapFile("hidden"),
+		"dir/a.txt":   newMapFile("a"),
+	}, fstest.MapFS{
+		"dir/b.txt": newMapFile("b"),
+	})
+	merged.UseDirectoryCaching(true)
+	merged.Use(func(next Opener) Opener {
+		return func(ctx context.Context, path string) (fs.File, error) {
+			f, e := next(ctx, path)
+			if dir, ok := f.(fs.ReadDirFile); ok && (e == nil) {
+				return &dotHidingDir{dir}, nil
+			}
+			return f, e
+		}
+	})
+	found := readAllOffsets(t, merged, "dir", 0)
+	if (len(found) != 2) || found[".hidden"] {
+		t.Logf("Got wrong entries through a filtering wrapper: %v\n", found)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,72 @@
+package merged_fs
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// Returned in place of a panic that occurred in an underlying filesystem, if
+// panic recovery is enabled using MergedFS.UsePanicRecovery.
+type LayerPanicError struct {
+	// The name of the layer that panicked: the Layer's Name if it was a named
+	// *Layer, or "A" or "B" otherwise.
+	Layer string
+	// The operation that panicked, e.g. "open", "stat", or "readdir".
+	Op string
+	// The path being accessed when the panic occurred.
+	Path string
+	// The value passed to panic().
+	Value interface{}
+	// The stack trace at the time of the panic.
+	Stack []byte
+}
+
+func (e *LayerPanicError) Error() string {
+	return fmt.Sprintf("FS %s panicked during %s of %s: %v", e.Layer, e.Op,
+		e.Path, e.Value)
+}
+
+// If a panic occurred, recovers it and sets *e to a LayerPanicError. Must be
+// called using defer.
+func (m *MergedFS) recoverLayerPanic(side int, op, path string, e *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	*e = &LayerPanicError{
+		Layer: m.layerName(side),
+		Op:    op,
+		Path:  path,
+		Value: r,
+		Stack: debug.Stack(),
+	}
+}
+
+func (m *MergedFS) recoveringPanics() bool {
+	return atomic.LoadInt32(&m.recoverPanics) != 0
+}
+
+// Enables or disables recovery from panics in the underlying filesystems.
+// When enabled, a panic during an Open, Stat, or ReadDir call made by m to
+// either of its underlying FSs will be returned as a *LayerPanicError rather
+// than crashing the program. Note that this doesn't cover panics in methods
+// of files after they've been returned by m.Open, e.g. calling Read on a
+// regular file.
+//
+// Like UsePathCaching, this also applies the setting to any MergedFS directly
+// nested within m, so calling it on the result of MergeMultiple is
+// sufficient. Recovery is disabled by default.
+func (m *MergedFS) UsePanicRecovery(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&m.recoverPanics, value)
+	for side := 0; side < 2; side++ {
+		nested, ok := m.layer(side).(*MergedFS)
+		if ok {
+			nested.UsePanicRecovery(enabled)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package merged_fs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// An FS that panics when opening any path other than ".".
+type panickingFS struct{}
+
+func (f panickingFS) Open(path string) (fs.File, error) {
+	if path == "." {
+		return fstest.MapFS{}.Open(".")
+	}
+	panic("panickingFS always panics")
+}
+
+func TestPanicRecovery(t *testing.T) {
+	fsA := fstest.MapFS{"a.txt": newMapFile("in A")}
+	merged := MergeMultiple(fsA, &Layer{FS: panickingFS{}, Name: "bad"},
+		fstest.MapFS{}).(*MergedFS)
+	merged.UsePanicRecovery(true)
+
+	// a.txt is a regular file in the top layer, so the bad layer is never
+	// consulted.
+	f, e := merged.Open("a.txt")
+	if e != nil {
+		t.Logf("Failed opening a.txt: %s\n", e)
+		t.FailNow()
+	}
+	f.Close()
+
+	_, e = merged.Open("b.txt")
+	var panicError *LayerPanicError
+	if !errors.As(e, &panicError) {
+		t.Logf("Didn't get expected LayerPanicError. Got %v.\n", e)
+		t.FailNow()
+	}
+	if (panicError.Layer != "bad") || (panicError.Op != "open") {
+		t.Logf("Panic attributed to the wrong layer or operation: %s\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+
+	// Make sure the setting can be turned off again.
+	merged.UsePanicRecovery(false)
+	defer func() {
+		if recover() == nil {
+			t.Logf("Didn't panic with panic recovery disabled.\n")
+			t.Fail()
+		}
+	}()
+	merged.Open("b.txt")
+}
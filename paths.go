@@ -0,0 +1,67 @@
+package merged_fs
+
+import (
+	"errors"
+	"strings"
+)
+
+// Returns the last element of the given slash-separated path, with the same
+// semantics as path.Base: trailing slashes are removed, an empty path returns
+// ".", and a path consisting only of slashes returns "/". Doesn't allocate.
+func baseName(p string) string {
+	if p == "" {
+		return "."
+	}
+	end := len(p)
+	for (end > 0) && (p[end-1] == '/') {
+		end--
+	}
+	if end == 0 {
+		return "/"
+	}
+	return p[strings.LastIndexByte(p[:end], '/')+1 : end]
+}
+
+// Splits a valid path (in the sense of fs.ValidPath) into its first component
+// and the remainder. For example, "a/b/c" returns "a" and "b/c", and "a"
+// returns "a" and an empty string. The root path, ".", has no components, so
+// it returns two empty strings. Doesn't allocate. Intended for use by layer
+// wrappers and middleware that need to walk paths one component at a time.
+func SplitFirst(p string) (first, rest string) {
+	if p == "." {
+		return "", ""
+	}
+	i := strings.IndexByte(p, '/')
+	if i < 0 {
+		return p, ""
+	}
+	return p[:i], p[i+1:]
+}
+
+// StopPrefixes may be returned by the callback passed to ForEachPrefix to
+// stop iterating without causing ForEachPrefix to return an error.
+var StopPrefixes = errors.New("stop iterating over prefixes")
+
+// Calls fn with each component-wise prefix of the valid path p, from shortest
+// to longest, ending with p itself. For example, "a/b/c" results in calls
+// with "a", "a/b", and "a/b/c". Nothing is called for ".". Stops and returns
+// the first error returned by fn, or nil if the error is StopPrefixes. The
+// prefixes are substrings of p, so this doesn't allocate.
+func ForEachPrefix(p string, fn func(prefix string) error) error {
+	if p == "." {
+		return nil
+	}
+	for i := 0; i <= len(p); i++ {
+		if (i < len(p)) && (p[i] != '/') {
+			continue
+		}
+		e := fn(p[:i])
+		if e == StopPrefixes {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package merged_fs
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestBaseName(t *testing.T) {
+	paths := []string{"", ".", "/", "//", "a", "a/b", "a/b/", "a//b",
+		"/a", "dir/ファイル.txt", "ü/ñ", "a/b/c.txt"}
+	for _, p := range paths {
+		if baseName(p) != path.Base(p) {
+			t.Logf("baseName(%q) returned %q, expected %q\n", p, baseName(p),
+				path.Base(p))
+			t.FailNow()
+		}
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		baseName("a/b/c/d.txt")
+	})
+	if allocs != 0 {
+		t.Logf("baseName allocated %f times\n", allocs)
+		t.FailNow()
+	}
+}
+
+func TestSplitFirst(t *testing.T) {
+	type testCase struct {
+		path, first, rest string
+	}
+	cases := []testCase{
+		{".", "", ""},
+		{"a", "a", ""},
+		{"a/b", "a", "b"},
+		{"a/b/c", "a", "b/c"},
+		{"日本/語/テキスト.txt", "日本", "語/テキスト.txt"},
+	}
+	for _, c := range cases {
+		first, rest := SplitFirst(c.path)
+		if (first != c.first) || (rest != c.rest) {
+			t.Logf("SplitFirst(%q) returned %q, %q; expected %q, %q\n",
+				c.path, first, rest, c.first, c.rest)
+			t.FailNow()
+		}
+	}
+}
+
+func TestForEachPrefix(t *testing.T) {
+	var prefixes []string
+	collect := func(prefix string) error {
+		prefixes = append(prefixes, prefix)
+		return nil
+	}
+	e := ForEachPrefix(".", collect)
+	if (e != nil) || (len(prefixes) != 0) {
+		t.Logf("Got prefixes %v, error %v for \".\"\n", prefixes, e)
+		t.FailNow()
+	}
+	e = ForEachPrefix("a/ß/c.txt", collect)
+	if e != nil {
+		t.Logf("Got unexpected error: %s\n", e)
+		t.FailNow()
+	}
+	if strings.Join(prefixes, ",") != "a,a/ß,a/ß/c.txt" {
+		t.Logf("Got incorrect prefixes: %v\n", prefixes)
+		t.FailNow()
+	}
+
+	prefixes = nil
+	e = ForEachPrefix("a/b/c", func(prefix string) error {
+		prefixes = append(prefixes, prefix)
+		if prefix == "a/b" {
+			return StopPrefixes
+		}
+		return nil
+	})
+	if (e != nil) || (len(prefixes) != 2) {
+		t.Logf("Stopping didn't work: got %v, error %v\n", prefixes, e)
+		t.FailNow()
+	}
+	testError := errors.New("test error")
+	e = ForEachPrefix("a/b/c", func(prefix string) error {
+		return testError
+	})
+	if e != testError {
+		t.Logf("Didn't get expected error: %v\n", e)
+		t.FailNow()
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		ForEachPrefix("a/b/c/d", func(prefix string) error { return nil })
+	})
+	if allocs != 0 {
+		t.Logf("ForEachPrefix allocated %f times\n", allocs)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,58 @@
+package merged_fs
+
+import (
+	"path"
+	"strings"
+)
+
+// Splits a path into its components, returning an empty slice for ".".
+func pathComponents(p string) []string {
+	if (p == ".") || (p == "") {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// Returns an error if the pattern is malformed. Patterns use the syntax of
+// path.Match, except that a path component consisting of "**" matches zero or
+// more path components.
+func validatePattern(pattern string) error {
+	for _, c := range pathComponents(pattern) {
+		if c == "**" {
+			continue
+		}
+		_, e := path.Match(c, "")
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func matchComponents(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		if matchComponents(pattern[1:], name) {
+			return true
+		}
+		return (len(name) != 0) && matchComponents(pattern, name[1:])
+	}
+	if len(name) == 0 {
+		return false
+	}
+	matched, e := path.Match(pattern[0], name[0])
+	if (e != nil) || !matched {
+		return false
+	}
+	return matchComponents(pattern[1:], name[1:])
+}
+
+// Returns true if the path matches the pattern. The pattern must have already
+// been checked using validatePattern. For example, "i18n/**" matches "i18n"
+// and every path within it, and "**/*.png" matches every path ending in
+// ".png".
+func matchPattern(pattern, name string) bool {
+	return matchComponents(pathComponents(pattern), pathComponents(name))
+}
@@ -0,0 +1,38 @@
+package merged_fs
+
+import (
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	type testCase struct {
+		pattern string
+		name    string
+		matches bool
+	}
+	testCases := []testCase{
+		{"i18n/**", "i18n/en/strings.json", true},
+		{"i18n/**", "i18n", true},
+		{"i18n/**", "other/i18n/a.json", false},
+		{"**/*.png", "logo.png", true},
+		{"**/*.png", "a/b/c/logo.png", true},
+		{"**/*.png", "a/b/c/logo.jpg", false},
+		{"a/**/c", "a/c", true},
+		{"a/**/c", "a/b/b/c", true},
+		{"a/*/c", "a/b/b/c", false},
+		{"*.txt", "a.txt", true},
+		{"*.txt", "dir/a.txt", false},
+		{"**", ".", true},
+	}
+	for _, c := range testCases {
+		if matchPattern(c.pattern, c.name) != c.matches {
+			t.Logf("Expected matchPattern(%q, %q) to be %v\n", c.pattern,
+				c.name, c.matches)
+			t.Fail()
+		}
+	}
+	if validatePattern("a/[b") == nil {
+		t.Logf("Didn't get expected error for a malformed pattern.\n")
+		t.Fail()
+	}
+}
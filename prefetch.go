@@ -0,0 +1,112 @@
+package merged_fs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Opens each of the given paths concurrently, so that the work of resolving
+// them in m's layers (and filling m's path and directory caches, if enabled)
+// is done before the paths are needed. This is intended for programs that
+// know which files they'll load ahead of time, such as a game loading the
+// assets listed in a level's manifest; issuing the opens early hides the
+// latency of probing multiple layers for each path.
+//
+// If headerBytes is positive, up to that many bytes are also read from the
+// start of each file that isn't a directory, which can warm the OS's page
+// cache for filesystems on disk. Bytes read this way count towards any read
+// quotas.
+//
+// Prefetch waits until every path has been processed. It returns the first
+// error encountered, if any, but an error for one path doesn't stop the
+// others from being prefetched.
+func (m *MergedFS) Prefetch(paths []string, headerBytes int) error {
+	var firstError error
+	var errorMutex sync.Mutex
+	runConcurrently(len(paths), func(i int) {
+		e := m.prefetchPath(paths[i], headerBytes)
+		if e == nil {
+			return
+		}
+		errorMutex.Lock()
+		if firstError == nil {
+			firstError = e
+		}
+		errorMutex.Unlock()
+	})
+	return firstError
+}
+
+// Opens a single path for Prefetch, reading up to headerBytes from it.
+func (m *MergedFS) prefetchPath(path string, headerBytes int) error {
+	f, e := m.Open(path)
+	if e != nil {
+		return fmt.Errorf("Couldn't prefetch %s: %w", path, e)
+	}
+	defer f.Close()
+	if headerBytes <= 0 {
+		return nil
+	}
+	info, e := f.Stat()
+	if e != nil {
+		return fmt.Errorf("Couldn't stat %s while prefetching: %w", path, e)
+	}
+	if info.IsDir() {
+		return nil
+	}
+	_, e = io.ReadFull(f, make([]byte, headerBytes))
+	if (e != nil) && (e != io.EOF) && (e != io.ErrUnexpectedEOF) {
+		return fmt.Errorf("Couldn't read %s while prefetching: %w", path, e)
+	}
+	return nil
+}
+
+// Sets a function that's called with the path of each file or directory
+// successfully opened using m, which returns paths likely to be opened next,
+// such as the stylesheets and scripts referenced by an HTML page. Each
+// returned path is resolved in the background, which fills m's path and
+// directory caches, if enabled, so the later open is cheaper. Pass nil to
+// disable prediction, which is the default.
+//
+// Prediction only warms caches, and never changes the result of any
+// operation: the predicted paths are opened without middleware, don't count
+// towards read quotas or appear in OpenFiles, aren't read, and are closed
+// immediately. Predicted opens never wait for a Layer's MaxConcurrent or
+// OpsPerSecond limits, so they can't delay other operations: a predicted path
+// is skipped if it needs a layer whose limits don't permit another operation
+// right away. Errors opening predicted paths, including paths that don't
+// exist, are ignored, and don't cause the predictor to be called again. At most
+// GOMAXPROCS predictions run at once; any opens made while that many are
+// running aren't passed to the predictor. The predictor is called from a
+// different goroutine than the one that opened the path, so it must be safe
+// for concurrent use.
+func (m *MergedFS) SetPredictor(predict func(opened string) []string) {
+	m.configMutex.Lock()
+	m.predictor = predict
+	m.configMutex.Unlock()
+}
+
+// Starts a goroutine resolving the paths predict returns for opened, unless
+// too many are already running.
+func (m *MergedFS) speculate(predict func(opened string) []string,
+	opened string) {
+	limit := int32(runtime.GOMAXPROCS(0))
+	if atomic.AddInt32(&m.speculating, 1) > limit {
+		atomic.AddInt32(&m.speculating, -1)
+		return
+	}
+	go func() {
+		defer atomic.AddInt32(&m.speculating, -1)
+		ctx := context.WithValue(context.Background(), speculativeKey{}, true)
+		for _, p := range predict(opened) {
+			f, e := m.openInternal(ctx, p)
+			if e == nil {
+				f.Close()
+			}
+		}
+	}()
+}
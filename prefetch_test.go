@@ -0,0 +1,156 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestPrefetch(t *testing.T) {
+	fsA := fstest.MapFS{
+		"level1/map.dat":   newMapFile("map data"),
+		"level1/music.ogg": newMapFile("music"),
+	}
+	fsB := fstest.MapFS{
+		"level1/sky.png": newMapFile("sky"),
+		"shared/font":    newMapFile("font"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	merged.UseDirectoryCaching(true)
+	merged.SetReadQuota(0)
+	paths := []string{"level1/map.dat", "level1/music.ogg", "level1/sky.png",
+		"shared/font", "level1"}
+	e := merged.Prefetch(paths, 4)
+	if e != nil {
+		t.Logf("Prefetch failed: %s\n", e)
+		t.FailNow()
+	}
+	if merged.BytesRead() != 15 {
+		t.Logf("Expected prefetch to read 15 bytes, got %d\n",
+			merged.BytesRead())
+		t.FailNow()
+	}
+	if len(merged.dirCache) != 1 {
+		t.Logf("Expected prefetch to cache 1 directory, got %d\n",
+			len(merged.dirCache))
+		t.FailNow()
+	}
+	e = merged.Prefetch(append(paths, "missing.txt"), 0)
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Didn't get expected error prefetching a missing path: %v\n",
+			e)
+		t.FailNow()
+	}
+}
+
+// Waits for any goroutines started by m's predictor to finish.
+func waitForSpeculation(m *MergedFS, t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&m.speculating) != 0 {
+		if time.Now().After(deadline) {
+			t.Logf("Timed out waiting for speculative opens\n")
+			t.FailNow()
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPredictor(t *testing.T) {
+	fsA := fstest.MapFS{
+		"index.html":   newMapFile("<link href=\"css/site.css\">"),
+		"css/base.css": newMapFile("html {}"),
+	}
+	fsB := fstest.MapFS{
+		"css/site.css": newMapFile("body {}"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	merged.UseDirectoryCaching(true)
+	merged.SetReadQuota(0)
+	merged.TrackOpenFiles(true, nil)
+	var calls int32
+	merged.SetPredictor(func(opened string) []string {
+		atomic.AddInt32(&calls, 1)
+		if opened != "index.html" {
+			return nil
+		}
+		return []string{"css", "missing.css", "../invalid"}
+	})
+	var debug strings.Builder
+	merged.DebugDump(&debug)
+	if !strings.Contains(debug.String(), "predictor: true") {
+		t.Logf("Debug output doesn't mention the predictor\n")
+		t.FailNow()
+	}
+	content, e := fs.ReadFile(merged, "index.html")
+	if e != nil {
+		t.Logf("Failed reading index.html: %s\n", e)
+		t.FailNow()
+	}
+	waitForSpeculation(merged, t)
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Logf("Expected 1 call to the predictor, got %d\n", calls)
+		t.FailNow()
+	}
+	merged.dirCacheMutex.Lock()
+	_, cached := merged.dirCache["css"]
+	merged.dirCacheMutex.Unlock()
+	if !cached {
+		t.Logf("The predicted directory wasn't cached\n")
+		t.FailNow()
+	}
+	if merged.BytesRead() != int64(len(content)) {
+		t.Logf("Expected %d bytes read, got %d\n", len(content),
+			merged.BytesRead())
+		t.FailNow()
+	}
+	if len(merged.OpenFiles()) != 0 {
+		t.Logf("Speculative opens were tracked: %v\n", merged.OpenFiles())
+		t.FailNow()
+	}
+
+	merged.SetPredictor(nil)
+	_, e = fs.ReadFile(merged, "css/site.css")
+	if e != nil {
+		t.Logf("Failed reading css/site.css: %s\n", e)
+		t.FailNow()
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Logf("The predictor was called after being disabled\n")
+		t.FailNow()
+	}
+}
+
+func TestPredictorSkipsBusyLayers(t *testing.T) {
+	tracker := &openCountingFS{FS: fstest.MapFS{
+		"style.css": newMapFile("body {}"),
+	}}
+	limited := &Layer{FS: tracker, Name: "remote", MaxConcurrent: 1}
+	merged := NewMergedFS(fstest.MapFS{"index.html": newMapFile("index")},
+		limited)
+	merged.SetPredictor(func(opened string) []string {
+		return []string{"style.css"}
+	})
+	// Occupy the layer's only slot, so a predicted open would have to wait.
+	release, e := limited.acquire(context.Background())
+	if e != nil {
+		t.Logf("Failed acquiring the layer's slot: %s\n", e)
+		t.FailNow()
+	}
+	_, e = fs.ReadFile(merged, "index.html")
+	if e != nil {
+		release()
+		t.Logf("Failed reading index.html: %s\n", e)
+		t.FailNow()
+	}
+	waitForSpeculation(merged, t)
+	release()
+	if atomic.LoadInt64(&tracker.opens) != 0 {
+		t.Logf("A predicted open used a layer with no free slots\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,120 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+)
+
+// Describes how adding a layer would change a MergedFS, as returned by
+// Preview. Each slice of paths is in lexical order.
+type LayerPreview struct {
+	// Files that are visible now, but would be served from the new layer
+	// instead.
+	Overridden []string
+	// Paths that aren't visible now, but would be after adding the layer.
+	Added []string
+	// Directories that would be hidden by files in the new layer.
+	ShadowedDirs []string
+	// Paths that are visible now, but wouldn't be after adding the layer,
+	// such as the contents of ShadowedDirs.
+	Removed []string
+	// Paths that would change from a directory to a file, including those in
+	// ShadowedDirs, or from a file to a directory.
+	Changed []string
+}
+
+// Records what's visible at a path while previewing a layer.
+type previewEntry struct {
+	dir bool
+	// The index of the layer providing the entry, or -1 if unknown.
+	layer int
+}
+
+// Returns everything visible in fsys, keyed by path.
+func previewEntries(fsys fs.FS) (map[string]previewEntry, error) {
+	toReturn := make(map[string]previewEntry)
+	e := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if p == "." {
+			return nil
+		}
+		entry := previewEntry{dir: d.IsDir(), layer: -1}
+		if provenance, ok := d.(ProvenanceEntry); ok {
+			entry.layer, _ = provenance.Provenance()
+		}
+		toReturn[p] = entry
+		return nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	return toReturn, nil
+}
+
+// Returns how m's visible content would change if newLayer were inserted at
+// the given index in the list returned by Layers, without modifying m. Index
+// 0 gives newLayer priority over every existing layer, and len(m.Layers())
+// gives it the lowest priority. This is intended for tools such as mod
+// managers or theme installers to show the effects of installing something
+// before doing it.
+//
+// The preview merges the current layers, and the layers with newLayer
+// inserted, in the same way as MergeMultiple, and compares the two, so it
+// doesn't reflect any priority overrides, pins, aliases, or other settings of
+// m, and those settings don't show up as changes. It requires walking both
+// merges in their entirety. Returns an error if the index is out of range.
+func (m *MergedFS) Preview(newLayer fs.FS, position int) (*LayerPreview,
+	error) {
+	layers := m.Layers()
+	if (position < 0) || (position > len(layers)) {
+		return nil, fmt.Errorf("Invalid layer index %d: the FS has %d "+
+			"layers", position, len(layers))
+	}
+	candidateLayers := make([]fs.FS, 0, len(layers)+1)
+	candidateLayers = append(candidateLayers, layers[:position]...)
+	candidateLayers = append(candidateLayers, newLayer)
+	candidateLayers = append(candidateLayers, layers[position:]...)
+	candidate := MergeMultiple(candidateLayers...)
+	// newLayer may be made up of several layers itself.
+	newEnd := position + layerCount(newLayer)
+
+	current, e := previewEntries(MergeMultiple(layers...))
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't list current content: %w", e)
+	}
+	previewed, e := previewEntries(candidate)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't list content with the new layer: "+
+			"%w", e)
+	}
+	toReturn := &LayerPreview{}
+	for p, before := range current {
+		after, ok := previewed[p]
+		switch {
+		case !ok:
+			toReturn.Removed = append(toReturn.Removed, p)
+		case before.dir && !after.dir:
+			toReturn.ShadowedDirs = append(toReturn.ShadowedDirs, p)
+			toReturn.Changed = append(toReturn.Changed, p)
+		case !before.dir && after.dir:
+			toReturn.Changed = append(toReturn.Changed, p)
+		case !before.dir && (after.layer >= position) &&
+			(after.layer < newEnd):
+			toReturn.Overridden = append(toReturn.Overridden, p)
+		}
+	}
+	for p := range previewed {
+		if _, ok := current[p]; !ok {
+			toReturn.Added = append(toReturn.Added, p)
+		}
+	}
+	sort.Strings(toReturn.Overridden)
+	sort.Strings(toReturn.Added)
+	sort.Strings(toReturn.ShadowedDirs)
+	sort.Strings(toReturn.Removed)
+	sort.Strings(toReturn.Changed)
+	return toReturn, nil
+}
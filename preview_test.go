@@ -0,0 +1,79 @@
+package merged_fs
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPreview(t *testing.T) {
+	base := fstest.MapFS{
+		"theme/style.css":  newMapFile("base"),
+		"theme/logo.png":   newMapFile("base"),
+		"plugins/a/a.js":   newMapFile("base"),
+		"plugins/b/b.js":   newMapFile("base"),
+		"content/page.md":  newMapFile("base"),
+		"content/other.md": newMapFile("base"),
+	}
+	site := fstest.MapFS{"content/page.md": newMapFile("site")}
+	merged := MergeMultiple(site, base).(*MergedFS)
+	newTheme := fstest.MapFS{
+		"theme/style.css": newMapFile("new"),
+		"theme/fonts.css": newMapFile("new"),
+		"plugins/b":       newMapFile("not a directory"),
+		// Replaces a file in the base layer with a directory.
+		"content/other.md/x.md": newMapFile("new"),
+		// Hidden by the site layer, so not overridden.
+		"content/page.md": newMapFile("new"),
+	}
+	preview, e := merged.Preview(newTheme, 1)
+	if e != nil {
+		t.Logf("Failed previewing layer: %s\n", e)
+		t.FailNow()
+	}
+	check := func(what string, got []string, expected string) {
+		if strings.Join(got, " ") != expected {
+			t.Logf("Got wrong %s paths: %v\n", what, got)
+			t.FailNow()
+		}
+	}
+	check("overridden", preview.Overridden, "theme/style.css")
+	check("added", preview.Added, "content/other.md/x.md theme/fonts.css")
+	check("shadowed", preview.ShadowedDirs, "plugins/b")
+	check("removed", preview.Removed, "plugins/b/b.js")
+	check("changed", preview.Changed, "content/other.md plugins/b")
+
+	// At the top, the new layer overrides the site's page, too.
+	preview, e = merged.Preview(newTheme, 0)
+	if e != nil {
+		t.Logf("Failed previewing layer at the top: %s\n", e)
+		t.FailNow()
+	}
+	check("overridden", preview.Overridden, "content/page.md theme/style.css")
+
+	// Settings of the merge, such as aliases, mustn't appear as changes.
+	e = merged.Alias("legacy", "theme")
+	if e != nil {
+		t.Logf("Failed adding an alias: %s\n", e)
+		t.FailNow()
+	}
+	preview, e = merged.Preview(fstest.MapFS{}, 0)
+	if e != nil {
+		t.Logf("Failed previewing an empty layer: %s\n", e)
+		t.FailNow()
+	}
+	check("added", preview.Added, "")
+	check("removed", preview.Removed, "")
+	check("changed", preview.Changed, "")
+
+	// The merge itself mustn't change.
+	if len(merged.Layers()) != 2 {
+		t.Logf("Preview modified the merge.\n")
+		t.FailNow()
+	}
+	_, e = merged.Preview(newTheme, 3)
+	if e == nil {
+		t.Logf("Didn't get expected error for an invalid index.\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,251 @@
+package merged_fs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A rule causing a specific layer to take priority for paths matching a
+// pattern.
+type priorityOverride struct {
+	pattern string
+	layer   int
+	fsys    fs.FS
+	// If true, this is a pin added using Pin, and pattern is an exact path
+	// rather than a pattern. The pin applies to the path and everything
+	// within it.
+	pinned bool
+}
+
+// Returns true if the rule applies to the given path.
+func (o *priorityOverride) matches(path string) bool {
+	if o.pinned {
+		return (o.pattern == path) || (o.pattern == ".") ||
+			strings.HasPrefix(path, o.pattern+"/")
+	}
+	return matchPattern(o.pattern, path)
+}
+
+// Causes regular files in the layer at the given index (see Layers) to take
+// priority over all other layers, for paths matching the given pattern. For
+// example:
+//
+//	merged := MergeMultiple(baseFS, themeFS, customerFS).(*MergedFS)
+//	e := merged.AddPriorityOverride("branding/**", 2)
+//
+// will serve any file under "branding" from customerFS if it's present there,
+// and follow the normal priority order otherwise. Patterns use the syntax of
+// path.Match, except that a "**" component matches zero or more path
+// components. Overrides are evaluated in the order they were added, before
+// the normal priority order, and only apply when opening paths using m itself
+// (not any MergedFS nested within it).
+//
+// Overrides only apply to regular files: if the overriding layer contains a
+// directory at a matching path, then the directory is merged in the normal
+// way. Additionally, an overriding file is served even if one of its parent
+// directories would normally be hidden by a regular file in a higher-priority
+// layer, though it won't be listed in any directory in that case.
+//
+// Returns an error if the pattern is malformed or the layer index is invalid.
+func (m *MergedFS) AddPriorityOverride(pattern string, layer int) error {
+	e := validatePattern(pattern)
+	if e != nil {
+		return fmt.Errorf("Invalid pattern %q: %w", pattern, e)
+	}
+	layers := m.Layers()
+	if (layer < 0) || (layer >= len(layers)) {
+		return fmt.Errorf("Invalid layer index %d: the FS has %d layers",
+			layer, len(layers))
+	}
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+	m.priorityOverrides = append(m.priorityOverrides, priorityOverride{
+		pattern: pattern,
+		layer:   layer,
+		fsys:    layers[layer],
+	})
+	m.publish(Event{Path: literalPrefix(pattern), Reason: "override"})
+	return nil
+}
+
+// Returns the regular file at path from the first matching override's layer,
+// or nil if no overrides apply.
+func (m *MergedFS) openOverride(ctx context.Context,
+	overrides []priorityOverride, path string) (fs.File, error) {
+	for _, o := range overrides {
+		if !o.matches(path) {
+			continue
+		}
+		f, e := m.openFlattenedLayer(ctx, o.layer, path)
+		if o.pinned {
+			if e != nil {
+				traceStep(ctx, "pin", "", "pinned to layer %d, which can't "+
+					"open the path", o.layer)
+				return nil, &fs.PathError{Op: "open", Path: path,
+					Err: fmt.Errorf("pinned to layer %d: %w", o.layer, e)}
+			}
+			traceStep(ctx, "pin", "", "pinned to layer %d", o.layer)
+			return f, nil
+		}
+		if e != nil {
+			if isBadPathError(e) {
+				traceStep(ctx, "override", "", "%q matches, but layer %d "+
+					"doesn't contain the path", o.pattern, o.layer)
+				continue
+			}
+			return nil, fmt.Errorf("Couldn't open %s in overriding layer "+
+				"%d: %w", path, o.layer, e)
+		}
+		info, e := f.Stat()
+		if e != nil {
+			f.Close()
+			return nil, fmt.Errorf("Couldn't stat %s in overriding layer "+
+				"%d: %w", path, o.layer, e)
+		}
+		if info.IsDir() {
+			f.Close()
+			continue
+		}
+		traceStep(ctx, "override", "", "%q matches, so using layer %d's "+
+			"copy", o.pattern, o.layer)
+		return f, nil
+	}
+	return nil, nil
+}
+
+// If f is a directory, returns a directory with the same metadata as f, but
+// with any regular files replaced by their overriding counterparts. Closes f
+// if it's replaced.
+func applyOverridesToDir(overrides []priorityOverride, dirPath string,
+	f fs.File) (fs.File, error) {
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return f, nil
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if !info.IsDir() {
+		return f, nil
+	}
+	entries, e := dir.ReadDir(-1)
+	f.Close()
+	if e != nil {
+		return nil, e
+	}
+	entries = append([]fs.DirEntry(nil), entries...)
+	for i, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		childPath := path.Join(dirPath, entry.Name())
+		for _, o := range overrides {
+			if !o.matches(childPath) {
+				continue
+			}
+			childInfo, e := fs.Stat(o.fsys, childPath)
+			if (e != nil) || childInfo.IsDir() {
+				continue
+			}
+			entries[i] = &provenanceEntry{
+				DirEntry:   infoDirEntry{childInfo},
+				layerIndex: o.layer,
+				layerName:  layerNameForProvenance(o.fsys),
+			}
+			break
+		}
+	}
+	return &completedDir{
+		MergedDirectory: &MergedDirectory{
+			name:    info.Name(),
+			mode:    info.Mode(),
+			entries: entries,
+		},
+		info: info,
+	}, nil
+}
+
+// Forces the given path to be served from the layer at the given index (see
+// Layers), regardless of the normal priority order or any overrides added
+// using AddPriorityOverride. For example, if the last layer holds an older
+// release, this serves the older copy of a single file:
+//
+//	e := merged.Pin("static/app.js", len(merged.Layers())-1)
+//
+// Unlike overrides, pins apply to directories as well as regular files, and
+// opening a pinned path fails if the pinned layer can't open it, rather than
+// falling back to the other layers. Pinning a directory pins its whole
+// subtree: the directory is served exactly as it appears in the pinned layer,
+// without merging, and so is every path within it. Pins on paths within a
+// pinned directory take precedence over the directory's pin. Directory
+// listings show the pinned layer's metadata for pinned regular files. Pinning
+// a path that's already pinned replaces the earlier pin. Like overrides, pins
+// only apply when opening paths using m itself.
+//
+// Returns an error if the path or layer index is invalid.
+func (m *MergedFS) Pin(path string, layer int) error {
+	if !fs.ValidPath(path) {
+		return &fs.PathError{Op: "pin", Path: path, Err: fs.ErrInvalid}
+	}
+	layers := m.Layers()
+	if (layer < 0) || (layer >= len(layers)) {
+		return fmt.Errorf("Invalid layer index %d: the FS has %d layers",
+			layer, len(layers))
+	}
+	pin := priorityOverride{
+		pattern: path,
+		layer:   layer,
+		fsys:    layers[layer],
+		pinned:  true,
+	}
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+	// Replace the slice rather than modifying it, since Opens in progress may
+	// still be using the old one.
+	pins := make([]priorityOverride, 0, len(m.pins)+1)
+	for _, p := range m.pins {
+		if p.pattern != path {
+			pins = append(pins, p)
+		}
+	}
+	pins = append(pins, pin)
+	// Pins within a pinned directory always have longer paths than the
+	// directory, so checking longer paths first lets them take precedence.
+	sort.SliceStable(pins, func(i, j int) bool {
+		return len(pins[i].pattern) > len(pins[j].pattern)
+	})
+	m.pins = pins
+	m.publish(Event{Path: path, Reason: "pin"})
+	return nil
+}
+
+// Removes the pin for the given path, if there is one.
+func (m *MergedFS) Unpin(path string) {
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+	var pins []priorityOverride
+	for _, p := range m.pins {
+		if p.pattern != path {
+			pins = append(pins, p)
+		}
+	}
+	m.pins = pins
+	m.publish(Event{Path: path, Reason: "pin"})
+}
+
+// Returns the pinned paths and the index of the layer each is pinned to.
+func (m *MergedFS) Pins() map[string]int {
+	m.configMutex.RLock()
+	defer m.configMutex.RUnlock()
+	toReturn := make(map[string]int, len(m.pins))
+	for _, p := range m.pins {
+		toReturn[p.pattern] = p.layer
+	}
+	return toReturn
+}
@@ -0,0 +1,262 @@
+package merged_fs
+
+import (
+	"errors"
+	"io/fs"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLayers(t *testing.T) {
+	fss := make([]fs.FS, 5)
+	for i := range fss {
+		fss[i] = &openCountingFS{FS: fstest.MapFS{}}
+	}
+	merged := MergeMultiple(fss...).(*MergedFS)
+	layers := merged.Layers()
+	if len(layers) != len(fss) {
+		t.Logf("Expected %d layers, got %d.\n", len(fss), len(layers))
+		t.FailNow()
+	}
+	for i := range fss {
+		if layers[i] != fss[i] {
+			t.Logf("Layer %d is out of order.\n", i)
+			t.FailNow()
+		}
+	}
+}
+
+func TestPriorityOverride(t *testing.T) {
+	base := fstest.MapFS{
+		"branding/logo.png": newMapFile("base logo"),
+		"branding/font.ttf": newMapFile("base font"),
+		"index.html":        newMapFile("base index"),
+	}
+	theme := fstest.MapFS{
+		"branding/logo.png": newMapFile("theme logo"),
+		"index.html":        newMapFile("theme index"),
+	}
+	customer := fstest.MapFS{
+		"branding/logo.png": newMapFile("customer logo!"),
+		"index.html":        newMapFile("customer index"),
+	}
+	merged := MergeMultiple(base, theme, customer).(*MergedFS)
+	e := merged.AddPriorityOverride("branding/**", 2)
+	if e != nil {
+		t.Logf("Failed adding priority override: %s\n", e)
+		t.FailNow()
+	}
+	expected := map[string]string{
+		"branding/logo.png": "customer logo!",
+		"branding/font.ttf": "base font",
+		"index.html":        "base index",
+	}
+	for p, content := range expected {
+		data, e := fs.ReadFile(merged, p)
+		if e != nil {
+			t.Logf("Failed reading %s: %s\n", p, e)
+			t.FailNow()
+		}
+		if string(data) != content {
+			t.Logf("Got content %q for %s, expected %q\n", data, p, content)
+			t.Fail()
+		}
+	}
+	e = fstest.TestFS(merged, "branding/logo.png", "branding/font.ttf",
+		"index.html")
+	if e != nil {
+		t.Logf("TestFS failed with a priority override: %s\n", e)
+		t.FailNow()
+	}
+
+	// The overriding layer should be recorded as the one serving the file.
+	sink := &recordingSink{}
+	e = merged.SetAuditSink(sink, 1)
+	if e != nil {
+		t.Logf("Failed setting audit sink: %s\n", e)
+		t.FailNow()
+	}
+	f, e := merged.Open("branding/logo.png")
+	if e != nil {
+		t.Logf("Failed opening an overridden file: %s\n", e)
+		t.FailNow()
+	}
+	f.Close()
+	records := sink.take()
+	if (len(records) != 1) || (records[0].LayerIndex != 2) {
+		t.Logf("Got incorrect audit records for an overridden file: %+v\n",
+			records)
+		t.FailNow()
+	}
+	if merged.AddPriorityOverride("a/[", 0) == nil {
+		t.Logf("Didn't get expected error for an invalid pattern.\n")
+		t.Fail()
+	}
+	if merged.AddPriorityOverride("a", 3) == nil {
+		t.Logf("Didn't get expected error for an invalid layer.\n")
+		t.Fail()
+	}
+}
+
+func TestPin(t *testing.T) {
+	current := fstest.MapFS{
+		"static/app.js":   newMapFile("new app"),
+		"static/app.css":  newMapFile("new css"),
+		"docs/index.html": newMapFile("new docs"),
+	}
+	lastWeek := fstest.MapFS{
+		"static/app.js":   newMapFile("old app"),
+		"static/app.css":  newMapFile("old css"),
+		"docs/old.html":   newMapFile("old page"),
+		"docs/index.html": newMapFile("old docs"),
+	}
+	merged := NewMergedFS(current, lastWeek)
+	e := merged.Pin("static/app.js", 1)
+	if e != nil {
+		t.Logf("Failed pinning a file: %s\n", e)
+		t.FailNow()
+	}
+	expected := map[string]string{
+		"static/app.js":   "old app",
+		"static/app.css":  "new css",
+		"docs/index.html": "new docs",
+	}
+	for p, content := range expected {
+		data, e := merged.ReadFile(p)
+		if (e != nil) || (string(data) != content) {
+			t.Logf("Got %q, %v for %s, expected %q\n", data, e, p, content)
+			t.FailNow()
+		}
+	}
+	e = fstest.TestFS(merged, "static/app.js", "static/app.css",
+		"docs/index.html", "docs/old.html")
+	if e != nil {
+		t.Logf("TestFS failed with a pinned file: %s\n", e)
+		t.FailNow()
+	}
+
+	// Pinning a directory serves it unmerged from the pinned layer.
+	e = merged.Pin("docs", 0)
+	if e != nil {
+		t.Logf("Failed pinning a directory: %s\n", e)
+		t.FailNow()
+	}
+	entries, e := merged.ReadDir("docs")
+	if (e != nil) || (len(entries) != 1) {
+		t.Logf("Expected 1 entry in pinned dir, got %v, %v\n", entries, e)
+		t.FailNow()
+	}
+	// The pin applies to everything within the directory, so the paths
+	// it lists are the only ones that can be opened.
+	_, e = merged.Open("docs/old.html")
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Opened a file outside a pinned directory's layer: %v\n", e)
+		t.FailNow()
+	}
+	e = merged.Pin("static", 0)
+	if e != nil {
+		t.Logf("Failed pinning a directory: %s\n", e)
+		t.FailNow()
+	}
+	expected = map[string]string{
+		"static/app.js":  "old app",
+		"static/app.css": "new css",
+	}
+	for p, content := range expected {
+		data, e := merged.ReadFile(p)
+		if (e != nil) || (string(data) != content) {
+			t.Logf("Got %q, %v for %s in a pinned directory, expected %q\n",
+				data, e, p, content)
+			t.FailNow()
+		}
+	}
+	merged.Unpin("static")
+
+	// Pinned paths fail if the pinned layer can't open them.
+	e = merged.Pin("docs/old.html", 0)
+	if e != nil {
+		t.Logf("Failed pinning a missing file: %s\n", e)
+		t.FailNow()
+	}
+	_, e = merged.Open("docs/old.html")
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Didn't get expected error opening missing pinned file: %v\n",
+			e)
+		t.FailNow()
+	}
+	pins := merged.Pins()
+	if (len(pins) != 3) || (pins["static/app.js"] != 1) {
+		t.Logf("Got incorrect pins: %v\n", pins)
+		t.FailNow()
+	}
+	merged.Unpin("docs")
+	merged.Unpin("docs/old.html")
+	merged.Unpin("static/app.js")
+	data, e := merged.ReadFile("static/app.js")
+	if (e != nil) || (string(data) != "new app") {
+		t.Logf("Got %q, %v after unpinning\n", data, e)
+		t.FailNow()
+	}
+	if merged.Pin("../a", 0) == nil {
+		t.Logf("Didn't get expected error for an invalid path.\n")
+		t.FailNow()
+	}
+	if merged.Pin("a", 2) == nil {
+		t.Logf("Didn't get expected error for an invalid layer.\n")
+		t.FailNow()
+	}
+}
+
+func TestPinnedLayerLimits(t *testing.T) {
+	tracker := &concurrencyTrackingFS{FS: fstest.MapFS{
+		"a.txt": newMapFile("old"),
+	}}
+	merged := NewMergedFS(fstest.MapFS{"a.txt": newMapFile("new")},
+		NewMergedFS(fstest.MapFS{}, &Layer{Name: "archive", FS: tracker,
+			MaxConcurrent: 1}))
+	e := merged.Pin("a.txt", 2)
+	if e != nil {
+		t.Logf("Failed pinning a file: %s\n", e)
+		t.FailNow()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, e := merged.ReadFile("a.txt")
+			if (e != nil) || (string(data) != "old") {
+				t.Logf("Got %q, %v for a pinned file\n", data, e)
+				t.Fail()
+			}
+		}()
+	}
+	wg.Wait()
+	if tracker.maxSeen > 1 {
+		t.Logf("Expected at most 1 concurrent open of a pinned file, saw "+
+			"%d\n", tracker.maxSeen)
+		t.FailNow()
+	}
+
+	// The pinned layer should be recorded as the one serving the file.
+	sink := &recordingSink{}
+	e = merged.SetAuditSink(sink, 1)
+	if e != nil {
+		t.Logf("Failed setting audit sink: %s\n", e)
+		t.FailNow()
+	}
+	f, e := merged.Open("a.txt")
+	if e != nil {
+		t.Logf("Failed opening a pinned file: %s\n", e)
+		t.FailNow()
+	}
+	f.Close()
+	records := sink.take()
+	if (len(records) != 1) || (records[0].LayerIndex != 2) ||
+		(records[0].Layer != "archive") {
+		t.Logf("Got incorrect audit records for a pinned file: %+v\n",
+			records)
+		t.FailNow()
+	}
+}
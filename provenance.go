@@ -0,0 +1,142 @@
+package merged_fs
+
+import (
+	"io"
+	"io/fs"
+)
+
+// ProvenanceEntry is implemented by the DirEntries in directories read from a
+// MergedFS, so that tools listing directories can tell which layer each entry
+// came from without opening it. Entries that the MergedFS synthesizes, such
+// as the entries for aliases or synthetic directories, don't implement it.
+type ProvenanceEntry interface {
+	fs.DirEntry
+	// Returns the index of the layer the entry came from, as used by Layers,
+	// and the layer's name if it's a *Layer with a Name, or an empty string
+	// otherwise. For a directory that exists in more than one layer, this
+	// refers to the highest-priority layer containing it.
+	Provenance() (layerIndex int, layerName string)
+}
+
+// Adds provenance to a DirEntry.
+type provenanceEntry struct {
+	fs.DirEntry
+	layerIndex int
+	layerName  string
+}
+
+func (e *provenanceEntry) Provenance() (int, string) {
+	return e.layerIndex, e.layerName
+}
+
+// Returns the name of fsys to report from Provenance.
+func layerNameForProvenance(fsys fs.FS) string {
+	if l, ok := fsys.(*Layer); ok {
+		return l.Name
+	}
+	return ""
+}
+
+// Returns the number of layers making up fsys, as returned by Layers.
+func layerCount(fsys fs.FS) int {
+	switch v := fsys.(type) {
+	case *MergedFS:
+		return layerCount(v.A) + layerCount(v.B)
+	case *Group:
+		return layerCount(v.MergedFS)
+	}
+	return 1
+}
+
+// Returns true if fsys is a MergedFS or Group.
+func isMergedFS(fsys fs.FS) bool {
+	switch fsys.(type) {
+	case *MergedFS, *Group:
+		return true
+	}
+	return false
+}
+
+// Returns entries read from the given side of m, with provenance relative to
+// m. Entries from a nested MergedFS already carry provenance relative to the
+// nested FS, which is adjusted.
+func (m *MergedFS) addProvenance(side int,
+	entries []fs.DirEntry) []fs.DirEntry {
+	offset := 0
+	if side == 1 {
+		offset = layerCount(m.A)
+	}
+	name := layerNameForProvenance(m.layer(side))
+	toReturn := make([]fs.DirEntry, len(entries))
+	for i, entry := range entries {
+		index := offset
+		entryName := name
+		if p, ok := entry.(ProvenanceEntry); ok {
+			var nestedIndex int
+			nestedIndex, entryName = p.Provenance()
+			index += nestedIndex
+			if wrapped, ok := entry.(*provenanceEntry); ok {
+				entry = wrapped.DirEntry
+			}
+		} else if isMergedFS(m.layer(side)) {
+			// The nested FS synthesized the entry, so it has no provenance.
+			toReturn[i] = entry
+			continue
+		}
+		toReturn[i] = &provenanceEntry{
+			DirEntry:   entry,
+			layerIndex: index,
+			layerName:  entryName,
+		}
+	}
+	return toReturn
+}
+
+// Directories in both A and B lose their provenance when their entries are
+// merged, so this restores it in merged using the entries from A.
+func (m *MergedFS) restoreProvenance(entriesA, merged []fs.DirEntry) {
+	var fromA map[string]*provenanceEntry
+	for i, entry := range merged {
+		d, ok := entry.(*MergedDirectory)
+		if !ok {
+			continue
+		}
+		if fromA == nil {
+			fromA = make(map[string]*provenanceEntry)
+			for _, a := range entriesA {
+				if p, ok := a.(*provenanceEntry); ok {
+					fromA[a.Name()] = p
+				}
+			}
+		}
+		if p := fromA[d.name]; p != nil {
+			merged[i] = &provenanceEntry{d, p.layerIndex, p.layerName}
+		}
+	}
+}
+
+// Adds provenance to the entries read from a directory from one side of a
+// MergedFS.
+type provenanceDir struct {
+	dir  dirReader
+	m    *MergedFS
+	side int
+}
+
+func (d *provenanceDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, e := d.dir.ReadDir(n)
+	return d.m.addProvenance(d.side, entries), e
+}
+
+// If f is a directory from the given side of m, returns a wrapper that adds
+// provenance to its entries. Otherwise returns f.
+func (m *MergedFS) withProvenance(side int, f fs.File) fs.File {
+	dir, ok := f.(dirReader)
+	if !ok {
+		return f
+	}
+	seeker, _ := f.(io.Seeker)
+	readerAt, _ := f.(io.ReaderAt)
+	return addFileInterfaces(f, &provenanceDir{dir, m, side}, seeker,
+		readerAt, nil, nil)
+}
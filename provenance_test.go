@@ -0,0 +1,70 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProvenance(t *testing.T) {
+	merged := MergeMultiple(
+		&Layer{Name: "patch", FS: fstest.MapFS{
+			"a.txt":          newMapFile("patch"),
+			"shared/p.txt":   newMapFile("patch"),
+			"patchonly/x.go": newMapFile("patch"),
+		}},
+		fstest.MapFS{
+			"b.txt":        newMapFile("mod"),
+			"shared/m.txt": newMapFile("mod"),
+		},
+		&Layer{Name: "base", FS: fstest.MapFS{
+			"a.txt":          newMapFile("base"),
+			"c.txt":          newMapFile("base"),
+			"shared/b.txt":   newMapFile("base"),
+			"baseonly/y.txt": newMapFile("base"),
+		}},
+	).(*MergedFS)
+	expected := map[string]int{
+		"a.txt":          0,
+		"b.txt":          1,
+		"c.txt":          2,
+		"shared":         0,
+		"patchonly":      0,
+		"baseonly":       2,
+		"shared/p.txt":   0,
+		"shared/m.txt":   1,
+		"shared/b.txt":   2,
+		"baseonly/y.txt": 2,
+		"patchonly/x.go": 0,
+	}
+	names := []string{"patch", "", "base"}
+	e := fs.WalkDir(merged, ".", func(p string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if p == "." {
+			return nil
+		}
+		provenance, ok := d.(ProvenanceEntry)
+		if !ok {
+			t.Logf("Entry for %s doesn't implement ProvenanceEntry\n", p)
+			t.FailNow()
+		}
+		index, name := provenance.Provenance()
+		if (index != expected[p]) || (name != names[index]) {
+			t.Logf("Got provenance %d (%q) for %s, expected %d\n", index,
+				name, p, expected[p])
+			t.FailNow()
+		}
+		return nil
+	})
+	if e != nil {
+		t.Logf("Failed walking merged FS: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(merged, "a.txt", "shared/m.txt", "baseonly/y.txt")
+	if e != nil {
+		t.Logf("Merged FS failed fstest: %s\n", e)
+		t.FailNow()
+	}
+}
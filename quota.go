@@ -0,0 +1,263 @@
+package merged_fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync/atomic"
+)
+
+// ErrQuotaExceeded is wrapped by every *QuotaExceededError, so callers can
+// check for quota failures using errors.Is.
+var ErrQuotaExceeded = errors.New("read quota exceeded")
+
+// Returned by reads that would exceed a read quota set using
+// MergedFS.SetReadQuota or Layer.ReadQuota.
+type QuotaExceededError struct {
+	// The name of the layer whose quota was exceeded, or an empty string if
+	// this was the quota of a MergedFS.
+	Layer string
+	// The quota, in bytes.
+	Limit int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Layer == "" {
+		return fmt.Sprintf("%s: limit is %d bytes", ErrQuotaExceeded, e.Limit)
+	}
+	return fmt.Sprintf("%s for layer %s: limit is %d bytes", ErrQuotaExceeded,
+		e.Layer, e.Limit)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// Counts bytes read, and enforces an optional limit on them. Safe for
+// concurrent use.
+type readMeter struct {
+	// The number of bytes read (or reserved by reads in progress). Only
+	// access this atomically.
+	count int64
+	// If positive, count may never exceed this.
+	limit int64
+	// Used in QuotaExceededErrors.
+	layerName string
+}
+
+// Reserves up to want bytes for a read, returning the number of bytes that may
+// be read, which will be 0 if the limit has been reached.
+func (r *readMeter) reserve(want int) int {
+	if r.limit <= 0 {
+		atomic.AddInt64(&r.count, int64(want))
+		return want
+	}
+	for {
+		current := atomic.LoadInt64(&r.count)
+		available := r.limit - current
+		if available <= 0 {
+			return 0
+		}
+		if int64(want) < available {
+			available = int64(want)
+		}
+		if atomic.CompareAndSwapInt64(&r.count, current, current+available) {
+			return int(available)
+		}
+	}
+}
+
+// Returns reserved bytes that were not actually read.
+func (r *readMeter) unreserve(n int) {
+	atomic.AddInt64(&r.count, -int64(n))
+}
+
+func (r *readMeter) bytesRead() int64 {
+	return atomic.LoadInt64(&r.count)
+}
+
+func (r *readMeter) quotaError() error {
+	return &QuotaExceededError{Layer: r.layerName, Limit: r.limit}
+}
+
+// Performs a metered read of p, using the given read function. If the limit
+// has been reached, this still reads a single byte in order to distinguish
+// between EOF and an actual attempt to exceed the quota.
+func (r *readMeter) read(p []byte, read func([]byte) (int, error)) (int,
+	error) {
+	if len(p) == 0 {
+		return read(p)
+	}
+	allowed := r.reserve(len(p))
+	if allowed == 0 {
+		var probe [1]byte
+		n, e := read(probe[:])
+		if n == 0 {
+			return 0, e
+		}
+		return 0, r.quotaError()
+	}
+	n, e := read(p[:allowed])
+	r.unreserve(allowed - n)
+	return n, e
+}
+
+// Wraps a File, counting the bytes read from it using one or more meters.
+type meteredFile struct {
+	fs.File
+	meters []*readMeter
+}
+
+// Wraps a read function so that it's metered by each of meters.
+func meteredRead(meters []*readMeter, p []byte,
+	read func([]byte) (int, error)) (int, error) {
+	if len(meters) == 0 {
+		return read(p)
+	}
+	return meters[0].read(p, func(p []byte) (int, error) {
+		return meteredRead(meters[1:], p, read)
+	})
+}
+
+func (f *meteredFile) Unwrap() fs.File {
+	return f.File
+}
+
+func (f *meteredFile) Read(p []byte) (int, error) {
+	return meteredRead(f.meters, p, f.File.Read)
+}
+
+// Provides a metered ReadAt for a meteredFile whose underlying File supports
+// it.
+type meteredReaderAt struct {
+	f *meteredFile
+}
+
+func (r meteredReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	readerAt := r.f.File.(io.ReaderAt)
+	return meteredRead(r.f.meters, p, func(p []byte) (int, error) {
+		return readerAt.ReadAt(p, off)
+	})
+}
+
+// Wraps f so that reads from it are counted by the given meters, while
+// preserving f's optional interfaces.
+func newMeteredFile(f fs.File, meters ...*readMeter) fs.File {
+	metered := &meteredFile{
+		File:   f,
+		meters: meters,
+	}
+	dir, _ := f.(dirReader)
+	seeker, _ := f.(io.Seeker)
+	var readerAt io.ReaderAt
+	if _, ok := f.(io.ReaderAt); ok {
+		readerAt = meteredReaderAt{metered}
+	}
+	var mapped mapper
+	if _, ok := f.(mapper); ok {
+		mapped = meteredMapper{metered}
+	}
+	var writerTo io.WriterTo
+	if _, ok := f.(io.WriterTo); ok {
+		writerTo = meteredWriterTo{metered}
+	}
+	return addFileInterfaces(metered, dir, seeker, readerAt, mapped, writerTo)
+}
+
+// Reserves n bytes from every meter, or none of them if any meter has fewer
+// than n bytes available, in which case this returns the meter's quota error.
+func reserveAll(meters []*readMeter, n int) error {
+	for i, meter := range meters {
+		if got := meter.reserve(n); got < n {
+			meter.unreserve(got)
+			for _, reserved := range meters[:i] {
+				reserved.unreserve(n)
+			}
+			return meter.quotaError()
+		}
+	}
+	return nil
+}
+
+// Provides a metered WriteTo for a meteredFile whose underlying File supports
+// it. Each chunk the underlying file writes is counted before it's passed on,
+// and the write fails if the chunk would exceed a quota.
+type meteredWriterTo struct {
+	f *meteredFile
+}
+
+func (w meteredWriterTo) WriteTo(dst io.Writer) (int64, error) {
+	return w.f.File.(io.WriterTo).WriteTo(&meteredWriter{
+		w:      dst,
+		meters: w.f.meters,
+	})
+}
+
+// Counts the bytes written to w using each of meters.
+type meteredWriter struct {
+	w      io.Writer
+	meters []*readMeter
+}
+
+func (w *meteredWriter) Write(p []byte) (int, error) {
+	e := reserveAll(w.meters, len(p))
+	if e != nil {
+		return 0, e
+	}
+	n, e := w.w.Write(p)
+	for _, meter := range w.meters {
+		meter.unreserve(len(p) - n)
+	}
+	return n, e
+}
+
+// Enforces a meter's limit on an entire file's contents that were read in one
+// operation, such as a call to fs.ReadFile.
+func (r *readMeter) readAll(data []byte, e error) ([]byte, error) {
+	if e != nil {
+		return data, e
+	}
+	if got := r.reserve(len(data)); got < len(data) {
+		r.unreserve(got)
+		return nil, r.quotaError()
+	}
+	return data, nil
+}
+
+// Starts or restarts counting the bytes read from files opened using m,
+// resetting the count to zero. If limit is positive, reads will fail with a
+// *QuotaExceededError rather than allowing the total to exceed limit bytes.
+// If limit is zero, bytes are counted without a quota. A negative limit stops
+// counting bytes entirely, which is the default.
+//
+// Counting bytes requires wrapping every File returned by m.Open. The wrappers
+// preserve the ReadDirFile, io.Seeker, io.ReaderAt, io.WriterTo, and
+// MappedFile interfaces of the wrapped files, but not necessarily any others.
+func (m *MergedFS) SetReadQuota(limit int64) {
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+	if limit < 0 {
+		m.readMeter = nil
+		return
+	}
+	m.readMeter = &readMeter{limit: limit}
+}
+
+// Returns the number of bytes read from files opened using m since the last
+// call to SetReadQuota. Returns 0 if byte counting isn't enabled.
+func (m *MergedFS) BytesRead() int64 {
+	m.configMutex.RLock()
+	meter := m.readMeter
+	m.configMutex.RUnlock()
+	if meter == nil {
+		return 0
+	}
+	return meter.bytesRead()
+}
+
+// Returns the number of bytes read from the layer's files so far.
+func (l *Layer) BytesRead() int64 {
+	l.init()
+	return l.meter.bytesRead()
+}
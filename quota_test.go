@@ -0,0 +1,139 @@
+package merged_fs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadQuota(t *testing.T) {
+	fsA := fstest.MapFS{
+		"a.txt":     newMapFile("0123456789"),
+		"dir/b.txt": newMapFile("abcde"),
+	}
+	fsB := fstest.MapFS{
+		"c.txt": newMapFile("hello"),
+	}
+	layerB := &Layer{FS: fsB, Name: "layer B"}
+	merged := NewMergedFS(fsA, layerB)
+	merged.SetReadQuota(0)
+	e := fstest.TestFS(merged, "a.txt", "dir/b.txt", "c.txt")
+	if e != nil {
+		t.Logf("TestFS failed with byte counting enabled: %s\n", e)
+		t.FailNow()
+	}
+	if merged.BytesRead() == 0 {
+		t.Logf("Didn't count any bytes read during TestFS.\n")
+		t.FailNow()
+	}
+	if layerB.BytesRead() == 0 {
+		t.Logf("Didn't count any bytes read from layer B.\n")
+		t.FailNow()
+	}
+	t.Logf("Read %d bytes total, %d from layer B.\n", merged.BytesRead(),
+		layerB.BytesRead())
+
+	// Reading exactly up to the quota must succeed.
+	merged.SetReadQuota(15)
+	data, e := merged.ReadFile("a.txt")
+	if e != nil {
+		t.Logf("Failed reading a.txt: %s\n", e)
+		t.FailNow()
+	}
+	data2, e := merged.ReadFile("dir/b.txt")
+	if e != nil {
+		t.Logf("Failed reading dir/b.txt: %s\n", e)
+		t.FailNow()
+	}
+	if (string(data) != "0123456789") || (string(data2) != "abcde") {
+		t.Logf("Got incorrect content: %q, %q\n", data, data2)
+		t.FailNow()
+	}
+	if merged.BytesRead() != 15 {
+		t.Logf("Expected 15 bytes read, got %d.\n", merged.BytesRead())
+		t.FailNow()
+	}
+	_, e = merged.ReadFile("c.txt")
+	var quotaError *QuotaExceededError
+	if !errors.As(e, &quotaError) || !errors.Is(e, ErrQuotaExceeded) {
+		t.Logf("Didn't get expected quota error. Got %v.\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected quota error: %s\n", e)
+
+	// Disabling the quota stops wrapping files.
+	merged.SetReadQuota(-1)
+	f, e := merged.Open("c.txt")
+	if e != nil {
+		t.Logf("Failed opening c.txt: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	if _, ok := f.(*meteredFile); ok {
+		t.Logf("File was still metered after disabling the quota.\n")
+		t.FailNow()
+	}
+}
+
+func TestLayerReadQuota(t *testing.T) {
+	fsA := fstest.MapFS{
+		"a.txt": newMapFile("0123456789"),
+	}
+	layer := &Layer{FS: fsA, Name: "limited", ReadQuota: 4}
+	merged := NewMergedFS(fstest.MapFS{}, layer)
+	f, e := merged.Open("a.txt")
+	if e != nil {
+		t.Logf("Failed opening a.txt: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	if _, ok := f.(io.ReaderAt); !ok {
+		t.Logf("Metered MapFS file doesn't implement io.ReaderAt.\n")
+		t.FailNow()
+	}
+	_, e = io.ReadAll(f)
+	var quotaError *QuotaExceededError
+	if !errors.As(e, &quotaError) {
+		t.Logf("Didn't get expected quota error. Got %v.\n", e)
+		t.FailNow()
+	}
+	if quotaError.Layer != "limited" {
+		t.Logf("Quota error had the wrong layer name: %s\n", quotaError.Layer)
+		t.FailNow()
+	}
+	if layer.BytesRead() != 4 {
+		t.Logf("Expected 4 bytes read from layer, got %d.\n",
+			layer.BytesRead())
+		t.FailNow()
+	}
+	_, e = fs.ReadFile(merged, "a.txt")
+	if !errors.Is(e, ErrQuotaExceeded) {
+		t.Logf("Didn't get expected quota error from ReadFile. Got %v.\n", e)
+		t.FailNow()
+	}
+}
+
+func TestReadQuotaFailedReadFile(t *testing.T) {
+	merged := NewMergedFS(fstest.MapFS{
+		"big.txt":   newMapFile("0123456789"),
+		"small.txt": newMapFile("ab"),
+	}, fstest.MapFS{})
+	merged.SetReadQuota(5)
+	_, e := merged.ReadFile("big.txt")
+	if !errors.Is(e, ErrQuotaExceeded) {
+		t.Logf("Didn't get expected quota error. Got %v.\n", e)
+		t.FailNow()
+	}
+	// The failed read must not use up any of the quota.
+	if merged.BytesRead() != 0 {
+		t.Logf("Failed read counted %d bytes.\n", merged.BytesRead())
+		t.FailNow()
+	}
+	data, e := merged.ReadFile("small.txt")
+	if (e != nil) || (string(data) != "ab") {
+		t.Logf("Got %q, %v reading within the quota.\n", data, e)
+		t.FailNow()
+	}
+}
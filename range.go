@@ -0,0 +1,82 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// Calls fn for every path in m that isn't a directory, in lexicographic order
+// of the full paths, starting at start (inclusive) and stopping before end
+// (exclusive). If end is empty, there is no upper bound. If fn returns an
+// error, RangePaths stops and returns it.
+//
+// Only directories that may contain paths within the range are read, so
+// querying a small range of a large tree is cheap, and no list of every path
+// is materialized. With UseDirectoryCaching enabled, repeated queries are
+// served from the cached merged listings. Note that the order differs from
+// the order used by fs.WalkDir, which visits the contents of "a" before
+// "a-b", even though "a-b" sorts before "a/b".
+func (m *MergedFS) RangePaths(start, end string,
+	fn func(path string, d fs.DirEntry) error) error {
+	return m.rangeDir(".", start, end, fn)
+}
+
+// Implements RangePaths within a single directory.
+func (m *MergedFS) rangeDir(dirPath, start, end string,
+	fn func(path string, d fs.DirEntry) error) error {
+	entries, e := fs.ReadDir(m, dirPath)
+	if e != nil {
+		return e
+	}
+	// Sort entries by the keys that their contents have in the full order:
+	// a directory's contents all start with its name followed by a slash.
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Name()
+		if entry.IsDir() {
+			keys[i] += "/"
+		}
+	}
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return keys[order[a]] < keys[order[b]]
+	})
+	for _, i := range order {
+		entry := entries[i]
+		p := keys[i]
+		if dirPath != "." {
+			p = path.Join(dirPath, p)
+			if entry.IsDir() {
+				p += "/"
+			}
+		}
+		if (end != "") && (p >= end) {
+			return nil
+		}
+		if !entry.IsDir() {
+			if p < start {
+				continue
+			}
+			e = fn(p, entry)
+			if e != nil {
+				return e
+			}
+			continue
+		}
+		// Every path within the directory starts with p (which ends with a
+		// slash), so they all sort before p with the slash replaced by the
+		// next character, '0'.
+		if p[:len(p)-1]+"0" <= start {
+			continue
+		}
+		e = m.rangeDir(p[:len(p)-1], start, end, fn)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRangePaths(t *testing.T) {
+	fsA := fstest.MapFS{
+		"a/b.txt":   newMapFile("A"),
+		"a-b.txt":   newMapFile("A"),
+		"c/d/e.txt": newMapFile("A"),
+		"z.txt":     newMapFile("A"),
+	}
+	fsB := fstest.MapFS{
+		"a/a.txt":   newMapFile("B"),
+		"a.txt":     newMapFile("B"),
+		"c/d/f.txt": newMapFile("B"),
+		"c/g.txt":   newMapFile("B"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	collect := func(start, end string) string {
+		var found []string
+		e := merged.RangePaths(start, end, func(p string,
+			d fs.DirEntry) error {
+			found = append(found, p)
+			return nil
+		})
+		if e != nil {
+			t.Logf("RangePaths(%q, %q) failed: %s\n", start, end, e)
+			t.FailNow()
+		}
+		return strings.Join(found, " ")
+	}
+	all := collect("", "")
+	expected := "a-b.txt a.txt a/a.txt a/b.txt c/d/e.txt c/d/f.txt c/g.txt " +
+		"z.txt"
+	if all != expected {
+		t.Logf("Got all paths %q, expected %q\n", all, expected)
+		t.FailNow()
+	}
+	found := collect("a/b.txt", "c/g.txt")
+	expected = "a/b.txt c/d/e.txt c/d/f.txt"
+	if found != expected {
+		t.Logf("Got range %q, expected %q\n", found, expected)
+		t.FailNow()
+	}
+	found = collect("c/e", "")
+	expected = "c/g.txt z.txt"
+	if found != expected {
+		t.Logf("Got range %q, expected %q\n", found, expected)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,46 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// Returns the contents of each of the given files, or the error encountered
+// while reading it, in the same order as paths. Exactly one of the contents
+// and error at each index is non-nil, unless a file is empty.
+//
+// This is intended for reading large numbers of small files, such as when
+// assembling sprite sheets or locale bundles. Paths are grouped by their
+// parent directories, and each group is read in sorted order by a single
+// goroutine, while up to GOMAXPROCS groups are read at once. Files within the
+// same directory are usually stored near each other (e.g., adjacent in a zip
+// archive's central directory and data), so this keeps each goroutine's
+// accesses mostly sequential. A Layer's MaxConcurrent setting still applies.
+func (m *MergedFS) ReadFileMany(paths []string) ([][]byte, []error) {
+	contents := make([][]byte, len(paths))
+	errs := make([]error, len(paths))
+	byParent := make(map[string][]int)
+	var parents []string
+	for i, p := range paths {
+		parent := path.Dir(p)
+		if _, ok := byParent[parent]; !ok {
+			parents = append(parents, parent)
+		}
+		byParent[parent] = append(byParent[parent], i)
+	}
+	sort.Strings(parents)
+	runConcurrently(len(parents), func(i int) {
+		indices := byParent[parents[i]]
+		sort.Slice(indices, func(a, b int) bool {
+			return paths[indices[a]] < paths[indices[b]]
+		})
+		for _, j := range indices {
+			contents[j], errs[j] = fs.ReadFile(m, paths[j])
+			if errs[j] != nil {
+				contents[j] = nil
+			}
+		}
+	})
+	return contents, errs
+}
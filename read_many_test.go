@@ -0,0 +1,47 @@
+package merged_fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadFileMany(t *testing.T) {
+	fsA := fstest.MapFS{}
+	fsB := fstest.MapFS{}
+	var paths []string
+	for i := 0; i < 200; i++ {
+		p := fmt.Sprintf("locale/%d/strings.txt", i%7)
+		if i%2 == 0 {
+			p = fmt.Sprintf("sprites/%03d.png", i)
+			fsA[p] = newMapFile(p)
+		} else {
+			fsB[p] = newMapFile(p)
+		}
+		paths = append(paths, p)
+	}
+	paths = append(paths, "missing.txt", "../invalid")
+	zip1 := openZip("test_data/test_a.zip", t)
+	merged := MergeMultiple(fsA, zip1, fsB).(*MergedFS)
+	paths = append(paths, "test1.txt")
+	contents, errs := merged.ReadFileMany(paths)
+	for i, p := range paths {
+		expected, expectedError := fs.ReadFile(merged, p)
+		if (errs[i] == nil) != (expectedError == nil) {
+			t.Logf("Got error %v for %s, expected %v\n", errs[i], p,
+				expectedError)
+			t.FailNow()
+		}
+		if string(contents[i]) != string(expected) {
+			t.Logf("Got content %q for %s, expected %q\n", contents[i], p,
+				expected)
+			t.FailNow()
+		}
+	}
+	if !errors.Is(errs[200], fs.ErrNotExist) {
+		t.Logf("Expected ErrNotExist for missing.txt, got %v\n", errs[200])
+		t.FailNow()
+	}
+}
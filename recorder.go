@@ -0,0 +1,184 @@
+package merged_fs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Describes a single Open call and its outcome, as recorded by a Recorder.
+type RecordedOpen struct {
+	// The path that was opened.
+	Path string `json:"path"`
+	// When the open happened. Not compared when replaying.
+	Time time.Time `json:"time"`
+	// The error returned by Open or Stat, if any.
+	Error string `json:"error,omitempty"`
+	// The metadata of the opened file. Not set if there was an error.
+	Name    string      `json:"name,omitempty"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime time.Time   `json:"mod_time,omitempty"`
+	// The names of the entries in the directory, if the path was a
+	// directory.
+	Entries []string `json:"entries,omitempty"`
+}
+
+// Records the paths opened using a MergedFS and their outcomes, so that a
+// sequence of operations that led to unexpected results can be replayed later
+// using Replay. Install it using MergedFS.Use(recorder.Middleware).
+//
+// Recording is expensive: in addition to each Open, the recorder calls Stat
+// on every file, and opens and reads every directory a second time to record
+// its entries.
+type Recorder struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	// The first error encountered while writing records.
+	err error
+}
+
+// Returns a Recorder that writes each record to w as a line of JSON.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// Returns the first error encountered while writing records, if any. Records
+// are no longer written after an error.
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+// A Middleware that records each Open. See MergedFS.Use.
+func (r *Recorder) Middleware(next Opener) Opener {
+	return func(ctx context.Context, path string) (fs.File, error) {
+		f, e := next(ctx, path)
+		reopen := func(path string) (fs.File, error) {
+			return next(ctx, path)
+		}
+		record := describeOpen(reopen, path, f, e)
+		record.Time = time.Now()
+		r.mutex.Lock()
+		if r.err == nil {
+			r.err = r.encoder.Encode(record)
+		}
+		r.mutex.Unlock()
+		return f, e
+	}
+}
+
+// Returns a record describing the results of opening path, using open to
+// re-open the path if it's a directory.
+func describeOpen(open func(path string) (fs.File, error), path string,
+	f fs.File, e error) *RecordedOpen {
+	record := &RecordedOpen{Path: path}
+	if e != nil {
+		record.Error = e.Error()
+		return record
+	}
+	info, e := f.Stat()
+	if e != nil {
+		record.Error = e.Error()
+		return record
+	}
+	record.Name = info.Name()
+	record.Mode = info.Mode()
+	record.Size = info.Size()
+	record.ModTime = info.ModTime()
+	if !info.IsDir() {
+		return record
+	}
+	d, e := open(path)
+	if e != nil {
+		record.Error = fmt.Sprintf("Couldn't re-open directory: %s", e)
+		return record
+	}
+	defer d.Close()
+	dir, ok := d.(fs.ReadDirFile)
+	if !ok {
+		return record
+	}
+	entries, e := dir.ReadDir(-1)
+	if e != nil {
+		record.Error = fmt.Sprintf("Couldn't read directory: %s", e)
+		return record
+	}
+	if len(entries) == 0 {
+		return record
+	}
+	record.Entries = make([]string, len(entries))
+	for i, entry := range entries {
+		record.Entries[i] = entry.Name()
+	}
+	return record
+}
+
+// Describes a recorded Open whose outcome differed when it was replayed.
+type ReplayDifference struct {
+	// The index of the record, starting at 0.
+	Index int
+	// The recorded outcome.
+	Recorded *RecordedOpen
+	// The outcome when replaying.
+	Replayed *RecordedOpen
+}
+
+func (d *ReplayDifference) String() string {
+	recorded, _ := json.Marshal(d.Recorded)
+	replayed, _ := json.Marshal(d.Replayed)
+	return fmt.Sprintf("Open %d (%s): recorded %s, replayed %s", d.Index,
+		d.Recorded.Path, recorded, replayed)
+}
+
+// Reads records written by a Recorder from r, and repeats each Open, in
+// order, using fsys, which is typically a MergedFS rebuilt with the same
+// layers and settings as the one that was recorded. Returns the records whose
+// outcomes differed. Returns an error if the records can't be read.
+func Replay(r io.Reader, fsys fs.FS) ([]ReplayDifference, error) {
+	decoder := json.NewDecoder(r)
+	var toReturn []ReplayDifference
+	for i := 0; ; i++ {
+		var recorded RecordedOpen
+		e := decoder.Decode(&recorded)
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return toReturn, fmt.Errorf("Couldn't read record %d: %w", i, e)
+		}
+		f, e := fsys.Open(recorded.Path)
+		replayed := describeOpen(fsys.Open, recorded.Path, f, e)
+		if f != nil {
+			f.Close()
+		}
+		replayed.Time = recorded.Time
+		if !recordsMatch(&recorded, replayed) {
+			toReturn = append(toReturn, ReplayDifference{
+				Index:    i,
+				Recorded: &recorded,
+				Replayed: replayed,
+			})
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns true if the outcomes in the two records match.
+func recordsMatch(a, b *RecordedOpen) bool {
+	if !a.ModTime.Equal(b.ModTime) {
+		return false
+	}
+	copyA, copyB := *a, *b
+	copyA.ModTime, copyB.ModTime = time.Time{}, time.Time{}
+	copyA.Time, copyB.Time = time.Time{}, time.Time{}
+	return reflect.DeepEqual(&copyA, &copyB)
+}
@@ -0,0 +1,79 @@
+package merged_fs
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	// Rebuilt layers need identical modification times.
+	file := func(content string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(content)}
+	}
+	newLayers := func() (fstest.MapFS, fstest.MapFS) {
+		return fstest.MapFS{
+			"a.txt":     file("A"),
+			"dir/a.txt": file("A"),
+		}, fstest.MapFS{
+			"b.txt":     file("B"),
+			"dir/b.txt": file("B"),
+		}
+	}
+	fsA, fsB := newLayers()
+	merged := NewMergedFS(fsA, fsB)
+	merged.UseDirectoryCaching(true)
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	merged.Use(recorder.Middleware)
+	fs.ReadDir(merged, "dir")
+	// The cached directory won't reflect this change.
+	fsB["dir/c.txt"] = file("C")
+	fs.ReadDir(merged, "dir")
+	fs.ReadFile(merged, "b.txt")
+	merged.Open("missing.txt")
+	if recorder.Err() != nil {
+		t.Logf("Recording failed: %s\n", recorder.Err())
+		t.FailNow()
+	}
+	recording := buf.Bytes()
+
+	// Replaying against the final state of the layers should show that both
+	// listings of "dir" lacked c.txt, even though it had been added before
+	// the second.
+	fsA, fsB = newLayers()
+	fsB["dir/c.txt"] = file("C")
+	differences, e := Replay(bytes.NewReader(recording),
+		NewMergedFS(fsA, fsB))
+	if e != nil {
+		t.Logf("Replay failed: %s\n", e)
+		t.FailNow()
+	}
+	if len(differences) != 2 {
+		t.Logf("Expected 2 differences, got %d\n", len(differences))
+		for i := range differences {
+			t.Logf("%s\n", &differences[i])
+		}
+		t.FailNow()
+	}
+	if (differences[0].Index != 0) || (differences[1].Index != 1) {
+		t.Logf("Got wrong differences: %s, %s\n", &differences[0],
+			&differences[1])
+		t.FailNow()
+	}
+
+	// Replaying against the original layers with caching should match.
+	fsA, fsB = newLayers()
+	replayFS := NewMergedFS(fsA, fsB)
+	replayFS.UseDirectoryCaching(true)
+	differences, e = Replay(bytes.NewReader(recording), replayFS)
+	if e != nil {
+		t.Logf("Replay failed: %s\n", e)
+		t.FailNow()
+	}
+	if len(differences) != 0 {
+		t.Logf("Expected no differences, got %s\n", &differences[0])
+		t.FailNow()
+	}
+}
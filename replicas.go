@@ -0,0 +1,151 @@
+package merged_fs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+)
+
+// Determines how a replica set returned by Replicas chooses a replica.
+type ReplicaPolicy int
+
+const (
+	// Uses each replica in turn.
+	ReplicaRoundRobin ReplicaPolicy = iota
+	// Uses the replica with the fewest open files, counting a file as open
+	// until it's closed. Ties are broken in round-robin order.
+	ReplicaLeastOutstanding
+)
+
+func (p ReplicaPolicy) String() string {
+	switch p {
+	case ReplicaRoundRobin:
+		return "round robin"
+	case ReplicaLeastOutstanding:
+		return "least outstanding"
+	}
+	return "unknown policy"
+}
+
+// Distributes operations across FSs with identical content. See Replicas.
+type replicaFS struct {
+	replicas []fs.FS
+	policy   ReplicaPolicy
+	// The number of replicas chosen so far. Only access this atomically.
+	counter uint64
+	// The number of open files from each replica. Only access these
+	// atomically.
+	outstanding []int64
+}
+
+// Returns an FS that serves each Open or ReadFile using one of the given
+// replicas, chosen according to the given policy. The replicas must contain
+// identical content, such as mirrored network filesystems, since any given
+// path may be served by any of them. The returned FS can then be used as a
+// single layer in a merge:
+//
+//	mirrors := Replicas(ReplicaLeastOutstanding, mirrorA, mirrorB)
+//	merged := NewMergedFS(overrides, mirrors)
+//
+// Each file is served entirely by the replica that opened it; Replicas
+// doesn't retry failed operations using other replicas. Panics if no replicas
+// are given.
+func Replicas(policy ReplicaPolicy, replicas ...fs.FS) fs.FS {
+	if len(replicas) == 0 {
+		panic("Replicas requires at least one replica")
+	}
+	return &replicaFS{
+		replicas:    append([]fs.FS(nil), replicas...),
+		policy:      policy,
+		outstanding: make([]int64, len(replicas)),
+	}
+}
+
+// Returns the index of the replica to use for the next operation.
+func (r *replicaFS) choose() int {
+	n := uint64(len(r.replicas))
+	start := int((atomic.AddUint64(&r.counter, 1) - 1) % n)
+	if r.policy != ReplicaLeastOutstanding {
+		return start
+	}
+	best := start
+	bestCount := atomic.LoadInt64(&r.outstanding[start])
+	for i := 1; i < len(r.replicas); i++ {
+		index := (start + i) % len(r.replicas)
+		count := atomic.LoadInt64(&r.outstanding[index])
+		if count < bestCount {
+			best = index
+			bestCount = count
+		}
+	}
+	return best
+}
+
+func (r *replicaFS) Open(path string) (fs.File, error) {
+	return r.openContext(context.Background(), path)
+}
+
+func (r *replicaFS) openContext(ctx context.Context, path string) (fs.File,
+	error) {
+	if !fs.ValidPath(path) {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrInvalid}
+	}
+	index := r.choose()
+	if r.policy != ReplicaLeastOutstanding {
+		return openContext(ctx, r.replicas[index], path)
+	}
+	atomic.AddInt64(&r.outstanding[index], 1)
+	f, e := openContext(ctx, r.replicas[index], path)
+	if e != nil {
+		atomic.AddInt64(&r.outstanding[index], -1)
+		return nil, e
+	}
+	return newReplicaFile(f, &r.outstanding[index]), nil
+}
+
+func (r *replicaFS) ReadFile(path string) ([]byte, error) {
+	if !fs.ValidPath(path) {
+		return nil, &fs.PathError{Op: "readfile", Path: path,
+			Err: fs.ErrInvalid}
+	}
+	index := r.choose()
+	atomic.AddInt64(&r.outstanding[index], 1)
+	defer atomic.AddInt64(&r.outstanding[index], -1)
+	return fs.ReadFile(r.replicas[index], path)
+}
+
+// Wraps a file opened from a replica, decrementing the replica's count of
+// outstanding files when closed.
+type replicaFile struct {
+	fs.File
+	outstanding *int64
+	closeOnce   sync.Once
+}
+
+// Wraps f, preserving its optional interfaces.
+func newReplicaFile(f fs.File, outstanding *int64) fs.File {
+	wrapped := &replicaFile{
+		File:        f,
+		outstanding: outstanding,
+	}
+	dir, _ := f.(dirReader)
+	seeker, _ := f.(io.Seeker)
+	readerAt, _ := f.(io.ReaderAt)
+	mapped, _ := f.(mapper)
+	writerTo, _ := f.(io.WriterTo)
+	return addFileInterfaces(wrapped, dir, seeker, readerAt, mapped,
+		writerTo)
+}
+
+func (f *replicaFile) Unwrap() fs.File {
+	return f.File
+}
+
+func (f *replicaFile) Close() error {
+	f.closeOnce.Do(func() {
+		atomic.AddInt64(f.outstanding, -1)
+	})
+	return f.File.Close()
+}
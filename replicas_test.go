@@ -0,0 +1,94 @@
+package merged_fs
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// Replicas must be identical, so this doesn't use newMapFile.
+func newReplica() *openCountingFS {
+	return &openCountingFS{FS: fstest.MapFS{
+		"a.txt":     &fstest.MapFile{Data: []byte("replicated")},
+		"dir/b.txt": &fstest.MapFile{Data: []byte("b")},
+	}}
+}
+
+func TestReplicasRoundRobin(t *testing.T) {
+	r1, r2 := newReplica(), newReplica()
+	replicas := Replicas(ReplicaRoundRobin, r1, r2)
+	merged := NewMergedFS(fstest.MapFS{"c.txt": newMapFile("c")}, replicas)
+	e := fstest.TestFS(merged, "a.txt", "dir/b.txt", "c.txt")
+	if e != nil {
+		t.Logf("TestFS failed for replicas: %s\n", e)
+		t.FailNow()
+	}
+	r1.opens, r2.opens = 0, 0
+	for i := 0; i < 10; i++ {
+		f, e := replicas.Open("a.txt")
+		if e != nil {
+			t.Logf("Failed opening a.txt: %s\n", e)
+			t.FailNow()
+		}
+		f.Close()
+	}
+	if (r1.opens != 5) || (r2.opens != 5) {
+		t.Logf("Expected 5 opens in each replica, got %d and %d\n", r1.opens,
+			r2.opens)
+		t.FailNow()
+	}
+	if !strings.Contains(merged.String(), "Replicas(round robin: ") {
+		t.Logf("Got incorrect description: %s\n", merged)
+		t.FailNow()
+	}
+}
+
+func TestReplicasLeastOutstanding(t *testing.T) {
+	r1, r2 := newReplica(), newReplica()
+	replicas := Replicas(ReplicaLeastOutstanding, r1, r2)
+	merged := NewMergedFS(fstest.MapFS{}, replicas)
+	e := fstest.TestFS(merged, "a.txt", "dir/b.txt")
+	if e != nil {
+		t.Logf("TestFS failed for replicas: %s\n", e)
+		t.FailNow()
+	}
+	r1.opens, r2.opens = 0, 0
+
+	// Keep a file open in whichever replica is used first, so every
+	// subsequent Open should go to the other one.
+	held, e := replicas.Open("a.txt")
+	if e != nil {
+		t.Logf("Failed opening a.txt: %s\n", e)
+		t.FailNow()
+	}
+	for i := 0; i < 4; i++ {
+		f, e := replicas.Open("dir/b.txt")
+		if e != nil {
+			t.Logf("Failed opening dir/b.txt: %s\n", e)
+			t.FailNow()
+		}
+		f.Close()
+		// Closing twice must not decrement the count twice.
+		f.Close()
+	}
+	if (r1.opens+r2.opens != 5) || ((r1.opens != 1) && (r2.opens != 1)) {
+		t.Logf("Expected 1 open in one replica and 4 in the other, got %d "+
+			"and %d\n", r1.opens, r2.opens)
+		t.FailNow()
+	}
+	held.Close()
+	r1.opens, r2.opens = 0, 0
+	for i := 0; i < 4; i++ {
+		f, e := replicas.Open("a.txt")
+		if e != nil {
+			t.Logf("Failed opening a.txt: %s\n", e)
+			t.FailNow()
+		}
+		f.Close()
+	}
+	if (r1.opens != 2) || (r2.opens != 2) {
+		t.Logf("Expected ties to alternate, got %d and %d opens\n", r1.opens,
+			r2.opens)
+		t.FailNow()
+	}
+}
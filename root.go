@@ -0,0 +1,98 @@
+package merged_fs
+
+import (
+	"context"
+	"io/fs"
+	"sync/atomic"
+)
+
+// Opening "." (the root directory) in a MergedFS follows the same rules as any
+// other directory:
+//
+//   - If "." is a directory in both A and B, the result is a MergedDirectory
+//     containing the entries from both. Its mode bits come from A's root
+//     directory, and its modification time is the most recent of the two.
+//
+//   - If opening "." in one of the FSs fails with an error wrapping
+//     fs.ErrNotExist or fs.ErrInvalid, that FS is treated as empty, and the
+//     root directory from the other FS is returned as-is.
+//
+//   - Any other error opening or statting "." in either FS is returned.
+//
+// If synthetic roots are enabled using UseSyntheticRoot, then every error
+// from either FS is treated as though the FS were empty, and if neither FS can
+// provide a root directory at all, Open(".") returns an empty directory
+// instead of an error. If both FSs provide a root directory, but they can't be
+// merged (e.g., one of them can't be listed), then B is treated as empty, and
+// A's root directory is returned as-is, or the error if A's root can no longer
+// be opened.
+//
+// Enables or disables synthetic root directories, as described above. Like
+// UsePathCaching, this applies the setting to any MergedFS directly nested
+// within m. Synthetic roots are disabled by default.
+func (m *MergedFS) UseSyntheticRoot(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&m.syntheticRoot, value)
+	for side := 0; side < 2; side++ {
+		nested, ok := m.layer(side).(*MergedFS)
+		if ok {
+			nested.UseSyntheticRoot(enabled)
+		}
+	}
+}
+
+// Returns an empty directory to use as the root of a MergedFS.
+func newSyntheticRoot() *MergedDirectory {
+	return &MergedDirectory{
+		name:    ".",
+		mode:    0555 | fs.ModeDir,
+		entries: nil,
+	}
+}
+
+// Opens "." in the given side of m, returning nil if it can't be opened or
+// isn't a directory.
+func (m *MergedFS) tryOpenRoot(ctx context.Context, side int) fs.File {
+	f, e := m.openLayer(ctx, side, ".")
+	if e != nil {
+		return nil
+	}
+	info, e := m.statLayerFile(side, f, ".")
+	if (e != nil) || !info.IsDir() {
+		f.Close()
+		return nil
+	}
+	return f
+}
+
+// Implements Open(".") when synthetic roots are enabled.
+func (m *MergedFS) openSyntheticRoot(ctx context.Context) (fs.File, error) {
+	fA := m.tryOpenRoot(ctx, 0)
+	fB := m.tryOpenRoot(ctx, 1)
+	if (fA != nil) && (fB != nil) {
+		f, e := m.newMergedDirectory(ctx, fA, fB, ".")
+		if e == nil {
+			return f, nil
+		}
+		// We were unable to merge the two directories (e.g., one didn't
+		// implement ReadDirFile), so treat B as empty. The merge closed both
+		// roots, so A's must be opened again.
+		traceStep(ctx, "decision", m.layerName(0), "couldn't merge roots "+
+			"(%s), so using %s's root", e, m.layerName(0))
+		fA = m.tryOpenRoot(ctx, 0)
+		if fA == nil {
+			return nil, e
+		}
+		return fA, nil
+	}
+	if fA != nil {
+		return fA, nil
+	}
+	if fB != nil {
+		return fB, nil
+	}
+	return newSyntheticRoot(), nil
+}
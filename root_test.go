@@ -0,0 +1,117 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// An FS that returns the given error for every Open.
+type failingFS struct {
+	err error
+}
+
+func (f failingFS) Open(path string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: path, Err: f.err}
+}
+
+// Wraps an FS, hiding the ReadDir method of its directories, so they can't be
+// listed or merged.
+type unlistableFS struct {
+	fs.FS
+}
+
+type unlistableFile struct {
+	fs.File
+}
+
+func (u unlistableFS) Open(path string) (fs.File, error) {
+	f, e := u.FS.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	return unlistableFile{f}, nil
+}
+
+func TestRootDirectory(t *testing.T) {
+	older := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsA := fstest.MapFS{
+		".":     &fstest.MapFile{Mode: fs.ModeDir | 0700, ModTime: older},
+		"a.txt": newMapFile("in A"),
+	}
+	fsB := fstest.MapFS{
+		".":     &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: newer},
+		"b.txt": newMapFile("in B"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	info, e := fs.Stat(merged, ".")
+	if e != nil {
+		t.Logf("Failed to stat \".\": %s\n", e)
+		t.FailNow()
+	}
+	if info.Mode() != (fs.ModeDir | 0700) {
+		t.Logf("Root had mode %s, expected the mode from A.\n", info.Mode())
+		t.Fail()
+	}
+	if !info.ModTime().Equal(newer) {
+		t.Logf("Root had mod time %s, expected %s.\n", info.ModTime(), newer)
+		t.Fail()
+	}
+	entries, e := fs.ReadDir(merged, ".")
+	if (e != nil) || (len(entries) != 2) {
+		t.Logf("Expected 2 entries in the root, got %d (error %v).\n",
+			len(entries), e)
+		t.FailNow()
+	}
+}
+
+func TestSyntheticRoot(t *testing.T) {
+	fsB := fstest.MapFS{"b.txt": newMapFile("in B")}
+	merged := NewMergedFS(failingFS{fs.ErrPermission}, fsB)
+
+	// By default, unexpected errors from either layer must be reported.
+	_, e := merged.Open(".")
+	if e == nil {
+		t.Logf("Didn't get expected error opening \".\" by default.\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error opening \".\": %s\n", e)
+
+	merged.UseSyntheticRoot(true)
+	entries, e := fs.ReadDir(merged, ".")
+	if e != nil {
+		t.Logf("Failed reading \".\" with synthetic roots: %s\n", e)
+		t.FailNow()
+	}
+	if (len(entries) != 1) || (entries[0].Name() != "b.txt") {
+		t.Logf("Got wrong root entries with synthetic roots: %v\n", entries)
+		t.FailNow()
+	}
+
+	// If the roots can't be merged, A's root must be served as-is.
+	merged = NewMergedFS(fstest.MapFS{"a.txt": newMapFile("in A")},
+		unlistableFS{fsB})
+	merged.UseSyntheticRoot(true)
+	entries, e = fs.ReadDir(merged, ".")
+	if e != nil {
+		t.Logf("Failed reading \".\" with an unlistable root: %s\n", e)
+		t.FailNow()
+	}
+	if (len(entries) != 1) || (entries[0].Name() != "a.txt") {
+		t.Logf("Got wrong root entries with an unlistable root: %v\n",
+			entries)
+		t.FailNow()
+	}
+
+	// If every layer fails, we should get an empty directory.
+	merged = MergeMultiple(failingFS{fs.ErrPermission},
+		failingFS{fs.ErrNotExist}, failingFS{fs.ErrClosed}).(*MergedFS)
+	merged.UseSyntheticRoot(true)
+	e = fstest.TestFS(merged)
+	if e != nil {
+		t.Logf("TestFS failed for a synthetic root: %s\n", e)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,158 @@
+package merged_fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// Wraps an FS, correcting common misbehaviors. See Sanitize.
+type sanitizedFS struct {
+	fsys fs.FS
+}
+
+// Returns an FS that wraps fsys, correcting some common ways in which FS
+// implementations fail to follow the io/fs conventions that a MergedFS
+// relies on. A merge is only as dependable as its least-compliant layer, so
+// wrapping layers of doubtful quality with Sanitize before merging them can
+// make the merged FS behave predictably. Specifically, the returned FS:
+//
+//   - Rejects invalid paths with fs.ErrInvalid without passing them to fsys.
+//   - Returns errors wrapping fs.ErrNotExist when opening paths that don't
+//     exist, even if fsys returns some other error. A path is considered
+//     nonexistent if its parent directory can't be read or doesn't list it.
+//   - Returns directory entries sorted by name, from both ReadDir and the
+//     ReadDir method of opened directories.
+//   - Returns directory entries whose Info method agrees with the FileInfo
+//     obtained by opening the entry and calling Stat.
+//
+// These corrections have a cost: directories are read in full before
+// returning any entries, and each entry's Info method opens the entry.
+func Sanitize(fsys fs.FS) fs.FS {
+	return &sanitizedFS{
+		fsys: fsys,
+	}
+}
+
+// Returns an error wrapping fs.ErrNotExist if e was returned because p
+// doesn't exist in s.fsys, and e otherwise.
+func (s *sanitizedFS) fixOpenError(p string, e error) error {
+	if errors.Is(e, fs.ErrNotExist) || errors.Is(e, fs.ErrInvalid) {
+		return e
+	}
+	if p == "." {
+		return e
+	}
+	entries, listError := fs.ReadDir(s.fsys, path.Dir(p))
+	if listError == nil {
+		name := path.Base(p)
+		for _, entry := range entries {
+			if entry.Name() == name {
+				// The path exists, so the error is legitimate.
+				return e
+			}
+		}
+	}
+	return &fs.PathError{Op: "open", Path: p,
+		Err: fmt.Errorf("%w (%s)", fs.ErrNotExist, e)}
+}
+
+func (s *sanitizedFS) Open(p string) (fs.File, error) {
+	if !fs.ValidPath(p) {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrInvalid}
+	}
+	f, e := s.fsys.Open(p)
+	if e != nil {
+		return nil, s.fixOpenError(p, e)
+	}
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return f, nil
+	}
+	return &sanitizedDir{
+		ReadDirFile: dir,
+		fs:          s,
+		path:        p,
+	}, nil
+}
+
+func (s *sanitizedFS) ReadDir(p string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(p) {
+		return nil, &fs.PathError{Op: "readdir", Path: p, Err: fs.ErrInvalid}
+	}
+	entries, e := fs.ReadDir(s.fsys, p)
+	if e != nil {
+		return nil, e
+	}
+	return s.sanitizeEntries(p, entries), nil
+}
+
+// Returns a sorted copy of entries from the directory at dirPath, with
+// corrected Info methods.
+func (s *sanitizedFS) sanitizeEntries(dirPath string,
+	entries []fs.DirEntry) []fs.DirEntry {
+	toReturn := make([]fs.DirEntry, len(entries))
+	for i, entry := range entries {
+		toReturn[i] = &sanitizedEntry{
+			DirEntry: entry,
+			fsys:     s.fsys,
+			path:     path.Join(dirPath, entry.Name()),
+		}
+	}
+	sort.Sort(dirEntrySlice(toReturn))
+	return toReturn
+}
+
+// A directory opened using a sanitizedFS. Reads all entries from the
+// underlying directory on the first call to ReadDir.
+type sanitizedDir struct {
+	fs.ReadDirFile
+	fs      *sanitizedFS
+	path    string
+	entries []fs.DirEntry
+	offset  int
+	// Set once entries have been read.
+	read bool
+}
+
+func (d *sanitizedDir) Unwrap() fs.File {
+	return d.ReadDirFile
+}
+
+func (d *sanitizedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, e := d.ReadDirFile.ReadDir(-1)
+		if e != nil {
+			return nil, e
+		}
+		d.entries = d.fs.sanitizeEntries(d.path, entries)
+		d.read = true
+	}
+	return readEntries(d.entries, &d.offset, n)
+}
+
+// A DirEntry whose Info method returns the result of opening the entry and
+// calling Stat.
+type sanitizedEntry struct {
+	fs.DirEntry
+	fsys fs.FS
+	path string
+}
+
+func (e *sanitizedEntry) Info() (fs.FileInfo, error) {
+	f, err := e.fsys.Open(e.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Name() != e.Name() {
+		return renamedInfo{info, e.Name()}, nil
+	}
+	return info, nil
+}
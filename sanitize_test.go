@@ -0,0 +1,105 @@
+package merged_fs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// An FS that violates several io/fs conventions.
+type misbehavingFS struct {
+	fstest.MapFS
+}
+
+// A DirEntry whose Info reports the wrong size.
+type wrongInfoEntry struct {
+	fs.DirEntry
+}
+
+type wrongSizeInfo struct {
+	fs.FileInfo
+}
+
+func (i wrongSizeInfo) Size() int64 {
+	return i.FileInfo.Size() + 1
+}
+
+func (e wrongInfoEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return wrongSizeInfo{info}, nil
+}
+
+// A directory listing entries in reverse order with the wrong info.
+type misbehavingDir struct {
+	fs.ReadDirFile
+}
+
+func (d *misbehavingDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, e := d.ReadDirFile.ReadDir(-1)
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	for i := range entries {
+		entries[i] = wrongInfoEntry{entries[i]}
+	}
+	return entries, e
+}
+
+func (m misbehavingFS) Open(path string) (fs.File, error) {
+	f, e := m.MapFS.Open(path)
+	if e != nil {
+		return nil, errors.New("something went wrong")
+	}
+	if dir, ok := f.(fs.ReadDirFile); ok {
+		return &misbehavingDir{dir}, nil
+	}
+	return f, nil
+}
+
+func TestSanitize(t *testing.T) {
+	modTime := time.Now()
+	bad := misbehavingFS{fstest.MapFS{
+		"a.txt":     &fstest.MapFile{Data: []byte("a"), ModTime: modTime},
+		"b.txt":     &fstest.MapFile{Data: []byte("b"), ModTime: modTime},
+		"dir/c.txt": &fstest.MapFile{Data: []byte("c"), ModTime: modTime},
+	}}
+	if fstest.TestFS(bad, "a.txt") == nil {
+		t.Logf("The misbehaving FS unexpectedly passed fstest.\n")
+		t.FailNow()
+	}
+	sanitized := Sanitize(bad)
+	e := fstest.TestFS(sanitized, "a.txt", "b.txt", "dir/c.txt")
+	if e != nil {
+		t.Logf("Sanitized FS failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	_, e = sanitized.Open("missing.txt")
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Expected ErrNotExist for a missing path, got %v\n", e)
+		t.FailNow()
+	}
+	_, e = sanitized.Open("dir/missing/x.txt")
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Expected ErrNotExist for a missing directory, got %v\n", e)
+		t.FailNow()
+	}
+
+	// The merge should now be able to fall back to B for missing paths.
+	merged := NewMergedFS(sanitized, fstest.MapFS{"d.txt": newMapFile("d")})
+	_, e = fs.Stat(merged, "d.txt")
+	if e != nil {
+		t.Logf("Failed getting info for d.txt in merged FS: %s\n", e)
+		t.FailNow()
+	}
+	unsanitized := NewMergedFS(bad, fstest.MapFS{"d.txt": newMapFile("d")})
+	_, e = fs.Stat(unsanitized, "d.txt")
+	if e == nil {
+		t.Logf("Expected an error when merging the unsanitized FS.\n")
+		t.FailNow()
+	}
+}
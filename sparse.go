@@ -0,0 +1,273 @@
+package merged_fs
+
+import (
+	"archive/zip"
+	"context"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Lists the directories implied by a Layer's KnownPaths, so that they can be
+// synthesized if the underlying FS is unable to open them.
+type sparseIndex struct {
+	// Maps each implied directory to its children. The children map each name
+	// to true if the child is a directory.
+	dirs map[string]map[string]bool
+}
+
+// Builds an index of the directories implied by the given file paths. Invalid
+// paths are ignored.
+func newSparseIndex(paths []string) *sparseIndex {
+	toReturn := &sparseIndex{
+		dirs: map[string]map[string]bool{
+			".": make(map[string]bool),
+		},
+	}
+	for _, p := range paths {
+		if !fs.ValidPath(p) || (p == ".") {
+			continue
+		}
+		isDir := false
+		for {
+			parent, name := path.Split(p)
+			parent = strings.TrimSuffix(parent, "/")
+			if parent == "" {
+				parent = "."
+			}
+			children := toReturn.dirs[parent]
+			if children == nil {
+				children = make(map[string]bool)
+				toReturn.dirs[parent] = children
+			}
+			children[name] = children[name] || isDir
+			if parent == "." {
+				break
+			}
+			p = parent
+			isDir = true
+		}
+	}
+	return toReturn
+}
+
+// A DirEntry for a regular file listed in a sparse layer's KnownPaths. Its
+// info is loaded lazily, as fstest requires it to match the result of Stat.
+type sparseFileEntry struct {
+	fsys fs.FS
+	path string
+}
+
+func (e *sparseFileEntry) Name() string {
+	return path.Base(e.path)
+}
+
+func (e *sparseFileEntry) IsDir() bool {
+	return false
+}
+
+func (e *sparseFileEntry) Type() fs.FileMode {
+	return 0
+}
+
+func (e *sparseFileEntry) Info() (fs.FileInfo, error) {
+	return fs.Stat(e.fsys, e.path)
+}
+
+// Returns the paths of the regular files in a zip archive, for use as the
+// KnownPaths of a Layer. Many zip files omit explicit records for directories,
+// in which case this ensures that they're still listed.
+func zipKnownPaths(r *zip.Reader) []string {
+	toReturn := make([]string, 0, len(r.File))
+	for _, f := range r.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		toReturn = append(toReturn, f.Name)
+	}
+	return toReturn
+}
+
+// Returns entries with the addition of any children of dirPath implied by the
+// index that aren't already present. The returned slice is sorted by name.
+// Returns entries unmodified if nothing needed to be added.
+func (s *sparseIndex) complete(fsys fs.FS, dirPath string,
+	entries []fs.DirEntry) []fs.DirEntry {
+	children, ok := s.dirs[dirPath]
+	if !ok {
+		return entries
+	}
+	present := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		present[entry.Name()] = true
+	}
+	missing := false
+	for name := range children {
+		if !present[name] {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return entries
+	}
+	toReturn := append([]fs.DirEntry(nil), entries...)
+	for _, entry := range s.directory(fsys, dirPath).entries {
+		name := entry.Name()
+		if present[name] {
+			continue
+		}
+		// Use the real metadata if the omitted entry can be opened.
+		info, e := fs.Stat(fsys, path.Join(dirPath, name))
+		if e == nil {
+			entry = infoDirEntry{info}
+		}
+		toReturn = append(toReturn, entry)
+	}
+	sort.Sort(dirEntrySlice(toReturn))
+	return toReturn
+}
+
+// Adapts a FileInfo into a DirEntry.
+type infoDirEntry struct {
+	info fs.FileInfo
+}
+
+func (e infoDirEntry) Name() string {
+	return e.info.Name()
+}
+
+func (e infoDirEntry) IsDir() bool {
+	return e.info.IsDir()
+}
+
+func (e infoDirEntry) Type() fs.FileMode {
+	return e.info.Mode().Type()
+}
+
+func (e infoDirEntry) Info() (fs.FileInfo, error) {
+	return e.info, nil
+}
+
+// A directory in a sparse layer that exists in the underlying FS, but with
+// additional entries implied by the layer's KnownPaths. Stat returns the
+// directory's original metadata.
+type completedDir struct {
+	*MergedDirectory
+	info fs.FileInfo
+}
+
+func (d *completedDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+// If f is a directory missing some of the entries implied by the index,
+// this closes f and returns a directory containing the missing entries.
+// Otherwise, returns f.
+func (s *sparseIndex) completeDir(fsys fs.FS, dirPath string,
+	f fs.File) (fs.File, error) {
+	if _, ok := s.dirs[dirPath]; !ok {
+		return f, nil
+	}
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return f, nil
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	entries, e := dir.ReadDir(-1)
+	f.Close()
+	if e != nil {
+		return nil, e
+	}
+	completed := &MergedDirectory{
+		name:    baseName(dirPath),
+		mode:    info.Mode(),
+		entries: s.complete(fsys, dirPath, entries),
+	}
+	return &completedDir{
+		MergedDirectory: completed,
+		info:            info,
+	}, nil
+}
+
+// Returns a synthesized directory for the given path, or nil if the path isn't
+// a directory implied by the index.
+func (s *sparseIndex) directory(fsys fs.FS, dirPath string) *MergedDirectory {
+	children, ok := s.dirs[dirPath]
+	if !ok {
+		return nil
+	}
+	entries := make([]fs.DirEntry, 0, len(children))
+	for name, isDir := range children {
+		childPath := name
+		if dirPath != "." {
+			childPath = dirPath + "/" + name
+		}
+		if isDir {
+			entries = append(entries, newSyntheticDir(name))
+		} else {
+			entries = append(entries, &sparseFileEntry{
+				fsys: fsys,
+				path: childPath,
+			})
+		}
+	}
+	sort.Sort(dirEntrySlice(entries))
+	toReturn := newSyntheticDir(baseName(dirPath))
+	toReturn.entries = entries
+	return toReturn
+}
+
+// If the listing of the synthesized directory d's parent in fsys includes d,
+// this copies the metadata from that listing to d, so that it remains
+// consistent with the parent's listing.
+func copyParentListingInfo(fsys fs.FS, dirPath string, d *MergedDirectory) {
+	if dirPath == "." {
+		return
+	}
+	parent := path.Dir(dirPath)
+	siblings, e := fs.ReadDir(fsys, parent)
+	if e != nil {
+		return
+	}
+	for _, entry := range siblings {
+		if (entry.Name() != d.name) || !entry.IsDir() {
+			continue
+		}
+		info, e := entry.Info()
+		if e != nil {
+			return
+		}
+		d.mode = info.Mode()
+		d.modTime = uint64(info.ModTime().Unix())
+		return
+	}
+}
+
+// Returns an empty directory with the given name, used in place of
+// directories that don't exist in the underlying FS.
+func newSyntheticDir(name string) *MergedDirectory {
+	return &MergedDirectory{
+		name: name,
+		mode: 0555 | fs.ModeDir,
+	}
+}
+
+// Exposes only the Open and ReadDir methods of a Layer, so that fs.Glob uses
+// them rather than the Layer's Glob method.
+type layerGlobFS struct {
+	l *Layer
+}
+
+func (f layerGlobFS) Open(path string) (fs.File, error) {
+	return f.l.openInternal(context.Background(), path)
+}
+
+func (f layerGlobFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	return f.l.readDirInternal(context.Background(), path)
+}
@@ -0,0 +1,155 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// Wraps a MapFS, but fails to open any directory other than ".", like some
+// generated filesystems.
+type sparseTestFS struct {
+	files fstest.MapFS
+}
+
+func (f sparseTestFS) Open(path string) (fs.File, error) {
+	if path != "." {
+		info, e := fs.Stat(f.files, path)
+		if (e == nil) && info.IsDir() {
+			return nil, &fs.PathError{Op: "open", Path: path,
+				Err: fs.ErrNotExist}
+		}
+	}
+	return f.files.Open(path)
+}
+
+func TestSparseLayer(t *testing.T) {
+	sparse := sparseTestFS{fstest.MapFS{
+		"a/b/c.txt": newMapFile("c"),
+		"a/d.txt":   newMapFile("d"),
+		"e.txt":     newMapFile("e"),
+	}}
+	fsB := fstest.MapFS{
+		"a/b/f.txt": newMapFile("f"),
+	}
+	expected := []string{"a/b/c.txt", "a/d.txt", "e.txt", "a/b/f.txt"}
+
+	// Without KnownPaths, the directories in the sparse FS are unreachable.
+	merged := NewMergedFS(sparse, fsB)
+	_, e := merged.Open("a/d.txt")
+	if e != nil {
+		t.Logf("Failed opening a/d.txt: %s\n", e)
+		t.FailNow()
+	}
+	entries, e := fs.ReadDir(merged, "a")
+	if e != nil {
+		t.Logf("Failed reading dir a: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 1 {
+		t.Logf("Expected dir a to only contain B's content, got %d "+
+			"entries.\n", len(entries))
+		t.FailNow()
+	}
+
+	layer := &Layer{
+		FS:         sparse,
+		KnownPaths: []string{"a/b/c.txt", "a/d.txt", "e.txt"},
+	}
+	merged = NewMergedFS(layer, fsB)
+	e = fstest.TestFS(merged, expected...)
+	if e != nil {
+		t.Logf("TestFS failed with a sparse layer: %s\n", e)
+		t.FailNow()
+	}
+	matches, e := fs.Glob(layer, "a/*/*.txt")
+	if e != nil {
+		t.Logf("Failed globbing sparse layer: %s\n", e)
+		t.FailNow()
+	}
+	if (len(matches) != 1) || (matches[0] != "a/b/c.txt") {
+		t.Logf("Got incorrect glob results: %v\n", matches)
+		t.FailNow()
+	}
+}
+
+// A directory that omits subdirectories from its listing.
+type dirOmittingDir struct {
+	fs.ReadDirFile
+}
+
+func (d dirOmittingDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, e := d.ReadDirFile.ReadDir(n)
+	toReturn := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			toReturn = append(toReturn, entry)
+		}
+	}
+	return toReturn, e
+}
+
+// Wraps a MapFS, but omits subdirectories from directory listings, like a zip
+// file without directory records read by a simplistic zip library.
+type dirOmittingFS struct {
+	files fstest.MapFS
+}
+
+func (f dirOmittingFS) Open(path string) (fs.File, error) {
+	file, e := f.files.Open(path)
+	if e != nil {
+		return nil, e
+	}
+	if d, ok := file.(fs.ReadDirFile); ok {
+		return dirOmittingDir{d}, nil
+	}
+	return file, nil
+}
+
+func TestImplicitDirectories(t *testing.T) {
+	omitting := dirOmittingFS{fstest.MapFS{
+		"a/b.txt": newMapFile("b"),
+		"c.txt":   newMapFile("c"),
+	}}
+	fsB := fstest.MapFS{
+		"d/e.txt": newMapFile("e"),
+	}
+	merged := NewMergedFS(omitting, fsB)
+	entries, e := fs.ReadDir(merged, ".")
+	if e != nil {
+		t.Logf("Failed reading root dir: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 2 {
+		t.Logf("Expected the listing to lack dir a, got %d entries.\n",
+			len(entries))
+		t.FailNow()
+	}
+
+	layer := &Layer{
+		FS:         omitting,
+		KnownPaths: []string{"a/b.txt", "c.txt"},
+	}
+	merged = NewMergedFS(layer, fsB)
+	e = fstest.TestFS(merged, "a/b.txt", "c.txt", "d/e.txt")
+	if e != nil {
+		t.Logf("TestFS failed when inferring directories: %s\n", e)
+		t.FailNow()
+	}
+
+	// Zip files should have their known paths inferred automatically.
+	zip2 := openZip("test_data/test_b.zip", t)
+	zip3 := openZip("test_data/test_c.zip", t)
+	zipLayer := &Layer{FS: zip2}
+	merged = NewMergedFS(zipLayer, zip3)
+	e = fstest.TestFS(merged, "test1.txt", "a/test4.txt", "b/1.txt",
+		"b/0.txt")
+	if e != nil {
+		t.Logf("TestFS failed for a zip layer: %s\n", e)
+		t.FailNow()
+	}
+	if zipLayer.sparse == nil {
+		t.Logf("Didn't infer known paths for a zip layer.\n")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,116 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+)
+
+// Returns the FileInfo for each of the given paths, or the error encountered
+// while getting it, in the same order as paths. Exactly one of the FileInfo
+// and error at each index is non-nil.
+//
+// This is intended for programs that need to check large numbers of paths
+// at once, such as when verifying an asset manifest at startup. Rather than
+// opening each path in turn, StatMany groups the paths by their parent
+// directories, reads each parent directory's merged listing once, and takes
+// each path's FileInfo from its directory entry. Directories are read
+// concurrently. Enabling UseDirectoryCaching lets later calls reuse the
+// listings. The authorizer (see SetAuthorizer) is called for each path, with
+// the op "stat", and not for the parent directories.
+func (m *MergedFS) StatMany(paths []string) ([]fs.FileInfo, []error) {
+	infos := make([]fs.FileInfo, len(paths))
+	errs := make([]error, len(paths))
+	byParent := make(map[string][]int)
+	var parents []string
+	for i, p := range paths {
+		if !fs.ValidPath(p) {
+			errs[i] = &fs.PathError{Op: "stat", Path: p, Err: fs.ErrInvalid}
+			continue
+		}
+		e := m.authorize(context.Background(), "stat", p)
+		if e != nil {
+			errs[i] = e
+			continue
+		}
+		if p == "." {
+			infos[i], errs[i] = m.statRoot()
+			continue
+		}
+		parent := path.Dir(p)
+		if _, ok := byParent[parent]; !ok {
+			parents = append(parents, parent)
+		}
+		byParent[parent] = append(byParent[parent], i)
+	}
+
+	runConcurrently(len(parents), func(i int) {
+		// Each index is only written by the goroutine handling its parent
+		// directory.
+		parent := parents[i]
+		m.statInDir(parent, byParent[parent], paths, infos, errs)
+	})
+	return infos, errs
+}
+
+// Returns the info for m's root directory, without calling the authorizer.
+func (m *MergedFS) statRoot() (fs.FileInfo, error) {
+	f, e := m.openAudited(context.Background(), ".", nil)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// Returns the entries of the directory at dirPath, without calling the
+// authorizer, since StatMany already checked the paths within it.
+func (m *MergedFS) readDirUnauthorized(dirPath string) ([]fs.DirEntry,
+	error) {
+	f, e := m.openAudited(context.Background(), dirPath, nil)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: dirPath,
+			Err: errors.New("not implemented")}
+	}
+	return dir.ReadDir(-1)
+}
+
+// Sets the FileInfo or error at each of the given indices, which must refer to
+// paths within the directory dirPath.
+func (m *MergedFS) statInDir(dirPath string, indices []int, paths []string,
+	infos []fs.FileInfo, errs []error) {
+	entries, e := m.readDirUnauthorized(dirPath)
+	if e != nil {
+		for _, i := range indices {
+			if isBadPathError(e) {
+				errs[i] = &fs.PathError{Op: "stat", Path: paths[i],
+					Err: fs.ErrNotExist}
+			} else {
+				errs[i] = e
+			}
+		}
+		return
+	}
+	byName := make(map[string]fs.DirEntry, len(entries))
+	for _, entry := range entries {
+		byName[entry.Name()] = entry
+	}
+	for _, i := range indices {
+		entry := byName[path.Base(paths[i])]
+		if entry == nil {
+			errs[i] = &fs.PathError{Op: "stat", Path: paths[i],
+				Err: fs.ErrNotExist}
+			continue
+		}
+		infos[i], errs[i] = entry.Info()
+		if (errs[i] != nil) && (infos[i] != nil) {
+			infos[i] = nil
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package merged_fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStatMany(t *testing.T) {
+	fsA := fstest.MapFS{
+		"file":     newMapFile("not a dir"),
+		"dir/a.go": newMapFile("package a"),
+	}
+	fsB := fstest.MapFS{
+		"file/x.txt": newMapFile("hidden by A"),
+		"dir/b.go":   newMapFile("package b"),
+	}
+	for i := 0; i < 100; i++ {
+		fsB[fmt.Sprintf("assets/%03d.png", i)] = newMapFile("png")
+	}
+	merged := NewMergedFS(fsA, fsB)
+	paths := []string{"dir/a.go", "dir/b.go", "file/x.txt", "dir/missing",
+		".", "dir", "../bad"}
+	for i := 0; i < 100; i++ {
+		paths = append(paths, fmt.Sprintf("assets/%03d.png", i))
+	}
+	infos, errs := merged.StatMany(paths)
+	for i, p := range paths {
+		expected, expectedError := fs.Stat(merged, p)
+		if (errs[i] == nil) != (expectedError == nil) {
+			t.Logf("Got error %v for %s, expected %v\n", errs[i], p,
+				expectedError)
+			t.FailNow()
+		}
+		if errs[i] != nil {
+			if infos[i] != nil {
+				t.Logf("Got both info and an error for %s\n", p)
+				t.FailNow()
+			}
+			continue
+		}
+		if (infos[i].Name() != expected.Name()) ||
+			(infos[i].Size() != expected.Size()) ||
+			(infos[i].Mode() != expected.Mode()) {
+			t.Logf("Got wrong info for %s\n", p)
+			t.FailNow()
+		}
+	}
+	if !errors.Is(errs[3], fs.ErrNotExist) {
+		t.Logf("Expected ErrNotExist for dir/missing, got %v\n", errs[3])
+		t.FailNow()
+	}
+	if !errors.Is(errs[6], fs.ErrInvalid) {
+		t.Logf("Expected ErrInvalid for ../bad, got %v\n", errs[6])
+		t.FailNow()
+	}
+}
@@ -0,0 +1,239 @@
+package merged_fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync/atomic"
+)
+
+// Describes a way in which a layer failed to follow the conventions of
+// io/fs, as detected by strict mode. See MergedFS.UseStrictMode.
+type ContractViolation struct {
+	// The name of the offending layer: the Layer's Name if it was a named
+	// *Layer, or "A" or "B" otherwise.
+	Layer string
+	// The operation that misbehaved, e.g. "open", "stat", or "readdir".
+	Op string
+	// The path being accessed.
+	Path string
+	// A description of the problem.
+	Problem string
+}
+
+func (v *ContractViolation) Error() string {
+	return fmt.Sprintf("FS %s violated the io/fs contract during %s of %s: "+
+		"%s", v.Layer, v.Op, v.Path, v.Problem)
+}
+
+// Enables strict mode if handler is non-nil, or disables it otherwise. In
+// strict mode, m checks the results of the Open, Stat, and ReadDir calls it
+// makes to its underlying filesystems, and calls handler with a description
+// of any violations of io/fs conventions it notices, such as:
+//
+//   - Open returning an error that isn't an *fs.PathError.
+//   - Open returning neither a file nor an error.
+//   - Stat returning a name other than the base name of the opened path.
+//   - FileInfo or DirEntry values whose IsDir and Mode or Type disagree.
+//   - Directory listings that aren't sorted or contain duplicate names.
+//
+// If handler returns nil, m carries on as usual, so handler can, for example,
+// log violations to help report bugs to the authors of a layer. If handler
+// returns an error, the operation fails with that error instead; returning
+// the *ContractViolation itself is a simple way to make violations fatal.
+//
+// Like UsePanicRecovery, this also applies the setting to any MergedFS
+// directly nested within m. Strict mode is disabled by default.
+func (m *MergedFS) UseStrictMode(handler func(v *ContractViolation) error) {
+	m.configMutex.Lock()
+	m.strictHandler = handler
+	m.configMutex.Unlock()
+	for side := 0; side < 2; side++ {
+		nested, ok := m.layer(side).(*MergedFS)
+		if ok {
+			nested.UseStrictMode(handler)
+		}
+	}
+}
+
+// Enables or disables integrity checks, which extend strict mode: for every
+// entry in a directory listing that m reads from a layer, m also opens the
+// entry's path in the same layer and reports a violation if the FileInfo
+// from the entry's Info method doesn't match the one from the opened file's
+// Stat method. The name, mode, size, and modification time must all match,
+// as required by testing/fstest. This is a diagnostic for layer authors,
+// since it requires opening every file in each directory m merges.
+//
+// Violations are reported to the strict-mode handler, so this has no effect
+// unless strict mode is enabled. Like UsePanicRecovery, this also applies the
+// setting to any MergedFS directly nested within m. Integrity checks are
+// disabled by default.
+func (m *MergedFS) UseIntegrityChecks(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&m.integrityChecks, value)
+	for side := 0; side < 2; side++ {
+		nested, ok := m.layer(side).(*MergedFS)
+		if ok {
+			nested.UseIntegrityChecks(enabled)
+		}
+	}
+}
+
+// Returns a description of how two FileInfos for the same file differ, or
+// an empty string if they match.
+func compareInfo(fromEntry, fromStat fs.FileInfo) string {
+	switch {
+	case fromEntry.Name() != fromStat.Name():
+		return fmt.Sprintf("the name %q, but Stat returned %q",
+			fromEntry.Name(), fromStat.Name())
+	case fromEntry.Mode() != fromStat.Mode():
+		return fmt.Sprintf("mode %s, but Stat returned %s", fromEntry.Mode(),
+			fromStat.Mode())
+	case fromEntry.Size() != fromStat.Size():
+		return fmt.Sprintf("size %d, but Stat returned %d", fromEntry.Size(),
+			fromStat.Size())
+	case !fromEntry.ModTime().Equal(fromStat.ModTime()):
+		return fmt.Sprintf("modification time %s, but Stat returned %s",
+			fromEntry.ModTime(), fromStat.ModTime())
+	}
+	return ""
+}
+
+// Checks that the info of an entry in the directory at dirPath in the given
+// side of m matches the result of opening it. Returns a non-nil error if the
+// strict-mode handler rejects it.
+func (m *MergedFS) checkEntryIntegrity(side int, dirPath string,
+	entry fs.DirEntry) error {
+	p := entry.Name()
+	if dirPath != "." {
+		p = dirPath + "/" + p
+	}
+	fromEntry, e := entry.Info()
+	if e != nil {
+		return m.reportViolation(side, "readdir", dirPath, "Info() for "+
+			"entry %q failed: %s", entry.Name(), e)
+	}
+	f, e := m.layer(side).Open(p)
+	if e != nil {
+		return m.reportViolation(side, "readdir", dirPath, "listed %q, but "+
+			"opening it failed: %s", entry.Name(), e)
+	}
+	defer f.Close()
+	fromStat, e := f.Stat()
+	if e != nil {
+		return m.reportViolation(side, "stat", p, "failed: %s", e)
+	}
+	difference := compareInfo(fromEntry, fromStat)
+	if difference == "" {
+		return nil
+	}
+	return m.reportViolation(side, "readdir", dirPath, "entry %q has %s",
+		entry.Name(), difference)
+}
+
+// Reports a contract violation by the given side of m to the strict-mode
+// handler, if there is one. Returns the error returned by the handler.
+func (m *MergedFS) reportViolation(side int, op, path, format string,
+	args ...interface{}) error {
+	m.configMutex.RLock()
+	handler := m.strictHandler
+	m.configMutex.RUnlock()
+	if handler == nil {
+		return nil
+	}
+	return handler(&ContractViolation{
+		Layer:   m.layerName(side),
+		Op:      op,
+		Path:    path,
+		Problem: fmt.Sprintf(format, args...),
+	})
+}
+
+// Checks the results of opening path in the given side of m. Returns a
+// non-nil error if the strict-mode handler rejects them.
+func (m *MergedFS) checkOpenResult(side int, path string, f fs.File,
+	e error) error {
+	if e == nil {
+		if f == nil {
+			return m.reportViolation(side, "open", path, "returned a nil "+
+				"file without an error")
+		}
+		return nil
+	}
+	var pathError *fs.PathError
+	if !errors.As(e, &pathError) {
+		return m.reportViolation(side, "open", path, "returned a %T error, "+
+			"not an *fs.PathError: %s", e, e)
+	}
+	return nil
+}
+
+// Checks the FileInfo returned by calling Stat on path in the given side of m.
+// Returns a non-nil error if the strict-mode handler rejects it.
+func (m *MergedFS) checkStatResult(side int, path string,
+	info fs.FileInfo) error {
+	if info == nil {
+		return m.reportViolation(side, "stat", path, "returned nil info "+
+			"without an error")
+	}
+	if (path != ".") && (info.Name() != baseName(path)) {
+		e := m.reportViolation(side, "stat", path, "returned the name %q",
+			info.Name())
+		if e != nil {
+			return e
+		}
+	}
+	if info.IsDir() != info.Mode().IsDir() {
+		return m.reportViolation(side, "stat", path, "IsDir() returned %v, "+
+			"but the mode is %s", info.IsDir(), info.Mode())
+	}
+	return nil
+}
+
+// Checks the entries read from the directory at path in the given side of m.
+// Returns a non-nil error if the strict-mode handler rejects them.
+func (m *MergedFS) checkDirEntries(side int, path string,
+	entries []fs.DirEntry) error {
+	m.configMutex.RLock()
+	strict := m.strictHandler != nil
+	m.configMutex.RUnlock()
+	checkIntegrity := strict && (atomic.LoadInt32(&m.integrityChecks) != 0)
+	for i, entry := range entries {
+		if checkIntegrity {
+			e := m.checkEntryIntegrity(side, path, entry)
+			if e != nil {
+				return e
+			}
+		}
+		if entry.IsDir() != entry.Type().IsDir() {
+			e := m.reportViolation(side, "readdir", path, "entry %q's "+
+				"IsDir() returned %v, but its type is %s", entry.Name(),
+				entry.IsDir(), entry.Type())
+			if e != nil {
+				return e
+			}
+		}
+		if i == 0 {
+			continue
+		}
+		previous := entries[i-1].Name()
+		if previous == entry.Name() {
+			e := m.reportViolation(side, "readdir", path, "listed %q more "+
+				"than once", entry.Name())
+			if e != nil {
+				return e
+			}
+		} else if previous > entry.Name() {
+			e := m.reportViolation(side, "readdir", path, "listed %q "+
+				"before %q, but entries must be sorted", previous,
+				entry.Name())
+			if e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
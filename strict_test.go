@@ -0,0 +1,110 @@
+package merged_fs
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStrictMode(t *testing.T) {
+	bad := &Layer{
+		Name: "bad",
+		FS: misbehavingFS{fstest.MapFS{
+			"dir/a.txt": newMapFile("a"),
+			"dir/b.txt": newMapFile("b"),
+		}},
+	}
+	good := fstest.MapFS{
+		"dir/c.txt": newMapFile("c"),
+		"d.txt":     newMapFile("d"),
+	}
+	merged := MergeMultiple(bad, good).(*MergedFS)
+	var violations []*ContractViolation
+	merged.UseStrictMode(func(v *ContractViolation) error {
+		violations = append(violations, v)
+		return nil
+	})
+	_, e := fs.ReadDir(merged, "dir")
+	if e != nil {
+		t.Logf("Failed reading dir: %s\n", e)
+		t.FailNow()
+	}
+	if (len(violations) != 1) || (violations[0].Op != "readdir") ||
+		(violations[0].Layer != "bad") {
+		t.Logf("Expected one readdir violation, got %v\n", violations)
+		t.FailNow()
+	}
+	if !strings.Contains(violations[0].Error(), "sorted") {
+		t.Logf("Got wrong violation: %s\n", violations[0])
+		t.FailNow()
+	}
+
+	// Making violations fatal should turn the misbehaving Open into an error
+	// other than "not found".
+	merged.UseStrictMode(func(v *ContractViolation) error {
+		return v
+	})
+	_, e = fs.Stat(merged, "d.txt")
+	var violation *ContractViolation
+	if !errors.As(e, &violation) || (violation.Op != "open") {
+		t.Logf("Expected an open contract violation, got %v\n", e)
+		t.FailNow()
+	}
+
+	merged.UseStrictMode(nil)
+	violations = nil
+	_, e = fs.ReadDir(merged, "dir")
+	if (e != nil) || (len(violations) != 0) {
+		t.Logf("Strict mode wasn't disabled: %v, %v\n", e, violations)
+		t.FailNow()
+	}
+}
+
+func TestIntegrityChecks(t *testing.T) {
+	bad := &Layer{
+		Name: "bad",
+		FS: misbehavingFS{fstest.MapFS{
+			"dir/a.txt": newMapFile("a"),
+		}},
+	}
+	good := fstest.MapFS{"dir/c.txt": newMapFile("c")}
+	merged := MergeMultiple(bad, good).(*MergedFS)
+	var violations []*ContractViolation
+	merged.UseStrictMode(func(v *ContractViolation) error {
+		violations = append(violations, v)
+		return nil
+	})
+	merged.UseIntegrityChecks(true)
+	_, e := fs.ReadDir(merged, "dir")
+	if e != nil {
+		t.Logf("Failed reading dir: %s\n", e)
+		t.FailNow()
+	}
+	if (len(violations) != 1) || (violations[0].Layer != "bad") ||
+		!strings.Contains(violations[0].Problem, "size 2") {
+		t.Logf("Expected one size mismatch, got %v\n", violations)
+		t.FailNow()
+	}
+	t.Logf("Got expected violation: %s\n", violations[0])
+
+	// Making violations fatal should make the merge fail.
+	merged.UseStrictMode(func(v *ContractViolation) error {
+		return v
+	})
+	_, e = fs.ReadDir(merged, "dir")
+	var violation *ContractViolation
+	if !errors.As(e, &violation) {
+		t.Logf("Expected a contract violation, got %v\n", e)
+		t.FailNow()
+	}
+
+	merged.UseIntegrityChecks(false)
+	merged.UseStrictMode(nil)
+	_, e = fs.ReadDir(merged, "dir")
+	if e != nil {
+		t.Logf("Failed reading dir without integrity checks: %s\n", e)
+		t.FailNow()
+	}
+}
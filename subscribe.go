@@ -0,0 +1,282 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The number of events buffered for each subscriber before events start being
+// dropped.
+const subscriberBufferSize = 64
+
+// Describes a change that may have affected which files are visible in a
+// MergedFS, sent to the channels returned by Subscribe.
+type Event struct {
+	// The path that may have changed, along with everything within it. This
+	// is "." if anything in the MergedFS may have changed.
+	Path string
+	// Why the event was sent: "pin" if Pin or Unpin was called, "override"
+	// if a priority override was added, "alias" if an alias was added,
+	// "visibility" if a gated Layer or a Layer's circuit breaker changed
+	// which layers are visible, "cache" if caching was reconfigured, and
+	// "changed" for paths passed to NotifyChanged. Events with the reason
+	// "overflow" mean that earlier events were dropped because the subscriber
+	// didn't keep up, so Path is always "." in that case.
+	Reason string
+}
+
+// A single channel returned by Subscribe.
+type subscription struct {
+	pattern string
+	events  chan Event
+	// Set if an event was dropped, so an overflow event must be sent before
+	// any others.
+	overflowed bool
+}
+
+// The subscriptions to a MergedFS's events, which have their own mutex.
+type subscribers struct {
+	mutex sync.Mutex
+	subs  map[*subscription]bool
+}
+
+// The most recent times at which NotifyChanged was called for each path or a
+// path within it, which have their own mutex.
+type changeTimes struct {
+	mutex sync.Mutex
+	// Maps paths to unix timestamps.
+	times map[string]int64
+}
+
+// Records that p changed at the given unix time, along with each directory
+// containing it. Earlier times never replace later ones.
+func (c *changeTimes) record(p string, t int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.times == nil {
+		c.times = make(map[string]int64)
+	}
+	for {
+		if c.times[p] < t {
+			c.times[p] = t
+		}
+		if p == "." {
+			return
+		}
+		p = path.Dir(p)
+	}
+}
+
+// Returns the most recent of modTime and the time at which p was last
+// recorded as changed. Forgets the recorded time once modTime catches up with
+// it, since it's no longer needed, so that the map doesn't keep growing.
+func (c *changeTimes) latest(p string, modTime int64) int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t, ok := c.times[p]
+	if !ok {
+		return modTime
+	}
+	if t > modTime {
+		return t
+	}
+	delete(c.times, p)
+	return modTime
+}
+
+// Applies the times recorded by NotifyChanged to the merged subdirectories
+// among the entries of the directory at dirPath, so their ModTimes match the
+// ones they report when opened.
+func (m *MergedFS) updateChangedEntries(dirPath string,
+	entries []fs.DirEntry) {
+	for _, entry := range entries {
+		d, ok := entry.(*MergedDirectory)
+		if !ok {
+			continue
+		}
+		d.modTime = uint64(m.changeTimes.latest(path.Join(dirPath, d.name),
+			int64(d.modTime)))
+	}
+}
+
+// Returns true if some path matching the pattern's components could be name
+// or a path within it.
+func matchesWithin(pattern, name []string) bool {
+	if len(name) == 0 {
+		return true
+	}
+	if len(pattern) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		return true
+	}
+	matched, e := path.Match(pattern[0], name[0])
+	if (e != nil) || !matched {
+		return false
+	}
+	return matchesWithin(pattern[1:], name[1:])
+}
+
+// Returns the leading components of a pattern that contain no wildcards,
+// joined into a path, or "." if the first component contains a wildcard.
+func literalPrefix(pattern string) string {
+	var literal []string
+	for _, c := range pathComponents(pattern) {
+		if strings.ContainsAny(c, "*?[\\") {
+			break
+		}
+		literal = append(literal, c)
+	}
+	if len(literal) == 0 {
+		return "."
+	}
+	return strings.Join(literal, "/")
+}
+
+// Tries to send the event without blocking, recording an overflow if the
+// subscriber's buffer is full. Must be called with the subscribers' mutex
+// held.
+func (s *subscription) send(event Event) {
+	if s.overflowed {
+		select {
+		case s.events <- Event{Path: ".", Reason: "overflow"}:
+			s.overflowed = false
+		default:
+			return
+		}
+	}
+	select {
+	case s.events <- event:
+	default:
+		s.overflowed = true
+	}
+}
+
+// Returns a channel that receives an Event whenever something happens that
+// may change the visibility of paths matching the pattern in m, along with a
+// function that unsubscribes and closes the channel. Patterns use the same
+// syntax as AddPriorityOverride, so "**" subscribes to every change. Events
+// aren't filtered precisely: an event may be sent for a change that doesn't
+// actually affect any matching path, such as when a gated layer becomes
+// visible, but hides nothing the subscriber cares about.
+//
+// Events are sent for pins, priority overrides, and aliases added to m,
+// for caching being reconfigured, and for gated Layers or circuit breakers
+// within m changing which layers are visible. Visibility changes are only
+// noticed when m is next accessed, since that is when m checks its gates.
+// m doesn't watch its layers for changes itself; code that does (e.g., using
+// a filesystem notification library) should call NotifyChanged, which
+// notifies subscribers in addition to clearing m's caches.
+//
+// Events are never sent to MergedFS instances nested within m, and sending
+// never blocks. If a subscriber's channel has too many unreceived events,
+// later events are dropped until it catches up, at which point it receives an
+// event with the reason "overflow". The channel is closed immediately if the
+// pattern is malformed. The returned function may be called more than once.
+func (m *MergedFS) Subscribe(pattern string) (<-chan Event, func()) {
+	s := &subscription{
+		pattern: pattern,
+		events:  make(chan Event, subscriberBufferSize),
+	}
+	if validatePattern(pattern) != nil {
+		close(s.events)
+		return s.events, func() {}
+	}
+	m.subscribers.mutex.Lock()
+	if m.subscribers.subs == nil {
+		m.subscribers.subs = make(map[*subscription]bool)
+	}
+	m.subscribers.subs[s] = true
+	m.subscribers.mutex.Unlock()
+	cancel := func() {
+		m.subscribers.mutex.Lock()
+		defer m.subscribers.mutex.Unlock()
+		if m.subscribers.subs[s] {
+			delete(m.subscribers.subs, s)
+			close(s.events)
+		}
+	}
+	return s.events, cancel
+}
+
+// Sends an event to every subscriber with a pattern that could match the
+// event's path or a path within it.
+func (m *MergedFS) publish(event Event) {
+	m.subscribers.mutex.Lock()
+	defer m.subscribers.mutex.Unlock()
+	if len(m.subscribers.subs) == 0 {
+		return
+	}
+	name := pathComponents(event.Path)
+	for s := range m.subscribers.subs {
+		if matchesWithin(pathComponents(s.pattern), name) {
+			s.send(event)
+		}
+	}
+}
+
+// Informs m that the given paths, and anything within them, may have changed
+// in one or more of its layers. This clears the caches of m and any MergedFS
+// or Group nested within it, and notifies m's subscribers (see Subscribe).
+// Pass "." if the change may have affected anything. Invalid paths are
+// ignored.
+//
+// The ModTime of each directory merged from more than one layer, by m or by a
+// MergedFS nested within it, that contains one of the paths, or is one of
+// them, becomes the time of the call if that's more recent than the layers'
+// own ModTimes. This keeps directory listings' ModTimes, e.g. as used by
+// http.FileServer for caching, moving forward when a watched layer changes a
+// file without changing the ModTimes of its parent directories. Directories
+// present in only one layer report that layer's ModTime.
+func (m *MergedFS) NotifyChanged(paths ...string) {
+	now := time.Now().Unix()
+	for _, p := range paths {
+		if fs.ValidPath(p) {
+			m.recordChange(p, now)
+		}
+	}
+	m.clearAllCaches()
+	for _, p := range paths {
+		if fs.ValidPath(p) {
+			m.publish(Event{Path: p, Reason: "changed"})
+		}
+	}
+}
+
+// Records that p changed at the given unix time in m and any MergedFS or
+// Group nested within it, so directories merged by any of them report it.
+func (m *MergedFS) recordChange(p string, t int64) {
+	m.changeTimes.record(p, t)
+	for side := 0; side < 2; side++ {
+		switch nested := m.layer(side).(type) {
+		case *MergedFS:
+			nested.recordChange(p, t)
+		case *Group:
+			nested.recordChange(p, t)
+		}
+	}
+}
+
+// Clears the caches of m and any MergedFS or Group nested within it,
+// including the caches used by ContentType and Classify.
+func (m *MergedFS) clearAllCaches() {
+	m.clearCaches()
+	m.contentTypes.mutex.Lock()
+	m.contentTypes.clearCache()
+	m.contentTypes.mutex.Unlock()
+	m.fileClasses.mutex.Lock()
+	m.fileClasses.cache = nil
+	m.fileClasses.mutex.Unlock()
+	for side := 0; side < 2; side++ {
+		switch nested := m.layer(side).(type) {
+		case *MergedFS:
+			nested.clearAllCaches()
+		case *Group:
+			nested.clearAllCaches()
+		}
+	}
+}
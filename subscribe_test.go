@@ -0,0 +1,276 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Returns the next event from the channel without blocking, failing the test
+// if there isn't one.
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Logf("Event channel was closed unexpectedly.\n")
+			t.FailNow()
+		}
+		return event
+	default:
+	}
+	t.Logf("Didn't get an expected event.\n")
+	t.FailNow()
+	return Event{}
+}
+
+// Fails the test if an event is waiting on the channel.
+func expectNoEvent(t *testing.T, events <-chan Event) {
+	select {
+	case event := <-events:
+		t.Logf("Got unexpected event: %+v\n", event)
+		t.FailNow()
+	default:
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	var enabled int32
+	fsA := &Layer{
+		FS: fstest.MapFS{"docs/beta.txt": newMapFile("beta")},
+		Enabled: func() bool {
+			return atomic.LoadInt32(&enabled) != 0
+		},
+	}
+	fsB := fstest.MapFS{
+		"docs/a.txt":      newMapFile("a"),
+		"images/logo.png": newMapFile("logo"),
+	}
+	merged := NewMergedFS(fsA, fsB)
+	docs, cancelDocs := merged.Subscribe("docs/*.txt")
+	images, cancelImages := merged.Subscribe("images/**")
+	defer cancelImages()
+
+	// Pinning an image shouldn't notify the docs subscriber.
+	e := merged.Pin("images/logo.png", 1)
+	if e != nil {
+		t.Logf("Failed pinning logo: %s\n", e)
+		t.FailNow()
+	}
+	event := nextEvent(t, images)
+	if (event.Path != "images/logo.png") || (event.Reason != "pin") {
+		t.Logf("Got wrong event for pin: %+v\n", event)
+		t.FailNow()
+	}
+	expectNoEvent(t, docs)
+
+	// Pinning a directory affects everything within it.
+	e = merged.Pin("docs", 1)
+	if e != nil {
+		t.Logf("Failed pinning docs: %s\n", e)
+		t.FailNow()
+	}
+	event = nextEvent(t, docs)
+	if event.Path != "docs" {
+		t.Logf("Got wrong path for pinned dir: %s\n", event.Path)
+		t.FailNow()
+	}
+	expectNoEvent(t, images)
+	merged.Unpin("docs")
+	nextEvent(t, docs)
+
+	e = merged.AddPriorityOverride("images/*.png", 1)
+	if e != nil {
+		t.Logf("Failed adding override: %s\n", e)
+		t.FailNow()
+	}
+	event = nextEvent(t, images)
+	if (event.Path != "images") || (event.Reason != "override") {
+		t.Logf("Got wrong event for override: %+v\n", event)
+		t.FailNow()
+	}
+	expectNoEvent(t, docs)
+
+	// Enabling the gated layer is noticed on the next access.
+	_, e = fs.Stat(merged, "docs/a.txt")
+	if e != nil {
+		t.Logf("Failed getting stats: %s\n", e)
+		t.FailNow()
+	}
+	expectNoEvent(t, docs)
+	atomic.StoreInt32(&enabled, 1)
+	_, e = fs.Stat(merged, "docs/beta.txt")
+	if e != nil {
+		t.Logf("Enabled file wasn't visible: %s\n", e)
+		t.FailNow()
+	}
+	event = nextEvent(t, docs)
+	if (event.Path != ".") || (event.Reason != "visibility") {
+		t.Logf("Got wrong event for visibility change: %+v\n", event)
+		t.FailNow()
+	}
+	nextEvent(t, images)
+
+	merged.NotifyChanged("docs/a.txt", "../invalid")
+	event = nextEvent(t, docs)
+	if (event.Path != "docs/a.txt") || (event.Reason != "changed") {
+		t.Logf("Got wrong event for change: %+v\n", event)
+		t.FailNow()
+	}
+	expectNoEvent(t, docs)
+	expectNoEvent(t, images)
+
+	cancelDocs()
+	cancelDocs()
+	_, ok := <-docs
+	if ok {
+		t.Logf("Channel wasn't closed after cancelling.\n")
+		t.FailNow()
+	}
+	merged.NotifyChanged(".")
+	nextEvent(t, images)
+
+	invalid, _ := merged.Subscribe("[")
+	_, ok = <-invalid
+	if ok {
+		t.Logf("Channel wasn't closed for a malformed pattern.\n")
+		t.FailNow()
+	}
+}
+
+func TestSubscribeOverflow(t *testing.T) {
+	merged := NewMergedFS(fstest.MapFS{}, fstest.MapFS{})
+	events, cancel := merged.Subscribe("**")
+	defer cancel()
+	for i := 0; i < subscriberBufferSize+10; i++ {
+		merged.NotifyChanged("a.txt")
+	}
+	for i := 0; i < subscriberBufferSize; i++ {
+		nextEvent(t, events)
+	}
+	expectNoEvent(t, events)
+	merged.NotifyChanged("b.txt")
+	event := nextEvent(t, events)
+	if event.Reason != "overflow" {
+		t.Logf("Expected an overflow event, got %+v\n", event)
+		t.FailNow()
+	}
+	event = nextEvent(t, events)
+	if event.Path != "b.txt" {
+		t.Logf("Expected an event for b.txt, got %+v\n", event)
+		t.FailNow()
+	}
+}
+
+func TestNotifyChangedModTime(t *testing.T) {
+	old := time.Unix(1000000, 0)
+	dir := &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: old}
+	file := &fstest.MapFile{Data: []byte("a"), Mode: 0644, ModTime: old}
+	fsA := fstest.MapFS{
+		".":                dir,
+		"docs":             dir,
+		"docs/guide":       dir,
+		"docs/guide/a.txt": file,
+		"other":            dir,
+	}
+	fsB := fstest.MapFS{
+		".":          dir,
+		"docs":       dir,
+		"docs/guide": dir,
+		"other":      dir,
+		"only_b":     dir,
+	}
+	merged := NewMergedFS(fsA, fsB)
+	merged.UseDirectoryCaching(true)
+	_, e := fs.ReadDir(merged, "docs")
+	if e != nil {
+		t.Logf("Failed reading docs: %s\n", e)
+		t.FailNow()
+	}
+	start := time.Now().Truncate(time.Second)
+	merged.NotifyChanged("docs/guide/a.txt")
+	for _, p := range []string{".", "docs", "docs/guide"} {
+		info, e := fs.Stat(merged, p)
+		if e != nil {
+			t.Logf("Failed getting info for %s: %s\n", p, e)
+			t.FailNow()
+		}
+		if info.ModTime().Before(start) {
+			t.Logf("Didn't update the mod time of %s: got %s\n", p,
+				info.ModTime())
+			t.FailNow()
+		}
+	}
+	for _, p := range []string{"other", "only_b", "docs/guide/a.txt"} {
+		info, e := fs.Stat(merged, p)
+		if e != nil {
+			t.Logf("Failed getting info for %s: %s\n", p, e)
+			t.FailNow()
+		}
+		if !info.ModTime().Equal(old) {
+			t.Logf("Unexpectedly changed the mod time of %s to %s\n", p,
+				info.ModTime())
+			t.FailNow()
+		}
+	}
+
+	// Entries for the merged directories must agree with their info.
+	e = fstest.TestFS(merged, "docs/guide/a.txt", "other", "only_b")
+	if e != nil {
+		t.Logf("Inconsistent FS after NotifyChanged: %s\n", e)
+		t.FailNow()
+	}
+
+	// The recorded time is forgotten once the layers' ModTimes catch up.
+	newer := time.Now().Add(time.Hour).Truncate(time.Second)
+	fsA["docs"] = &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: newer}
+	merged.clearAllCaches()
+	info, e := fs.Stat(merged, "docs")
+	if e != nil {
+		t.Logf("Failed getting info for docs: %s\n", e)
+		t.FailNow()
+	}
+	if !info.ModTime().Equal(newer) {
+		t.Logf("Got mod time %s for docs, expected %s\n", info.ModTime(),
+			newer)
+		t.FailNow()
+	}
+	merged.changeTimes.mutex.Lock()
+	_, ok := merged.changeTimes.times["docs"]
+	merged.changeTimes.mutex.Unlock()
+	if ok {
+		t.Logf("Kept the change time for docs after its layers caught up\n")
+		t.FailNow()
+	}
+}
+
+func TestNotifyChangedNestedModTime(t *testing.T) {
+	old := time.Unix(1000000, 0)
+	dir := &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: old}
+	file := &fstest.MapFile{Data: []byte("a"), Mode: 0644, ModTime: old}
+	// "docs" is only merged by the MergedFS nested within the one returned
+	// by MergeMultiple.
+	merged := MergeMultiple(
+		fstest.MapFS{"index.html": file},
+		fstest.MapFS{"docs": dir, "docs/a.txt": file},
+		fstest.MapFS{"docs": dir, "docs/b.txt": file},
+	).(*MergedFS)
+	start := time.Now().Truncate(time.Second)
+	merged.NotifyChanged("docs/a.txt")
+	info, e := fs.Stat(merged, "docs")
+	if e != nil {
+		t.Logf("Failed getting info for docs: %s\n", e)
+		t.FailNow()
+	}
+	if info.ModTime().Before(start) {
+		t.Logf("Didn't update the mod time of a nested merged dir: got %s\n",
+			info.ModTime())
+		t.FailNow()
+	}
+	e = fstest.TestFS(merged, "index.html", "docs/a.txt", "docs/b.txt")
+	if e != nil {
+		t.Logf("Inconsistent FS after NotifyChanged: %s\n", e)
+		t.FailNow()
+	}
+}
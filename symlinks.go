@@ -0,0 +1,393 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// Determines how a MergedFS treats a symbolic link in a higher-priority layer
+// when a lower-priority layer has something at the same path. See
+// MergedFS.SetSymlinkShadowing.
+type SymlinkShadowing int32
+
+const (
+	// The link's layer resolves the link before it's compared with the
+	// lower-priority layer, so a link is treated exactly like whatever it
+	// points to. A link to a directory merges with a directory at the same
+	// path in the lower-priority layer, a link to a regular file shadows
+	// anything at the path, and a link that can't be resolved (because it
+	// dangles or is part of a cycle) is treated as nonexistent, leaving the
+	// lower-priority layer's copy visible.
+	ResolveBeforeShadowing SymlinkShadowing = iota
+	// A link shadows anything at the same path in the lower-priority layer,
+	// as a regular file would, regardless of what it points to. Opening the
+	// path serves the link's target from the link's layer alone, without
+	// merging, so paths within a link to a directory are never served from
+	// the lower-priority layer. A link that can't be resolved still shadows
+	// the path, which then doesn't exist.
+	SymlinksShadow
+	// Links are resolved by the MergedFS in its merged namespace, rather than
+	// by their own layers, so a link in one layer may point to a path served
+	// by another. As with SymlinksShadow, a link in A shadows anything at the
+	// same path in B. Since links in different layers may now form cycles
+	// that no single layer can detect, opening a path fails with a
+	// *SymlinkLoopError if resolving it encounters a cycle or follows more
+	// than 40 links. Every Open must check each component of the path for
+	// links, so this is the slowest policy.
+	ResolveAcrossLayers
+)
+
+// The maximum number of links followed when resolving a single path with
+// ResolveAcrossLayers, matching the limit used by Linux.
+const maxSymlinkHops = 40
+
+// Wrapped by every *SymlinkLoopError, so callers can check for them using
+// errors.Is.
+var ErrSymlinkLoop = errors.New("too many levels of symbolic links")
+
+// Returned, wrapped in an *fs.PathError, when resolving a path using
+// ResolveAcrossLayers encounters a cycle of links or too many links.
+type SymlinkLoopError struct {
+	// The links followed while resolving the path, in order. If a cycle was
+	// detected, the last link is the first one to be repeated.
+	Links []string
+	// True if the cycle was detected because too many links were followed,
+	// rather than because a link was repeated.
+	TooManyLinks bool
+}
+
+func (e *SymlinkLoopError) Error() string {
+	if e.TooManyLinks {
+		return fmt.Sprintf("%s: followed more than %d links", ErrSymlinkLoop,
+			maxSymlinkHops)
+	}
+	return fmt.Sprintf("%s: cycle %s", ErrSymlinkLoop,
+		strings.Join(e.Links, " -> "))
+}
+
+func (e *SymlinkLoopError) Unwrap() error {
+	return ErrSymlinkLoop
+}
+
+func (s SymlinkShadowing) String() string {
+	switch s {
+	case ResolveBeforeShadowing:
+		return "resolve before shadowing"
+	case SymlinksShadow:
+		return "symlinks shadow"
+	case ResolveAcrossLayers:
+		return "resolve across layers"
+	}
+	return "unknown symlink shadowing"
+}
+
+// Implemented by layers that can report symbolic links, such as those
+// returned by DirLayer with ExposeSymlinks, and by MergedFS. The methods match
+// those of fs.ReadLinkFS in newer versions of Go.
+type symlinkFS interface {
+	fs.FS
+	ReadLink(path string) (string, error)
+	Lstat(path string) (fs.FileInfo, error)
+}
+
+// Sets how symbolic links in A interact with paths in B, and clears m's
+// caches. This only affects links in layers that report them, using ReadLink
+// and Lstat methods like those of fs.ReadLinkFS: DirLayers using
+// ExposeSymlinks, and MergedFS instances. Links in other layers are always
+// resolved by the layers themselves, and look like ordinary files and
+// directories to m. Like UsePanicRecovery, this applies the setting to any
+// MergedFS directly nested within m. The default is ResolveBeforeShadowing.
+//
+// Unless the policy is ResolveAcrossLayers, each layer resolves its own
+// links, never using the content of any other layer. So links can't form
+// cycles across layers, even if, for example, a link in A points to a path
+// that's a link in B pointing back to it. Directory listings always list a
+// link in A, rather than any file or directory in B with the same name.
+func (m *MergedFS) SetSymlinkShadowing(policy SymlinkShadowing) {
+	atomic.StoreInt32(&m.symlinkShadowing, int32(policy))
+	m.clearCaches()
+	for side := 0; side < 2; side++ {
+		nested, ok := m.layer(side).(*MergedFS)
+		if ok {
+			nested.SetSymlinkShadowing(policy)
+		}
+	}
+}
+
+// Returns true if links in A must shadow paths in B.
+func (m *MergedFS) symlinksShadow() bool {
+	return SymlinkShadowing(atomic.LoadInt32(&m.symlinkShadowing)) !=
+		ResolveBeforeShadowing
+}
+
+// Returns true if m must resolve links itself.
+func (m *MergedFS) resolvesAcrossLayers() bool {
+	return SymlinkShadowing(atomic.LoadInt32(&m.symlinkShadowing)) ==
+		ResolveAcrossLayers
+}
+
+// Returns the info for the path in the given side of m, without following it
+// if it's a link. Returns an error if the layer doesn't report links.
+func (m *MergedFS) lstatLayer(side int, path string) (fs.FileInfo, error) {
+	layer, ok := m.layer(side).(symlinkFS)
+	if !ok {
+		return nil, &fs.PathError{Op: "lstat", Path: path,
+			Err: fs.ErrNotExist}
+	}
+	return layer.Lstat(path)
+}
+
+// Returns true if the path is a symbolic link in the given side of m.
+func (m *MergedFS) isLayerSymlink(side int, path string) bool {
+	info, e := m.lstatLayer(side, path)
+	return (e == nil) && (info.Mode()&fs.ModeSymlink != 0)
+}
+
+// Returns true if the path can be opened in the given side of m.
+func (m *MergedFS) layerHasPath(side int, path string) bool {
+	f, e := m.openLayer(context.Background(), side, path)
+	if e != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// Returns the side of m containing the link at path, and the link's info.
+// Returns an error wrapping fs.ErrInvalid if the path isn't a link in m, or
+// fs.ErrNotExist if nothing visible in m has the path.
+func (m *MergedFS) findSymlink(op, path string) (int, fs.FileInfo, error) {
+	if !fs.ValidPath(path) {
+		return 0, nil, &fs.PathError{Op: op, Path: path, Err: fs.ErrInvalid}
+	}
+	notLink := &fs.PathError{Op: op, Path: path,
+		Err: fmt.Errorf("%w: not a symbolic link", fs.ErrInvalid)}
+	info, e := m.lstatLayer(0, path)
+	if e == nil {
+		if info.Mode()&fs.ModeSymlink == 0 {
+			// Whatever A has at the path hides any link in B.
+			return 0, nil, notLink
+		}
+		// Unless links shadow, a link in A that can't be resolved is treated
+		// as nonexistent.
+		if m.symlinksShadow() || m.layerHasPath(0, path) {
+			return 0, info, nil
+		}
+	} else if m.layerHasPath(0, path) {
+		// A layer that doesn't report links has something at the path.
+		return 0, nil, notLink
+	}
+	info, e = m.lstatLayer(1, path)
+	if e != nil {
+		return 0, nil, &fs.PathError{Op: op, Path: path, Err: fs.ErrNotExist}
+	}
+	e = m.validatePathPrefix(context.Background(), path)
+	if e != nil {
+		return 0, nil, &fs.PathError{Op: op, Path: path, Err: e}
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return 0, nil, notLink
+	}
+	return 1, info, nil
+}
+
+// Returns p with any links in its parent directories resolved in m's merged
+// namespace, if m resolves links across layers. Otherwise, each layer
+// resolves links in the parent directories itself, so this returns p.
+func (m *MergedFS) resolveParent(op, p string) (string, error) {
+	if !m.resolvesAcrossLayers() || !fs.ValidPath(p) || (p == ".") {
+		return p, nil
+	}
+	dir, e := m.evalSymlinks(context.Background(), op, path.Dir(p))
+	if e != nil {
+		return "", &fs.PathError{Op: op, Path: p, Err: e}
+	}
+	return path.Join(dir, baseName(p)), nil
+}
+
+// Returns e with its path replaced by p, if it's an *fs.PathError for a
+// resolved path.
+func withErrorPath(e error, p string) error {
+	var pathError *fs.PathError
+	if errors.As(e, &pathError) && (pathError.Path != p) {
+		return &fs.PathError{Op: pathError.Op, Path: p, Err: pathError.Err}
+	}
+	return e
+}
+
+// Returns the destination of the symbolic link at the given path, as reported
+// by the layer serving it. This, along with Lstat, lets a MergedFS be used as
+// a layer in another MergedFS without hiding links, and matches the
+// fs.ReadLinkFS interface in newer versions of Go. Returns an error if the
+// path isn't a link in m, including if it's a link in B that's shadowed by a
+// file in A. As with Lstat, links in the path's parent directories are
+// followed.
+func (m *MergedFS) ReadLink(p string) (string, error) {
+	e := m.authorize(context.Background(), "readlink", p)
+	if e != nil {
+		return "", e
+	}
+	resolved, e := m.resolveParent("readlink", p)
+	if e != nil {
+		return "", e
+	}
+	side, _, e := m.findSymlink("readlink", resolved)
+	if e != nil {
+		return "", withErrorPath(e, p)
+	}
+	target, e := m.layer(side).(symlinkFS).ReadLink(resolved)
+	return target, withErrorPath(e, p)
+}
+
+// Returns the info for the given path, without following it if it's a
+// symbolic link visible in m. Otherwise, this returns the same info as
+// fs.Stat, which follows links. Links in the path's parent directories are
+// always followed, using the same policy as Open, so with
+// ResolveAcrossLayers, the path may be within a link in A to a directory in
+// B. Directory listings from m agree with Lstat: an entry's Type includes
+// fs.ModeSymlink exactly when Lstat reports the entry as a link.
+func (m *MergedFS) Lstat(p string) (fs.FileInfo, error) {
+	e := m.authorize(context.Background(), "lstat", p)
+	if e != nil {
+		return nil, e
+	}
+	resolved, e := m.resolveParent("lstat", p)
+	if e != nil {
+		return nil, e
+	}
+	_, info, e := m.findSymlink("lstat", resolved)
+	if e != nil {
+		info, e = m.statAuthorized(resolved)
+		if e != nil {
+			return nil, withErrorPath(e, p)
+		}
+	}
+	if resolved != p {
+		return renamedInfo{info, baseName(p)}, nil
+	}
+	return info, nil
+}
+
+// Removes entries from B's listing of the directory at dirPath that are
+// shadowed by links in A, which A's listing omits if they can't be resolved.
+// Only needed if links shadow B, since otherwise a link that can't be
+// resolved is treated as nonexistent.
+func (m *MergedFS) removeLinkShadowed(dirPath string, entriesA,
+	entriesB []fs.DirEntry) []fs.DirEntry {
+	if !m.symlinksShadow() {
+		return entriesB
+	}
+	if _, ok := m.layer(0).(symlinkFS); !ok {
+		return entriesB
+	}
+	listedInA := make(map[string]bool, len(entriesA))
+	for _, entry := range entriesA {
+		listedInA[entry.Name()] = true
+	}
+	toReturn := entriesB[:0:0]
+	for _, entry := range entriesB {
+		name := entry.Name()
+		if !listedInA[name] &&
+			m.isLayerSymlink(0, path.Join(dirPath, name)) {
+			continue
+		}
+		toReturn = append(toReturn, entry)
+	}
+	return toReturn
+}
+
+// Returns the path that p refers to after resolving every link visible in m
+// along it, in m's merged namespace. Components that don't exist are left
+// as they are, so the result only refers to an existing file if p does. The
+// authorizer, if any, is called with the given op for each link's
+// destination, and for the result if it differs from p and from the last
+// destination, so links can't be used to reach paths it would deny.
+func (m *MergedFS) evalSymlinks(ctx context.Context, op, p string) (string,
+	error) {
+	var resolved []string
+	remaining := pathComponents(p)
+	var links []string
+	// Maps each link followed, along with the path remaining after it, to
+	// true. Following the same link with the same remaining path twice means
+	// resolution will never finish.
+	seen := make(map[string]bool)
+	// The last path the authorizer allowed.
+	lastChecked := p
+	for len(remaining) != 0 {
+		candidate := strings.Join(append(resolved, remaining[0]), "/")
+		remaining = remaining[1:]
+		side, _, e := m.findSymlink("open", candidate)
+		if e != nil {
+			resolved = append(resolved, baseName(candidate))
+			continue
+		}
+		links = append(links, candidate)
+		key := candidate + "\x00" + strings.Join(remaining, "/")
+		if seen[key] {
+			return "", &SymlinkLoopError{Links: links}
+		}
+		seen[key] = true
+		if len(links) > maxSymlinkHops {
+			return "", &SymlinkLoopError{Links: links, TooManyLinks: true}
+		}
+		target, e := m.layer(side).(symlinkFS).ReadLink(candidate)
+		if e != nil {
+			return "", e
+		}
+		traceStep(ctx, "symlink", m.layerName(side), "%s points to %s",
+			candidate, target)
+		if strings.HasPrefix(target, "/") {
+			return "", fmt.Errorf("%w: link %s has an absolute destination",
+				fs.ErrNotExist, candidate)
+		}
+		next := path.Join(path.Dir(candidate), target)
+		if !fs.ValidPath(next) {
+			return "", fmt.Errorf("%w: link %s leads outside of the FS",
+				fs.ErrNotExist, candidate)
+		}
+		e = m.authorize(ctx, op, next)
+		if e != nil {
+			return "", e
+		}
+		lastChecked = next
+		// The destination may contain links of its own, so start over.
+		resolved = nil
+		remaining = append(pathComponents(next), remaining...)
+	}
+	result := "."
+	if len(resolved) != 0 {
+		result = strings.Join(resolved, "/")
+	}
+	if (result != p) && (result != lastChecked) {
+		e := m.authorize(ctx, op, result)
+		if e != nil {
+			return "", e
+		}
+	}
+	return result, nil
+}
+
+// Opens the path after resolving any links along it in m's merged namespace,
+// for ResolveAcrossLayers. Returns nil, nil if the path contains no links.
+func (m *MergedFS) openAcrossLayers(ctx context.Context, p string) (fs.File,
+	error) {
+	resolved, e := m.evalSymlinks(ctx, "open", p)
+	if e != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: e}
+	}
+	if resolved == p {
+		return nil, nil
+	}
+	traceStep(ctx, "decision", "", "opening %s, which %s resolves to",
+		resolved, p)
+	f, e := m.openDefault(ctx, resolved)
+	if e != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: e}
+	}
+	return renameFile(f, baseName(p)), nil
+}
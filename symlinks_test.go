@@ -0,0 +1,324 @@
+package merged_fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// Creates a temporary directory containing the given files and symlinks, and
+// returns a DirLayer exposing its symlinks.
+func createLinkLayer(t *testing.T, files, links map[string]string) fs.FS {
+	dir := t.TempDir()
+	for name, content := range files {
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		e := os.MkdirAll(filepath.Dir(full), 0755)
+		if e == nil {
+			e = os.WriteFile(full, []byte(content), 0644)
+		}
+		if e != nil {
+			t.Logf("Failed creating %s: %s\n", name, e)
+			t.FailNow()
+		}
+	}
+	for name, target := range links {
+		e := os.Symlink(target, filepath.Join(dir, name))
+		if e != nil {
+			t.Skipf("Can't create symlinks: %s\n", e)
+		}
+	}
+	layer, e := DirLayer(dir, DirLayerOptions{Symlinks: ExposeSymlinks})
+	if e != nil {
+		t.Logf("Failed creating dir layer: %s\n", e)
+		t.FailNow()
+	}
+	return layer
+}
+
+func TestSymlinkShadowing(t *testing.T) {
+	fsA := createLinkLayer(t, map[string]string{
+		"real_assets/a.txt": "a",
+		"cfg.txt":           "config",
+	}, map[string]string{
+		"assets": "real_assets",
+		"config": "cfg.txt",
+		// A cycle within A.
+		"loop1": "loop2",
+		"loop2": "loop1",
+		// Half of a cycle across layers.
+		"x": "y",
+	})
+	fsB := createLinkLayer(t, map[string]string{
+		"assets/b.txt":     "b",
+		"config/inner.txt": "inner",
+		"loop1":            "from B",
+	}, map[string]string{
+		"y": "x",
+	})
+	merged := NewMergedFS(fsA, fsB)
+	expectContent := func(path, expected string) {
+		content, e := fs.ReadFile(merged, path)
+		if e != nil {
+			t.Logf("Failed reading %s: %s\n", path, e)
+			t.FailNow()
+		}
+		if string(content) != expected {
+			t.Logf("Got wrong content for %s: %s\n", path, content)
+			t.FailNow()
+		}
+	}
+	expectMissing := func(path string) {
+		_, e := fs.ReadFile(merged, path)
+		if !errors.Is(e, fs.ErrNotExist) {
+			t.Logf("Expected %s not to exist, got %v\n", path, e)
+			t.FailNow()
+		}
+		_, e = fs.Stat(merged, path)
+		if !errors.Is(e, fs.ErrNotExist) {
+			t.Logf("Expected stat of %s to fail, got %v\n", path, e)
+			t.FailNow()
+		}
+	}
+
+	// By default, links are resolved before they're compared with B.
+	expectContent("assets/a.txt", "a")
+	expectContent("assets/b.txt", "b")
+	expectContent("config", "config")
+	expectMissing("config/inner.txt")
+	expectContent("loop1", "from B")
+	// Neither layer resolves links using the other, so there's no cycle.
+	expectMissing("x")
+	expectMissing("y")
+	target, e := merged.ReadLink("assets")
+	if (e != nil) || (target != "real_assets") {
+		t.Logf("Got wrong link target for assets: %q, %v\n", target, e)
+		t.FailNow()
+	}
+	info, e := merged.Lstat("assets")
+	if (e != nil) || (info.Mode()&fs.ModeSymlink == 0) {
+		t.Logf("Lstat didn't report assets as a link: %v\n", e)
+		t.FailNow()
+	}
+	info, e = merged.Lstat("loop1")
+	if (e != nil) || !info.Mode().IsRegular() {
+		t.Logf("Lstat didn't report B's loop1 as a file: %v\n", e)
+		t.FailNow()
+	}
+	target, e = merged.ReadLink("y")
+	if (e != nil) || (target != "x") {
+		t.Logf("Got wrong link target for y: %q, %v\n", target, e)
+		t.FailNow()
+	}
+	_, e = merged.ReadLink("cfg.txt")
+	if !errors.Is(e, fs.ErrInvalid) {
+		t.Logf("Expected an error reading a non-link, got %v\n", e)
+		t.FailNow()
+	}
+
+	// Links shadow B entirely with SymlinksShadow, even if they're broken.
+	merged.SetSymlinkShadowing(SymlinksShadow)
+	expectContent("assets/a.txt", "a")
+	expectMissing("assets/b.txt")
+	expectContent("config", "config")
+	expectMissing("config/inner.txt")
+	expectMissing("loop1")
+	expectMissing("x")
+	expectMissing("y")
+	entries, e := fs.ReadDir(merged, "assets")
+	if (e != nil) || (len(entries) != 1) {
+		t.Logf("Expected 1 entry in assets, got %d (%v)\n", len(entries), e)
+		t.FailNow()
+	}
+
+	// Links must also be reported through nested MergedFS instances.
+	fsC := fstest.MapFS{"assets/c.txt": newMapFile("c")}
+	nested := MergeMultiple(fsA, fsB, fsC).(*MergedFS)
+	merged = nested
+	expectContent("assets/c.txt", "c")
+	nested.SetSymlinkShadowing(SymlinksShadow)
+	expectMissing("assets/c.txt")
+	expectMissing("assets/b.txt")
+	expectContent("assets/a.txt", "a")
+}
+
+func TestResolveAcrossLayers(t *testing.T) {
+	linksA := map[string]string{
+		"x":      "y",
+		"assets": "shared/assets",
+	}
+	// A chain of links that's too long to follow.
+	for i := 0; i <= maxSymlinkHops; i++ {
+		linksA[fmt.Sprintf("chain%d", i)] = fmt.Sprintf("chain%d", i+1)
+	}
+	linksA[fmt.Sprintf("chain%d", maxSymlinkHops+1)] = "shared/assets"
+	fsA := createLinkLayer(t, map[string]string{"a.txt": "a"}, linksA)
+	fsB := createLinkLayer(t, map[string]string{
+		"shared/assets/b.txt": "b",
+	}, map[string]string{
+		"y": "x",
+	})
+	merged := NewMergedFS(fsA, fsB)
+	_, e := fs.Stat(merged, "assets/b.txt")
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Link was resolved across layers by default: %v\n", e)
+		t.FailNow()
+	}
+
+	merged.SetSymlinkShadowing(ResolveAcrossLayers)
+	content, e := fs.ReadFile(merged, "assets/b.txt")
+	if e != nil {
+		t.Logf("Failed reading through a link to B: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "b" {
+		t.Logf("Got wrong content through a link to B: %s\n", content)
+		t.FailNow()
+	}
+	info, e := fs.Stat(merged, "assets")
+	if (e != nil) || !info.IsDir() || (info.Name() != "assets") {
+		t.Logf("Got wrong info for linked directory: %v\n", e)
+		t.FailNow()
+	}
+
+	_, e = merged.Open("x")
+	var loopError *SymlinkLoopError
+	if !errors.As(e, &loopError) || !errors.Is(e, ErrSymlinkLoop) {
+		t.Logf("Expected a symlink loop error, got %v\n", e)
+		t.FailNow()
+	}
+	if strings.Join(loopError.Links, " ") != "x y x" {
+		t.Logf("Got wrong cycle: %v\n", loopError.Links)
+		t.FailNow()
+	}
+	t.Logf("Got expected error for a cycle: %s\n", e)
+
+	_, e = fs.ReadFile(merged, "chain0/b.txt")
+	if !errors.As(e, &loopError) || !loopError.TooManyLinks {
+		t.Logf("Expected an error for too many links, got %v\n", e)
+		t.FailNow()
+	}
+	content, e = fs.ReadFile(merged, "chain2/b.txt")
+	if (e != nil) || (string(content) != "b") {
+		t.Logf("Failed reading through %d links: %v\n", maxSymlinkHops, e)
+		t.FailNow()
+	}
+}
+
+// Fails the test unless the type of each entry in the directory matches the
+// type reported by Lstat.
+func checkEntryTypes(t *testing.T, m *MergedFS, dir string) {
+	entries, e := fs.ReadDir(m, dir)
+	if e != nil {
+		t.Logf("Failed reading %s: %s\n", dir, e)
+		t.FailNow()
+	}
+	for _, entry := range entries {
+		p := path.Join(dir, entry.Name())
+		info, e := m.Lstat(p)
+		if e != nil {
+			t.Logf("Failed getting Lstat info for listed entry %s: %s\n", p,
+				e)
+			t.FailNow()
+		}
+		if info.Mode().Type() != entry.Type() {
+			t.Logf("Entry %s has type %s, but Lstat reports %s\n", p,
+				entry.Type(), info.Mode().Type())
+			t.FailNow()
+		}
+	}
+}
+
+func TestLstat(t *testing.T) {
+	fsA := createLinkLayer(t, map[string]string{
+		"a.txt": "a",
+	}, map[string]string{
+		"assets":  "shared/assets",
+		"dangler": "missing",
+		"link":    "a.txt",
+	})
+	fsB := createLinkLayer(t, map[string]string{
+		"shared/assets/b.txt": "b",
+		"dangler":             "from B",
+	}, map[string]string{
+		"shared/assets/latest": "b.txt",
+	})
+	merged := NewMergedFS(fsA, fsB)
+
+	// A's dangling link doesn't exist unless links shadow.
+	info, e := merged.Lstat("dangler")
+	if (e != nil) || !info.Mode().IsRegular() {
+		t.Logf("Expected Lstat to report B's dangler as a file: %v\n", e)
+		t.FailNow()
+	}
+	info, e = merged.Lstat("link")
+	if (e != nil) || (info.Mode()&fs.ModeSymlink == 0) {
+		t.Logf("Expected Lstat to report a link: %v\n", e)
+		t.FailNow()
+	}
+	info, e = fs.Stat(merged, "link")
+	if (e != nil) || !info.Mode().IsRegular() {
+		t.Logf("Expected Stat to follow a link: %v\n", e)
+		t.FailNow()
+	}
+	checkEntryTypes(t, merged, ".")
+	checkEntryTypes(t, merged, "shared/assets")
+
+	merged.SetSymlinkShadowing(SymlinksShadow)
+	info, e = merged.Lstat("dangler")
+	if (e != nil) || (info.Mode()&fs.ModeSymlink == 0) {
+		t.Logf("Expected Lstat to report a shadowing link: %v\n", e)
+		t.FailNow()
+	}
+	entries, e := fs.ReadDir(merged, ".")
+	if e != nil {
+		t.Logf("Failed reading the root directory: %s\n", e)
+		t.FailNow()
+	}
+	for _, entry := range entries {
+		if entry.Name() == "dangler" {
+			t.Logf("A file shadowed by a dangling link was listed\n")
+			t.FailNow()
+		}
+	}
+	checkEntryTypes(t, merged, ".")
+
+	// Links in parent directories are resolved across layers, but the last
+	// component isn't followed.
+	merged.SetSymlinkShadowing(ResolveAcrossLayers)
+	info, e = merged.Lstat("assets/latest")
+	if e != nil {
+		t.Logf("Lstat failed within a link across layers: %s\n", e)
+		t.FailNow()
+	}
+	if (info.Mode()&fs.ModeSymlink == 0) || (info.Name() != "latest") {
+		t.Logf("Got wrong Lstat info within a link: %s, %s\n", info.Name(),
+			info.Mode())
+		t.FailNow()
+	}
+	info, e = fs.Stat(merged, "assets/latest")
+	if (e != nil) || !info.Mode().IsRegular() {
+		t.Logf("Expected Stat to follow links across layers: %v\n", e)
+		t.FailNow()
+	}
+	target, e := merged.ReadLink("assets/latest")
+	if (e != nil) || (target != "b.txt") {
+		t.Logf("Got wrong target within a link: %q, %v\n", target, e)
+		t.FailNow()
+	}
+	_, e = merged.Lstat("assets/missing")
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Expected an error for a missing path, got %v\n", e)
+		t.FailNow()
+	}
+	if !strings.Contains(e.Error(), "assets/missing") {
+		t.Logf("Error doesn't mention the path that was passed: %s\n", e)
+		t.FailNow()
+	}
+	checkEntryTypes(t, merged, "assets")
+}
@@ -0,0 +1,207 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// Determines what a view returned by MergedFS.TenantView can see.
+type TenantRule struct {
+	// The directory in the MergedFS that the tenant sees as its root
+	// directory, e.g. "tenants/acme". Defaults to ".", the MergedFS's root.
+	Root string
+	// Patterns matching the paths visible to the tenant, relative to Root,
+	// using the syntax of AddPriorityOverride. A directory is also visible
+	// if it contains paths matching a pattern, but it only lists the
+	// visible paths within it. If empty, everything within Root is visible.
+	Visible []string
+}
+
+// Returns true if the path, relative to the rule's root, matches one of the
+// rule's patterns, and false if it doesn't. within is true if p doesn't
+// match, but paths within p could.
+func (r *TenantRule) matches(p string) (matched, within bool) {
+	if len(r.Visible) == 0 {
+		return true, false
+	}
+	components := pathComponents(p)
+	for _, pattern := range r.Visible {
+		if matchPattern(pattern, p) {
+			return true, false
+		}
+		if matchesWithin(pathComponents(pattern), components) {
+			within = true
+		}
+	}
+	return false, within
+}
+
+// Returns true if the entry, within the directory at dirPath, is visible
+// under the rule.
+func (r *TenantRule) entryVisible(dirPath string, entry fs.DirEntry) bool {
+	matched, within := r.matches(path.Join(dirPath, entry.Name()))
+	return matched || (within && entry.IsDir())
+}
+
+// Sets the rule determining what the tenant with the given ID can see
+// through the views returned by TenantView, replacing any previous rule for
+// the tenant. Existing views use the new rule immediately. Returns an error if
+// the root isn't a valid path or any pattern is malformed.
+func (m *MergedFS) SetTenantRule(tenantID string, rule TenantRule) error {
+	if rule.Root == "" {
+		rule.Root = "."
+	}
+	if !fs.ValidPath(rule.Root) {
+		return fmt.Errorf("Invalid tenant root %q", rule.Root)
+	}
+	visible := make([]string, len(rule.Visible))
+	for i, pattern := range rule.Visible {
+		e := validatePattern(pattern)
+		if e != nil {
+			return fmt.Errorf("Invalid pattern %q: %w", pattern, e)
+		}
+		visible[i] = pattern
+	}
+	rule.Visible = visible
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+	if m.tenants == nil {
+		m.tenants = make(map[string]*TenantRule)
+	}
+	m.tenants[tenantID] = &rule
+	return nil
+}
+
+// Removes the rule for the given tenant. Existing views for the tenant then
+// behave as though they're empty.
+func (m *MergedFS) RemoveTenant(tenantID string) {
+	m.configMutex.Lock()
+	defer m.configMutex.Unlock()
+	delete(m.tenants, tenantID)
+}
+
+// Returns the rule for the given tenant, or nil if there isn't one.
+func (m *MergedFS) tenantRule(tenantID string) *TenantRule {
+	m.configMutex.RLock()
+	defer m.configMutex.RUnlock()
+	return m.tenants[tenantID]
+}
+
+// A view of a MergedFS restricted to a single tenant. See TenantView.
+type tenantFS struct {
+	m        *MergedFS
+	tenantID string
+}
+
+// Returns an FS containing only the paths visible to the given tenant, as
+// determined by the rule set using SetTenantRule. The view reads everything
+// through m, so it shares m's caches, settings, and middleware rather than
+// duplicating them, making it cheap to serve many tenants from a single
+// merge. Directory entries keep their provenance (see ProvenanceEntry), and
+// layer indices refer to m's layers. Returns an error if the tenant has no
+// rule.
+func (m *MergedFS) TenantView(tenantID string) (fs.FS, error) {
+	if m.tenantRule(tenantID) == nil {
+		return nil, fmt.Errorf("No rule for tenant %q", tenantID)
+	}
+	return &tenantFS{
+		m:        m,
+		tenantID: tenantID,
+	}, nil
+}
+
+// Returns the tenant's rule and the path in m corresponding to p, or an error
+// if p isn't valid.
+func (t *tenantFS) resolve(op, p string) (*TenantRule, string, error) {
+	if !fs.ValidPath(p) {
+		return nil, "", &fs.PathError{Op: op, Path: p, Err: fs.ErrInvalid}
+	}
+	rule := t.m.tenantRule(t.tenantID)
+	if rule == nil {
+		return nil, "", &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+	}
+	return rule, path.Join(rule.Root, p), nil
+}
+
+// Returns e with the path in m replaced by p, the path given to the view, so
+// that errors don't reveal the tenant's root, as fs.Sub does. Errors that
+// aren't an *fs.PathError are wrapped in one.
+func tenantError(op, p string, e error) error {
+	pathError, ok := e.(*fs.PathError)
+	if !ok {
+		return &fs.PathError{Op: op, Path: p, Err: e}
+	}
+	copied := *pathError
+	copied.Path = p
+	return &copied
+}
+
+func (t *tenantFS) Open(p string) (fs.File, error) {
+	rule, fullPath, e := t.resolve("open", p)
+	if e != nil {
+		return nil, e
+	}
+	matched, within := rule.matches(p)
+	if !matched && !within && (p != ".") {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	f, e := t.m.Open(fullPath)
+	if e != nil {
+		return nil, tenantError("open", p, e)
+	}
+	if matched && (len(rule.Visible) == 0) {
+		return f, nil
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, tenantError("stat", p, e)
+	}
+	if !info.IsDir() {
+		if matched {
+			return f, nil
+		}
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return f, nil
+	}
+	entries, e := dir.ReadDir(-1)
+	f.Close()
+	if e != nil {
+		return nil, tenantError("readdir", p, e)
+	}
+	visible := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if rule.entryVisible(p, entry) {
+			visible = append(visible, entry)
+		}
+	}
+	return &completedDir{
+		MergedDirectory: &MergedDirectory{
+			name:    info.Name(),
+			mode:    info.Mode(),
+			entries: visible,
+		},
+		info: info,
+	}, nil
+}
+
+func (t *tenantFS) ReadFile(p string) ([]byte, error) {
+	rule, fullPath, e := t.resolve("readfile", p)
+	if e != nil {
+		return nil, e
+	}
+	if matched, _ := rule.matches(p); !matched {
+		return nil, &fs.PathError{Op: "readfile", Path: p,
+			Err: fs.ErrNotExist}
+	}
+	content, e := t.m.ReadFile(fullPath)
+	if e != nil {
+		return nil, tenantError("readfile", p, e)
+	}
+	return content, nil
+}
@@ -0,0 +1,118 @@
+package merged_fs
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTenantView(t *testing.T) {
+	a := &Layer{Name: "tenant data", FS: fstest.MapFS{
+		"tenants/acme/logo.png":   newMapFile("acme logo"),
+		"tenants/acme/secret.txt": newMapFile("acme secret"),
+		"tenants/other/logo.png":  newMapFile("other logo"),
+	}}
+	b := &Layer{Name: "defaults", FS: fstest.MapFS{
+		"tenants/acme/style.css": newMapFile("default style"),
+		"shared/help.html":       newMapFile("help"),
+	}}
+	m := NewMergedFS(a, b)
+	_, e := m.TenantView("acme")
+	if e == nil {
+		t.Logf("Didn't get an error for a tenant without a rule\n")
+		t.FailNow()
+	}
+	e = m.SetTenantRule("acme", TenantRule{
+		Root:    "tenants/acme",
+		Visible: []string{"*.png", "*.css"},
+	})
+	if e != nil {
+		t.Logf("Failed setting tenant rule: %s\n", e)
+		t.FailNow()
+	}
+	e = m.SetTenantRule("public", TenantRule{
+		Visible: []string{"shared/**", "tenants/*/logo.png"},
+	})
+	if e != nil {
+		t.Logf("Failed setting tenant rule: %s\n", e)
+		t.FailNow()
+	}
+	acme, e := m.TenantView("acme")
+	if e != nil {
+		t.Logf("Failed getting tenant view: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(acme, "logo.png", "style.css")
+	if e != nil {
+		t.Logf("Tenant view failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	_, e = fs.ReadFile(acme, "secret.txt")
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Didn't get expected error reading hidden file: %v\n", e)
+		t.FailNow()
+	}
+	// Errors mustn't reveal the tenant's root.
+	for _, p := range []string{"missing.png", "sub/missing.css"} {
+		_, e = fs.ReadFile(acme, p)
+		if !errors.Is(e, fs.ErrNotExist) {
+			t.Logf("Didn't get expected error reading %s: %v\n", p, e)
+			t.FailNow()
+		}
+		if strings.Contains(e.Error(), "tenants") {
+			t.Logf("Error for %s reveals the tenant's root: %s\n", p, e)
+			t.FailNow()
+		}
+		_, e = acme.Open(p)
+		if (e == nil) || strings.Contains(e.Error(), "tenants") {
+			t.Logf("Error opening %s reveals the tenant's root: %v\n", p, e)
+			t.FailNow()
+		}
+	}
+
+	public, e := m.TenantView("public")
+	if e != nil {
+		t.Logf("Failed getting public view: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(public, "shared/help.html", "tenants/acme/logo.png",
+		"tenants/other/logo.png")
+	if e != nil {
+		t.Logf("Public view failed fstest: %s\n", e)
+		t.FailNow()
+	}
+	entries, e := fs.ReadDir(public, "tenants/acme")
+	if e != nil {
+		t.Logf("Failed reading tenants/acme: %s\n", e)
+		t.FailNow()
+	}
+	if (len(entries) != 1) || (entries[0].Name() != "logo.png") {
+		t.Logf("Got wrong entries in tenants/acme: %v\n", entries)
+		t.FailNow()
+	}
+	// Entries keep the provenance from the parent merge.
+	_, name := entries[0].(ProvenanceEntry).Provenance()
+	if name != "tenant data" {
+		t.Logf("Got wrong provenance: %q\n", name)
+		t.FailNow()
+	}
+	_, e = public.Open("tenants/acme/secret.txt")
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Didn't get expected error opening hidden file: %v\n", e)
+		t.FailNow()
+	}
+
+	// Removing a tenant's rule empties its existing views.
+	m.RemoveTenant("acme")
+	_, e = fs.ReadFile(acme, "logo.png")
+	if !errors.Is(e, fs.ErrNotExist) {
+		t.Logf("Removed tenant could still read files: %v\n", e)
+		t.FailNow()
+	}
+	if !strings.Contains(describeFS(public), `TenantView("public"`) {
+		t.Logf("Bad description of tenant view: %s\n", describeFS(public))
+		t.FailNow()
+	}
+}
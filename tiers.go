@@ -0,0 +1,153 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// Determines how Tiers orders layers assigned to the same tier.
+type TieBreak int
+
+const (
+	// Layers in the same tier take priority in the order they were added,
+	// earliest first.
+	TieByRegistration TieBreak = iota
+	// Layers in the same tier are ordered by the modification time of their
+	// root directories, newest first, breaking ties by registration order.
+	TieByModTime
+	// Adding more than one layer to the same tier is an error, reported by
+	// Order and Merge as a *TierConflictError.
+	TieIsError
+)
+
+func (t TieBreak) String() string {
+	switch t {
+	case TieByRegistration:
+		return "tie by registration"
+	case TieByModTime:
+		return "tie by modification time"
+	case TieIsError:
+		return "tie is error"
+	}
+	return "unknown tie break"
+}
+
+// Returned by Tiers.Order and Tiers.Merge when using TieIsError, if more than
+// one layer was added to the same tier.
+type TierConflictError struct {
+	Tier int
+	// The indices of the conflicting layers, in the order they were added to
+	// the Tiers.
+	Layers []int
+}
+
+func (e *TierConflictError) Error() string {
+	return fmt.Sprintf("Layers %v are all in priority tier %d", e.Layers,
+		e.Tier)
+}
+
+// A layer added to Tiers.
+type tieredLayer struct {
+	fsys fs.FS
+	tier int
+	// The order in which the layer was added.
+	index   int
+	modTime time.Time
+}
+
+// Builds a merge from layers assigned to numeric priority tiers, rather than
+// given in a total order. This is intended for plugin systems, in which each
+// plugin can declare a tier (e.g. "overrides the base game" or "library")
+// without knowing about the other plugins. Layers in higher tiers take
+// priority over those in lower tiers, and layers within the same tier are
+// ordered using the TieBreak passed to NewTiers. Not safe for concurrent use.
+type Tiers struct {
+	tieBreak TieBreak
+	layers   []tieredLayer
+}
+
+// Returns a new, empty Tiers using the given rule for ordering layers within
+// a tier.
+func NewTiers(tieBreak TieBreak) *Tiers {
+	return &Tiers{
+		tieBreak: tieBreak,
+	}
+}
+
+// Adds a layer to the given tier. Returns the layer's index, as used in a
+// *TierConflictError.
+func (t *Tiers) Add(tier int, layer fs.FS) int {
+	t.layers = append(t.layers, tieredLayer{
+		fsys:  layer,
+		tier:  tier,
+		index: len(t.layers),
+	})
+	return len(t.layers) - 1
+}
+
+// Returns the layers in priority order, highest priority first, as they
+// would be passed to MergeMultiple. Using TieByModTime, this stats the root
+// directory of every layer, returning an error if any of them can't be
+// read.
+func (t *Tiers) Order() ([]fs.FS, error) {
+	layers := make([]tieredLayer, len(t.layers))
+	copy(layers, t.layers)
+	switch t.tieBreak {
+	case TieByRegistration, TieIsError:
+	case TieByModTime:
+		for i := range layers {
+			info, e := fs.Stat(layers[i].fsys, ".")
+			if e != nil {
+				return nil, fmt.Errorf("Couldn't get the modification time "+
+					"of layer %d: %w", layers[i].index, e)
+			}
+			layers[i].modTime = info.ModTime()
+		}
+	default:
+		return nil, fmt.Errorf("Invalid tie break: %d", t.tieBreak)
+	}
+	sort.SliceStable(layers, func(i, j int) bool {
+		a, b := &layers[i], &layers[j]
+		if a.tier != b.tier {
+			return a.tier > b.tier
+		}
+		if t.tieBreak == TieByModTime {
+			return a.modTime.After(b.modTime)
+		}
+		return false
+	})
+	if t.tieBreak == TieIsError {
+		for i := 0; i < len(layers); {
+			end := i + 1
+			for (end < len(layers)) && (layers[end].tier == layers[i].tier) {
+				end++
+			}
+			if end-i > 1 {
+				conflict := &TierConflictError{Tier: layers[i].tier}
+				for _, l := range layers[i:end] {
+					conflict.Layers = append(conflict.Layers, l.index)
+				}
+				return nil, conflict
+			}
+			i = end
+		}
+	}
+	toReturn := make([]fs.FS, len(layers))
+	for i := range layers {
+		toReturn[i] = layers[i].fsys
+	}
+	return toReturn, nil
+}
+
+// Merges the layers in the order returned by Order, using MergeMultiple. The
+// order is determined when this is called, so call Merge again after adding
+// layers or after the modification times used by TieByModTime change.
+func (t *Tiers) Merge() (fs.FS, error) {
+	layers, e := t.Order()
+	if e != nil {
+		return nil, e
+	}
+	return MergeMultiple(layers...), nil
+}
@@ -0,0 +1,79 @@
+package merged_fs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Returns a layer containing a.txt with the given content, whose root
+// directory has the given modification time.
+func tierTestLayer(content string, modTime time.Time) fs.FS {
+	return fstest.MapFS{
+		".":     &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: modTime},
+		"a.txt": newMapFile(content),
+	}
+}
+
+func TestTiers(t *testing.T) {
+	now := time.Now()
+	old := tierTestLayer("old", now.Add(-time.Hour))
+	newer := tierTestLayer("newer", now)
+	library := tierTestLayer("library", now.Add(time.Hour))
+	top := tierTestLayer("top", now.Add(-2*time.Hour))
+
+	expectContent := func(tieBreak TieBreak, expected string) {
+		tiers := NewTiers(tieBreak)
+		tiers.Add(0, library)
+		tiers.Add(5, old)
+		tiers.Add(5, newer)
+		merged, e := tiers.Merge()
+		if e != nil {
+			t.Logf("Failed merging tiers using %s: %s\n", tieBreak, e)
+			t.FailNow()
+		}
+		content, e := fs.ReadFile(merged, "a.txt")
+		if e != nil {
+			t.Logf("Failed reading a.txt: %s\n", e)
+			t.FailNow()
+		}
+		if string(content) != expected {
+			t.Logf("Expected %s to serve %q, got %q\n", tieBreak, expected,
+				content)
+			t.FailNow()
+		}
+		// A higher tier always wins, regardless of the tie break.
+		tiers.Add(10, top)
+		merged, e = tiers.Merge()
+		if e != nil {
+			t.Logf("Failed merging tiers with top layer: %s\n", e)
+			t.FailNow()
+		}
+		content, _ = fs.ReadFile(merged, "a.txt")
+		if string(content) != "top" {
+			t.Logf("Highest tier didn't take priority: got %q\n", content)
+			t.FailNow()
+		}
+	}
+	expectContent(TieByRegistration, "old")
+	expectContent(TieByModTime, "newer")
+
+	tiers := NewTiers(TieIsError)
+	tiers.Add(0, library)
+	tiers.Add(5, old)
+	tiers.Add(1, top)
+	tiers.Add(5, newer)
+	_, e := tiers.Merge()
+	var conflict *TierConflictError
+	if !errors.As(e, &conflict) {
+		t.Logf("Didn't get expected tier conflict: %v\n", e)
+		t.FailNow()
+	}
+	if (conflict.Tier != 5) || (len(conflict.Layers) != 2) ||
+		(conflict.Layers[0] != 1) || (conflict.Layers[1] != 3) {
+		t.Logf("Got wrong conflict: %s\n", conflict)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,312 @@
+package merged_fs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Describes a single FS in the tree making up a MergedFS, as returned by
+// Topology. Unlike DebugDump, a topology only includes configuration, not
+// cache sizes or other counters, so topologies of identically configured
+// merges are identical. It can be encoded using encoding/json.
+type TopologyNode struct {
+	// The kind of FS: "merged", "group", "layer", "mount", "sanitize",
+	// "normalize_windows_paths", "transcode", "normalize_line_endings",
+	// "archive_dir", "content_cache", "disk_cache", "replicas", "dir_layer",
+	// "compiled", "index", "tenant_view", or "fs" for any other FS.
+	Kind string `json:"kind"`
+	// The name of a Layer or Group, if it has one.
+	Name string `json:"name,omitempty"`
+	// The FS's Go type, e.g. "fstest.MapFS" or "*zip.Reader". Only set for
+	// kinds this package doesn't know the contents of.
+	Type string `json:"type,omitempty"`
+	// The FS's role in its parent: "A" or "B" within a MergedFS, "replica 0"
+	// and so on within replicas, "source" for the MergedFS serving a compiled
+	// FS, index, or tenant view, or empty otherwise.
+	Role string `json:"role,omitempty"`
+	// Options set on the FS that affect how it serves content, keyed by a
+	// short description, e.g. "symlink shadowing" or "read quota".
+	Settings map[string]string `json:"settings,omitempty"`
+	// The filesystems that this one wraps or merges. A MergedFS's children
+	// are its A and B, in that order, so the tree's leaves are in priority
+	// order when read depth-first.
+	Children []*TopologyNode `json:"children,omitempty"`
+}
+
+// Returns a machine-readable description of the tree of filesystems making
+// up m, including nested MergedFS instances, Layers and their names, mounts
+// and other wrappers, and the policies set on each. This is intended for
+// documenting complex merges and checking them in review, e.g. by comparing
+// the JSON encoding of a deployment's topology to a committed copy, or by
+// rendering it using WriteDOT.
+func (m *MergedFS) Topology() *TopologyNode {
+	return topologyOf(m, "")
+}
+
+// Returns the topology of fsys, with the given role in its parent.
+func topologyOf(fsys fs.FS, role string) *TopologyNode {
+	n := &TopologyNode{Role: role, Settings: make(map[string]string)}
+	switch v := fsys.(type) {
+	case *MergedFS:
+		n.Kind = "merged"
+		v.addTopology(n)
+	case *Group:
+		n.Kind = "group"
+		n.Name = v.name
+		v.MergedFS.addTopology(n)
+	case *Layer:
+		n.Kind = "layer"
+		n.Name = v.Name
+		v.addTopology(n)
+	case *mountFS:
+		n.Kind = "mount"
+		n.Settings["prefix"] = v.prefix
+		n.Children = []*TopologyNode{topologyOf(v.fsys, "")}
+	case *windowsPathFS:
+		n.Kind = "normalize_windows_paths"
+		n.Children = []*TopologyNode{topologyOf(v.fsys, "")}
+	case *sanitizedFS:
+		n.Kind = "sanitize"
+		n.Children = []*TopologyNode{topologyOf(v.fsys, "")}
+	case *transcodeFS:
+		n.Kind = "transcode"
+		if v.name == "NormalizeLineEndings" {
+			n.Kind = "normalize_line_endings"
+		}
+		n.Settings["conversion"] = v.detail
+		if len(v.patterns) != 0 {
+			n.Settings["patterns"] = strings.Join(v.patterns, ", ")
+		}
+		n.Children = []*TopologyNode{topologyOf(v.fsys, "")}
+	case *ArchiveDirFS:
+		n.Kind = "archive_dir"
+		n.Settings["dir"] = v.dir
+		n.Settings["pattern"] = v.pattern
+		n.Children = []*TopologyNode{topologyOf(v.current(), "")}
+	case *ContentCache:
+		n.Kind = "content_cache"
+		n.Settings["max bytes"] = strconv.FormatInt(v.maxBytes, 10)
+		n.Children = []*TopologyNode{topologyOf(v.fsys, "")}
+	case *diskCacheFS:
+		n.Kind = "disk_cache"
+		n.Settings["dir"] = v.dir
+		n.Children = []*TopologyNode{topologyOf(v.fsys, "")}
+	case *replicaFS:
+		n.Kind = "replicas"
+		n.Settings["policy"] = v.policy.String()
+		for i, r := range v.replicas {
+			n.Children = append(n.Children,
+				topologyOf(r, fmt.Sprintf("replica %d", i)))
+		}
+	case *dirLayerFS:
+		n.Kind = "dir_layer"
+		n.Settings["root"] = v.root
+	case exposedDirLayerFS:
+		n.Kind = "dir_layer"
+		n.Settings["root"] = v.root
+		n.Settings["exposes symlinks"] = "true"
+	case *compiledFS:
+		n.Kind = "compiled"
+		n.Children = []*TopologyNode{topologyOf(v.source, "source")}
+	case *IndexFS:
+		n.Kind = "index"
+		n.Children = []*TopologyNode{topologyOf(v.source, "source")}
+	case *tenantFS:
+		n.Kind = "tenant_view"
+		n.Settings["tenant"] = v.tenantID
+		n.Children = []*TopologyNode{topologyOf(v.m, "source")}
+	default:
+		n.Kind = "fs"
+		n.Type = fmt.Sprintf("%T", fsys)
+	}
+	if len(n.Settings) == 0 {
+		n.Settings = nil
+	}
+	return n
+}
+
+// Adds m's settings and layers to n.
+func (m *MergedFS) addTopology(n *TopologyNode) {
+	m.okPrefixesMutex.Lock()
+	n.Settings["path caching"] = strconv.FormatBool(m.prefixCachingEnabled)
+	m.okPrefixesMutex.Unlock()
+	m.dirCacheMutex.Lock()
+	n.Settings["directory caching"] = strconv.FormatBool(m.dirCache != nil)
+	m.dirCacheMutex.Unlock()
+	n.Settings["symlink shadowing"] = SymlinkShadowing(
+		atomic.LoadInt32(&m.symlinkShadowing)).String()
+	flags := map[string]bool{
+		"panic recovery":   m.recoveringPanics(),
+		"synthetic root":   atomic.LoadInt32(&m.syntheticRoot) != 0,
+		"read fallback":    atomic.LoadInt32(&m.readFallback) != 0,
+		"integrity checks": atomic.LoadInt32(&m.integrityChecks) != 0,
+	}
+	m.configMutex.RLock()
+	flags["strict mode"] = m.strictHandler != nil
+	flags["predictor"] = m.predictor != nil
+	flags["authorizer"] = m.authorizer != nil
+	if a := m.auditor; a != nil {
+		n.Settings["audit sampling"] = "patterns only"
+		if a.sampleEvery > 0 {
+			n.Settings["audit sampling"] = fmt.Sprintf("1 in %d",
+				a.sampleEvery)
+		}
+		if len(a.patterns) != 0 {
+			n.Settings["audit patterns"] = strings.Join(a.patterns, ", ")
+		}
+	}
+	counts := map[string]int{
+		"middleware":         len(m.middleware),
+		"priority overrides": len(m.priorityOverrides),
+		"pins":               len(m.pins),
+		"conflict resolvers": len(m.conflictRules),
+		"aliases":            len(m.aliases),
+		"tenants":            len(m.tenants),
+	}
+	if m.readMeter != nil {
+		n.Settings["read quota"] = strconv.FormatInt(m.readMeter.limit, 10)
+	}
+	m.configMutex.RUnlock()
+	for name, enabled := range flags {
+		if enabled {
+			n.Settings[name] = "true"
+		}
+	}
+	for name, count := range counts {
+		if count != 0 {
+			n.Settings[name] = strconv.Itoa(count)
+		}
+	}
+	maxEntries := atomic.LoadInt64(&m.maxDirEntries)
+	maxWork := atomic.LoadInt64(&m.maxMergeWork)
+	if (maxEntries > 0) || (maxWork > 0) {
+		n.Settings["merge limits"] = fmt.Sprintf("%d entries, %d work",
+			maxEntries, maxWork)
+	}
+	maxPathDepth := atomic.LoadInt64(&m.maxPathDepth)
+	maxNesting := atomic.LoadInt64(&m.maxNestingDepth)
+	if (maxPathDepth > 0) || (maxNesting > 0) {
+		n.Settings["depth limits"] = fmt.Sprintf("%d components, %d nested "+
+			"merges", maxPathDepth, maxNesting)
+	}
+	n.Children = []*TopologyNode{
+		topologyOf(m.layer(0), "A"),
+		topologyOf(m.layer(1), "B"),
+	}
+}
+
+// Adds l's settings and FS to n.
+func (l *Layer) addTopology(n *TopologyNode) {
+	if l.Root != "" {
+		n.Settings["root"] = l.Root
+	}
+	if l.MaxConcurrent > 0 {
+		n.Settings["max concurrent"] = strconv.Itoa(l.MaxConcurrent)
+	}
+	if l.OpsPerSecond > 0 {
+		n.Settings["ops per second"] = strconv.FormatFloat(l.OpsPerSecond,
+			'g', -1, 64)
+	}
+	if l.ReadQuota > 0 {
+		n.Settings["read quota"] = strconv.FormatInt(l.ReadQuota, 10)
+	}
+	if l.BreakerThreshold > 0 {
+		n.Settings["breaker threshold"] = strconv.Itoa(l.BreakerThreshold)
+		n.Settings["breaker backoff"] = l.BreakerBackoff.String()
+	}
+	if l.SlowStat {
+		n.Settings["slow stat"] = "true"
+	}
+	if len(l.KnownPaths) != 0 {
+		n.Settings["known paths"] = strconv.Itoa(len(l.KnownPaths))
+	}
+	if l.Enabled != nil {
+		n.Settings["gated"] = "true"
+		if len(l.GatedPatterns) != 0 {
+			n.Settings["gated patterns"] = strings.Join(l.GatedPatterns, ", ")
+		}
+	}
+	if l.Visible != nil {
+		n.Settings["visibility hook"] = "true"
+	}
+	n.Children = []*TopologyNode{topologyOf(l.FS, "")}
+}
+
+// Returns s as a quoted Graphviz DOT string.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+// Returns the label used for n by WriteDOT.
+func (n *TopologyNode) dotLabel() string {
+	lines := []string{n.Kind}
+	if n.Name != "" {
+		lines[0] += " " + strconv.Quote(n.Name)
+	}
+	if n.Type != "" {
+		lines = append(lines, n.Type)
+	}
+	keys := make([]string, 0, len(n.Settings))
+	for key := range n.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lines = append(lines, key+": "+n.Settings[key])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Writes the topology rooted at n to w as a Graphviz DOT digraph, with an
+// edge from each FS to each of its children, labeled with the child's role:
+//
+//	merged.Topology().WriteDOT(f)
+//	// Then render it using: dot -Tsvg merge.dot -o merge.svg
+//
+// Nodes are numbered in depth-first order, so the output is stable for a
+// given topology.
+func (n *TopologyNode) WriteDOT(w io.Writer) error {
+	_, e := io.WriteString(w, "digraph merge {\n\tnode [shape=box];\n")
+	if e != nil {
+		return e
+	}
+	next := 0
+	var write func(n *TopologyNode) (int, error)
+	write = func(n *TopologyNode) (int, error) {
+		id := next
+		next++
+		_, e := fmt.Fprintf(w, "\tn%d [label=%s];\n", id,
+			dotQuote(n.dotLabel()))
+		if e != nil {
+			return 0, e
+		}
+		for _, child := range n.Children {
+			childID, e := write(child)
+			if e != nil {
+				return 0, e
+			}
+			edge := fmt.Sprintf("\tn%d -> n%d", id, childID)
+			if child.Role != "" {
+				edge += " [label=" + dotQuote(child.Role) + "]"
+			}
+			_, e = io.WriteString(w, edge+";\n")
+			if e != nil {
+				return 0, e
+			}
+		}
+		return id, nil
+	}
+	_, e = write(n)
+	if e != nil {
+		return e
+	}
+	_, e = io.WriteString(w, "}\n")
+	return e
+}
@@ -0,0 +1,105 @@
+package merged_fs
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTopology(t *testing.T) {
+	static, e := Mount(fstest.MapFS{
+		"app.js": newMapFile("app"),
+	}, "static")
+	if e != nil {
+		t.Logf("Failed mounting static files: %s\n", e)
+		t.FailNow()
+	}
+	overrides := &Layer{
+		Name:      "overrides \"local\"",
+		FS:        fstest.MapFS{"static/app.js": newMapFile("patched")},
+		ReadQuota: 1024,
+	}
+	merged := MergeMultiple(overrides, static, fstest.MapFS{}).(*MergedFS)
+	merged.SetReadQuota(4096)
+	e = merged.Pin("static/app.js", 1)
+	if e != nil {
+		t.Logf("Failed pinning a path: %s\n", e)
+		t.FailNow()
+	}
+
+	topology := merged.Topology()
+	if (topology.Kind != "merged") || (len(topology.Children) != 2) {
+		t.Logf("Got incorrect root node: %+v\n", topology)
+		t.FailNow()
+	}
+	if (topology.Settings["read quota"] != "4096") ||
+		(topology.Settings["pins"] != "1") {
+		t.Logf("Got incorrect root settings: %v\n", topology.Settings)
+		t.FailNow()
+	}
+	layer := topology.Children[0]
+	if (layer.Kind != "layer") || (layer.Role != "A") ||
+		(layer.Name != overrides.Name) ||
+		(layer.Settings["read quota"] != "1024") {
+		t.Logf("Got incorrect layer node: %+v\n", layer)
+		t.FailNow()
+	}
+	if layer.Children[0].Type != "fstest.MapFS" {
+		t.Logf("Got incorrect type for the layer's FS: %q\n",
+			layer.Children[0].Type)
+		t.FailNow()
+	}
+	nested := topology.Children[1]
+	if (nested.Kind != "merged") || (nested.Role != "B") {
+		t.Logf("Got incorrect nested node: %+v\n", nested)
+		t.FailNow()
+	}
+	mount := nested.Children[0]
+	if (mount.Kind != "mount") || (mount.Settings["prefix"] != "static") {
+		t.Logf("Got incorrect mount node: %+v\n", mount)
+		t.FailNow()
+	}
+
+	// Topologies only include configuration, so they don't change when the
+	// FS is used.
+	before, e := json.Marshal(topology)
+	if e != nil {
+		t.Logf("Failed encoding topology: %s\n", e)
+		t.FailNow()
+	}
+	_, e = merged.ReadFile("static/app.js")
+	if e != nil {
+		t.Logf("Failed reading static/app.js: %s\n", e)
+		t.FailNow()
+	}
+	after, e := json.Marshal(merged.Topology())
+	if e != nil {
+		t.Logf("Failed encoding topology: %s\n", e)
+		t.FailNow()
+	}
+	if string(before) != string(after) {
+		t.Logf("Topology changed after reading a file:\n%s\n%s\n", before,
+			after)
+		t.FailNow()
+	}
+
+	var dot strings.Builder
+	e = topology.WriteDOT(&dot)
+	if e != nil {
+		t.Logf("Failed writing DOT: %s\n", e)
+		t.FailNow()
+	}
+	expected := []string{
+		"digraph merge {",
+		`n0 -> n1 [label="A"];`,
+		`label="layer \"overrides \\\"local\\\"\"\nread quota: 1024"`,
+		`label="mount\nprefix: static"`,
+	}
+	for _, s := range expected {
+		if !strings.Contains(dot.String(), s) {
+			t.Logf("DOT output doesn't contain %s\n", s)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package merged_fs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Returns a Reader that decodes text in some encoding read from r, returning
+// it as UTF-8. It's called once for each file that's read, so the Reader may
+// keep state without being safe for concurrent use. See RegisterDecoder.
+type Decoder func(r io.Reader) io.Reader
+
+var (
+	decodersMutex sync.RWMutex
+	decoders      = map[string]Decoder{
+		"iso-8859-1": func(r io.Reader) io.Reader {
+			return &decodingReader{r: r, decode: decodeLatin1}
+		},
+		"utf-16be": func(r io.Reader) io.Reader {
+			return &decodingReader{r: r,
+				decode: utf16Decoder(binary.BigEndian)}
+		},
+		"utf-16le": func(r io.Reader) io.Reader {
+			return &decodingReader{r: r,
+				decode: utf16Decoder(binary.LittleEndian)}
+		},
+	}
+)
+
+// Makes a decoder available to Transcode under the given name, replacing any
+// decoder already registered with the name. Names are case-insensitive. This
+// package only depends on the standard library, so it only provides
+// "iso-8859-1", "utf-16le", and "utf-16be", but others can be registered by
+// programs that need them. For example, using golang.org/x/text:
+//
+//	merged_fs.RegisterDecoder("shift_jis", func(r io.Reader) io.Reader {
+//		return japanese.ShiftJIS.NewDecoder().Reader(r)
+//	})
+func RegisterDecoder(name string, d Decoder) {
+	decodersMutex.Lock()
+	defer decodersMutex.Unlock()
+	decoders[strings.ToLower(name)] = d
+}
+
+// Returns the decoder registered with the given name.
+func getDecoder(name string) (Decoder, error) {
+	decodersMutex.RLock()
+	defer decodersMutex.RUnlock()
+	d := decoders[strings.ToLower(name)]
+	if d == nil {
+		return nil, fmt.Errorf("No decoder named %q is registered", name)
+	}
+	return d, nil
+}
+
+// Decodes as much of in as possible, appending the UTF-8 result to out and
+// returning it, along with the number of bytes of in that were consumed. If
+// eof is false, incomplete characters at the end of in may be left
+// unconsumed until more data is available.
+type decodeFunc func(out, in []byte, eof bool) ([]byte, int)
+
+// Implements the decoders provided by this package.
+type decodingReader struct {
+	r      io.Reader
+	decode decodeFunc
+	// Bytes read from r that haven't been decoded yet.
+	in []byte
+	// Decoded bytes that haven't been returned yet.
+	out []byte
+	// The error returned by r, if any.
+	e error
+	// Holds data read from r, before it's appended to in.
+	buffer []byte
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	if d.buffer == nil {
+		d.buffer = make([]byte, 4096)
+	}
+	for (len(d.out) == 0) && (d.e == nil) {
+		n, e := d.r.Read(d.buffer)
+		d.in = append(d.in, d.buffer[:n]...)
+		d.e = e
+		var consumed int
+		d.out, consumed = d.decode(d.out, d.in, e != nil)
+		d.in = d.in[consumed:]
+	}
+	if len(d.out) == 0 {
+		return 0, d.e
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// Implements the "iso-8859-1" decoder, in which each byte is a code point.
+func decodeLatin1(out, in []byte, eof bool) ([]byte, int) {
+	for _, b := range in {
+		if b < utf8.RuneSelf {
+			out = append(out, b)
+			continue
+		}
+		out = append(out, 0xc0|(b>>6), 0x80|(b&0x3f))
+	}
+	return out, len(in)
+}
+
+// Returns a decodeFunc for UTF-16 with the given byte order. Unpaired
+// surrogates and trailing odd bytes are replaced with U+FFFD.
+func utf16Decoder(order binary.ByteOrder) decodeFunc {
+	return func(out, in []byte, eof bool) ([]byte, int) {
+		var encoded [utf8.UTFMax]byte
+		consumed := 0
+		for len(in)-consumed >= 2 {
+			r := rune(order.Uint16(in[consumed:]))
+			size := 2
+			if utf16.IsSurrogate(r) {
+				if (len(in)-consumed < 4) && !eof {
+					break
+				}
+				r = utf8.RuneError
+				if len(in)-consumed >= 4 {
+					pair := utf16.DecodeRune(rune(order.Uint16(in[consumed:])),
+						rune(order.Uint16(in[consumed+2:])))
+					if pair != utf8.RuneError {
+						r = pair
+						size = 4
+					}
+				}
+			}
+			n := utf8.EncodeRune(encoded[:], r)
+			out = append(out, encoded[:n]...)
+			consumed += size
+		}
+		if eof && (consumed < len(in)) {
+			n := utf8.EncodeRune(encoded[:], utf8.RuneError)
+			out = append(out, encoded[:n]...)
+			consumed = len(in)
+		}
+		return out, consumed
+	}
+}
+
+// The decoded size of a file, along with the info used to check that it's
+// current.
+type decodedSize struct {
+	size    int64
+	modTime time.Time
+	decoded int64
+}
+
+// Wraps an FS, converting the content of files using a Decoder. See
+// Transcode and NormalizeLineEndings.
+type transcodeFS struct {
+	fsys fs.FS
+	// The name of the function that created the FS, and a description of the
+	// conversion, e.g. "Transcode" and "from shift_jis", for debug output.
+	name, detail string
+	decoder      Decoder
+	patterns     []string
+	// Protects sizes.
+	mutex sync.Mutex
+	sizes map[string]decodedSize
+}
+
+// Returns an FS that wraps fsys, converting the content of text files from
+// the given encoding to UTF-8 as they're read. The encoding must be the name
+// of a decoder registered using RegisterDecoder. If any patterns are given,
+// only regular files matching at least one of them are converted, e.g.
+// "**/*.txt" or "scripts/**"; otherwise every regular file is converted.
+// Patterns use the same syntax as AddPriorityOverride. Returns an error if
+// the encoding isn't registered or any pattern is malformed.
+//
+// The sizes reported for converted files, by Stat or by directory entries,
+// are the sizes of the UTF-8 content. Since these can't be known without
+// decoding the entire file, a file is decoded once to measure it the first
+// time its size is needed, and the result is kept until the underlying
+// file's size or modification time changes.
+//
+// Converted files implement io.Seeker, so they can be served using
+// http.FileServer, but seeking is emulated: seeking backwards decodes the
+// file again from the start. They don't implement io.ReaderAt.
+func Transcode(fsys fs.FS, encoding string, patterns ...string) (fs.FS,
+	error) {
+	decoder, e := getDecoder(encoding)
+	if e != nil {
+		return nil, e
+	}
+	return newTranscodeFS(fsys, "Transcode", "from "+encoding, decoder,
+		patterns)
+}
+
+// Returns a transcodeFS converting files in fsys matching any of the patterns
+// using the decoder, or an error if any pattern is malformed.
+func newTranscodeFS(fsys fs.FS, name, detail string, decoder Decoder,
+	patterns []string) (*transcodeFS, error) {
+	for _, pattern := range patterns {
+		e := validatePattern(pattern)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return &transcodeFS{
+		fsys:     fsys,
+		name:     name,
+		detail:   detail,
+		decoder:  decoder,
+		patterns: append([]string(nil), patterns...),
+		sizes:    make(map[string]decodedSize),
+	}, nil
+}
+
+// Returns true if the file at p should be converted, given its type.
+func (t *transcodeFS) converts(p string, mode fs.FileMode) bool {
+	if !mode.IsRegular() {
+		return false
+	}
+	if len(t.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range t.patterns {
+		if matchPattern(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns info for the converted file at p, given the underlying file's info,
+// decoding the file to measure its size if necessary.
+func (t *transcodeFS) convertedInfo(p string, info fs.FileInfo) (fs.FileInfo,
+	error) {
+	t.mutex.Lock()
+	cached, ok := t.sizes[p]
+	t.mutex.Unlock()
+	if ok && (cached.size == info.Size()) &&
+		cached.modTime.Equal(info.ModTime()) {
+		return sizedInfo{FileInfo: info, size: cached.decoded}, nil
+	}
+	f, e := t.fsys.Open(p)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	var counter countingWriter
+	_, e = io.Copy(&counter, t.decoder(f))
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't convert %s (%s): %w", p, t.detail,
+			e)
+	}
+	t.mutex.Lock()
+	t.sizes[p] = decodedSize{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		decoded: int64(counter),
+	}
+	t.mutex.Unlock()
+	return sizedInfo{FileInfo: info, size: int64(counter)}, nil
+}
+
+func (t *transcodeFS) Open(p string) (fs.File, error) {
+	if !fs.ValidPath(p) {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrInvalid}
+	}
+	f, e := t.fsys.Open(p)
+	if e != nil {
+		return nil, e
+	}
+	info, e := f.Stat()
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	// Some regular files, such as *os.File, also implement ReadDirFile, so
+	// only the FileInfo can tell whether this is a directory.
+	if dir, ok := f.(fs.ReadDirFile); ok && info.IsDir() {
+		return &transcodedDir{
+			ReadDirFile: dir,
+			fs:          t,
+			path:        p,
+		}, nil
+	}
+	if !t.converts(p, info.Mode()) {
+		return f, nil
+	}
+	return &transcodedFile{
+		f:       f,
+		fs:      t,
+		path:    p,
+		decoded: t.decoder(f),
+	}, nil
+}
+
+// A FileInfo with a different size.
+type sizedInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i sizedInfo) Size() int64 {
+	return i.size
+}
+
+// A file opened using a transcodeFS that's being converted.
+type transcodedFile struct {
+	f    fs.File
+	fs   *transcodeFS
+	path string
+	// Reads the converted content of f.
+	decoded io.Reader
+	// The offset of the next byte decoded will return, and the offset
+	// requested using Seek, respectively.
+	decodedOffset, offset int64
+}
+
+func (f *transcodedFile) Unwrap() fs.File {
+	return f.f
+}
+
+func (f *transcodedFile) Stat() (fs.FileInfo, error) {
+	info, e := f.f.Stat()
+	if e != nil {
+		return nil, e
+	}
+	return f.fs.convertedInfo(f.path, info)
+}
+
+// Makes decoded return content starting at offset, reopening the file if it
+// needs to go backwards.
+func (f *transcodedFile) catchUp() error {
+	if f.offset < f.decodedOffset {
+		reopened, e := f.fs.fsys.Open(f.path)
+		if e != nil {
+			return e
+		}
+		f.f.Close()
+		f.f = reopened
+		f.decoded = f.fs.decoder(reopened)
+		f.decodedOffset = 0
+	}
+	n, e := io.CopyN(io.Discard, f.decoded, f.offset-f.decodedOffset)
+	f.decodedOffset += n
+	return e
+}
+
+func (f *transcodedFile) Read(data []byte) (int, error) {
+	if f.offset != f.decodedOffset {
+		e := f.catchUp()
+		if e != nil {
+			return 0, e
+		}
+	}
+	n, e := f.decoded.Read(data)
+	f.decodedOffset += int64(n)
+	f.offset = f.decodedOffset
+	return n, e
+}
+
+// Only records the new offset, so that seeking to the end to find the size,
+// as http.ServeContent does, doesn't decode the file.
+func (f *transcodedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		info, e := f.Stat()
+		if e != nil {
+			return 0, e
+		}
+		offset += info.Size()
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.path, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.path, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *transcodedFile) Close() error {
+	return f.f.Close()
+}
+
+// A directory opened using a transcodeFS, which reports the converted sizes
+// of its entries.
+type transcodedDir struct {
+	fs.ReadDirFile
+	fs   *transcodeFS
+	path string
+}
+
+func (d *transcodedDir) Unwrap() fs.File {
+	return d.ReadDirFile
+}
+
+func (d *transcodedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, e := d.ReadDirFile.ReadDir(n)
+	for i, entry := range entries {
+		p := path.Join(d.path, entry.Name())
+		if d.fs.converts(p, entry.Type()) {
+			entries[i] = &transcodedEntry{
+				DirEntry: entry,
+				fs:       d.fs,
+				path:     p,
+			}
+		}
+	}
+	return entries, e
+}
+
+// A DirEntry for a converted file, whose Info method reports its converted
+// size.
+type transcodedEntry struct {
+	fs.DirEntry
+	fs   *transcodeFS
+	path string
+}
+
+func (e *transcodedEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return e.fs.convertedInfo(e.path, info)
+}
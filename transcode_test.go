@@ -0,0 +1,149 @@
+package merged_fs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTranscode(t *testing.T) {
+	// "café 𝄞" in UTF-16LE, including a surrogate pair.
+	utf16Data := []byte{'c', 0, 'a', 0, 'f', 0, 0xe9, 0, ' ', 0, 0x34, 0xd8,
+		0x1e, 0xdd}
+	original := fstest.MapFS{
+		"text/latin1.txt": newMapFile("caf\xe9"),
+		"text/utf16.txt":  &fstest.MapFile{Data: utf16Data},
+		"image.png":       newMapFile("\x89PNG\xff"),
+	}
+	_, e := Transcode(original, "no-such-encoding")
+	if e == nil {
+		t.Logf("Didn't get expected error for an unknown encoding\n")
+		t.FailNow()
+	}
+	latin1, e := Transcode(original, "ISO-8859-1", "**/*.txt")
+	if e != nil {
+		t.Logf("Failed transcoding from ISO-8859-1: %s\n", e)
+		t.FailNow()
+	}
+	e = fstest.TestFS(latin1, "text/latin1.txt", "image.png")
+	if e != nil {
+		t.Logf("TestFS failed: %s\n", e)
+		t.FailNow()
+	}
+	content, e := fs.ReadFile(latin1, "text/latin1.txt")
+	if e != nil {
+		t.Logf("Failed reading latin1.txt: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "café" {
+		t.Logf("Got incorrect latin1.txt content: %q\n", content)
+		t.FailNow()
+	}
+	content, e = fs.ReadFile(latin1, "image.png")
+	if e != nil {
+		t.Logf("Failed reading image.png: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "\x89PNG\xff" {
+		t.Logf("A file not matching the patterns was converted: %q\n",
+			content)
+		t.FailNow()
+	}
+	entries, e := fs.ReadDir(latin1, "text")
+	if e != nil {
+		t.Logf("Failed reading text dir: %s\n", e)
+		t.FailNow()
+	}
+	info, e := entries[0].Info()
+	if e != nil {
+		t.Logf("Failed getting latin1.txt's info: %s\n", e)
+		t.FailNow()
+	}
+	if info.Size() != int64(len("café")) {
+		t.Logf("Expected latin1.txt's size to be %d, got %d\n",
+			len("café"), info.Size())
+		t.FailNow()
+	}
+
+	utf16, e := Transcode(original, "utf-16le", "text/utf16.txt")
+	if e != nil {
+		t.Logf("Failed transcoding from UTF-16: %s\n", e)
+		t.FailNow()
+	}
+	f, e := utf16.Open("text/utf16.txt")
+	if e != nil {
+		t.Logf("Failed opening utf16.txt: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	seeker := f.(io.ReadSeeker)
+	size, e := seeker.Seek(0, io.SeekEnd)
+	if e != nil {
+		t.Logf("Failed seeking to the end of utf16.txt: %s\n", e)
+		t.FailNow()
+	}
+	if size != int64(len("café 𝄞")) {
+		t.Logf("Expected utf16.txt's size to be %d, got %d\n",
+			len("café 𝄞"), size)
+		t.FailNow()
+	}
+	_, e = seeker.Seek(3, io.SeekStart)
+	if e != nil {
+		t.Logf("Failed seeking in utf16.txt: %s\n", e)
+		t.FailNow()
+	}
+	content, e = io.ReadAll(seeker)
+	if e != nil {
+		t.Logf("Failed reading utf16.txt: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "é 𝄞" {
+		t.Logf("Got incorrect utf16.txt content: %q\n", content)
+		t.FailNow()
+	}
+	var debug strings.Builder
+	NewMergedFS(utf16, nil).DebugDump(&debug)
+	if !strings.Contains(debug.String(), "Transcode from utf-16le") {
+		t.Logf("Debug output doesn't mention transcoding: %s\n", debug.String())
+		t.FailNow()
+	}
+}
+
+func TestTranscodeDirFS(t *testing.T) {
+	dir := t.TempDir()
+	e := os.MkdirAll(filepath.Join(dir, "text"), 0755)
+	if e != nil {
+		t.Logf("Failed creating text dir: %s\n", e)
+		t.FailNow()
+	}
+	e = os.WriteFile(filepath.Join(dir, "text", "latin1.txt"),
+		[]byte("caf\xe9"), 0644)
+	if e != nil {
+		t.Logf("Failed creating latin1.txt: %s\n", e)
+		t.FailNow()
+	}
+	// Files from os.DirFS implement ReadDirFile, but must still be converted.
+	latin1, e := Transcode(os.DirFS(dir), "ISO-8859-1", "**/*.txt")
+	if e != nil {
+		t.Logf("Failed transcoding from ISO-8859-1: %s\n", e)
+		t.FailNow()
+	}
+	content, e := fs.ReadFile(latin1, "text/latin1.txt")
+	if e != nil {
+		t.Logf("Failed reading latin1.txt: %s\n", e)
+		t.FailNow()
+	}
+	if string(content) != "café" {
+		t.Logf("Got incorrect latin1.txt content: %q\n", content)
+		t.FailNow()
+	}
+	e = fstest.TestFS(latin1, "text/latin1.txt")
+	if e != nil {
+		t.Logf("TestFS failed for an on-disk layer: %s\n", e)
+		t.FailNow()
+	}
+}
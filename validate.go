@@ -0,0 +1,191 @@
+package merged_fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// ErrInvalidConfig is wrapped by every *ConfigError, so callers can check for
+// configuration problems using errors.Is.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Describes a setting that is invalid, or a combination of settings that
+// can't be used together, as found by MergedFS.Validate.
+type ConfigError struct {
+	// The settings involved, e.g. "Layer.GatedPatterns", or "Compile" for
+	// settings that can't be used with Compile.
+	Settings []string
+	// The index of the layer with the problem, as used by Layers, or -1 if
+	// the problem doesn't concern a single layer.
+	LayerIndex int
+	// A description of the problem, and how to fix it.
+	Problem string
+}
+
+func (e *ConfigError) Error() string {
+	settings := strings.Join(e.Settings, " with ")
+	if e.LayerIndex >= 0 {
+		settings = fmt.Sprintf("%s of layer %d", settings, e.LayerIndex)
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrInvalidConfig, settings, e.Problem)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// Every problem found by MergedFS.Validate, in the order of m's layers. There
+// is always at least one.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	problems := make([]string, len(e))
+	for i, c := range e {
+		problems[i] = c.Error()
+	}
+	return fmt.Sprintf("%d configuration problems: %s", len(e),
+		strings.Join(problems, "; "))
+}
+
+// Returns the first problem, so errors.As and errors.Is can inspect it.
+func (e ConfigErrors) Unwrap() error {
+	return e[0]
+}
+
+// Checks the settings of m, and of every Layer and MergedFS nested within it,
+// for values that would silently have no effect or fail at runtime, such as
+// a Layer with a nil FS, an invalid Root, malformed GatedPatterns, or a
+// visibility window that ends before it begins. Since a MergedFS is
+// configured using fields and setters rather than at construction, call this
+// after configuring m, e.g. at startup or in a test. Returns nil if there are
+// no problems, or ConfigErrors describing each of them otherwise.
+//
+// Compile, WriteIndex, and OpenIndex also check that none of m's settings
+// depend on when or by whom a path is opened, since they would be bypassed or
+// frozen by a snapshot: Layers with Enabled, VisibleFrom, VisibleUntil,
+// Visible, or a circuit breaker, layers that are an *ArchiveDirFS, which can
+// be rescanned, or an authorizer set using SetAuthorizer. Those fail with the
+// same errors.
+func (m *MergedFS) Validate() error {
+	return m.validateFor("")
+}
+
+// Implements Validate. If snapshot is non-empty, it names the operation
+// snapshotting m, and settings that can't be snapshotted are also reported.
+func (m *MergedFS) validateFor(snapshot string) error {
+	var toReturn ConfigErrors
+	add := func(layerIndex int, problem string, settings ...string) {
+		if snapshot != "" {
+			settings = append(settings, snapshot)
+		}
+		toReturn = append(toReturn, &ConfigError{
+			Settings:   settings,
+			LayerIndex: layerIndex,
+			Problem:    problem,
+		})
+	}
+	for i, fsys := range m.Layers() {
+		switch v := fsys.(type) {
+		case *Layer:
+			v.validate(i, add, snapshot != "")
+		case *ArchiveDirFS:
+			if snapshot != "" {
+				add(i, "the snapshot won't reflect archives added by Rescan; "+
+					"snapshot the merge again after rescanning instead",
+					"ArchiveDirFS")
+			}
+		}
+	}
+	if snapshot != "" {
+		for _, merged := range nestedMergedFS(m) {
+			merged.configMutex.RLock()
+			authorizing := merged.authorizer != nil
+			merged.configMutex.RUnlock()
+			if authorizing {
+				add(-1, "files are opened directly from their layers, "+
+					"bypassing the authorizer; check access before using the "+
+					"snapshot instead", "SetAuthorizer")
+				break
+			}
+		}
+	}
+	if len(toReturn) == 0 {
+		return nil
+	}
+	return toReturn
+}
+
+// Reports problems with l, which is the layer with the given index, to add,
+// which takes the layer index, a description, and the settings involved. If
+// snapshot is true, settings that can't be snapshotted are also reported.
+func (l *Layer) validate(index int, add func(int, string, ...string),
+	snapshot bool) {
+	if l.FS == nil {
+		add(index, "must not be nil; use Empty for a layer with no "+
+			"content", "Layer.FS")
+		return
+	}
+	if (l.Root != "") && !fs.ValidPath(l.Root) {
+		add(index, fmt.Sprintf("%q isn't a valid path, so every operation "+
+			"would fail", l.Root), "Layer.Root")
+	}
+	for _, pattern := range l.GatedPatterns {
+		if e := validatePattern(pattern); e != nil {
+			add(index, fmt.Sprintf("pattern %q would never match: %s",
+				pattern, e), "Layer.GatedPatterns")
+		}
+	}
+	timeWindow := !l.VisibleFrom.IsZero() || !l.VisibleUntil.IsZero()
+	if (len(l.GatedPatterns) != 0) && !timeWindow && (l.Enabled == nil) {
+		add(index, "has no effect unless VisibleFrom, VisibleUntil, or "+
+			"Enabled can make the layer invisible", "Layer.GatedPatterns")
+	}
+	if !l.VisibleFrom.IsZero() && !l.VisibleUntil.IsZero() &&
+		!l.VisibleUntil.After(l.VisibleFrom) {
+		add(index, "the layer would never be visible, since VisibleUntil "+
+			"isn't after VisibleFrom", "Layer.VisibleFrom",
+			"Layer.VisibleUntil")
+	}
+	if (l.BreakerBackoff != 0) && (l.BreakerThreshold <= 0) {
+		add(index, "has no effect unless BreakerThreshold is positive",
+			"Layer.BreakerBackoff")
+	}
+	if !snapshot {
+		return
+	}
+	dynamic := []struct {
+		setting string
+		set     bool
+	}{
+		{"Layer.VisibleFrom/VisibleUntil", timeWindow},
+		{"Layer.Enabled", l.Enabled != nil},
+		{"Layer.Visible", l.Visible != nil},
+		{"Layer.BreakerThreshold", l.BreakerThreshold > 0},
+	}
+	for _, d := range dynamic {
+		if d.set {
+			add(index, "the layer's visibility can change, but the snapshot "+
+				"would keep whatever was visible when it was taken",
+				d.setting)
+		}
+	}
+}
+
+// Returns m and every MergedFS nested within it, including within Groups.
+func nestedMergedFS(m *MergedFS) []*MergedFS {
+	toReturn := []*MergedFS{m}
+	for side := 0; side < 2; side++ {
+		switch nested := m.layer(side).(type) {
+		case *MergedFS:
+			toReturn = append(toReturn, nestedMergedFS(nested)...)
+		case *Group:
+			toReturn = append(toReturn, nestedMergedFS(nested.MergedFS)...)
+		}
+	}
+	return toReturn
+}
@@ -0,0 +1,116 @@
+package merged_fs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	content := fstest.MapFS{"a.txt": newMapFile("a")}
+	m := NewMergedFS(&Layer{FS: content}, content)
+	e := m.Validate()
+	if e != nil {
+		t.Logf("Got an error validating a valid FS: %s\n", e)
+		t.FailNow()
+	}
+
+	now := time.Now()
+	m = MergeMultiple(
+		&Layer{FS: content, Root: "../outside"},
+		&Layer{FS: content, GatedPatterns: []string{"["}},
+		&Layer{FS: content, VisibleFrom: now, VisibleUntil: now},
+		&Layer{FS: content, BreakerBackoff: time.Second},
+	).(*MergedFS)
+	e = m.Validate()
+	var configErrors ConfigErrors
+	if !errors.As(e, &configErrors) {
+		t.Logf("Didn't get ConfigErrors for invalid layers: %v\n", e)
+		t.FailNow()
+	}
+	expected := []string{"Layer.Root", "Layer.GatedPatterns",
+		"Layer.GatedPatterns", "Layer.VisibleFrom", "Layer.BreakerBackoff"}
+	if len(configErrors) != len(expected) {
+		t.Logf("Expected %d problems, got %d: %s\n", len(expected),
+			len(configErrors), e)
+		t.FailNow()
+	}
+	for i, c := range configErrors {
+		if c.Settings[0] != expected[i] {
+			t.Logf("Expected problem %d to concern %s, got %s\n", i,
+				expected[i], c)
+			t.FailNow()
+		}
+	}
+	if configErrors[4].LayerIndex != 3 {
+		t.Logf("Got wrong layer index for problem: %d\n",
+			configErrors[4].LayerIndex)
+		t.FailNow()
+	}
+	if !errors.Is(e, ErrInvalidConfig) {
+		t.Logf("ConfigErrors didn't wrap ErrInvalidConfig\n")
+		t.FailNow()
+	}
+}
+
+func TestValidateSnapshot(t *testing.T) {
+	content := fstest.MapFS{"a.txt": newMapFile("a")}
+	gated := &Layer{FS: content, Enabled: func() bool { return true }}
+	m := NewMergedFS(gated, content)
+	e := m.Validate()
+	if e != nil {
+		t.Logf("Got an error validating a gated layer: %s\n", e)
+		t.FailNow()
+	}
+	_, e = m.Compile()
+	var configError *ConfigError
+	if !errors.As(e, &configError) {
+		t.Logf("Didn't get a ConfigError compiling a gated layer: %v\n", e)
+		t.FailNow()
+	}
+	if (strings.Join(configError.Settings, ", ") != "Layer.Enabled, "+
+		"Compile") || (configError.LayerIndex != 0) {
+		t.Logf("Got wrong error compiling a gated layer: %s\n", e)
+		t.FailNow()
+	}
+	var buf strings.Builder
+	e = m.WriteIndex(&buf)
+	if !errors.Is(e, ErrInvalidConfig) {
+		t.Logf("Didn't get an error writing an index: %v\n", e)
+		t.FailNow()
+	}
+	a, e := m.Analyze()
+	if e != nil {
+		t.Logf("Failed analyzing FS: %s\n", e)
+		t.FailNow()
+	}
+	for _, r := range a.Recommendations {
+		if strings.Contains(r.Action, "Compile") {
+			t.Logf("Recommended compiling a gated layer: %s\n", r)
+			t.FailNow()
+		}
+	}
+
+	// An authorizer anywhere in the tree prevents snapshots, since they'd
+	// bypass it.
+	nested := NewMergedFS(content, content)
+	nested.SetAuthorizer(func(ctx context.Context, p, op string) error {
+		return nil
+	})
+	m = NewMergedFS(content, nested)
+	_, e = m.Compile()
+	if !errors.As(e, &configError) || (configError.LayerIndex != -1) ||
+		(configError.Settings[0] != "SetAuthorizer") {
+		t.Logf("Didn't get the expected error for an authorizer: %v\n", e)
+		t.FailNow()
+	}
+	nested.SetAuthorizer(nil)
+	_, e = m.Compile()
+	if e != nil {
+		t.Logf("Failed compiling after removing the authorizer: %s\n", e)
+		t.FailNow()
+	}
+}
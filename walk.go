@@ -0,0 +1,165 @@
+package merged_fs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+)
+
+// SkipLayer may be returned by a WalkDirFunc to skip every remaining entry
+// from the same layer as the current entry, for the rest of the walk. If the
+// current entry is a directory that exists only in that layer, it isn't
+// descended into. Merged directories are still visited, since they contain
+// entries from other layers, but their entries from the skipped layer aren't.
+var SkipLayer = errors.New("skip the rest of this layer")
+
+// SkipMergedDirs may be returned by a WalkDirFunc to stop WalkDir from
+// descending into any merged directory, meaning a directory present in more
+// than one layer, for the rest of the walk. Merged directories are still
+// passed to the WalkDirFunc, and directories from a single layer are still
+// descended into.
+var SkipMergedDirs = errors.New("skip merged directories")
+
+// Describes where an entry visited by WalkDir came from.
+type WalkInfo struct {
+	// The index of the layer the entry came from, as used by Layers, or -1
+	// if it's unknown, as for the root of the walk or for entries the
+	// MergedFS synthesized. For a merged directory, this is the
+	// highest-priority layer containing it.
+	Layer int
+	// The layer's name if it's a *Layer with a Name, or an empty string.
+	LayerName string
+	// True if the entry is a directory present in more than one layer, whose
+	// contents were merged.
+	Merged bool
+}
+
+// The type of function called by WalkDir for each file or directory. This is
+// the same as fs.WalkDirFunc, with the addition of the entry's WalkInfo, and
+// may additionally return SkipLayer or SkipMergedDirs.
+type WalkDirFunc func(path string, d fs.DirEntry, info WalkInfo,
+	err error) error
+
+// Returns the WalkInfo for an entry read from a MergedFS directory.
+func walkInfoFor(d fs.DirEntry) WalkInfo {
+	toReturn := WalkInfo{Layer: -1}
+	if p, ok := d.(ProvenanceEntry); ok {
+		toReturn.Layer, toReturn.LayerName = p.Provenance()
+	}
+	if wrapped, ok := d.(*provenanceEntry); ok {
+		d = wrapped.DirEntry
+	}
+	_, toReturn.Merged = d.(*MergedDirectory)
+	return toReturn
+}
+
+// Holds the state of a call to WalkDir.
+type mergedWalker struct {
+	m             *MergedFS
+	fn            WalkDirFunc
+	skippedLayers map[int]bool
+	skipMerged    bool
+}
+
+// Walks the tree rooted at root in m in the same way as fs.WalkDir, except
+// that fn also receives the WalkInfo of each entry, and can return SkipLayer
+// or SkipMergedDirs to control which layers' contributions are visited. For
+// example, this visits everything except the files from the layer at index 0:
+//
+//	e := WalkDir(merged, ".", func(p string, d fs.DirEntry, info WalkInfo,
+//		e error) error {
+//		if e != nil {
+//			return e
+//		}
+//		if info.Layer == 0 {
+//			return SkipLayer
+//		}
+//		fmt.Println(p)
+//		return nil
+//	})
+//
+// As with fs.WalkDir, entries are visited in lexical order, and returning
+// fs.SkipDir skips the current directory, or the remaining entries in the
+// current file's directory.
+func WalkDir(m *MergedFS, root string, fn WalkDirFunc) error {
+	w := &mergedWalker{
+		m:             m,
+		fn:            fn,
+		skippedLayers: make(map[int]bool),
+	}
+	rootInfo := WalkInfo{Layer: -1}
+	info, e := fs.Stat(m, root)
+	if e != nil {
+		e = fn(root, nil, rootInfo, e)
+	} else {
+		_, rootInfo.Merged = info.(*MergedDirectory)
+		e = w.walk(root, infoDirEntry{info}, rootInfo)
+	}
+	if (e == fs.SkipDir) || (e == SkipLayer) || (e == SkipMergedDirs) {
+		return nil
+	}
+	return e
+}
+
+// Calls w.fn, handling SkipLayer and SkipMergedDirs. Returns fs.SkipDir if d
+// is a directory that mustn't be descended into.
+func (w *mergedWalker) call(p string, d fs.DirEntry, info WalkInfo,
+	err error) error {
+	e := w.fn(p, d, info, err)
+	switch e {
+	case SkipLayer:
+		if info.Layer >= 0 {
+			w.skippedLayers[info.Layer] = true
+		}
+		if (d != nil) && d.IsDir() && !info.Merged {
+			return fs.SkipDir
+		}
+		return nil
+	case SkipMergedDirs:
+		w.skipMerged = true
+		if info.Merged {
+			return fs.SkipDir
+		}
+		return nil
+	}
+	return e
+}
+
+func (w *mergedWalker) walk(p string, d fs.DirEntry, info WalkInfo) error {
+	e := w.call(p, d, info, nil)
+	if (e != nil) || !d.IsDir() {
+		if (e == fs.SkipDir) && d.IsDir() {
+			e = nil
+		}
+		return e
+	}
+	if w.skipMerged && info.Merged {
+		return nil
+	}
+	entries, readError := w.m.ReadDir(p)
+	if readError != nil {
+		// As with fs.WalkDir, report the error using a second call for the
+		// directory.
+		e = w.call(p, d, info, readError)
+		if e != nil {
+			if e == fs.SkipDir {
+				e = nil
+			}
+			return e
+		}
+	}
+	for _, entry := range entries {
+		entryInfo := walkInfoFor(entry)
+		if !entryInfo.Merged && w.skippedLayers[entryInfo.Layer] {
+			continue
+		}
+		e = w.walk(path.Join(p, entry.Name()), entry, entryInfo)
+		if e != nil {
+			if e == fs.SkipDir {
+				break
+			}
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// Returns the paths visited by WalkDir, with a callback that returns the
+// result of skip for each path.
+func walkPaths(m *MergedFS, skip func(p string, info WalkInfo) error,
+	t *testing.T) []string {
+	var visited []string
+	e := WalkDir(m, ".", func(p string, d fs.DirEntry, info WalkInfo,
+		e error) error {
+		if e != nil {
+			return e
+		}
+		visited = append(visited, p)
+		return skip(p, info)
+	})
+	if e != nil {
+		t.Logf("WalkDir failed: %s\n", e)
+		t.FailNow()
+	}
+	return visited
+}
+
+func TestWalkDir(t *testing.T) {
+	base := &Layer{Name: "base", FS: fstest.MapFS{
+		"data/a.txt":      newMapFile("a"),
+		"data/b.txt":      newMapFile("b"),
+		"base_only/c.txt": newMapFile("c"),
+	}}
+	mod := &Layer{Name: "mod", FS: fstest.MapFS{
+		"data/b.txt":     newMapFile("modded b"),
+		"data/d.txt":     newMapFile("d"),
+		"mod_only/e.txt": newMapFile("e"),
+	}}
+	merged := NewMergedFS(mod, base)
+	noSkip := func(p string, info WalkInfo) error {
+		return nil
+	}
+	visited := walkPaths(merged, noSkip, t)
+	expected := ".,base_only,base_only/c.txt,data,data/a.txt,data/b.txt," +
+		"data/d.txt,mod_only,mod_only/e.txt"
+	if strings.Join(visited, ",") != expected {
+		t.Logf("Got incorrect paths: %v\n", visited)
+		t.FailNow()
+	}
+
+	// Skip everything from the base layer, at index 1.
+	skipBase := func(p string, info WalkInfo) error {
+		if info.Layer == 1 {
+			return SkipLayer
+		}
+		return nil
+	}
+	visited = walkPaths(merged, skipBase, t)
+	expected = ".,base_only,data,data/b.txt,data/d.txt,mod_only," +
+		"mod_only/e.txt"
+	if strings.Join(visited, ",") != expected {
+		t.Logf("Got incorrect paths skipping the base: %v\n", visited)
+		t.FailNow()
+	}
+
+	var dataInfo WalkInfo
+	skipMerged := func(p string, info WalkInfo) error {
+		if p == "data" {
+			dataInfo = info
+		}
+		// The root is merged too, but should still be descended into.
+		if info.Merged && (p != ".") {
+			return SkipMergedDirs
+		}
+		return nil
+	}
+	visited = walkPaths(merged, skipMerged, t)
+	expected = ".,base_only,base_only/c.txt,data,mod_only,mod_only/e.txt"
+	if strings.Join(visited, ",") != expected {
+		t.Logf("Got incorrect paths skipping merged dirs: %v\n", visited)
+		t.FailNow()
+	}
+	if !dataInfo.Merged || (dataInfo.Layer != 0) ||
+		(dataInfo.LayerName != "mod") {
+		t.Logf("Got incorrect info for merged dir: %+v\n", dataInfo)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,223 @@
+package merged_fs
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Wraps an FS, accepting Windows-style paths and normalizing names that
+// contain backslashes. See NormalizeWindowsPaths.
+type windowsPathFS struct {
+	fsys fs.FS
+	// Used to lazily build backslashIndex.
+	indexOnce sync.Once
+	// The directories implied by backslash-separated names in the root of
+	// fsys.
+	backslashIndex *sparseIndex
+}
+
+// Returns an FS that wraps fsys, for use by programs that are prone to passing
+// Windows-style paths to io/fs functions. The returned FS accepts relative
+// paths using either backslashes or slashes as separators, e.g. "a\b\c.txt",
+// ".\a\b\c.txt", or "a/b\c.txt", converting them to the equivalent io/fs path
+// before passing them to fsys. Paths with drive letters, or that refer to a
+// location outside of fsys, are still rejected with fs.ErrInvalid.
+//
+// Additionally, archives created on Windows sometimes store a file such as
+// "a\b.txt" under that literal name in the root directory, rather than as
+// "b.txt" within a directory named "a". The returned FS presents such files
+// at their slash-separated paths instead, synthesizing any directories that
+// are needed.
+func NormalizeWindowsPaths(fsys fs.FS) fs.FS {
+	return &windowsPathFS{
+		fsys: fsys,
+	}
+}
+
+// Converts a Windows-style relative path to an io/fs path. Returns false if
+// the path can't be converted.
+func normalizeWindowsPath(p string) (string, bool) {
+	if fs.ValidPath(p) && !strings.Contains(p, "\\") {
+		return p, true
+	}
+	if p == "" {
+		return "", false
+	}
+	p = strings.ReplaceAll(p, "\\", "/")
+	if isDriveQualified(p) {
+		// Drive letters aren't allowed.
+		return "", false
+	}
+	if strings.HasPrefix(p, "/") {
+		// Neither are absolute paths.
+		return "", false
+	}
+	p = path.Clean(p)
+	if !fs.ValidPath(p) {
+		return "", false
+	}
+	return p, true
+}
+
+// Returns true if p, which uses slashes as separators, starts with a drive
+// letter, e.g. "C:" or "C:/a.txt", rather than merely containing a colon.
+func isDriveQualified(p string) bool {
+	if (len(p) < 2) || (p[1] != ':') {
+		return false
+	}
+	letter := p[0] | 0x20
+	if (letter < 'a') || (letter > 'z') {
+		return false
+	}
+	return (len(p) == 2) || (p[2] == '/')
+}
+
+func (w *windowsPathFS) index() *sparseIndex {
+	w.indexOnce.Do(func() {
+		var paths []string
+		entries, _ := fs.ReadDir(w.fsys, ".")
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.Contains(name, "\\") {
+				continue
+			}
+			if p, ok := normalizeWindowsPath(name); ok {
+				paths = append(paths, p)
+			}
+		}
+		if len(paths) != 0 {
+			w.backslashIndex = newSparseIndex(paths)
+		}
+	})
+	return w.backslashIndex
+}
+
+// A directory with backslash-containing names removed from its listing, and
+// replaced by the entries implied by them.
+type normalizedDir struct {
+	fs.ReadDirFile
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *normalizedDir) Unwrap() fs.File {
+	return d.ReadDirFile
+}
+
+func (d *normalizedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	return readEntries(d.entries, &d.offset, n)
+}
+
+// Reports a different name for a file than the one in the underlying FS.
+type renamedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i renamedInfo) Name() string {
+	return i.name
+}
+
+// Wraps a file, changing the name reported by Stat.
+type renamedFile struct {
+	fs.File
+	name string
+}
+
+func (f *renamedFile) Unwrap() fs.File {
+	return f.File
+}
+
+func (f *renamedFile) Stat() (fs.FileInfo, error) {
+	info, e := f.File.Stat()
+	if e != nil {
+		return nil, e
+	}
+	return renamedInfo{info, f.name}, nil
+}
+
+// Returns f, but reporting the given name from Stat. Preserves the optional
+// interfaces of f.
+func renameFile(f fs.File, name string) fs.File {
+	dir, _ := f.(dirReader)
+	seeker, _ := f.(io.Seeker)
+	readerAt, _ := f.(io.ReaderAt)
+	mapped, _ := f.(mapper)
+	writerTo, _ := f.(io.WriterTo)
+	return addFileInterfaces(&renamedFile{f, name}, dir, seeker, readerAt,
+		mapped, writerTo)
+}
+
+// Returns entries without any names containing backslashes, but including the
+// entries they imply in the given directory.
+func (w *windowsPathFS) normalizeEntries(dirPath string,
+	entries []fs.DirEntry) []fs.DirEntry {
+	index := w.index()
+	if index == nil {
+		return entries
+	}
+	toReturn := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry.Name(), "\\") {
+			toReturn = append(toReturn, entry)
+		}
+	}
+	toReturn = index.complete(w, dirPath, toReturn)
+	sort.Sort(dirEntrySlice(toReturn))
+	return toReturn
+}
+
+func (w *windowsPathFS) Open(name string) (fs.File, error) {
+	p, ok := normalizeWindowsPath(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, e := w.fsys.Open(p)
+	if e == nil {
+		dir, ok := f.(fs.ReadDirFile)
+		if !ok || (w.index() == nil) {
+			return f, nil
+		}
+		// Some regular files, such as *os.File, also implement ReadDirFile.
+		info, e := f.Stat()
+		if e != nil {
+			f.Close()
+			return nil, e
+		}
+		if !info.IsDir() {
+			return f, nil
+		}
+		entries, e := dir.ReadDir(-1)
+		if e != nil {
+			f.Close()
+			return nil, e
+		}
+		return &normalizedDir{
+			ReadDirFile: dir,
+			entries:     w.normalizeEntries(p, entries),
+		}, nil
+	}
+	if !isBadPathError(e) {
+		return nil, e
+	}
+	index := w.index()
+	if index == nil {
+		return nil, e
+	}
+	if d := index.directory(w, p); d != nil {
+		return d, nil
+	}
+	if !strings.Contains(p, "/") {
+		return nil, e
+	}
+	// Try the file's literal backslash-separated name in the root directory.
+	f, e2 := w.fsys.Open(strings.ReplaceAll(p, "/", "\\"))
+	if e2 != nil {
+		return nil, e
+	}
+	return renameFile(f, path.Base(p)), nil
+}
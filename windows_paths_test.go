@@ -0,0 +1,129 @@
+package merged_fs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNormalizeWindowsPaths(t *testing.T) {
+	fsA := fstest.MapFS{
+		"a/b/c.txt":    newMapFile("c"),
+		"x\\y\\z.txt":  newMapFile("z"),
+		"x\\w.txt":     newMapFile("w"),
+		"a\\other.txt": newMapFile("other"),
+	}
+	normalized := NormalizeWindowsPaths(fsA)
+	// The normalized FS intentionally accepts paths that fstest expects to be
+	// rejected, so we walk it rather than using fstest.
+	var found []string
+	e := fs.WalkDir(normalized, ".", func(p string, d fs.DirEntry,
+		e error) error {
+		if e != nil {
+			return e
+		}
+		if !d.IsDir() {
+			found = append(found, p)
+		}
+		return nil
+	})
+	if e != nil {
+		t.Logf("Failed walking normalized FS: %s\n", e)
+		t.FailNow()
+	}
+	expected := "a/b/c.txt a/other.txt x/w.txt x/y/z.txt"
+	if strings.Join(found, " ") != expected {
+		t.Logf("Walking normalized FS found %v, expected %s\n", found,
+			expected)
+		t.FailNow()
+	}
+	validPaths := []string{
+		"a\\b\\c.txt",
+		".\\a\\b\\c.txt",
+		"a/b\\c.txt",
+		"a\\b\\..\\b\\c.txt",
+		"x\\y\\z.txt",
+	}
+	for _, p := range validPaths {
+		content, e := fs.ReadFile(normalized, p)
+		if e != nil {
+			t.Logf("Failed reading %s: %s\n", p, e)
+			t.FailNow()
+		}
+		if (string(content) != "c") && (string(content) != "z") {
+			t.Logf("Got wrong content for %s: %s\n", p, content)
+			t.FailNow()
+		}
+	}
+	invalidPaths := []string{
+		"",
+		"C:\\a\\b\\c.txt",
+		"c:",
+		"\\a\\b\\c.txt",
+		"..\\a\\b\\c.txt",
+	}
+	for _, p := range invalidPaths {
+		_, e := normalized.Open(p)
+		if e == nil {
+			t.Logf("Didn't get expected error opening %q.\n", p)
+			t.FailNow()
+		}
+	}
+
+	// Make sure normalized names merge properly with other layers.
+	fsB := fstest.MapFS{"x/v.txt": newMapFile("v")}
+	merged := NewMergedFS(normalized, fsB)
+	entries, e := fs.ReadDir(merged, "x")
+	if e != nil {
+		t.Logf("Failed reading merged dir x: %s\n", e)
+		t.FailNow()
+	}
+	if len(entries) != 3 {
+		t.Logf("Expected 3 entries in merged dir x, got %d.\n", len(entries))
+		t.FailNow()
+	}
+}
+
+func TestNormalizeWindowsPathsDirFS(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"plain.txt": "plain",
+		"x\\y.txt":  "y",
+		"a:b":       "colon",
+	}
+	for name, content := range files {
+		e := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if e != nil {
+			t.Logf("Failed creating %s: %s\n", name, e)
+			t.FailNow()
+		}
+	}
+	normalized := NormalizeWindowsPaths(os.DirFS(dir))
+	// Regular files from os.DirFS implement ReadDirFile, so they mustn't be
+	// mistaken for directories.
+	expected := map[string]string{
+		"plain.txt": "plain",
+		"x/y.txt":   "y",
+		"x\\y.txt":  "y",
+		"a:b":       "colon",
+	}
+	for p, content := range expected {
+		data, e := fs.ReadFile(normalized, p)
+		if e != nil {
+			t.Logf("Failed reading %s: %s\n", p, e)
+			t.FailNow()
+		}
+		if string(data) != content {
+			t.Logf("Got wrong content for %s: %q\n", p, data)
+			t.FailNow()
+		}
+	}
+	entries, e := fs.ReadDir(normalized, "x")
+	if (e != nil) || (len(entries) != 1) || (entries[0].Name() != "y.txt") {
+		t.Logf("Got wrong entries for x: %v, %v\n", entries, e)
+		t.FailNow()
+	}
+}